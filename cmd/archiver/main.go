@@ -0,0 +1,113 @@
+// Command archiver moves closed-period ledger entries into compressed segment
+// files and verifies existing segments against the hash chain.
+//
+// Usage:
+//
+//	archiver archive -org 1 -before 2026-01-01 [-segment-size 10000]
+//	archiver verify -org 1
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"ledger-go-system/internal/archive"
+	"ledger-go-system/internal/db"
+	"ledger-go-system/internal/identity"
+	"ledger-go-system/internal/repository"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("DATABASE_URL not set")
+	}
+
+	archiveDir := os.Getenv("ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "./data/archive"
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	orgID := fs.Int("org", 0, "organization to archive or verify")
+	before := fs.String("before", "", "archive entries created before this date (YYYY-MM-DD, exclusive; at most the first of the current month)")
+	segmentSize := fs.Int("segment-size", 10000, "maximum entries per segment file")
+	fs.Parse(os.Args[2:])
+
+	if *orgID <= 0 {
+		log.Fatal("-org is required")
+	}
+
+	conn, err := db.New(dsn)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	store, err := archive.NewStore(archiveDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize archive store: %v", err)
+	}
+	repo := repository.NewArchiveRepository(conn, store)
+
+	// The archiver acts as an admin of the organization it was pointed at
+	ctx := identity.NewContext(context.Background(), identity.Identity{Role: "admin", OrgID: *orgID})
+
+	switch os.Args[1] {
+	case "archive":
+		periodEnd, err := time.Parse("2006-01-02", *before)
+		if err != nil {
+			log.Fatal("-before must be a YYYY-MM-DD date")
+		}
+		if *segmentSize <= 0 {
+			log.Fatal("-segment-size must be positive")
+		}
+
+		segments, err := repo.ArchivePeriod(ctx, periodEnd, *segmentSize, "archiver")
+		for _, s := range segments {
+			fmt.Printf("segment %d: %d entries (ids %d-%d) %s\n", s.Sequence, s.EntryCount, s.FirstLedgerID, s.LastLedgerID, s.FileName)
+		}
+		if err != nil {
+			log.Fatalf("Archival failed: %v", err)
+		}
+		if len(segments) == 0 {
+			fmt.Println("nothing to archive")
+		}
+
+	case "verify":
+		segments, problems, err := repo.Verify(ctx)
+		if err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		for _, p := range problems {
+			fmt.Println("FAIL:", p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("OK: %d segments verified\n", len(segments))
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: archiver archive -org N -before YYYY-MM-DD [-segment-size N]")
+	fmt.Fprintln(os.Stderr, "       archiver verify -org N")
+	os.Exit(2)
+}
@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
+	"ledger-go-system/internal/archive"
 	"ledger-go-system/internal/auth"
+	"ledger-go-system/internal/calendar"
 	"ledger-go-system/internal/db"
+	"ledger-go-system/internal/fix"
 	"ledger-go-system/internal/handler"
+	"ledger-go-system/internal/jobs"
 	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+	"ledger-go-system/internal/storage"
+	"ledger-go-system/internal/validation"
+	"ledger-go-system/internal/webhook"
 )
 
 func main() {
@@ -37,15 +50,157 @@ func main() {
 	tlsCert := os.Getenv("TLS_CERT")
 	tlsKey := os.Getenv("TLS_KEY")
 
+	attachmentDir := os.Getenv("ATTACHMENT_DIR")
+	if attachmentDir == "" {
+		attachmentDir = "./data/attachments"
+	}
+
+	attachmentMaxBytes := int64(10 << 20)
+	if v := os.Getenv("ATTACHMENT_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid ATTACHMENT_MAX_BYTES: %q", v)
+		}
+		attachmentMaxBytes = n
+	}
+
+	archiveDir := os.Getenv("ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "./data/archive"
+	}
+
+	settlement := calendar.SettlementCycle{Calendar: calendar.New(), Lag: 1}
+	if v := os.Getenv("SETTLEMENT_LAG_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid SETTLEMENT_LAG_DAYS: %q", v)
+		}
+		settlement.Lag = n
+	}
+	if path := os.Getenv("SETTLEMENT_HOLIDAYS_FILE"); path != "" {
+		cal, err := calendar.LoadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to load settlement holidays: %v", err)
+		}
+		settlement.Calendar = cal
+	}
+
+	var validationRules *validation.Set
+	if path := os.Getenv("VALIDATION_RULES_FILE"); path != "" {
+		var err error
+		if validationRules, err = validation.LoadFile(path); err != nil {
+			log.Fatalf("Failed to load validation rules: %v", err)
+		}
+		log.Printf("Loaded %d validation rules from %s", validationRules.Len(), path)
+
+		// Reload on SIGHUP so policy changes need no restart
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := validationRules.Reload(); err != nil {
+					log.Printf("Failed to reload validation rules, keeping the previous ones: %v", err)
+					continue
+				}
+				log.Printf("Reloaded %d validation rules from %s", validationRules.Len(), path)
+			}
+		}()
+	}
+
+	fixListenAddr := os.Getenv("FIX_LISTEN_ADDR")
+	fixConfig := fix.Config{
+		CompID: envOrDefault("FIX_COMP_ID", "LEDGER"),
+		OrgID:  1,
+		Accounts: fix.Accounts{
+			Cash:        envOrDefault("FIX_CASH_ACCOUNT", "Assets:Cash"),
+			Positions:   envOrDefault("FIX_POSITION_ACCOUNT", "Assets:Positions"),
+			Fees:        envOrDefault("FIX_FEE_ACCOUNT", "Expenses:Commissions"),
+			RealizedPnL: envOrDefault("FIX_REALIZED_PNL_ACCOUNT", "Income:RealizedPnL"),
+		},
+		LotMethod:  envOrDefault("FIX_LOT_METHOD", "fifo"),
+		Settlement: settlement,
+	}
+	if v := os.Getenv("FIX_ORG_ID"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid FIX_ORG_ID: %q", v)
+		}
+		fixConfig.OrgID = n
+	}
+	switch fixConfig.LotMethod {
+	case "fifo", "lifo":
+	default:
+		log.Fatalf("Invalid FIX_LOT_METHOD: %q (use fifo or lifo)", fixConfig.LotMethod)
+	}
+	if v := os.Getenv("FIX_ALLOWED_COMP_IDS"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				fixConfig.AllowedCompIDs = append(fixConfig.AllowedCompIDs, id)
+			}
+		}
+	}
+	// Any client that reaches the port could otherwise book trades
+	if fixListenAddr != "" && len(fixConfig.AllowedCompIDs) == 0 {
+		log.Fatal("FIX_ALLOWED_COMP_IDS must list the counterparties allowed to log on when FIX_LISTEN_ADDR is set")
+	}
+
+	revaluationAccounts := repository.RevaluationAccounts{
+		Revaluation:   envOrDefault("MTM_REVALUATION_ACCOUNT", "Assets:Revaluation"),
+		UnrealizedPnL: envOrDefault("MTM_UNREALIZED_PNL_ACCOUNT", "Income:UnrealizedPnL"),
+	}
+	mtmOrgIDs := envOrgIDs("MTM_ORG_IDS")
+	mtmBook := os.Getenv("MTM_BOOK_ENTRIES") == "true"
+	interestOrgIDs := envOrgIDs("INTEREST_ORG_IDS")
+	deferralOrgIDs := envOrgIDs("DEFERRAL_ORG_IDS")
+	budgetOrgIDs := envOrgIDs("BUDGET_ORG_IDS")
+	budgetWebhookURL := os.Getenv("BUDGET_WEBHOOK_URL")
+
+	attachmentTypes := []string{"application/pdf", "image/png", "image/jpeg", "text/plain"}
+	if v := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); v != "" {
+		attachmentTypes = strings.Split(v, ",")
+		for i := range attachmentTypes {
+			attachmentTypes[i] = strings.TrimSpace(attachmentTypes[i])
+		}
+	}
+
 	conn, err := db.New(dsn)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer conn.Close()
 
+	attachmentStore, err := storage.NewLocalStorage(attachmentDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize attachment storage: %v", err)
+	}
+
+	archiveStore, err := archive.NewStore(archiveDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize archive store: %v", err)
+	}
+
 	authManager := auth.NewAuthManager(jwtSecret)
 	userRepository := auth.NewUserRepository(conn)
-	ledgerHandler := handler.NewLedgerHandler(conn)
+	var validators []repository.Validator
+	if validationRules != nil {
+		validators = append(validators, validationRules)
+	}
+	ledgerHandler := handler.NewLedgerHandler(conn, archiveStore, settlement, validators...)
+	positionHandler := handler.NewPositionHandler(conn)
+	valuationHandler := handler.NewValuationHandler(conn, revaluationAccounts, validators...)
+	settlementHandler := handler.NewSettlementHandler(conn, archiveStore, settlement.Calendar)
+	counterpartyHandler := handler.NewCounterpartyHandler(conn)
+	interestHandler := handler.NewInterestHandler(conn, validators...)
+	deferralHandler := handler.NewDeferralHandler(conn, validators...)
+	consolidationHandler := handler.NewConsolidationHandler(conn)
+	taxHandler := handler.NewTaxHandler(conn)
+	bookHandler := handler.NewBookHandler(conn)
+	accountHandler := handler.NewAccountHandler(conn)
+	dimensionHandler := handler.NewDimensionHandler(conn)
+	budgetHandler := handler.NewBudgetHandler(conn)
+	categorizationHandler := handler.NewCategorizationHandler(conn)
+	anomalyHandler := handler.NewAnomalyHandler(conn, validators...)
+	attachmentHandler := handler.NewAttachmentHandler(conn, attachmentStore, attachmentMaxBytes, attachmentTypes)
 	authHandler := handler.NewAuthHandler(authManager, userRepository)
 	refreshHandler := handler.NewRefreshHandler(authManager, userRepository)
 	rateLimiter := middleware.NewRateLimiter(conn)
@@ -64,6 +219,73 @@ func main() {
 		}
 	}()
 
+	// Trade capture from FIX execution reports, when enabled
+	if fixListenAddr != "" {
+		acceptor := fix.NewAcceptor(conn, fixConfig, validators...)
+		go func() {
+			if err := acceptor.ListenAndServe(fixListenAddr); err != nil {
+				log.Fatalf("FIX acceptor error: %v", err)
+			}
+		}()
+	}
+
+	// Daily mark-to-market of the previous day, when enabled
+	if len(mtmOrgIDs) > 0 {
+		valuations := repository.NewValuationRepository(conn, validators...)
+		jobs.Daily{
+			Name:   "mark-to-market",
+			OrgIDs: mtmOrgIDs,
+			Run: func(ctx context.Context, date time.Time) error {
+				var accounts *repository.RevaluationAccounts
+				if mtmBook {
+					accounts = &revaluationAccounts
+				}
+				_, _, err := valuations.MarkToMarket(ctx, date, accounts, "mtm-job")
+				return err
+			},
+		}.Start(1 * time.Hour)
+	}
+
+	// Daily interest accrual through the previous day, when enabled
+	if len(interestOrgIDs) > 0 {
+		interest := repository.NewInterestRepository(conn, validators...)
+		jobs.Daily{
+			Name:   "interest-accrual",
+			OrgIDs: interestOrgIDs,
+			Run: func(ctx context.Context, date time.Time) error {
+				_, err := interest.Accrue(ctx, date, "interest-job")
+				return err
+			},
+		}.Start(1 * time.Hour)
+	}
+
+	// Daily release of deferral lines recognized through the previous day, when enabled
+	if len(deferralOrgIDs) > 0 {
+		deferrals := repository.NewDeferralRepository(conn, validators...)
+		jobs.Daily{
+			Name:   "deferral-release",
+			OrgIDs: deferralOrgIDs,
+			Run: func(ctx context.Context, date time.Time) error {
+				_, err := deferrals.Release(ctx, date, "deferral-job")
+				return err
+			},
+		}.Start(1 * time.Hour)
+	}
+
+	// Budget alert delivery to the webhook every minute, when enabled
+	if len(budgetOrgIDs) > 0 && budgetWebhookURL != "" {
+		budgets := repository.NewBudgetRepository(conn)
+		hook := webhook.New(budgetWebhookURL)
+		jobs.Periodic{
+			Name:   "budget-alerts",
+			OrgIDs: budgetOrgIDs,
+			Run: func(ctx context.Context) error {
+				_, err := budgets.DeliverAlerts(ctx, hook.Post)
+				return err
+			},
+		}.Start(1 * time.Minute)
+	}
+
 	mux := http.NewServeMux()
 
 	// Apply rate limiting to all endpoints
@@ -75,13 +297,118 @@ func main() {
 	mux.HandleFunc("POST /auth/logout", refreshHandler.RevokeRefreshToken)
 
 	// Protected endpoints (require JWT)
-	// Admin only: POST /ledger
+	// Admin only: POST /ledger, POST /ledger/import
 	mux.Handle("POST /ledger", middleware.RequireRole("admin", authManager, http.HandlerFunc(ledgerHandler.Create)))
+	mux.Handle("POST /ledger/import", middleware.RequireRole("admin", authManager, http.HandlerFunc(ledgerHandler.Import)))
 
-	// Both admin and viewer: GET /ledger, GET /ledger/{id}
+	// Both admin and viewer: GET /ledger, GET /ledger/{id}, GET /ledger/export,
+	// GET /ledger/search, GET /ledger/stats, GET /ledger/positions, GET /ledger/lots
 	mux.Handle("GET /ledger", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.List)))
+	mux.Handle("GET /ledger/export", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.Export)))
+	mux.Handle("GET /ledger/search", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.Search)))
+	mux.Handle("GET /ledger/stats", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.Stats)))
+	mux.Handle("GET /ledger/positions", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(positionHandler.Positions)))
+	mux.Handle("GET /ledger/lots", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(positionHandler.Lots)))
 	mux.Handle("GET /ledger/", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.GetByID)))
 
+	// Both admin and viewer: POST /ledger/query (read-only)
+	mux.Handle("POST /ledger/query", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(ledgerHandler.Query)))
+
+	// Instruments: admin registers, admin and viewer can list
+	mux.Handle("POST /ledger/instruments", middleware.RequireRole("admin", authManager, http.HandlerFunc(positionHandler.CreateInstrument)))
+	mux.Handle("GET /ledger/instruments", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(positionHandler.ListInstruments)))
+
+	// Prices & valuation: admin loads prices and runs valuations, admin and viewer can read
+	mux.Handle("POST /ledger/prices", middleware.RequireRole("admin", authManager, http.HandlerFunc(valuationHandler.UploadPrices)))
+	mux.Handle("GET /ledger/prices", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(valuationHandler.ListPrices)))
+	mux.Handle("GET /ledger/valuation", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(valuationHandler.Valuation)))
+	mux.Handle("GET /ledger/valuation/runs", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(valuationHandler.ListRuns)))
+	mux.Handle("POST /ledger/valuation/runs", middleware.RequireRole("admin", authManager, http.HandlerFunc(valuationHandler.RunMarkToMarket)))
+
+	// Settlement: admin records settled/failed, admin and viewer can read status and aging
+	mux.Handle("GET /ledger/settlements", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(settlementHandler.Aging)))
+	mux.Handle("POST /ledger/{id}/settlement", middleware.RequireRole("admin", authManager, http.HandlerFunc(settlementHandler.Transition)))
+	mux.Handle("GET /ledger/{id}/settlement", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(settlementHandler.Get)))
+
+	// Counterparties: admin maintains master data and limits, admin and viewer can read exposure
+	mux.Handle("POST /ledger/counterparties", middleware.RequireRole("admin", authManager, http.HandlerFunc(counterpartyHandler.Create)))
+	mux.Handle("GET /ledger/counterparties", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(counterpartyHandler.List)))
+	mux.Handle("GET /ledger/counterparties/exposure", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(counterpartyHandler.Exposure)))
+	mux.Handle("POST /ledger/counterparties/{id}/limit", middleware.RequireRole("admin", authManager, http.HandlerFunc(counterpartyHandler.SetLimit)))
+
+	// Interest: admin maintains schedules and runs accruals, admin and viewer can read
+	mux.Handle("POST /ledger/interest/schedules", middleware.RequireRole("admin", authManager, http.HandlerFunc(interestHandler.CreateSchedule)))
+	mux.Handle("GET /ledger/interest/schedules", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(interestHandler.ListSchedules)))
+	mux.Handle("GET /ledger/interest/accruals", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(interestHandler.ListAccruals)))
+	mux.Handle("POST /ledger/interest/accruals", middleware.RequireRole("admin", authManager, http.HandlerFunc(interestHandler.Accrue)))
+
+	// Deferrals: admin attaches schedules and releases, admin and viewer can read
+	mux.Handle("POST /ledger/{id}/deferral", middleware.RequireRole("admin", authManager, http.HandlerFunc(deferralHandler.Create)))
+	mux.Handle("GET /ledger/{id}/deferral", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(deferralHandler.Get)))
+	mux.Handle("POST /ledger/deferrals/releases", middleware.RequireRole("admin", authManager, http.HandlerFunc(deferralHandler.Release)))
+	mux.Handle("GET /ledger/deferrals/waterfall", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(deferralHandler.Waterfall)))
+
+	// Trial balance & consolidation: admin maintains groups, tags and rates, admin and viewer can report
+	mux.Handle("GET /ledger/trial-balance", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(consolidationHandler.TrialBalance)))
+	mux.Handle("POST /ledger/consolidation/groups", middleware.RequireRole("admin", authManager, http.HandlerFunc(consolidationHandler.CreateGroup)))
+	mux.Handle("GET /ledger/consolidation/groups", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(consolidationHandler.ListGroups)))
+	mux.Handle("POST /ledger/consolidation/groups/{id}/members", middleware.RequireRole("admin", authManager, http.HandlerFunc(consolidationHandler.AddMember)))
+	mux.Handle("GET /ledger/consolidation/groups/{id}/trial-balance", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(consolidationHandler.Consolidate)))
+	mux.Handle("POST /ledger/intercompany-accounts", middleware.RequireRole("admin", authManager, http.HandlerFunc(consolidationHandler.TagIntercompany)))
+	mux.Handle("GET /ledger/intercompany-accounts", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(consolidationHandler.ListIntercompany)))
+	mux.Handle("POST /ledger/fx-rates", middleware.RequireRole("admin", authManager, http.HandlerFunc(consolidationHandler.AddRates)))
+	mux.Handle("GET /ledger/fx-rates", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(consolidationHandler.ListRates)))
+
+	// Tax: admin maintains codes and rates, admin and viewer can read codes and returns
+	mux.Handle("POST /ledger/tax/codes", middleware.RequireRole("admin", authManager, http.HandlerFunc(taxHandler.CreateCode)))
+	mux.Handle("GET /ledger/tax/codes", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(taxHandler.ListCodes)))
+	mux.Handle("POST /ledger/tax/codes/{code}/rates", middleware.RequireRole("admin", authManager, http.HandlerFunc(taxHandler.AddRate)))
+	mux.Handle("GET /ledger/tax/return", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(taxHandler.Return)))
+
+	// Books: admin registers books, admin and viewer can see them and their last voucher numbers
+	mux.Handle("POST /ledger/books", middleware.RequireRole("admin", authManager, http.HandlerFunc(bookHandler.Create)))
+	mux.Handle("GET /ledger/books", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(bookHandler.List)))
+
+	// Chart of accounts: admin maintains it, admin and viewer can read it and its rolled-up balances
+	mux.Handle("POST /ledger/accounts", middleware.RequireRole("admin", authManager, http.HandlerFunc(accountHandler.Create)))
+	mux.Handle("GET /ledger/accounts", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(accountHandler.List)))
+	mux.Handle("GET /ledger/accounts/tree", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(accountHandler.Tree)))
+	mux.Handle("PATCH /ledger/accounts/{id}", middleware.RequireRole("admin", authManager, http.HandlerFunc(accountHandler.Update)))
+	mux.Handle("DELETE /ledger/accounts/{id}", middleware.RequireRole("admin", authManager, http.HandlerFunc(accountHandler.Delete)))
+
+	// Dimensions: admin configures dimensions, values and account rules, admin and viewer can read them and report
+	mux.Handle("POST /ledger/dimensions", middleware.RequireRole("admin", authManager, http.HandlerFunc(dimensionHandler.Create)))
+	mux.Handle("GET /ledger/dimensions", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(dimensionHandler.List)))
+	mux.Handle("POST /ledger/dimensions/{code}/values", middleware.RequireRole("admin", authManager, http.HandlerFunc(dimensionHandler.AddValue)))
+	mux.Handle("GET /ledger/dimensions/report", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(dimensionHandler.Report)))
+	mux.Handle("POST /ledger/dimension-rules", middleware.RequireRole("admin", authManager, http.HandlerFunc(dimensionHandler.SetRule)))
+	mux.Handle("GET /ledger/dimension-rules", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(dimensionHandler.ListRules)))
+	mux.Handle("DELETE /ledger/dimension-rules/{id}", middleware.RequireRole("admin", authManager, http.HandlerFunc(dimensionHandler.DeleteRule)))
+
+	// Budgets: admin sets budgets, admin and viewer can read variance and alerts
+	mux.Handle("POST /ledger/budgets", middleware.RequireRole("admin", authManager, http.HandlerFunc(budgetHandler.Create)))
+	mux.Handle("GET /ledger/budgets", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(budgetHandler.List)))
+	mux.Handle("GET /ledger/budgets/variance", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(budgetHandler.Variance)))
+	mux.Handle("GET /ledger/budgets/alerts", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(budgetHandler.Alerts)))
+
+	// Categorization rules: admin defines rules, admin and viewer can list and test them
+	mux.Handle("POST /ledger/categorization-rules", middleware.RequireRole("admin", authManager, http.HandlerFunc(categorizationHandler.Create)))
+	mux.Handle("GET /ledger/categorization-rules", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(categorizationHandler.List)))
+	mux.Handle("DELETE /ledger/categorization-rules/{id}", middleware.RequireRole("admin", authManager, http.HandlerFunc(categorizationHandler.Delete)))
+	mux.Handle("POST /ledger/categorization-rules/test", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(categorizationHandler.Test)))
+
+	// Anomalies: admin sets screening and reviews flagged or held entries, admin and viewer can read them
+	mux.Handle("GET /ledger/anomalies", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(anomalyHandler.List)))
+	mux.Handle("GET /ledger/anomalies/settings", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(anomalyHandler.Settings)))
+	mux.Handle("POST /ledger/anomalies/settings", middleware.RequireRole("admin", authManager, http.HandlerFunc(anomalyHandler.SetSettings)))
+	mux.Handle("POST /ledger/anomalies/{id}/approve", middleware.RequireRole("admin", authManager, http.HandlerFunc(anomalyHandler.Approve)))
+	mux.Handle("POST /ledger/anomalies/{id}/reject", middleware.RequireRole("admin", authManager, http.HandlerFunc(anomalyHandler.Reject)))
+
+	// Attachments: admin uploads, admin and viewer can list and download
+	mux.Handle("POST /ledger/{id}/attachments", middleware.RequireRole("admin", authManager, http.HandlerFunc(attachmentHandler.Upload)))
+	mux.Handle("GET /ledger/{id}/attachments", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(attachmentHandler.List)))
+	mux.Handle("GET /ledger/{id}/attachments/{attachmentID}", middleware.AllowRoles([]string{"admin", "viewer"}, authManager, http.HandlerFunc(attachmentHandler.Download)))
+
 	server := &http.Server{
 		Addr:         ":" + port,
 		Handler:      rateLimitedMux,
@@ -104,3 +431,28 @@ func main() {
 		}
 	}
 }
+
+// envOrDefault returns the environment variable key, or def when it is unset
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envOrgIDs parses a comma-separated list of organization ids; unset means none
+func envOrgIDs(key string) []int {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	var ids []int
+	for _, part := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid %s: %q", key, v)
+		}
+		ids = append(ids, n)
+	}
+	return ids
+}
@@ -0,0 +1,133 @@
+// Command valuation loads price files and runs mark-to-market valuations.
+//
+// Usage:
+//
+//	valuation load -org 1 prices/2026-03-31.csv [more files...]
+//	valuation run -org 1 -date 2026-03-31 [-book]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"ledger-go-system/internal/db"
+	"ledger-go-system/internal/identity"
+	"ledger-go-system/internal/prices"
+	"ledger-go-system/internal/repository"
+	"ledger-go-system/internal/validation"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("DATABASE_URL not set")
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	orgID := fs.Int("org", 0, "organization to load prices into or value")
+	date := fs.String("date", "", "valuation date (YYYY-MM-DD)")
+	book := fs.Bool("book", false, "post the change in unrealized P&L as a revaluation entry")
+	fs.Parse(os.Args[2:])
+
+	if *orgID <= 0 {
+		log.Fatal("-org is required")
+	}
+
+	conn, err := db.New(dsn)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	// A booked revaluation is held to the same rules as the server's entries
+	var validators []repository.Validator
+	if path := os.Getenv("VALIDATION_RULES_FILE"); path != "" {
+		rules, err := validation.LoadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to load validation rules: %v", err)
+		}
+		validators = append(validators, rules)
+	}
+
+	repo := repository.NewValuationRepository(conn, validators...)
+
+	// The tool acts as an admin of the organization it was pointed at
+	ctx := identity.NewContext(context.Background(), identity.Identity{Role: "admin", OrgID: *orgID})
+
+	switch os.Args[1] {
+	case "load":
+		if fs.NArg() == 0 {
+			log.Fatal("no price files given")
+		}
+		for _, path := range fs.Args() {
+			ps, err := prices.ParseFile(path)
+			if err != nil {
+				log.Fatalf("Failed to read prices: %v", err)
+			}
+			n, err := repo.AddPrices(ctx, ps, "file:"+filepath.Base(path))
+			if err != nil {
+				log.Fatalf("Failed to load %s: %v", path, err)
+			}
+			fmt.Printf("%s: %d prices loaded\n", path, n)
+		}
+
+	case "run":
+		d, err := time.Parse("2006-01-02", *date)
+		if err != nil {
+			log.Fatal("-date must be a YYYY-MM-DD date")
+		}
+
+		var accounts *repository.RevaluationAccounts
+		if *book {
+			accounts = &repository.RevaluationAccounts{
+				Revaluation:   envOrDefault("MTM_REVALUATION_ACCOUNT", "Assets:Revaluation"),
+				UnrealizedPnL: envOrDefault("MTM_UNREALIZED_PNL_ACCOUNT", "Income:UnrealizedPnL"),
+			}
+		}
+
+		run, created, err := repo.MarkToMarket(ctx, d, accounts, "valuation")
+		if err != nil {
+			log.Fatalf("Valuation failed: %v", err)
+		}
+		if !created {
+			fmt.Printf("%s already valued (run %d)\n", *date, run.ID)
+		}
+		fmt.Printf("%s: market value %.2f, cost %.2f, unrealized P&L %.2f, %d unpriced\n",
+			*date, run.MarketValue, run.CostBasis, run.UnrealizedPnL, run.Unpriced)
+		if run.LedgerID != nil {
+			fmt.Printf("revaluation booked as ledger entry %d\n", *run.LedgerID)
+		}
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: valuation load -org N FILE...")
+	fmt.Fprintln(os.Stderr, "       valuation run -org N -date YYYY-MM-DD [-book]")
+	os.Exit(2)
+}
+
+// envOrDefault returns the environment variable key, or def when it is unset
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
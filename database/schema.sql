@@ -1,3 +1,20 @@
+-- Session variables published per transaction by repository.beginScopedTx.
+-- Unset variables read as NULL, which matches no row-level security policy.
+CREATE OR REPLACE FUNCTION app_org_id() RETURNS INTEGER
+    LANGUAGE sql STABLE
+    AS $$ SELECT NULLIF(current_setting('app.org_id', true), '')::INTEGER $$;
+
+CREATE OR REPLACE FUNCTION app_user_id() RETURNS INTEGER
+    LANGUAGE sql STABLE
+    AS $$ SELECT NULLIF(current_setting('app.user_id', true), '')::INTEGER $$;
+
+-- Create organizations table: each legal entity is an isolated tenant
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Create users table for credential storage
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
@@ -7,6 +24,14 @@ CREATE TABLE IF NOT EXISTS users (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Create user_organizations table: users may belong to several organizations
+CREATE TABLE IF NOT EXISTS user_organizations (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, org_id)
+);
+
 -- Create refresh_tokens table
 CREATE TABLE IF NOT EXISTS refresh_tokens (
     id SERIAL PRIMARY KEY,
@@ -29,23 +54,743 @@ CREATE TABLE IF NOT EXISTS rate_limit_log (
 -- Create ledger table
 CREATE TABLE IF NOT EXISTS ledger (
     id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
     amount NUMERIC NOT NULL,
     description TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    trade_date DATE,
+    settlement_date DATE,
+    counterparty_id INTEGER,
+    book VARCHAR(16),
+    voucher_number VARCHAR(40),
+    metadata JSONB,
+    tags TEXT[],
+    rule_id INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Full-text search: description weighted above metadata values
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', description), 'A') ||
+        setweight(jsonb_to_tsvector('english', COALESCE(metadata, '{}'::jsonb), '["string"]'), 'B')
+    ) STORED,
+    CHECK (settlement_date IS NULL OR trade_date IS NULL OR settlement_date >= trade_date),
+    UNIQUE (org_id, voucher_number)
+);
+
+-- Create ledger_archive_segments table: one row per compressed segment file.
+-- chain_hash = sha256(prev_chain_hash || content_sha256) links segments in order.
+CREATE TABLE IF NOT EXISTS ledger_archive_segments (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    sequence INTEGER NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    first_ledger_id INTEGER NOT NULL,
+    last_ledger_id INTEGER NOT NULL,
+    entry_count INTEGER NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    content_sha256 CHAR(64) NOT NULL,
+    prev_chain_hash CHAR(64) NOT NULL,
+    chain_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, sequence)
+);
+
+-- Create ledger_archive_index table: where each archived entry now lives
+CREATE TABLE IF NOT EXISTS ledger_archive_index (
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    segment_id INTEGER NOT NULL REFERENCES ledger_archive_segments(id),
+    PRIMARY KEY (org_id, ledger_id)
 );
 
+-- Entries may be moved from ledger into archive segments, so tables that point
+-- at an entry check it exists (live or archived, in the same organization) with
+-- this trigger rather than a foreign key. Those tables are append-only, so a
+-- check on INSERT is sufficient.
+CREATE OR REPLACE FUNCTION check_ledger_entry() RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM ledger WHERE id = NEW.ledger_id AND org_id = NEW.org_id)
+       AND NOT EXISTS (SELECT 1 FROM ledger_archive_index WHERE ledger_id = NEW.ledger_id AND org_id = NEW.org_id) THEN
+        RAISE EXCEPTION 'ledger entry % does not exist in organization %', NEW.ledger_id, NEW.org_id
+            USING ERRCODE = 'foreign_key_violation';
+    END IF;
+    RETURN NEW;
+END
+$$;
+
 -- Create audit_ledger table for immutability tracking
+-- check_ledger_entry guarantees an audit row belongs to the same tenant as its entry;
+-- user_id is stamped by the database from the authenticated session, not by the app
 CREATE TABLE IF NOT EXISTS audit_ledger (
     id SERIAL PRIMARY KEY,
-    ledger_id INTEGER NOT NULL REFERENCES ledger(id),
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
     actor VARCHAR(50) NOT NULL,
     action VARCHAR(50) NOT NULL,
+    user_id INTEGER DEFAULT app_user_id(),
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Create ledger_attachments table for receipts and trade confirmations
+-- Blobs live in the storage backend; the hash makes them tamper-evident
+CREATE TABLE IF NOT EXISTS ledger_attachments (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    uploaded_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create ledger_postings table: the legs of a balanced entry. Amounts are
+-- signed (debit positive, credit negative) and sum to zero per entry; legs
+-- that move a position also carry the instrument and quantity. Postings stay
+-- here when their entry is archived, so balances span archived periods.
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    amount NUMERIC NOT NULL,
+    instrument VARCHAR(64),
+    quantity NUMERIC,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create fix_sessions table: sequence numbers per FIX counterparty session
+CREATE TABLE IF NOT EXISTS fix_sessions (
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    local_comp_id VARCHAR(64) NOT NULL,
+    remote_comp_id VARCHAR(64) NOT NULL,
+    next_incoming_seq INTEGER NOT NULL DEFAULT 1,
+    next_outgoing_seq INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, local_comp_id, remote_comp_id)
+);
+
+-- Create fix_executions table: one row per booked fill. The unique ExecID per
+-- counterparty is what stops replayed execution reports from double-booking.
+CREATE TABLE IF NOT EXISTS fix_executions (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    local_comp_id VARCHAR(64) NOT NULL,
+    remote_comp_id VARCHAR(64) NOT NULL,
+    msg_seq_num INTEGER NOT NULL,
+    exec_id VARCHAR(128) NOT NULL,
+    order_id VARCHAR(128),
+    symbol VARCHAR(64) NOT NULL,
+    side CHAR(1) NOT NULL,
+    quantity NUMERIC NOT NULL,
+    price NUMERIC NOT NULL,
+    commission NUMERIC NOT NULL DEFAULT 0,
+    currency CHAR(3),
+    transact_time TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, remote_comp_id, exec_id)
+);
+
+-- Create instruments table: the securities that quantity-bearing postings
+-- refer to. Instruments are registered on first use; admins may describe
+-- them up front.
+CREATE TABLE IF NOT EXISTS instruments (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    symbol VARCHAR(64) NOT NULL,
+    name VARCHAR(255),
+    asset_class VARCHAR(50),
+    currency CHAR(3),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, symbol)
+);
+
+-- Create lots table: one row per acquisition of an instrument into an
+-- account. Lots are never updated; the open quantity is the lot quantity
+-- less the quantity recorded against it in lot_closures.
+CREATE TABLE IF NOT EXISTS lots (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    instrument VARCHAR(64) NOT NULL,
+    quantity NUMERIC NOT NULL CHECK (quantity > 0),
+    cost_basis NUMERIC NOT NULL CHECK (cost_basis >= 0),
+    opened_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create lot_closures table: the part of a lot consumed by a sale, with the
+-- cost relieved, the proceeds allocated to it and the entry that booked the
+-- realized P&L
+CREATE TABLE IF NOT EXISTS lot_closures (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    lot_id INTEGER NOT NULL REFERENCES lots(id),
+    ledger_id INTEGER NOT NULL,
+    pnl_ledger_id INTEGER,
+    method VARCHAR(10) NOT NULL,
+    quantity NUMERIC NOT NULL CHECK (quantity > 0),
+    cost NUMERIC NOT NULL,
+    proceeds NUMERIC NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create prices table: end-of-day prices loaded from price files or the API.
+-- Append-only; the latest row for an instrument and date is the one used.
+CREATE TABLE IF NOT EXISTS prices (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    instrument VARCHAR(64) NOT NULL,
+    price_date DATE NOT NULL,
+    price NUMERIC NOT NULL CHECK (price > 0),
+    currency CHAR(3),
+    source VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create valuation_runs table: one mark-to-market run per organization and
+-- day, which is what makes the daily job safe to rerun. booked_unrealized_pnl
+-- is the cumulative revaluation posted to the ledger, NULL for report-only runs.
+CREATE TABLE IF NOT EXISTS valuation_runs (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    valuation_date DATE NOT NULL,
+    market_value NUMERIC NOT NULL,
+    cost_basis NUMERIC NOT NULL,
+    unrealized_pnl NUMERIC NOT NULL,
+    unpriced INTEGER NOT NULL DEFAULT 0,
+    booked_unrealized_pnl NUMERIC,
+    ledger_id INTEGER,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, valuation_date)
+);
+
+-- Create valuation_lines table: the per-position detail of a run
+CREATE TABLE IF NOT EXISTS valuation_lines (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    run_id INTEGER NOT NULL REFERENCES valuation_runs(id),
+    account VARCHAR(255) NOT NULL,
+    instrument VARCHAR(64) NOT NULL,
+    quantity NUMERIC NOT NULL,
+    cost_basis NUMERIC NOT NULL,
+    price NUMERIC,
+    price_date DATE,
+    market_value NUMERIC NOT NULL
+);
+
+-- Create settlement_events table: the append-only settlement lifecycle of
+-- entries booked with a settlement date (pending, then settled or failed).
+-- The current status is the latest row.
+CREATE TABLE IF NOT EXISTS settlement_events (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('pending', 'settled', 'failed')),
+    reason TEXT,
+    actor VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create counterparties table: master data for the other side of an entry.
+-- ledger.counterparty_id is checked against it in the posting path.
+CREATE TABLE IF NOT EXISTS counterparties (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    code VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    lei CHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+-- Create counterparty_limits table: exposure limits are appended, never
+-- edited; the latest row applies and a NULL limit means unlimited
+CREATE TABLE IF NOT EXISTS counterparty_limits (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    counterparty_id INTEGER NOT NULL REFERENCES counterparties(id),
+    exposure_limit NUMERIC CHECK (exposure_limit >= 0),
+    action VARCHAR(10) NOT NULL CHECK (action IN ('reject', 'flag')),
+    set_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create counterparty_limit_breaches table: entries let through over a limit set to flag
+CREATE TABLE IF NOT EXISTS counterparty_limit_breaches (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    counterparty_id INTEGER NOT NULL REFERENCES counterparties(id),
+    ledger_id INTEGER NOT NULL,
+    exposure NUMERIC NOT NULL,
+    exposure_limit NUMERIC NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create interest_schedules table: interest terms per account, appended and
+-- never edited. The schedule with the latest effective_from on or before a
+-- day applies to that day. Accruals post to accrued_account against
+-- interest_account and are capitalized back into the account at the end of
+-- each capitalization period.
+CREATE TABLE IF NOT EXISTS interest_schedules (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    account VARCHAR(255) NOT NULL,
+    annual_rate NUMERIC NOT NULL,
+    day_count VARCHAR(10) NOT NULL CHECK (day_count IN ('ACT/360', 'ACT/365', '30/360')),
+    accrued_account VARCHAR(255) NOT NULL,
+    interest_account VARCHAR(255) NOT NULL,
+    capitalization VARCHAR(10) NOT NULL DEFAULT 'none' CHECK (capitalization IN ('none', 'monthly', 'quarterly', 'annually')),
+    effective_from DATE NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (accrued_account <> account AND interest_account <> account)
+);
+
+-- Create interest_accruals table: one row per account and day, which is what
+-- makes accrual reruns safe. amount is the exact accrual; booked is what was
+-- posted, rounded so that the running total of booked tracks the running
+-- total of amount to the cent. ledger_id is NULL when nothing was posted.
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    schedule_id INTEGER NOT NULL REFERENCES interest_schedules(id),
+    account VARCHAR(255) NOT NULL,
+    accrual_date DATE NOT NULL,
+    balance NUMERIC NOT NULL,
+    annual_rate NUMERIC NOT NULL,
+    day_count VARCHAR(10) NOT NULL,
+    year_fraction NUMERIC NOT NULL,
+    amount NUMERIC NOT NULL,
+    booked NUMERIC NOT NULL,
+    ledger_id INTEGER,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, account, accrual_date)
+);
+
+-- Create interest_capitalizations table: one row per account and period end
+CREATE TABLE IF NOT EXISTS interest_capitalizations (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    account VARCHAR(255) NOT NULL,
+    period_end DATE NOT NULL,
+    amount NUMERIC NOT NULL,
+    ledger_id INTEGER,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, account, period_end)
+);
+
+-- Create deferral_schedules table: recognition of an entry's deferred
+-- amount over time. total is what the entry posted to deferred_account,
+-- negated: positive for deferred revenue (a credit balance), negative for a
+-- prepaid expense (a debit balance). Releases debit deferred_account and
+-- credit recognition_account by the signed line amount.
+CREATE TABLE IF NOT EXISTS deferral_schedules (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    deferred_account VARCHAR(255) NOT NULL,
+    recognition_account VARCHAR(255) NOT NULL,
+    method VARCHAR(20) NOT NULL CHECK (method IN ('straight_line', 'custom')),
+    total NUMERIC NOT NULL,
+    start_date DATE NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, ledger_id, deferred_account)
+);
+
+-- Create deferral_lines table: the amount of a schedule recognized on each date
+CREATE TABLE IF NOT EXISTS deferral_lines (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    schedule_id INTEGER NOT NULL REFERENCES deferral_schedules(id),
+    recognition_date DATE NOT NULL,
+    amount NUMERIC NOT NULL,
+    UNIQUE (schedule_id, recognition_date)
+);
+
+-- Create deferral_releases table: one row per released line, which is what
+-- makes the release job safe to rerun
+CREATE TABLE IF NOT EXISTS deferral_releases (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    line_id INTEGER NOT NULL UNIQUE REFERENCES deferral_lines(id),
+    ledger_id INTEGER NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create consolidation_groups table: a set of entities (organizations)
+-- reported together in the owning organization's presentation currency.
+-- Translation differences post to cta_account and intercompany differences
+-- within tolerance to difference_account.
+CREATE TABLE IF NOT EXISTS consolidation_groups (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    cta_account VARCHAR(255) NOT NULL,
+    difference_account VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, name)
+);
+
+-- Create consolidation_members table: the entities of a group and the
+-- functional currency their books are kept in
+CREATE TABLE IF NOT EXISTS consolidation_members (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    group_id INTEGER NOT NULL REFERENCES consolidation_groups(id),
+    member_org_id INTEGER NOT NULL REFERENCES organizations(id),
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (group_id, member_org_id)
+);
+
+-- Create intercompany_accounts table: accounts an entity uses for balances
+-- with another entity. Tags are kept by the entity that owns the account.
+CREATE TABLE IF NOT EXISTS intercompany_accounts (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    account VARCHAR(255) NOT NULL,
+    counterparty_org_id INTEGER NOT NULL REFERENCES organizations(id),
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, account)
+);
+
+-- Create fx_rates table: period rates used to translate entities, appended
+-- and never edited. The latest row for a pair, type and date applies.
+-- closing rates translate balance sheet accounts, average rates income and expenses.
+CREATE TABLE IF NOT EXISTS fx_rates (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    from_currency CHAR(3) NOT NULL,
+    to_currency CHAR(3) NOT NULL,
+    rate_date DATE NOT NULL,
+    rate_type VARCHAR(10) NOT NULL CHECK (rate_type IN ('closing', 'average')),
+    rate NUMERIC NOT NULL CHECK (rate > 0),
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create tax_codes table: VAT/GST codes and the account their tax posts to
+CREATE TABLE IF NOT EXISTS tax_codes (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    code VARCHAR(32) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    tax_account VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+-- Create tax_rates table: rates of a code, appended and never edited. The
+-- rate with the latest effective_from on or before an entry's tax date applies.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    tax_code_id INTEGER NOT NULL REFERENCES tax_codes(id),
+    rate NUMERIC NOT NULL CHECK (rate >= 0),
+    effective_from DATE NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create tax_lines table: the taxable base and tax generated for each
+-- posting made with a tax code, the source of tax returns
+CREATE TABLE IF NOT EXISTS tax_lines (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER NOT NULL,
+    tax_code_id INTEGER NOT NULL REFERENCES tax_codes(id),
+    account VARCHAR(255) NOT NULL,
+    tax_date DATE NOT NULL,
+    rate NUMERIC NOT NULL,
+    base NUMERIC NOT NULL,
+    tax NUMERIC NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create books table: journals entries are numbered in. A book's fiscal
+-- year starts on the first of fiscal_year_start_month and is named after the
+-- calendar year it ends in. The default JV book needs no row.
+CREATE TABLE IF NOT EXISTS books (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    code VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    fiscal_year_start_month INTEGER NOT NULL DEFAULT 1 CHECK (fiscal_year_start_month BETWEEN 1 AND 12),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+-- Create voucher_sequences table: the last voucher number issued per book
+-- and fiscal year. It is incremented inside the posting transaction, so the
+-- row lock serializes concurrent postings and a rollback returns the number.
+CREATE TABLE IF NOT EXISTS voucher_sequences (
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    book VARCHAR(16) NOT NULL,
+    fiscal_year INTEGER NOT NULL,
+    last_number INTEGER NOT NULL,
+    PRIMARY KEY (org_id, book, fiscal_year)
+);
+
+-- Create accounts table: the chart of accounts. code is the account name
+-- postings use; parent_id builds the tree that balances roll up along.
+-- Header accounts only group their children and cannot be posted to.
+CREATE TABLE IF NOT EXISTS accounts (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    code VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    parent_id INTEGER REFERENCES accounts(id),
+    header BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code),
+    CHECK (parent_id IS NULL OR parent_id <> id)
+);
+
+-- Create dimensions table: analytical dimensions such as desk, project or
+-- cost center that postings can be tagged with
+CREATE TABLE IF NOT EXISTS dimensions (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    code VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+-- Create dimension_values table: the values a dimension allows
+CREATE TABLE IF NOT EXISTS dimension_values (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    dimension_id INTEGER NOT NULL REFERENCES dimensions(id),
+    value VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, dimension_id, value)
+);
+
+-- Create dimension_rules table: which dimensions an account (and the accounts
+-- under it in the chart) requires or allows. Accounts without rules accept any
+-- dimension; accounts with rules only accept the dimensions they name.
+CREATE TABLE IF NOT EXISTS dimension_rules (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    account VARCHAR(255) NOT NULL,
+    dimension_id INTEGER NOT NULL REFERENCES dimensions(id),
+    rule VARCHAR(16) NOT NULL CHECK (rule IN ('required', 'optional')),
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, account, dimension_id)
+);
+
+-- Create posting_dimensions table: the dimension values of each posting.
+-- Like postings they are append-only and outlive archival of their entry.
+CREATE TABLE IF NOT EXISTS posting_dimensions (
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    posting_id INTEGER NOT NULL REFERENCES ledger_postings(id),
+    dimension_id INTEGER NOT NULL REFERENCES dimensions(id),
+    value_id INTEGER NOT NULL REFERENCES dimension_values(id),
+    PRIMARY KEY (posting_id, dimension_id)
+);
+
+-- Create budgets table: a budgeted amount for an account (and the accounts
+-- under it in the chart) over a period, signed like postings. Thresholds are
+-- percentages of the budget that raise an alert when actuals cross them.
+CREATE TABLE IF NOT EXISTS budgets (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount <> 0),
+    thresholds NUMERIC[] NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (period_end >= period_start)
+);
+
+-- Create budget_dimensions table: dimension values a budget is narrowed to
+CREATE TABLE IF NOT EXISTS budget_dimensions (
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    budget_id INTEGER NOT NULL REFERENCES budgets(id),
+    dimension_id INTEGER NOT NULL REFERENCES dimensions(id),
+    value_id INTEGER NOT NULL REFERENCES dimension_values(id),
+    PRIMARY KEY (budget_id, dimension_id)
+);
+
+-- Create budget_alerts table: one row per threshold a budget's actuals have
+-- crossed, raised by the entry that crossed it. Rows double as the webhook
+-- outbox: delivered_at is set once the webhook has accepted the alert.
+CREATE TABLE IF NOT EXISTS budget_alerts (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    budget_id INTEGER NOT NULL REFERENCES budgets(id),
+    threshold NUMERIC NOT NULL,
+    actual NUMERIC NOT NULL,
+    ledger_id INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, budget_id, threshold)
+);
+
+-- Create categorization_rules table: conditions matched against entries as
+-- they are created, lowest priority first, and the account, tags and
+-- dimensions the first matching rule assigns. Empty conditions match anything.
+CREATE TABLE IF NOT EXISTS categorization_rules (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 100,
+    description_pattern TEXT,
+    min_amount NUMERIC,
+    max_amount NUMERIC,
+    metadata JSONB,
+    account VARCHAR(255),
+    tags TEXT[],
+    dimensions JSONB,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (min_amount IS NULL OR max_amount IS NULL OR max_amount >= min_amount)
+);
+
+-- Create anomaly_settings table: how new entries are screened, latest row
+-- wins. Entries matching one posted within duplicate_window_seconds, or with
+-- a posting outlier_factor times the median of its account's recent postings
+-- (once the account has min_history of them), are flagged after posting or
+-- held for approval before it.
+CREATE TABLE IF NOT EXISTS anomaly_settings (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    duplicate_window_seconds INTEGER NOT NULL CHECK (duplicate_window_seconds >= 0),
+    outlier_factor NUMERIC NOT NULL CHECK (outlier_factor > 1),
+    min_history INTEGER NOT NULL CHECK (min_history >= 1),
+    action VARCHAR(10) NOT NULL CHECK (action IN ('flag', 'hold')),
+    set_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create anomalies table: suspicious entries awaiting or given review. A
+-- flagged entry is already posted (ledger_id); a held one is kept as JSON in
+-- entry and only posted, filling in ledger_id, when approved.
+CREATE TABLE IF NOT EXISTS anomalies (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id),
+    ledger_id INTEGER,
+    entry JSONB,
+    reasons JSONB NOT NULL,
+    score NUMERIC NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('flagged', 'held', 'approved', 'rejected')),
+    created_by VARCHAR(50) NOT NULL,
+    reviewed_by VARCHAR(50),
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ledger_id IS NOT NULL OR entry IS NOT NULL)
+);
+
+CREATE TRIGGER audit_ledger_entry_exists BEFORE INSERT ON audit_ledger
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+CREATE TRIGGER ledger_attachments_entry_exists BEFORE INSERT ON ledger_attachments
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER ledger_postings_entry_exists BEFORE INSERT ON ledger_postings
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+CREATE TRIGGER fix_executions_entry_exists BEFORE INSERT ON fix_executions
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER lots_entry_exists BEFORE INSERT ON lots
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+CREATE TRIGGER lot_closures_entry_exists BEFORE INSERT ON lot_closures
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER settlement_events_entry_exists BEFORE INSERT ON settlement_events
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER counterparty_limit_breaches_entry_exists BEFORE INSERT ON counterparty_limit_breaches
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER deferral_schedules_entry_exists BEFORE INSERT ON deferral_schedules
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+CREATE TRIGGER deferral_releases_entry_exists BEFORE INSERT ON deferral_releases
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER tax_lines_entry_exists BEFORE INSERT ON tax_lines
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER budget_alerts_entry_exists BEFORE INSERT ON budget_alerts
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry();
+
+CREATE TRIGGER anomalies_entry_exists BEFORE INSERT ON anomalies
+    FOR EACH ROW WHEN (NEW.ledger_id IS NOT NULL) EXECUTE FUNCTION check_ledger_entry();
+
+-- Archival is the only path that removes rows from ledger. The function runs
+-- as the schema owner, and only deletes entries of the caller's organization
+-- that are already recorded in the archive index for the given segment. FORCE
+-- -- ROW LEVEL SECURITY applies to the owner as well, so the ledger_archiver_*
+-- and ledger_archive_index_archiver policies below, created for the role that
+-- runs this file, let it do so. Run the schema as the role that owns it.
+CREATE OR REPLACE FUNCTION archive_ledger_segment(p_segment_id INTEGER) RETURNS INTEGER
+    LANGUAGE plpgsql SECURITY DEFINER SET search_path = public
+    AS $$
+DECLARE
+    moved INTEGER;
+BEGIN
+    DELETE FROM ledger l
+    USING ledger_archive_index i
+    WHERE i.segment_id = p_segment_id
+      AND i.org_id = app_org_id()
+      AND l.id = i.ledger_id
+      AND l.org_id = i.org_id;
+    GET DIAGNOSTICS moved = ROW_COUNT;
+    RETURN moved;
+END
+$$;
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_audit_ledger_id ON audit_ledger(ledger_id);
+CREATE INDEX IF NOT EXISTS idx_audit_ledger_org_id ON audit_ledger(org_id);
+CREATE INDEX IF NOT EXISTS idx_audit_ledger_history ON audit_ledger(org_id, ledger_id, timestamp);
 CREATE INDEX IF NOT EXISTS idx_ledger_created_at ON ledger(created_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_org_id ON ledger(org_id, id);
+CREATE INDEX IF NOT EXISTS idx_ledger_attachments_ledger_id ON ledger_attachments(org_id, ledger_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_archive_index_segment ON ledger_archive_index(segment_id);
+CREATE INDEX IF NOT EXISTS idx_user_organizations_org_id ON user_organizations(org_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_ledger_id ON ledger_postings(org_id, ledger_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account ON ledger_postings(org_id, account);
+CREATE INDEX IF NOT EXISTS idx_lots_position ON lots(org_id, account, instrument, id);
+CREATE INDEX IF NOT EXISTS idx_lot_closures_lot_id ON lot_closures(org_id, lot_id);
+CREATE INDEX IF NOT EXISTS idx_prices_lookup ON prices(org_id, instrument, price_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_valuation_lines_run_id ON valuation_lines(org_id, run_id);
+CREATE INDEX IF NOT EXISTS idx_settlement_events_ledger_id ON settlement_events(org_id, ledger_id, id);
+CREATE INDEX IF NOT EXISTS idx_ledger_settlement_date ON ledger(org_id, settlement_date) WHERE settlement_date IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_ledger_counterparty_id ON ledger(org_id, counterparty_id) WHERE counterparty_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_counterparty_limits_lookup ON counterparty_limits(org_id, counterparty_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_interest_schedules_account ON interest_schedules(org_id, account, effective_from);
+CREATE INDEX IF NOT EXISTS idx_deferral_lines_due ON deferral_lines(org_id, recognition_date);
+CREATE INDEX IF NOT EXISTS idx_fx_rates_lookup ON fx_rates(org_id, from_currency, to_currency, rate_type, rate_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tax_rates_lookup ON tax_rates(org_id, tax_code_id, effective_from DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tax_lines_date ON tax_lines(org_id, tax_date);
+CREATE INDEX IF NOT EXISTS idx_accounts_parent ON accounts(org_id, parent_id);
+CREATE INDEX IF NOT EXISTS idx_posting_dimensions_value ON posting_dimensions(org_id, dimension_id, value_id);
+CREATE INDEX IF NOT EXISTS idx_budgets_period ON budgets(org_id, period_start, period_end);
+CREATE INDEX IF NOT EXISTS idx_budget_alerts_pending ON budget_alerts(org_id) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_categorization_rules_priority ON categorization_rules(org_id, priority, id);
+CREATE INDEX IF NOT EXISTS idx_anomalies_status ON anomalies(org_id, status, id);
+CREATE INDEX IF NOT EXISTS idx_ledger_duplicates ON ledger(org_id, description, created_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_search ON ledger USING GIN (search_vector);
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
 CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
@@ -55,18 +800,35 @@ CREATE INDEX IF NOT EXISTS idx_rate_limit_log_ip ON rate_limit_log(ip_address, e
 CREATE ROLE ledger_admin LOGIN PASSWORD 'admin_password';
 CREATE ROLE ledger_viewer LOGIN PASSWORD 'viewer_password';
 
+-- The application connects as ledger_app. NOINHERIT means it holds none of
+-- the ledger privileges itself: each request transaction must SET LOCAL ROLE
+-- to the caller's role (see repository.beginScopedTx), so Postgres enforces
+-- what that role may do even if the HTTP middleware is bypassed.
+CREATE ROLE ledger_app LOGIN PASSWORD 'app_password' NOINHERIT;
+GRANT ledger_admin, ledger_viewer TO ledger_app;
+
 -- Grant connection permissions
-GRANT CONNECT ON DATABASE railway TO ledger_admin, ledger_viewer;
-GRANT USAGE ON SCHEMA public TO ledger_admin, ledger_viewer;
+GRANT CONNECT ON DATABASE railway TO ledger_admin, ledger_viewer, ledger_app;
+GRANT USAGE ON SCHEMA public TO ledger_admin, ledger_viewer, ledger_app;
 
 -- Users table permissions
-GRANT SELECT ON users TO ledger_admin, ledger_viewer;
+GRANT SELECT ON users TO ledger_admin, ledger_viewer, ledger_app;
+GRANT INSERT ON users TO ledger_app;
+GRANT USAGE, SELECT ON SEQUENCE users_id_seq TO ledger_app;
+
+-- Organization and membership permissions
+GRANT SELECT ON organizations, user_organizations TO ledger_admin, ledger_viewer, ledger_app;
+GRANT INSERT ON user_organizations TO ledger_app;
 
--- Refresh tokens table permissions
+-- Refresh tokens table permissions: login and logout run before any role is assumed
 GRANT SELECT ON refresh_tokens TO ledger_admin, ledger_viewer;
+GRANT SELECT, INSERT, DELETE ON refresh_tokens TO ledger_app;
+GRANT USAGE, SELECT ON SEQUENCE refresh_tokens_id_seq TO ledger_app;
 
 -- Rate limit log permissions
 GRANT SELECT, INSERT, UPDATE ON rate_limit_log TO ledger_admin, ledger_viewer;
+GRANT SELECT, INSERT, UPDATE, DELETE ON rate_limit_log TO ledger_app;
+GRANT USAGE, SELECT ON SEQUENCE rate_limit_log_id_seq TO ledger_app;
 
 -- Ledger table permissions: admin can INSERT and SELECT, viewer can only SELECT
 GRANT INSERT, SELECT ON ledger TO ledger_admin;
@@ -78,6 +840,104 @@ GRANT INSERT, SELECT ON audit_ledger TO ledger_admin;
 GRANT SELECT ON audit_ledger TO ledger_viewer;
 GRANT USAGE, SELECT ON SEQUENCE audit_ledger_id_seq TO ledger_admin;
 
+-- Attachment permissions: admin can upload, viewer can read
+GRANT INSERT, SELECT ON ledger_attachments TO ledger_admin;
+GRANT SELECT ON ledger_attachments TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE ledger_attachments_id_seq TO ledger_admin;
+
+-- Archive permissions: admin (the archiver) records segments, viewer can read them
+GRANT INSERT, SELECT ON ledger_archive_segments, ledger_archive_index TO ledger_admin;
+GRANT SELECT ON ledger_archive_segments, ledger_archive_index TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE ledger_archive_segments_id_seq TO ledger_admin;
+REVOKE ALL ON FUNCTION archive_ledger_segment(INTEGER) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION archive_ledger_segment(INTEGER) TO ledger_admin;
+
+-- Postings follow the ledger: admin can INSERT and SELECT, viewer can only SELECT
+GRANT INSERT, SELECT ON ledger_postings TO ledger_admin;
+GRANT SELECT ON ledger_postings TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE ledger_postings_id_seq TO ledger_admin;
+
+-- FIX capture runs as admin; session state is the only mutable FIX table
+GRANT INSERT, SELECT, UPDATE ON fix_sessions TO ledger_admin;
+GRANT INSERT, SELECT ON fix_executions TO ledger_admin;
+GRANT SELECT ON fix_sessions, fix_executions TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE fix_executions_id_seq TO ledger_admin;
+
+-- Lots are append-only like the ledger; instruments are registered by admin
+GRANT INSERT, SELECT ON instruments, lots, lot_closures TO ledger_admin;
+GRANT SELECT ON instruments, lots, lot_closures TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE instruments_id_seq, lots_id_seq, lot_closures_id_seq TO ledger_admin;
+
+-- Prices and valuation runs are append-only; loading and booking is admin only
+GRANT INSERT, SELECT ON prices, valuation_runs, valuation_lines TO ledger_admin;
+GRANT SELECT ON prices, valuation_runs, valuation_lines TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE prices_id_seq, valuation_runs_id_seq, valuation_lines_id_seq TO ledger_admin;
+
+-- Settlement events are append-only; admin records transitions
+GRANT INSERT, SELECT ON settlement_events TO ledger_admin;
+GRANT SELECT ON settlement_events TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE settlement_events_id_seq TO ledger_admin;
+
+-- Counterparty master data, limits and breaches are append-only and maintained by admin
+GRANT INSERT, SELECT ON counterparties, counterparty_limits, counterparty_limit_breaches TO ledger_admin;
+GRANT SELECT ON counterparties, counterparty_limits, counterparty_limit_breaches TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE counterparties_id_seq, counterparty_limits_id_seq, counterparty_limit_breaches_id_seq TO ledger_admin;
+
+-- Interest schedules, accruals and capitalizations are append-only; admin and the accrual job write them
+GRANT INSERT, SELECT ON interest_schedules, interest_accruals, interest_capitalizations TO ledger_admin;
+GRANT SELECT ON interest_schedules, interest_accruals, interest_capitalizations TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE interest_schedules_id_seq, interest_accruals_id_seq, interest_capitalizations_id_seq TO ledger_admin;
+
+-- Deferral schedules, lines and releases are append-only; admin and the release job write them
+GRANT INSERT, SELECT ON deferral_schedules, deferral_lines, deferral_releases TO ledger_admin;
+GRANT SELECT ON deferral_schedules, deferral_lines, deferral_releases TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE deferral_schedules_id_seq, deferral_lines_id_seq, deferral_releases_id_seq TO ledger_admin;
+
+-- Consolidation setup, intercompany tags and rates are append-only and maintained by admin
+GRANT INSERT, SELECT ON consolidation_groups, consolidation_members, intercompany_accounts, fx_rates TO ledger_admin;
+GRANT SELECT ON consolidation_groups, consolidation_members, intercompany_accounts, fx_rates TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE consolidation_groups_id_seq, consolidation_members_id_seq, intercompany_accounts_id_seq, fx_rates_id_seq TO ledger_admin;
+
+-- Tax codes, rates and lines are append-only; admin maintains codes and rates, posting writes lines
+GRANT INSERT, SELECT ON tax_codes, tax_rates, tax_lines TO ledger_admin;
+GRANT SELECT ON tax_codes, tax_rates, tax_lines TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE tax_codes_id_seq, tax_rates_id_seq, tax_lines_id_seq TO ledger_admin;
+
+-- Books are maintained by admin; voucher sequences are advanced by posting, the one counter that is updated
+GRANT INSERT, SELECT ON books TO ledger_admin;
+GRANT SELECT ON books TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE books_id_seq TO ledger_admin;
+GRANT INSERT, UPDATE, SELECT ON voucher_sequences TO ledger_admin;
+GRANT SELECT ON voucher_sequences TO ledger_viewer;
+
+-- The chart of accounts is master data: admin creates, edits and removes accounts
+GRANT INSERT, UPDATE, DELETE, SELECT ON accounts TO ledger_admin;
+GRANT SELECT ON accounts TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE accounts_id_seq TO ledger_admin;
+
+-- Dimensions and their values are append-only; rules are maintained by admin
+GRANT INSERT, SELECT ON dimensions, dimension_values, posting_dimensions TO ledger_admin;
+GRANT SELECT ON dimensions, dimension_values, dimension_rules, posting_dimensions TO ledger_viewer;
+GRANT INSERT, UPDATE, DELETE, SELECT ON dimension_rules TO ledger_admin;
+GRANT USAGE, SELECT ON SEQUENCE dimensions_id_seq, dimension_values_id_seq, dimension_rules_id_seq TO ledger_admin;
+
+-- Budgets are append-only; alerts are only updated to record webhook delivery
+GRANT INSERT, SELECT ON budgets, budget_dimensions TO ledger_admin;
+GRANT INSERT, UPDATE, SELECT ON budget_alerts TO ledger_admin;
+GRANT SELECT ON budgets, budget_dimensions, budget_alerts TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE budgets_id_seq, budget_alerts_id_seq TO ledger_admin;
+
+-- Categorization rules are replaced by deleting and recreating them
+GRANT INSERT, DELETE, SELECT ON categorization_rules TO ledger_admin;
+GRANT SELECT ON categorization_rules TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE categorization_rules_id_seq TO ledger_admin;
+
+-- Anomaly settings are append-only; anomalies are updated when reviewed
+GRANT INSERT, SELECT ON anomaly_settings TO ledger_admin;
+GRANT INSERT, UPDATE, SELECT ON anomalies TO ledger_admin;
+GRANT SELECT ON anomaly_settings, anomalies TO ledger_viewer;
+GRANT USAGE, SELECT ON SEQUENCE anomaly_settings_id_seq, anomalies_id_seq TO ledger_admin;
+
 -- Enforce immutability: explicitly revoke UPDATE and DELETE on ledger
 REVOKE UPDATE, DELETE ON ledger FROM ledger_admin;
 REVOKE UPDATE, DELETE ON ledger FROM ledger_viewer;
@@ -86,9 +946,401 @@ REVOKE UPDATE, DELETE ON ledger FROM ledger_viewer;
 REVOKE UPDATE, DELETE ON audit_ledger FROM ledger_admin;
 REVOKE UPDATE, DELETE ON audit_ledger FROM ledger_viewer;
 
+-- Attachments are append-only, like the entries they document
+REVOKE UPDATE, DELETE ON ledger_attachments FROM ledger_admin;
+REVOKE UPDATE, DELETE ON ledger_attachments FROM ledger_viewer;
+
+-- Archive segments and their index are append-only
+REVOKE UPDATE, DELETE ON ledger_archive_segments, ledger_archive_index FROM ledger_admin, ledger_viewer;
+
+-- Postings and captured executions are append-only
+REVOKE UPDATE, DELETE ON ledger_postings, fix_executions FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON instruments, lots, lot_closures FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON prices, valuation_runs, valuation_lines FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON settlement_events FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON counterparties, counterparty_limits, counterparty_limit_breaches FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON interest_schedules, interest_accruals, interest_capitalizations FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON deferral_schedules, deferral_lines, deferral_releases FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON consolidation_groups, consolidation_members, intercompany_accounts, fx_rates FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON tax_codes, tax_rates, tax_lines FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON books FROM ledger_admin, ledger_viewer;
+REVOKE DELETE ON voucher_sequences FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON dimensions, dimension_values, posting_dimensions FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON budgets, budget_dimensions FROM ledger_admin, ledger_viewer;
+REVOKE DELETE ON budget_alerts FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE ON categorization_rules FROM ledger_admin, ledger_viewer;
+
+REVOKE UPDATE, DELETE ON anomaly_settings FROM ledger_admin, ledger_viewer;
+REVOKE DELETE ON anomalies FROM ledger_admin, ledger_viewer;
+
+-- Row-level security: policies are granted per database role and filter on
+-- the organization published by the application as app.org_id. FORCE applies
+-- them to the table owner too; a session that has not assumed ledger_admin or
+-- ledger_viewer matches no policy and sees no rows at all.
+ALTER TABLE ledger ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ledger FORCE ROW LEVEL SECURITY;
+CREATE POLICY ledger_read ON ledger FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_write ON ledger FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+-- The schema owner, running archive_ledger_segment(), finds and removes the
+-- archived entries of the caller's organization; no login role can delete
+CREATE POLICY ledger_archiver_read ON ledger FOR SELECT TO CURRENT_USER
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_archiver_delete ON ledger FOR DELETE TO CURRENT_USER
+    USING (org_id = app_org_id());
+
+ALTER TABLE audit_ledger ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_ledger FORCE ROW LEVEL SECURITY;
+CREATE POLICY audit_ledger_read ON audit_ledger FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY audit_ledger_write ON audit_ledger FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id() AND user_id IS NOT DISTINCT FROM app_user_id());
+
+ALTER TABLE ledger_attachments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ledger_attachments FORCE ROW LEVEL SECURITY;
+CREATE POLICY ledger_attachments_read ON ledger_attachments FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_attachments_write ON ledger_attachments FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE ledger_archive_segments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ledger_archive_segments FORCE ROW LEVEL SECURITY;
+CREATE POLICY ledger_archive_segments_read ON ledger_archive_segments FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_archive_segments_write ON ledger_archive_segments FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE ledger_archive_index ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ledger_archive_index FORCE ROW LEVEL SECURITY;
+CREATE POLICY ledger_archive_index_read ON ledger_archive_index FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_archive_index_write ON ledger_archive_index FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY ledger_archive_index_archiver ON ledger_archive_index FOR SELECT TO CURRENT_USER
+    USING (org_id = app_org_id());
+
+ALTER TABLE ledger_postings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ledger_postings FORCE ROW LEVEL SECURITY;
+CREATE POLICY ledger_postings_read ON ledger_postings FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY ledger_postings_write ON ledger_postings FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE fix_sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE fix_sessions FORCE ROW LEVEL SECURITY;
+CREATE POLICY fix_sessions_read ON fix_sessions FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY fix_sessions_write ON fix_sessions FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY fix_sessions_update ON fix_sessions FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE fix_executions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE fix_executions FORCE ROW LEVEL SECURITY;
+CREATE POLICY fix_executions_read ON fix_executions FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY fix_executions_write ON fix_executions FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE instruments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE instruments FORCE ROW LEVEL SECURITY;
+CREATE POLICY instruments_read ON instruments FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY instruments_write ON instruments FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE lots ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lots FORCE ROW LEVEL SECURITY;
+CREATE POLICY lots_read ON lots FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY lots_write ON lots FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE lot_closures ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lot_closures FORCE ROW LEVEL SECURITY;
+CREATE POLICY lot_closures_read ON lot_closures FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY lot_closures_write ON lot_closures FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE prices ENABLE ROW LEVEL SECURITY;
+ALTER TABLE prices FORCE ROW LEVEL SECURITY;
+CREATE POLICY prices_read ON prices FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY prices_write ON prices FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE valuation_runs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE valuation_runs FORCE ROW LEVEL SECURITY;
+CREATE POLICY valuation_runs_read ON valuation_runs FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY valuation_runs_write ON valuation_runs FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE valuation_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE valuation_lines FORCE ROW LEVEL SECURITY;
+CREATE POLICY valuation_lines_read ON valuation_lines FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY valuation_lines_write ON valuation_lines FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE settlement_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE settlement_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY settlement_events_read ON settlement_events FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY settlement_events_write ON settlement_events FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE counterparties ENABLE ROW LEVEL SECURITY;
+ALTER TABLE counterparties FORCE ROW LEVEL SECURITY;
+CREATE POLICY counterparties_read ON counterparties FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY counterparties_write ON counterparties FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE counterparty_limits ENABLE ROW LEVEL SECURITY;
+ALTER TABLE counterparty_limits FORCE ROW LEVEL SECURITY;
+CREATE POLICY counterparty_limits_read ON counterparty_limits FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY counterparty_limits_write ON counterparty_limits FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE counterparty_limit_breaches ENABLE ROW LEVEL SECURITY;
+ALTER TABLE counterparty_limit_breaches FORCE ROW LEVEL SECURITY;
+CREATE POLICY counterparty_limit_breaches_read ON counterparty_limit_breaches FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY counterparty_limit_breaches_write ON counterparty_limit_breaches FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE interest_schedules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE interest_schedules FORCE ROW LEVEL SECURITY;
+CREATE POLICY interest_schedules_read ON interest_schedules FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY interest_schedules_write ON interest_schedules FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE interest_accruals ENABLE ROW LEVEL SECURITY;
+ALTER TABLE interest_accruals FORCE ROW LEVEL SECURITY;
+CREATE POLICY interest_accruals_read ON interest_accruals FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY interest_accruals_write ON interest_accruals FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE interest_capitalizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE interest_capitalizations FORCE ROW LEVEL SECURITY;
+CREATE POLICY interest_capitalizations_read ON interest_capitalizations FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY interest_capitalizations_write ON interest_capitalizations FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE deferral_schedules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE deferral_schedules FORCE ROW LEVEL SECURITY;
+CREATE POLICY deferral_schedules_read ON deferral_schedules FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY deferral_schedules_write ON deferral_schedules FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE deferral_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE deferral_lines FORCE ROW LEVEL SECURITY;
+CREATE POLICY deferral_lines_read ON deferral_lines FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY deferral_lines_write ON deferral_lines FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE deferral_releases ENABLE ROW LEVEL SECURITY;
+ALTER TABLE deferral_releases FORCE ROW LEVEL SECURITY;
+CREATE POLICY deferral_releases_read ON deferral_releases FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY deferral_releases_write ON deferral_releases FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE consolidation_groups ENABLE ROW LEVEL SECURITY;
+ALTER TABLE consolidation_groups FORCE ROW LEVEL SECURITY;
+CREATE POLICY consolidation_groups_read ON consolidation_groups FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY consolidation_groups_write ON consolidation_groups FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE consolidation_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE consolidation_members FORCE ROW LEVEL SECURITY;
+CREATE POLICY consolidation_members_read ON consolidation_members FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY consolidation_members_write ON consolidation_members FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE intercompany_accounts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE intercompany_accounts FORCE ROW LEVEL SECURITY;
+CREATE POLICY intercompany_accounts_read ON intercompany_accounts FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY intercompany_accounts_write ON intercompany_accounts FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE fx_rates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE fx_rates FORCE ROW LEVEL SECURITY;
+CREATE POLICY fx_rates_read ON fx_rates FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY fx_rates_write ON fx_rates FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE tax_codes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tax_codes FORCE ROW LEVEL SECURITY;
+CREATE POLICY tax_codes_read ON tax_codes FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY tax_codes_write ON tax_codes FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE tax_rates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tax_rates FORCE ROW LEVEL SECURITY;
+CREATE POLICY tax_rates_read ON tax_rates FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY tax_rates_write ON tax_rates FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE tax_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tax_lines FORCE ROW LEVEL SECURITY;
+CREATE POLICY tax_lines_read ON tax_lines FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY tax_lines_write ON tax_lines FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE books ENABLE ROW LEVEL SECURITY;
+ALTER TABLE books FORCE ROW LEVEL SECURITY;
+CREATE POLICY books_read ON books FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY books_write ON books FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE voucher_sequences ENABLE ROW LEVEL SECURITY;
+ALTER TABLE voucher_sequences FORCE ROW LEVEL SECURITY;
+CREATE POLICY voucher_sequences_read ON voucher_sequences FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY voucher_sequences_write ON voucher_sequences FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY voucher_sequences_update ON voucher_sequences FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE accounts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE accounts FORCE ROW LEVEL SECURITY;
+CREATE POLICY accounts_read ON accounts FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY accounts_write ON accounts FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY accounts_update ON accounts FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY accounts_delete ON accounts FOR DELETE TO ledger_admin
+    USING (org_id = app_org_id());
+
+ALTER TABLE dimensions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE dimensions FORCE ROW LEVEL SECURITY;
+CREATE POLICY dimensions_read ON dimensions FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY dimensions_write ON dimensions FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE dimension_values ENABLE ROW LEVEL SECURITY;
+ALTER TABLE dimension_values FORCE ROW LEVEL SECURITY;
+CREATE POLICY dimension_values_read ON dimension_values FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY dimension_values_write ON dimension_values FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE dimension_rules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE dimension_rules FORCE ROW LEVEL SECURITY;
+CREATE POLICY dimension_rules_read ON dimension_rules FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY dimension_rules_write ON dimension_rules FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY dimension_rules_update ON dimension_rules FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY dimension_rules_delete ON dimension_rules FOR DELETE TO ledger_admin
+    USING (org_id = app_org_id());
+
+ALTER TABLE posting_dimensions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE posting_dimensions FORCE ROW LEVEL SECURITY;
+CREATE POLICY posting_dimensions_read ON posting_dimensions FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY posting_dimensions_write ON posting_dimensions FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE budgets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budgets FORCE ROW LEVEL SECURITY;
+CREATE POLICY budgets_read ON budgets FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY budgets_write ON budgets FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE budget_dimensions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budget_dimensions FORCE ROW LEVEL SECURITY;
+CREATE POLICY budget_dimensions_read ON budget_dimensions FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY budget_dimensions_write ON budget_dimensions FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE budget_alerts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budget_alerts FORCE ROW LEVEL SECURITY;
+CREATE POLICY budget_alerts_read ON budget_alerts FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY budget_alerts_write ON budget_alerts FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY budget_alerts_update ON budget_alerts FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE categorization_rules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE categorization_rules FORCE ROW LEVEL SECURITY;
+CREATE POLICY categorization_rules_read ON categorization_rules FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY categorization_rules_write ON categorization_rules FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY categorization_rules_delete ON categorization_rules FOR DELETE TO ledger_admin
+    USING (org_id = app_org_id());
+
+ALTER TABLE anomaly_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE anomaly_settings FORCE ROW LEVEL SECURITY;
+CREATE POLICY anomaly_settings_read ON anomaly_settings FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY anomaly_settings_write ON anomaly_settings FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+
+ALTER TABLE anomalies ENABLE ROW LEVEL SECURITY;
+ALTER TABLE anomalies FORCE ROW LEVEL SECURITY;
+CREATE POLICY anomalies_read ON anomalies FOR SELECT TO ledger_admin, ledger_viewer
+    USING (org_id = app_org_id());
+CREATE POLICY anomalies_write ON anomalies FOR INSERT TO ledger_admin
+    WITH CHECK (org_id = app_org_id());
+CREATE POLICY anomalies_update ON anomalies FOR UPDATE TO ledger_admin
+    USING (org_id = app_org_id())
+    WITH CHECK (org_id = app_org_id());
+
 -- Insert default users with hashed passwords
 -- admin: admin_password, viewer: viewer_password
 INSERT INTO users (username, password_hash, role) VALUES
   ('admin', '$2a$10$9x0.K5kpXZMqHt/tC0I8J.9u6L8sK8mD6vL9mP0qR2sT3uV4wX5yZ', 'admin'),
   ('viewer', '$2a$10$8y1bL6lqYONnGuuUsD9hJ.8t5KcjL7nE5uK8lO9nQ1rS2tU3vW6xA', 'viewer')
 ON CONFLICT (username) DO NOTHING;
+
+-- Insert the default organization and add the default users to it
+INSERT INTO organizations (name) VALUES ('default')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO user_organizations (user_id, org_id)
+SELECT u.id, o.id FROM users u, organizations o
+WHERE u.username IN ('admin', 'viewer') AND o.name = 'default'
+ON CONFLICT (user_id, org_id) DO NOTHING;
@@ -0,0 +1,316 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GenesisHash is the previous-chain hash of an organization's first segment
+var GenesisHash = strings.Repeat("0", 64)
+
+// Record is an archived ledger entry, serialized one JSON object per line
+type Record struct {
+	ID             int               `json:"id"`
+	OrgID          int               `json:"org_id"`
+	Amount         float64           `json:"amount"`
+	Description    string            `json:"description"`
+	CreatedAt      time.Time         `json:"created_at"`
+	TradeDate      *time.Time        `json:"trade_date,omitempty"`
+	SettlementDate *time.Time        `json:"settlement_date,omitempty"`
+	CounterpartyID *int              `json:"counterparty_id,omitempty"`
+	Book           string            `json:"book,omitempty"`
+	VoucherNumber  string            `json:"voucher_number,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	RuleID         *int              `json:"rule_id,omitempty"`
+	Postings       []Posting         `json:"postings,omitempty"`
+}
+
+// Posting is an archived copy of one leg of an entry
+type Posting struct {
+	Account    string            `json:"account"`
+	Amount     float64           `json:"amount"`
+	Instrument string            `json:"instrument,omitempty"`
+	Quantity   float64           `json:"quantity,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+// Segment describes one compressed segment file. The same description is kept
+// in Postgres (ledger_archive_segments) and in the on-disk manifest, and the two
+// are cross-checked by Verify.
+type Segment struct {
+	ID            int       `json:"id"`
+	OrgID         int       `json:"org_id"`
+	Sequence      int       `json:"sequence"`
+	FileName      string    `json:"file_name"`
+	PeriodEnd     time.Time `json:"period_end"`
+	FirstLedgerID int       `json:"first_ledger_id"`
+	LastLedgerID  int       `json:"last_ledger_id"`
+	EntryCount    int       `json:"entry_count"`
+	SHA256        string    `json:"sha256"`
+	ContentSHA256 string    `json:"content_sha256"`
+	PrevChainHash string    `json:"prev_chain_hash"`
+	ChainHash     string    `json:"chain_hash"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Manifest lists an organization's segments in sequence order
+type Manifest struct {
+	OrgID     int       `json:"org_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Segments  []Segment `json:"segments"`
+}
+
+// ChainHash links a segment to its predecessor: sha256(prev || content_sha256).
+// Altering, dropping or reordering any segment breaks every later link.
+func ChainHash(prev, contentSHA256 string) string {
+	sum := sha256.Sum256([]byte(prev + contentSHA256))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store keeps segment files and manifests below a root directory, one
+// subdirectory per organization
+type Store struct {
+	root string
+}
+
+func NewStore(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &Store{root: root}, nil
+}
+
+func (s *Store) orgDir(orgID int) string {
+	return filepath.Join(s.root, fmt.Sprintf("org-%d", orgID))
+}
+
+// WriteSegment compresses records (sorted by id) into a new segment file chained to prevChainHash
+func (s *Store) WriteSegment(orgID, sequence int, periodEnd time.Time, prevChainHash string, records []Record) (*Segment, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("cannot write an empty segment")
+	}
+
+	var content bytes.Buffer
+	enc := json.NewEncoder(&content)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return nil, fmt.Errorf("failed to encode record %d: %w", rec.ID, err)
+		}
+	}
+	contentSum := sha256.Sum256(content.Bytes())
+
+	var compressed bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %w", err)
+	}
+	if _, err := zw.Write(content.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to compress segment: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress segment: %w", err)
+	}
+	fileSum := sha256.Sum256(compressed.Bytes())
+
+	seg := &Segment{
+		OrgID:         orgID,
+		Sequence:      sequence,
+		FileName:      fmt.Sprintf("segment-%06d.jsonl.gz", sequence),
+		PeriodEnd:     periodEnd,
+		FirstLedgerID: records[0].ID,
+		LastLedgerID:  records[len(records)-1].ID,
+		EntryCount:    len(records),
+		SHA256:        hex.EncodeToString(fileSum[:]),
+		ContentSHA256: hex.EncodeToString(contentSum[:]),
+		PrevChainHash: prevChainHash,
+	}
+	seg.ChainHash = ChainHash(prevChainHash, seg.ContentSHA256)
+
+	if err := writeFileAtomic(filepath.Join(s.orgDir(orgID), seg.FileName), compressed.Bytes()); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// RemoveSegment deletes a segment file whose database transaction did not commit
+func (s *Store) RemoveSegment(seg *Segment) error {
+	err := os.Remove(filepath.Join(s.orgDir(seg.OrgID), seg.FileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove segment: %w", err)
+	}
+	return nil
+}
+
+// ReadSegment decompresses a segment after checking both its file and content hashes
+func (s *Store) ReadSegment(seg Segment) ([]Record, error) {
+	data, err := os.ReadFile(filepath.Join(s.orgDir(seg.OrgID), seg.FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment %d: %w", seg.Sequence, err)
+	}
+
+	fileSum := sha256.Sum256(data)
+	if hex.EncodeToString(fileSum[:]) != seg.SHA256 {
+		return nil, fmt.Errorf("segment %d: file hash mismatch", seg.Sequence)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", seg.Sequence, err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: failed to decompress: %w", seg.Sequence, err)
+	}
+
+	contentSum := sha256.Sum256(content)
+	if hex.EncodeToString(contentSum[:]) != seg.ContentSHA256 {
+		return nil, fmt.Errorf("segment %d: content hash mismatch", seg.Sequence)
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("segment %d: invalid record: %w", seg.Sequence, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("segment %d: %w", seg.Sequence, err)
+	}
+	return records, nil
+}
+
+// FindRecord returns a single archived entry from a segment
+func (s *Store) FindRecord(seg Segment, ledgerID int) (*Record, error) {
+	records, err := s.ReadSegment(seg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].ID == ledgerID {
+			return &records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("ledger entry %d not in segment %d", ledgerID, seg.Sequence)
+}
+
+// WriteManifest replaces the organization's manifest with the given segments
+func (s *Store) WriteManifest(orgID int, segments []Segment) error {
+	m := Manifest{OrgID: orgID, UpdatedAt: time.Now().UTC(), Segments: segments}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(s.orgDir(orgID), "manifest.json"), append(data, '\n'))
+}
+
+func (s *Store) ReadManifest(orgID int) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(s.orgDir(orgID), "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Verify checks the segments recorded in the database against the manifest and
+// the files on disk: file and content hashes, record counts and id ranges, and
+// the hash chain from the genesis hash through the latest segment. It returns
+// every problem found rather than stopping at the first.
+func (s *Store) Verify(orgID int, recorded []Segment) []error {
+	var problems []error
+
+	manifest, err := s.ReadManifest(orgID)
+	if err != nil {
+		problems = append(problems, err)
+		manifest = &Manifest{}
+	}
+	if len(manifest.Segments) != len(recorded) {
+		problems = append(problems, fmt.Errorf("manifest lists %d segments, database lists %d", len(manifest.Segments), len(recorded)))
+	}
+
+	prev := GenesisHash
+	for i, seg := range recorded {
+		if seg.Sequence != i+1 {
+			problems = append(problems, fmt.Errorf("segment sequence gap: expected %d, found %d", i+1, seg.Sequence))
+		}
+		if seg.PrevChainHash != prev {
+			problems = append(problems, fmt.Errorf("segment %d: previous chain hash does not match segment %d", seg.Sequence, seg.Sequence-1))
+		}
+		if ChainHash(seg.PrevChainHash, seg.ContentSHA256) != seg.ChainHash {
+			problems = append(problems, fmt.Errorf("segment %d: chain hash mismatch", seg.Sequence))
+		}
+		prev = seg.ChainHash
+
+		if i < len(manifest.Segments) {
+			m := manifest.Segments[i]
+			if m.Sequence != seg.Sequence || m.SHA256 != seg.SHA256 || m.ContentSHA256 != seg.ContentSHA256 || m.ChainHash != seg.ChainHash {
+				problems = append(problems, fmt.Errorf("segment %d: manifest does not match database", seg.Sequence))
+			}
+		}
+
+		records, err := s.ReadSegment(seg)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if len(records) != seg.EntryCount {
+			problems = append(problems, fmt.Errorf("segment %d: %d records, expected %d", seg.Sequence, len(records), seg.EntryCount))
+		}
+		if len(records) > 0 && (records[0].ID != seg.FirstLedgerID || records[len(records)-1].ID != seg.LastLedgerID) {
+			problems = append(problems, fmt.Errorf("segment %d: ledger id range does not match", seg.Sequence))
+		}
+		for _, rec := range records {
+			if rec.OrgID != orgID {
+				problems = append(problems, fmt.Errorf("segment %d: record %d belongs to organization %d", seg.Sequence, rec.ID, rec.OrgID))
+			}
+		}
+	}
+	return problems
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -9,13 +9,15 @@ import (
 )
 
 type Claims struct {
-	Role string `json:"role"`
+	Role  string `json:"role"`
+	OrgID int    `json:"org_id"`
 	jwt.RegisteredClaims
 }
 
 type RefreshClaims struct {
 	UserID int    `json:"user_id"`
 	Role   string `json:"role"`
+	OrgID  int    `json:"org_id"`
 	jwt.RegisteredClaims
 }
 
@@ -33,10 +35,11 @@ func NewAuthManager(secretKey string) *AuthManager {
 	return &AuthManager{secretKey: secretKey}
 }
 
-// GenerateToken creates a JWT token for the given role with 1 hour expiration
-func (am *AuthManager) GenerateToken(role string, userID int) (string, error) {
+// GenerateToken creates a JWT token for the given role and active organization with 1 hour expiration
+func (am *AuthManager) GenerateToken(role string, userID int, orgID int) (string, error) {
 	claims := &Claims{
-		Role: role,
+		Role:  role,
+		OrgID: orgID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   fmt.Sprintf("%d", userID),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
@@ -54,10 +57,11 @@ func (am *AuthManager) GenerateToken(role string, userID int) (string, error) {
 }
 
 // GenerateRefreshToken creates a long-lived refresh token (7 days)
-func (am *AuthManager) GenerateRefreshToken(userID int, role string) (string, error) {
+func (am *AuthManager) GenerateRefreshToken(userID int, role string, orgID int) (string, error) {
 	claims := &RefreshClaims{
 		UserID: userID,
 		Role:   role,
+		OrgID:  orgID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   fmt.Sprintf("%d", userID),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
@@ -92,6 +96,11 @@ func (am *AuthManager) VerifyToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// Tokens issued before organizations existed carry no tenant and are rejected
+	if claims.OrgID == 0 {
+		return nil, fmt.Errorf("token has no active organization")
+	}
+
 	return claims, nil
 }
 
@@ -22,6 +22,11 @@ type User struct {
 	CreatedAt    time.Time
 }
 
+type Organization struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
 type RefreshToken struct {
 	ID        int
 	UserID    int
@@ -58,6 +63,43 @@ func (ur *UserRepository) VerifyUserPassword(user *User, password string) bool {
 	return err == nil
 }
 
+// GetUserOrganizations lists the organizations a user belongs to, oldest membership first
+func (ur *UserRepository) GetUserOrganizations(userID int) ([]Organization, error) {
+	rows, err := ur.db.Query(
+		`SELECT o.id, o.name FROM user_organizations uo
+		 JOIN organizations o ON o.id = uo.org_id
+		 WHERE uo.user_id = $1 ORDER BY uo.created_at, o.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, rows.Err()
+}
+
+// IsMember reports whether a user belongs to the given organization
+func (ur *UserRepository) IsMember(userID, orgID int) (bool, error) {
+	var exists bool
+	err := ur.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM user_organizations WHERE user_id = $1 AND org_id = $2)",
+		userID, orgID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check organization membership: %w", err)
+	}
+	return exists, nil
+}
+
 // CreateRefreshToken stores a new refresh token in the database
 func (ur *UserRepository) CreateRefreshToken(userID int, tokenString string, expiresAt time.Time) error {
 	// Hash the token for storage (don't store plaintext tokens)
@@ -127,20 +169,38 @@ func (ur *UserRepository) CleanupExpiredRefreshTokens() error {
 	return nil
 }
 
-// CreateUser creates a new user in the database (for admin operations)
-func (ur *UserRepository) CreateUser(username, password, role string) error {
+// CreateUser creates a new user as a member of the given organization (for admin operations)
+func (ur *UserRepository) CreateUser(username, password, role string, orgID int) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	_, err = ur.db.Exec(
-		"INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3)",
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		"INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) RETURNING id",
 		username, string(hashedPassword), role,
-	)
+	).Scan(&userID)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 
+	_, err = tx.Exec(
+		"INSERT INTO user_organizations (user_id, org_id) VALUES ($1, $2)",
+		userID, orgID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add user to organization: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
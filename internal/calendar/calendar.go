@@ -0,0 +1,133 @@
+// Package calendar counts settlement business days: weekdays that are not
+// listed holidays.
+//
+// Holiday files hold one YYYY-MM-DD date per line; blank lines and anything
+// after a # are ignored:
+//
+//	2026-01-01  # New Year's Day
+//	2026-12-25  # Christmas Day
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Calendar is a set of holidays on top of Saturday/Sunday weekends. The zero
+// value has no holidays.
+type Calendar struct {
+	holidays map[time.Time]bool
+}
+
+// New returns a calendar with the given holidays
+func New(holidays ...time.Time) *Calendar {
+	c := &Calendar{holidays: make(map[time.Time]bool, len(holidays))}
+	for _, h := range holidays {
+		c.holidays[Day(h)] = true
+	}
+	return c
+}
+
+// LoadFile reads a holiday file
+func LoadFile(path string) (*Calendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads holidays in the file format described in the package documentation
+func Parse(r io.Reader) (*Calendar, error) {
+	c := New()
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, text)
+		}
+		c.holidays[d] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Day truncates t to midnight UTC of its calendar date
+func Day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// IsBusinessDay reports whether d is a weekday that is not a holiday
+func (c *Calendar) IsBusinessDay(d time.Time) bool {
+	d = Day(d)
+	if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	return !c.holidays[d]
+}
+
+// AddBusinessDays returns the date n business days after d (T+n). With n == 0
+// a non-business day rolls forward to the next business day.
+func (c *Calendar) AddBusinessDays(d time.Time, n int) time.Time {
+	d = Day(d)
+	for !c.IsBusinessDay(d) {
+		d = d.AddDate(0, 0, 1)
+	}
+	for n > 0 {
+		d = d.AddDate(0, 0, 1)
+		if c.IsBusinessDay(d) {
+			n--
+		}
+	}
+	return d
+}
+
+// BusinessDaysBetween counts business days after from up to and including to;
+// it is zero when to is not after from
+func (c *Calendar) BusinessDaysBetween(from, to time.Time) int {
+	from, to = Day(from), Day(to)
+	n := 0
+	for d := from.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if c.IsBusinessDay(d) {
+			n++
+		}
+	}
+	return n
+}
+
+// SettlementCycle derives settlement dates as trade date plus Lag business days (T+Lag)
+type SettlementCycle struct {
+	Calendar *Calendar
+	Lag      int
+}
+
+// SettlementDate returns the contractual settlement date for a trade date
+func (s SettlementCycle) SettlementDate(tradeDate time.Time) time.Time {
+	cal := s.Calendar
+	if cal == nil {
+		cal = New()
+	}
+	return cal.AddBusinessDays(tradeDate, s.Lag)
+}
@@ -0,0 +1,58 @@
+// Package daycount implements the day-count conventions used to turn an
+// annual interest rate into the interest for a period.
+package daycount
+
+import (
+	"fmt"
+	"time"
+)
+
+// Supported conventions
+const (
+	Act360    = "ACT/360"
+	Act365    = "ACT/365"
+	Thirty360 = "30/360"
+)
+
+// Valid reports whether convention is supported
+func Valid(convention string) bool {
+	switch convention {
+	case Act360, Act365, Thirty360:
+		return true
+	}
+	return false
+}
+
+// YearFraction returns the fraction of a year between start and end under convention:
+//   - ACT/360: actual days / 360
+//   - ACT/365: actual days / 365 (ACT/365 Fixed)
+//   - 30/360: 30/360 US (Bond Basis) days / 360, where the 31st counts as the 30th
+func YearFraction(convention string, start, end time.Time) (float64, error) {
+	switch convention {
+	case Act360:
+		return float64(actualDays(start, end)) / 360, nil
+	case Act365:
+		return float64(actualDays(start, end)) / 365, nil
+	case Thirty360:
+		return float64(thirty360Days(start, end)) / 360, nil
+	}
+	return 0, fmt.Errorf("unsupported day-count convention %q", convention)
+}
+
+func actualDays(start, end time.Time) int {
+	s := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	e := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(e.Sub(s).Hours() / 24)
+}
+
+func thirty360Days(start, end time.Time) int {
+	y1, m1, d1 := start.Date()
+	y2, m2, d2 := end.Date()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+	return 360*(y2-y1) + 30*(int(m2)-int(m1)) + (d2 - d1)
+}
@@ -2,10 +2,31 @@ package db
 
 import (
 	"database/sql"
+	"net/url"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
 
+// New opens a connection pool whose sessions run in UTC, so the TIMESTAMP
+// columns filled by CURRENT_TIMESTAMP hold UTC wall-clock times
 func New(dsn string) (*sql.DB, error) {
-	return sql.Open("postgres", dsn)
+	return sql.Open("postgres", withUTC(dsn))
+}
+
+// withUTC sets the TimeZone startup parameter on a URL or key=value DSN,
+// replacing any time zone it already names
+func withUTC(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// Leave the error to the driver, which reports it on first use
+			return dsn
+		}
+		q := u.Query()
+		q.Set("timezone", "UTC")
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " timezone=UTC"
 }
@@ -0,0 +1,415 @@
+package fix
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"ledger-go-system/internal/calendar"
+	"ledger-go-system/internal/identity"
+	"ledger-go-system/internal/repository"
+)
+
+// Config controls the acceptor: our CompID, the organization fills are booked
+// into, the accounts used, how sells relieve lots (empty = FIFO), the cycle
+// used when a fill carries no SettlDate, and which counterparties may log on
+// (empty = none)
+type Config struct {
+	CompID         string
+	OrgID          int
+	Accounts       Accounts
+	LotMethod      string
+	Settlement     calendar.SettlementCycle
+	AllowedCompIDs []string
+}
+
+// Acceptor is a session-lite FIX 4.4 acceptor. It handles Logon, Heartbeat,
+// TestRequest, ResendRequest, SequenceReset and Logout, tracks sequence numbers
+// in Postgres, and books ExecutionReport fills to the ledger. It keeps no
+// outgoing message store: resend requests are answered with a gap fill, since
+// it only ever sends session-level messages.
+type Acceptor struct {
+	cfg            Config
+	allowed        map[string]bool
+	repo           *repository.FIXRepository
+	counterparties *repository.CounterpartyRepository
+}
+
+// NewAcceptor returns an acceptor whose fills must pass the given validators
+// before they are booked
+func NewAcceptor(db *sql.DB, cfg Config, validators ...repository.Validator) *Acceptor {
+	allowed := make(map[string]bool, len(cfg.AllowedCompIDs))
+	for _, id := range cfg.AllowedCompIDs {
+		allowed[id] = true
+	}
+	return &Acceptor{
+		cfg:            cfg,
+		allowed:        allowed,
+		repo:           repository.NewFIXRepository(db, validators...),
+		counterparties: repository.NewCounterpartyRepository(db),
+	}
+}
+
+// ListenAndServe accepts counterparty connections on addr until the listener fails
+func (a *Acceptor) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	defer ln.Close()
+
+	log.Printf("FIX acceptor %s listening on %s", a.cfg.CompID, addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go a.serve(conn)
+	}
+}
+
+type session struct {
+	acceptor *Acceptor
+	conn     net.Conn
+	reader   *bufio.Reader
+	ctx      context.Context
+	remote   string
+	expected int
+	heartbt  time.Duration
+
+	// counterpartyID links fills to the counterparty whose code is the SenderCompID, if any
+	counterpartyID *int
+
+	// resendFrom is the first sequence number of an outstanding ResendRequest
+	resendFrom int
+
+	writeMu sync.Mutex
+}
+
+func (a *Acceptor) serve(conn net.Conn) {
+	defer conn.Close()
+
+	s := &session{
+		acceptor: a,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		ctx:      identity.NewContext(context.Background(), identity.Identity{Role: "admin", OrgID: a.cfg.OrgID}),
+	}
+	if err := s.run(); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("FIX session %s (%s) ended: %v", s.remote, conn.RemoteAddr(), err)
+	}
+}
+
+func (s *session) run() error {
+	s.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	logon, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := s.logon(logon); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeats(done)
+
+	for {
+		// Two missed heartbeats from the counterparty end the session
+		s.conn.SetReadDeadline(time.Now().Add(2*s.heartbt + 5*time.Second))
+		m, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		process, err := s.sequence(m)
+		if err != nil {
+			return err
+		}
+		if !process {
+			continue
+		}
+
+		stop, err := s.dispatch(m)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+func (s *session) read() (*Message, error) {
+	raw, err := ReadMessage(s.reader)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("garbled message: %w", err)
+	}
+	return m, nil
+}
+
+func (s *session) logon(m *Message) error {
+	if m.MsgType() != MsgLogon {
+		return fmt.Errorf("first message must be Logon, got %q", m.MsgType())
+	}
+
+	target, _ := m.Get(TagTargetCompID)
+	s.remote, _ = m.Get(TagSenderCompID)
+	if target != s.acceptor.cfg.CompID {
+		return fmt.Errorf("logon for unknown TargetCompID %q", target)
+	}
+	if s.remote == "" || !s.acceptor.allowed[s.remote] {
+		return fmt.Errorf("logon from unknown SenderCompID %q", s.remote)
+	}
+
+	hb, err := m.Int(TagHeartBtInt)
+	if err != nil || hb <= 0 {
+		return fmt.Errorf("invalid HeartBtInt")
+	}
+	s.heartbt = time.Duration(hb) * time.Second
+
+	state, err := s.acceptor.repo.Session(s.ctx, s.acceptor.cfg.CompID, s.remote)
+	if err != nil {
+		return err
+	}
+	if reset, _ := m.Get(TagResetSeqNumFlag); reset == "Y" {
+		if err := s.acceptor.repo.ResetSequences(s.ctx, s.acceptor.cfg.CompID, s.remote); err != nil {
+			return err
+		}
+		state.NextIncomingSeq = 1
+	}
+	s.expected = state.NextIncomingSeq
+
+	cp, err := s.acceptor.counterparties.GetByCode(s.ctx, s.remote)
+	if err != nil && !errors.Is(err, repository.ErrUnknownCounterparty) {
+		return err
+	}
+	if cp != nil {
+		s.counterpartyID = &cp.ID
+	}
+
+	if err := s.send(MsgLogon, Field{Tag: 98, Value: "0"}, Field{Tag: TagHeartBtInt, Value: strconv.Itoa(hb)}); err != nil {
+		return err
+	}
+	log.Printf("FIX session %s logged on, expecting MsgSeqNum %d", s.remote, s.expected)
+
+	// The Logon itself is subject to sequence checks; a gap triggers a resend
+	_, err = s.sequence(m)
+	return err
+}
+
+// sequence applies MsgSeqNum rules and reports whether the message should be processed
+func (s *session) sequence(m *Message) (bool, error) {
+	seq, err := m.Int(TagMsgSeqNum)
+	if err != nil {
+		return false, err
+	}
+
+	if m.MsgType() == MsgSequenceReset {
+		newSeq, err := m.Int(TagNewSeqNo)
+		if err != nil {
+			return false, err
+		}
+		gapFill, _ := m.Get(TagGapFillFlag)
+		if gapFill == "Y" && seq < s.expected {
+			return false, nil
+		}
+		if newSeq > s.expected {
+			s.expected = newSeq
+			s.resendFrom = 0
+			return false, s.acceptor.repo.SetNextIncoming(s.ctx, s.acceptor.cfg.CompID, s.remote, s.expected)
+		}
+		return false, nil
+	}
+
+	switch {
+	case seq == s.expected:
+		s.resendFrom = 0
+		if m.MsgType() == MsgLogon {
+			s.expected++
+			return false, s.acceptor.repo.SetNextIncoming(s.ctx, s.acceptor.cfg.CompID, s.remote, s.expected)
+		}
+		return true, nil
+
+	case seq > s.expected:
+		if s.resendFrom != s.expected {
+			s.resendFrom = s.expected
+			log.Printf("FIX session %s: sequence gap, expected %d got %d; requesting resend", s.remote, s.expected, seq)
+			return false, s.send(MsgResendRequest,
+				Field{Tag: TagBeginSeqNo, Value: strconv.Itoa(s.expected)},
+				Field{Tag: TagEndSeqNo, Value: "0"})
+		}
+		return false, nil
+
+	default:
+		if dup, _ := m.Get(TagPossDupFlag); dup == "Y" {
+			return false, nil
+		}
+		text := fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.expected, seq)
+		s.send(MsgLogout, Field{Tag: TagText, Value: text})
+		return false, errors.New(text)
+	}
+}
+
+// dispatch handles an in-sequence message and reports whether the session should end
+func (s *session) dispatch(m *Message) (bool, error) {
+	seq, _ := m.Int(TagMsgSeqNum)
+
+	switch m.MsgType() {
+	case MsgExecutionReport:
+		return false, s.execution(m, seq)
+
+	case MsgTestRequest:
+		id, _ := m.Get(TagTestReqID)
+		if err := s.send(MsgHeartbeat, Field{Tag: TagTestReqID, Value: id}); err != nil {
+			return false, err
+		}
+
+	case MsgResendRequest:
+		// Only session messages are ever sent, so every resend is a gap fill
+		begin, err := m.Int(TagBeginSeqNo)
+		if err != nil {
+			return false, err
+		}
+		if err := s.gapFill(begin); err != nil {
+			return false, err
+		}
+
+	case MsgLogout:
+		s.advance(seq)
+		s.send(MsgLogout)
+		log.Printf("FIX session %s logged out", s.remote)
+		return true, nil
+	}
+
+	return false, s.advance(seq)
+}
+
+func (s *session) execution(m *Message, seq int) error {
+	er, err := ParseExecutionReport(m)
+	if err != nil {
+		log.Printf("FIX session %s: rejecting MsgSeqNum %d: %v", s.remote, seq, err)
+		if err := s.send(MsgReject,
+			Field{Tag: TagRefSeqNum, Value: strconv.Itoa(seq)},
+			Field{Tag: TagText, Value: err.Error()}); err != nil {
+			return err
+		}
+		return s.advance(seq)
+	}
+
+	if !er.IsFill() {
+		return s.advance(seq)
+	}
+
+	exec := repository.FIXExecution{
+		LocalCompID:  s.acceptor.cfg.CompID,
+		RemoteCompID: s.remote,
+		MsgSeqNum:    seq,
+		ExecID:       er.ExecID,
+		OrderID:      er.OrderID,
+		Symbol:       er.Symbol,
+		Side:         er.Side,
+		Quantity:     er.LastQty,
+		Price:        er.LastPx,
+		Commission:   er.Commission,
+		Currency:     er.Currency,
+		TransactTime: er.TransactTime,
+	}
+	entry := er.Entry(s.acceptor.cfg.Accounts)
+	entry.LotMethod = s.acceptor.cfg.LotMethod
+	entry.TradeDate, entry.SettlementDate = er.Dates(s.acceptor.cfg.Settlement)
+	entry.CounterpartyID = s.counterpartyID
+	ledgerID, duplicate, err := s.acceptor.repo.RecordExecution(s.ctx, exec, entry, "fix:"+s.remote)
+	var held *repository.EntryHeld
+	if errors.As(err, &held) {
+		// The hold was committed with the sequence number; the fill is booked
+		// if the anomaly is approved
+		s.expected = seq + 1
+		log.Printf("FIX session %s: ExecID %s held for approval as anomaly %d", s.remote, er.ExecID, held.AnomalyID)
+		return nil
+	}
+	var violation *repository.RuleViolation
+	if errors.Is(err, repository.ErrInsufficientLots) || errors.Is(err, repository.ErrExposureLimit) || errors.As(err, &violation) {
+		// A sell without the lots to cover it, a fill over the counterparty's
+		// limit or one a validation rule refuses is a business problem, not a
+		// session one: reject the report and keep the session up
+		log.Printf("FIX session %s: rejecting ExecID %s: %v", s.remote, er.ExecID, err)
+		if err := s.send(MsgReject,
+			Field{Tag: TagRefSeqNum, Value: strconv.Itoa(seq)},
+			Field{Tag: TagText, Value: err.Error()}); err != nil {
+			return err
+		}
+		return s.advance(seq)
+	}
+	if err != nil {
+		return err
+	}
+	s.expected = seq + 1
+
+	if duplicate {
+		log.Printf("FIX session %s: duplicate ExecID %s ignored", s.remote, er.ExecID)
+	} else {
+		log.Printf("FIX session %s: booked ExecID %s as ledger entry %d", s.remote, er.ExecID, ledgerID)
+	}
+	return nil
+}
+
+func (s *session) advance(seq int) error {
+	s.expected = seq + 1
+	return s.acceptor.repo.SetNextIncoming(s.ctx, s.acceptor.cfg.CompID, s.remote, s.expected)
+}
+
+func (s *session) gapFill(begin int) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	state, err := s.acceptor.repo.Session(s.ctx, s.acceptor.cfg.CompID, s.remote)
+	if err != nil {
+		return err
+	}
+	msg := Build(MsgSequenceReset, s.acceptor.cfg.CompID, s.remote, begin,
+		Field{Tag: TagPossDupFlag, Value: "Y"},
+		Field{Tag: TagGapFillFlag, Value: "Y"},
+		Field{Tag: TagNewSeqNo, Value: strconv.Itoa(state.NextOutgoingSeq)})
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+func (s *session) send(msgType string, fields ...Field) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	seq, err := s.acceptor.repo.NextOutgoing(s.ctx, s.acceptor.cfg.CompID, s.remote)
+	if err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = s.conn.Write(Build(msgType, s.acceptor.cfg.CompID, s.remote, seq, fields...))
+	return err
+}
+
+func (s *session) heartbeats(done <-chan struct{}) {
+	ticker := time.NewTicker(s.heartbt)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.send(MsgHeartbeat); err != nil {
+				log.Printf("FIX session %s: failed to send heartbeat: %v", s.remote, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package fix
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"ledger-go-system/internal/calendar"
+	"ledger-go-system/internal/repository"
+)
+
+// ExecType values (tag 150) relevant to booking
+const (
+	ExecTypeTrade = "F"
+)
+
+// Side values (tag 54)
+const (
+	SideBuy             = "1"
+	SideSell            = "2"
+	SideSellShort       = "5"
+	SideSellShortExempt = "6"
+)
+
+// ExecutionReport holds the fields of a 35=8 message needed to book a fill
+type ExecutionReport struct {
+	ExecID       string
+	OrderID      string
+	ExecType     string
+	Symbol       string
+	Side         string
+	LastQty      float64
+	LastPx       float64
+	Commission   float64
+	Currency     string
+	TradeDate    string
+	SettlDate    string
+	TransactTime time.Time
+}
+
+// ParseExecutionReport extracts an execution report, requiring the fill fields when ExecType is Trade
+func ParseExecutionReport(m *Message) (*ExecutionReport, error) {
+	if m.MsgType() != MsgExecutionReport {
+		return nil, fmt.Errorf("not an execution report: MsgType %q", m.MsgType())
+	}
+
+	er := &ExecutionReport{}
+	var ok bool
+	if er.ExecID, ok = m.Get(TagExecID); !ok || er.ExecID == "" {
+		return nil, fmt.Errorf("missing ExecID (17)")
+	}
+	if er.ExecType, ok = m.Get(TagExecType); !ok {
+		return nil, fmt.Errorf("missing ExecType (150)")
+	}
+	er.OrderID, _ = m.Get(TagOrderID)
+	er.Symbol, _ = m.Get(TagSymbol)
+	er.Side, _ = m.Get(TagSide)
+	er.Currency, _ = m.Get(TagCurrency)
+	er.TradeDate, _ = m.Get(TagTradeDate)
+	er.SettlDate, _ = m.Get(TagSettlDate)
+	if v, ok := m.Get(TagTransactTime); ok {
+		if t, err := parseUTCTimestamp(v); err == nil {
+			er.TransactTime = t
+		}
+	}
+
+	if !er.IsFill() {
+		return er, nil
+	}
+
+	if er.Symbol == "" {
+		return nil, fmt.Errorf("missing Symbol (55)")
+	}
+	switch er.Side {
+	case SideBuy, SideSell, SideSellShort, SideSellShortExempt:
+	default:
+		return nil, fmt.Errorf("unsupported Side %q", er.Side)
+	}
+
+	var err error
+	if er.LastQty, err = m.Float(TagLastQty); err != nil {
+		return nil, err
+	}
+	if er.LastPx, err = m.Float(TagLastPx); err != nil {
+		return nil, err
+	}
+	if er.LastQty <= 0 || er.LastPx <= 0 {
+		return nil, fmt.Errorf("LastQty and LastPx must be positive")
+	}
+	if _, ok := m.Get(TagCommission); ok {
+		if er.Commission, err = m.Float(TagCommission); err != nil {
+			return nil, err
+		}
+		if er.Commission < 0 {
+			return nil, fmt.Errorf("Commission must not be negative")
+		}
+	}
+	return er, nil
+}
+
+// IsFill reports whether the report represents an executed trade
+func (er *ExecutionReport) IsFill() bool {
+	return er.ExecType == ExecTypeTrade
+}
+
+// IsBuy reports whether the fill increases the position
+func (er *ExecutionReport) IsBuy() bool {
+	return er.Side == SideBuy
+}
+
+// Accounts names the ledger accounts fills are booked to
+type Accounts struct {
+	Cash        string
+	Positions   string
+	Fees        string
+	RealizedPnL string
+}
+
+// Entry turns a fill into a balanced ledger entry: the position leg at
+// LastQty x LastPx carries the instrument quantity, cash settles the trade
+// and the commission is expensed. Sells relieve open lots in the position
+// account, which books the realized P&L against accounts.RealizedPnL.
+func (er *ExecutionReport) Entry(accounts Accounts) repository.NewEntry {
+	gross := round2(er.LastQty * er.LastPx)
+	fee := round2(er.Commission)
+
+	verb := "SELL"
+	position, quantity, cash := -gross, -er.LastQty, gross-fee
+	if er.IsBuy() {
+		verb = "BUY"
+		position, quantity, cash = gross, er.LastQty, -(gross + fee)
+	}
+
+	postings := []repository.Posting{
+		{Account: accounts.Positions, Amount: position, Instrument: er.Symbol, Quantity: quantity},
+		{Account: accounts.Cash, Amount: cash},
+	}
+	if fee > 0 {
+		postings = append(postings, repository.Posting{Account: accounts.Fees, Amount: fee})
+	}
+
+	var debits float64
+	for _, p := range postings {
+		if p.Amount > 0 {
+			debits += p.Amount
+		}
+	}
+
+	return repository.NewEntry{
+		Amount:      round2(debits),
+		Description: fmt.Sprintf("FIX %s %g %s @ %g (ExecID %s)", verb, er.LastQty, er.Symbol, er.LastPx, er.ExecID),
+		Postings:    postings,
+		PnLAccount:  accounts.RealizedPnL,
+	}
+}
+
+// Dates returns the trade and settlement dates of a fill: TradeDate (75) or
+// else the TransactTime date, and SettlDate (64) or else the settlement cycle
+// applied to the trade date. Either is nil when it cannot be determined.
+func (er *ExecutionReport) Dates(cycle calendar.SettlementCycle) (*time.Time, *time.Time) {
+	var trade, settle *time.Time
+	if d, err := time.Parse("20060102", er.TradeDate); err == nil {
+		trade = &d
+	} else if !er.TransactTime.IsZero() {
+		d := calendar.Day(er.TransactTime)
+		trade = &d
+	}
+
+	if d, err := time.Parse("20060102", er.SettlDate); err == nil {
+		settle = &d
+	} else if trade != nil {
+		d := cycle.SettlementDate(*trade)
+		settle = &d
+	}
+	return trade, settle
+}
+
+func parseUTCTimestamp(v string) (time.Time, error) {
+	for _, layout := range []string{"20060102-15:04:05.000", "20060102-15:04:05"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid UTCTimestamp %q", v)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
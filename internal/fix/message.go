@@ -0,0 +1,225 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const soh = '\x01'
+
+// BeginString is the only protocol version the acceptor speaks
+const BeginString = "FIX.4.4"
+
+// Tags used by the session layer and execution reports
+const (
+	TagBeginString     = 8
+	TagBodyLength      = 9
+	TagCheckSum        = 10
+	TagBeginSeqNo      = 7
+	TagCommission      = 12
+	TagCurrency        = 15
+	TagEndSeqNo        = 16
+	TagExecID          = 17
+	TagLastPx          = 31
+	TagLastQty         = 32
+	TagMsgSeqNum       = 34
+	TagMsgType         = 35
+	TagNewSeqNo        = 36
+	TagOrderID         = 37
+	TagPossDupFlag     = 43
+	TagRefSeqNum       = 45
+	TagSenderCompID    = 49
+	TagSendingTime     = 52
+	TagSide            = 54
+	TagSymbol          = 55
+	TagTargetCompID    = 56
+	TagText            = 58
+	TagTransactTime    = 60
+	TagSettlDate       = 64
+	TagTradeDate       = 75
+	TagHeartBtInt      = 108
+	TagTestReqID       = 112
+	TagGapFillFlag     = 123
+	TagResetSeqNumFlag = 141
+	TagExecType        = 150
+)
+
+// Session and application message types
+const (
+	MsgHeartbeat       = "0"
+	MsgTestRequest     = "1"
+	MsgResendRequest   = "2"
+	MsgReject          = "3"
+	MsgSequenceReset   = "4"
+	MsgLogout          = "5"
+	MsgExecutionReport = "8"
+	MsgLogon           = "A"
+)
+
+// Field is a single tag=value pair
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message with its fields in wire order
+type Message struct {
+	Fields []Field
+}
+
+// Get returns the first value for tag
+func (m *Message) Get(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the value for tag as an integer
+func (m *Message) Int(tag int) (int, error) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: invalid integer %q", tag, v)
+	}
+	return n, nil
+}
+
+// Float returns the value for tag as a float
+func (m *Message) Float(tag int) (float64, error) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: invalid number %q", tag, v)
+	}
+	return f, nil
+}
+
+func (m *Message) MsgType() string {
+	v, _ := m.Get(TagMsgType)
+	return v
+}
+
+// Parse decodes a complete raw message, validating BeginString, BodyLength and CheckSum
+func Parse(raw []byte) (*Message, error) {
+	if len(raw) == 0 || raw[len(raw)-1] != soh {
+		return nil, fmt.Errorf("message must end with SOH")
+	}
+
+	m := &Message{}
+	for _, part := range bytes.Split(raw[:len(raw)-1], []byte{soh}) {
+		eq := bytes.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed field %q", part)
+		}
+		tag, err := strconv.Atoi(string(part[:eq]))
+		if err != nil || tag <= 0 {
+			return nil, fmt.Errorf("invalid tag %q", part[:eq])
+		}
+		m.Fields = append(m.Fields, Field{Tag: tag, Value: string(part[eq+1:])})
+	}
+
+	if len(m.Fields) < 4 || m.Fields[0].Tag != TagBeginString || m.Fields[1].Tag != TagBodyLength ||
+		m.Fields[2].Tag != TagMsgType || m.Fields[len(m.Fields)-1].Tag != TagCheckSum {
+		return nil, fmt.Errorf("message must start with 8, 9, 35 and end with 10")
+	}
+	if m.Fields[0].Value != BeginString {
+		return nil, fmt.Errorf("unsupported BeginString %q", m.Fields[0].Value)
+	}
+
+	// BodyLength counts from the field after 9= up to and including the SOH before 10=
+	bodyStart := len(fmt.Sprintf("8=%s\x019=%s\x01", m.Fields[0].Value, m.Fields[1].Value))
+	trailerStart := bytes.LastIndex(raw, []byte("\x0110=")) + 1
+	bodyLength, err := strconv.Atoi(m.Fields[1].Value)
+	if err != nil || bodyLength != trailerStart-bodyStart {
+		return nil, fmt.Errorf("BodyLength %s does not match body of %d bytes", m.Fields[1].Value, trailerStart-bodyStart)
+	}
+
+	want := fmt.Sprintf("%03d", checksum(raw[:trailerStart]))
+	if m.Fields[len(m.Fields)-1].Value != want {
+		return nil, fmt.Errorf("CheckSum %s does not match computed %s", m.Fields[len(m.Fields)-1].Value, want)
+	}
+	return m, nil
+}
+
+// ReadMessage reads one framed message from r, using BodyLength to find its end
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	begin, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(begin, []byte("8=")) {
+		return nil, fmt.Errorf("expected BeginString, got %q", begin)
+	}
+
+	lengthField, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(lengthField, []byte("9=")) {
+		return nil, fmt.Errorf("expected BodyLength, got %q", lengthField)
+	}
+	bodyLength, err := strconv.Atoi(string(lengthField[2 : len(lengthField)-1]))
+	if err != nil || bodyLength <= 0 || bodyLength > 1<<20 {
+		return nil, fmt.Errorf("invalid BodyLength %q", lengthField)
+	}
+
+	// Body plus the fixed-width "10=NNN<SOH>" trailer
+	rest := make([]byte, bodyLength+7)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 0, len(begin)+len(lengthField)+len(rest))
+	raw = append(raw, begin...)
+	raw = append(raw, lengthField...)
+	return append(raw, rest...), nil
+}
+
+// Build encodes a message with the standard header and trailer. fields are the
+// body fields after SendingTime, in order.
+func Build(msgType, sender, target string, seq int, fields ...Field) []byte {
+	var body bytes.Buffer
+	writeField(&body, TagMsgType, msgType)
+	writeField(&body, TagSenderCompID, sender)
+	writeField(&body, TagTargetCompID, target)
+	writeField(&body, TagMsgSeqNum, strconv.Itoa(seq))
+	writeField(&body, TagSendingTime, time.Now().UTC().Format("20060102-15:04:05.000"))
+	for _, f := range fields {
+		writeField(&body, f.Tag, f.Value)
+	}
+
+	var msg bytes.Buffer
+	writeField(&msg, TagBeginString, BeginString)
+	writeField(&msg, TagBodyLength, strconv.Itoa(body.Len()))
+	msg.Write(body.Bytes())
+	writeField(&msg, TagCheckSum, fmt.Sprintf("%03d", checksum(msg.Bytes())))
+	return msg.Bytes()
+}
+
+func writeField(b *bytes.Buffer, tag int, value string) {
+	b.WriteString(strconv.Itoa(tag))
+	b.WriteByte('=')
+	b.WriteString(value)
+	b.WriteByte(soh)
+}
+
+func checksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
@@ -0,0 +1,192 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// frame wraps body, written with | for SOH, in BeginString, BodyLength and CheckSum
+func frame(body string) []byte {
+	body = strings.ReplaceAll(body, "|", "\x01")
+	head := fmt.Sprintf("8=%s\x019=%d\x01%s", BeginString, len(body), body)
+	sum := 0
+	for i := 0; i < len(head); i++ {
+		sum += int(head[i])
+	}
+	return []byte(fmt.Sprintf("%s10=%03d\x01", head, sum%256))
+}
+
+const fillBody = "35=8|49=BROKER|56=LEDGER|34=2|52=20260115-14:30:00.000|37=O1|17=E1|150=F|55=BRK.B|54=1|" +
+	"32=100|31=150.25|12=4.5|15=USD|75=20260115|64=20260116|60=20260115-14:30:00.000|"
+
+func TestParseKnownFrame(t *testing.T) {
+	// A heartbeat with its BodyLength and CheckSum worked out by hand
+	raw := []byte("8=FIX.4.4\x019=5\x0135=0\x0110=163\x01")
+	m, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.MsgType() != MsgHeartbeat {
+		t.Errorf("MsgType = %q, want %q", m.MsgType(), MsgHeartbeat)
+	}
+	if !bytes.Equal(frame("35=0|"), raw) {
+		t.Errorf("frame(35=0) = %q, want %q", frame("35=0|"), raw)
+	}
+}
+
+func TestParseExecutionReport(t *testing.T) {
+	m, err := Parse(frame(fillBody))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	er, err := ParseExecutionReport(m)
+	if err != nil {
+		t.Fatalf("ParseExecutionReport: %v", err)
+	}
+
+	want := ExecutionReport{
+		ExecID: "E1", OrderID: "O1", ExecType: ExecTypeTrade, Symbol: "BRK.B", Side: SideBuy,
+		LastQty: 100, LastPx: 150.25, Commission: 4.5, Currency: "USD", TradeDate: "20260115", SettlDate: "20260116",
+	}
+	got := *er
+	got.TransactTime = want.TransactTime
+	if got != want {
+		t.Errorf("ExecutionReport = %+v, want %+v", got, want)
+	}
+	if er.TransactTime.Format("2006-01-02T15:04:05") != "2026-01-15T14:30:00" {
+		t.Errorf("TransactTime = %v", er.TransactTime)
+	}
+	if !er.IsFill() || !er.IsBuy() {
+		t.Errorf("IsFill() = %v, IsBuy() = %v, want both true", er.IsFill(), er.IsBuy())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	valid := frame(fillBody)
+	withChecksum := func(sum string) []byte {
+		raw := bytes.Clone(valid)
+		return append(raw[:len(raw)-4], sum+"\x01"...)
+	}
+	withLength := func(n int) []byte {
+		return bytes.Replace(valid, []byte(fmt.Sprintf("\x019=%d\x01", len(fillBody))), []byte(fmt.Sprintf("\x019=%d\x01", n)), 1)
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+		msg  string
+	}{
+		{"bad checksum", withChecksum("000"), "CheckSum 000 does not match"},
+		{"checksum not three digits", withChecksum("7"), "does not match"},
+		{"body length short", withLength(len(fillBody) - 1), "does not match body of"},
+		{"body length long", withLength(len(fillBody) + 1), "does not match body of"},
+		{"body length not a number", bytes.Replace(valid, []byte("\x019="), []byte("\x019=x"), 1), "BodyLength"},
+		{"truncated", valid[:len(valid)-10], "must end with SOH"},
+		{"no trailer", frame("35=0|")[:len("8=FIX.4.4\x019=5\x0135=0\x01")], "must start with 8, 9, 35 and end with 10"},
+		{"wrong version", bytes.Replace(valid, []byte("8=FIX.4.4"), []byte("8=FIX.4.2"), 1), "unsupported BeginString"},
+		{"header out of order", frame("49=BROKER|35=0|"), "must start with 8, 9, 35"},
+		{"field without =", frame("35=0|58|"), "malformed field"},
+		{"empty field", frame("35=0||"), "malformed field"},
+		{"tag not a number", frame("35=0|x=1|"), "invalid tag"},
+		{"tag zero", frame("35=0|0=1|"), "invalid tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.raw)
+			if err == nil || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("Parse error = %v, want it to contain %q", err, tt.msg)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	// A value may hold '='; a repeated tag keeps both fields and Get returns the first
+	m, err := Parse(frame("35=8|58=a=b|58=second|32=x|"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []Field{{TagBeginString, BeginString}, {TagBodyLength, "27"}, {TagMsgType, "8"},
+		{TagText, "a=b"}, {TagText, "second"}, {TagLastQty, "x"}}
+	if len(m.Fields) != len(want)+1 {
+		t.Fatalf("Fields = %v, want %v and the checksum", m.Fields, want)
+	}
+	for i, f := range want {
+		if m.Fields[i] != f {
+			t.Errorf("Fields[%d] = %v, want %v", i, m.Fields[i], f)
+		}
+	}
+	if v, _ := m.Get(TagText); v != "a=b" {
+		t.Errorf("Get(58) = %q, want the first value", v)
+	}
+	if _, ok := m.Get(TagExecID); ok {
+		t.Errorf("Get(17) found a tag that is not there")
+	}
+	if _, err := m.Int(TagLastQty); err == nil {
+		t.Errorf("Int(32) of %q did not fail", "x")
+	}
+	if _, err := m.Float(TagLastPx); err == nil {
+		t.Errorf("Float(31) of a missing tag did not fail")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	raw := Build(MsgLogon, "LEDGER", "BROKER", 1, Field{TagHeartBtInt, "30"})
+	m, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse(Build()): %v\n%q", err, raw)
+	}
+	if seq, _ := m.Int(TagMsgSeqNum); m.MsgType() != MsgLogon || seq != 1 {
+		t.Errorf("MsgType = %q, MsgSeqNum = %d", m.MsgType(), seq)
+	}
+	if v, _ := m.Get(TagHeartBtInt); v != "30" || m.Fields[len(m.Fields)-2].Tag != TagHeartBtInt {
+		t.Errorf("HeartBtInt = %q, want 30 as the last body field", v)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	first, second := frame(fillBody), frame("35=0|")
+	r := bufio.NewReader(bytes.NewReader(append(bytes.Clone(first), second...)))
+	for i, want := range [][]byte{first, second} {
+		raw, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+		if !bytes.Equal(raw, want) {
+			t.Errorf("message %d = %q, want %q", i+1, raw, want)
+		}
+	}
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("after the last message: %v, want io.EOF", err)
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+		err  error  // when the read fails with a plain I/O error
+		msg  string // otherwise
+	}{
+		{"truncated body", first[:len(first)-20], io.ErrUnexpectedEOF, ""},
+		{"truncated header", []byte("8=FIX.4.4\x019=12"), io.EOF, ""},
+		{"not BeginString", []byte("9=5\x0135=0\x0110=163\x01"), nil, "expected BeginString"},
+		{"not BodyLength", []byte("8=FIX.4.4\x0135=0\x0110=163\x01"), nil, "expected BodyLength"},
+		{"zero BodyLength", []byte("8=FIX.4.4\x019=0\x0110=000\x01"), nil, "invalid BodyLength"},
+		{"huge BodyLength", []byte("8=FIX.4.4\x019=99999999\x01"), nil, "invalid BodyLength"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadMessage(bufio.NewReader(bytes.NewReader(tt.raw)))
+			switch {
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Errorf("ReadMessage error = %v, want %v", err, tt.err)
+			case tt.err == nil && (err == nil || !strings.Contains(err.Error(), tt.msg)):
+				t.Errorf("ReadMessage error = %v, want it to contain %q", err, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/repository"
+)
+
+type AccountHandler struct {
+	repo *repository.AccountRepository
+}
+
+func NewAccountHandler(db *sql.DB) *AccountHandler {
+	return &AccountHandler{repo: repository.NewAccountRepository(db)}
+}
+
+// CreateAccountRequest adds an account to the chart, under the header
+// account named by parent when it is set
+type CreateAccountRequest struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	Header bool   `json:"header"`
+}
+
+// UpdateAccountRequest changes only the fields present; "parent": "" moves
+// the account to the top level
+type UpdateAccountRequest struct {
+	Name   *string `json:"name"`
+	Parent *string `json:"parent"`
+	Header *bool   `json:"header"`
+}
+
+func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Code = strings.TrimSpace(body.Code)
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Code == "" || body.Name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "code and name are required"})
+		return
+	}
+
+	a, err := h.repo.Create(r.Context(), repository.Account{Code: body.Code, Name: body.Name, Header: body.Header}, strings.TrimSpace(body.Parent))
+	if errors.Is(err, repository.ErrUnknownParent) || errors.Is(err, repository.ErrParentNotHeader) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrAccountExists) || errors.Is(err, repository.ErrAccountInUse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// List returns the chart of accounts, each account after its parent
+func (h *AccountHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Tree returns the chart of accounts with balances as of ?as_of= (default
+// now) and each header's rolled-up total
+func (h *AccountHandler) Tree(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	at := time.Now().UTC()
+	if asOf != nil {
+		at = *asOf
+	}
+
+	data, err := h.repo.Tree(r.Context(), at)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *AccountHandler) Update(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid account id"})
+		return
+	}
+
+	var body UpdateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "name cannot be empty"})
+			return
+		}
+		body.Name = &name
+	}
+	if body.Parent != nil {
+		parent := strings.TrimSpace(*body.Parent)
+		body.Parent = &parent
+	}
+
+	a, err := h.repo.Update(r.Context(), accountID, repository.AccountUpdate{Name: body.Name, Parent: body.Parent, Header: body.Header})
+	if errors.Is(err, repository.ErrUnknownAccount) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownParent) || errors.Is(err, repository.ErrParentNotHeader) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrAccountCycle) || errors.Is(err, repository.ErrAccountInUse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a)
+}
+
+func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid account id"})
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), accountID)
+	if errors.Is(err, repository.ErrUnknownAccount) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrAccountInUse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": accountID})
+}
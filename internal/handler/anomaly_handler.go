@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type AnomalyHandler struct {
+	repo *repository.AnomalyRepository
+}
+
+// NewAnomalyHandler returns a handler whose approved entries must pass the
+// given validators before they are posted
+func NewAnomalyHandler(db *sql.DB, validators ...repository.Validator) *AnomalyHandler {
+	return &AnomalyHandler{repo: repository.NewAnomalyRepository(db, validators...)}
+}
+
+// AnomalySettingsRequest replaces how new entries are screened
+type AnomalySettingsRequest struct {
+	DuplicateWindowSeconds int     `json:"duplicate_window_seconds"`
+	OutlierFactor          float64 `json:"outlier_factor"`
+	MinHistory             int     `json:"min_history"`
+	Action                 string  `json:"action"`
+}
+
+// List returns anomalies, optionally only those with ?status=
+func (h *AnomalyHandler) List(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", repository.AnomalyFlagged, repository.AnomalyHeld, repository.AnomalyApproved, repository.AnomalyRejected:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "status must be flagged, held, approved or rejected"})
+		return
+	}
+
+	data, err := h.repo.List(r.Context(), status)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *AnomalyHandler) Settings(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Settings(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *AnomalyHandler) SetSettings(w http.ResponseWriter, r *http.Request) {
+	var body AnomalySettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if body.Action != repository.AnomalyFlag && body.Action != repository.AnomalyHold {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "action must be flag or hold"})
+		return
+	}
+	if body.DuplicateWindowSeconds < 0 || body.OutlierFactor <= 1 || body.MinHistory < 1 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "duplicate_window_seconds cannot be negative, outlier_factor must be above 1 and min_history at least 1"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	data, err := h.repo.SetSettings(r.Context(), repository.AnomalySettings{
+		DuplicateWindowSeconds: body.DuplicateWindowSeconds,
+		OutlierFactor:          body.OutlierFactor,
+		MinHistory:             body.MinHistory,
+		Action:                 body.Action,
+	}, actor)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Approve posts a held entry, or marks a flagged one as reviewed
+func (h *AnomalyHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, h.repo.Approve)
+}
+
+// Reject discards a held entry, or closes a flagged one
+func (h *AnomalyHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, h.repo.Reject)
+}
+
+func (h *AnomalyHandler) review(w http.ResponseWriter, r *http.Request, review func(ctx context.Context, id int, actor string) (*repository.Anomaly, error)) {
+	anomalyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid anomaly id"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	data, err := review(r.Context(), anomalyID, actor)
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: violation.Error(), Rule: violation.Rule})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownAnomaly) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	// A held entry that can no longer be posted as it stands, e.g. because
+	// the lots it relieves have since been sold
+	if errors.Is(err, repository.ErrAnomalyReviewed) || errors.Is(err, repository.ErrInsufficientLots) ||
+		errors.Is(err, repository.ErrExposureLimit) || errors.Is(err, repository.ErrUnbalanced) ||
+		errors.Is(err, repository.ErrUnknownCounterparty) || errors.Is(err, repository.ErrUnknownTaxCode) ||
+		errors.Is(err, repository.ErrNoTaxRate) || errors.Is(err, repository.ErrUnknownBook) ||
+		errors.Is(err, repository.ErrHeaderAccount) || errors.Is(err, repository.ErrUnknownDimension) ||
+		errors.Is(err, repository.ErrDimensionRule) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"ledger-go-system/internal/identity"
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+	"ledger-go-system/internal/storage"
+)
+
+type AttachmentHandler struct {
+	ledgers      *repository.LedgerRepository
+	attachments  *repository.AttachmentRepository
+	store        storage.Storage
+	maxBytes     int64
+	allowedTypes map[string]bool
+}
+
+func NewAttachmentHandler(db *sql.DB, store storage.Storage, maxBytes int64, allowedTypes []string) *AttachmentHandler {
+	allowed := make(map[string]bool, len(allowedTypes))
+	for _, t := range allowedTypes {
+		allowed[t] = true
+	}
+	return &AttachmentHandler{
+		ledgers:      repository.NewLedgerRepository(db),
+		attachments:  repository.NewAttachmentRepository(db),
+		store:        store,
+		maxBytes:     maxBytes,
+		allowedTypes: allowed,
+	}
+}
+
+// Upload stores the "file" part of a multipart request against a ledger entry
+func (h *AttachmentHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	if _, err := h.ledgers.GetByID(r.Context(), ledgerID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "ledger entry not found"})
+		return
+	}
+
+	// Allow some headroom for the multipart envelope around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes+64*1024)
+	if err := r.ParseMultipartForm(h.maxBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("attachment exceeds %d bytes", h.maxBytes)})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid multipart body"})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "file field is required"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.maxBytes {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("attachment exceeds %d bytes", h.maxBytes)})
+		return
+	}
+
+	// Sniff the content type rather than trusting the client-supplied header
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to read attachment"})
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if !h.allowedTypes[contentType] {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("content type %s is not allowed", contentType)})
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to read attachment"})
+		return
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to read attachment"})
+		return
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	// Blobs are content-addressed within each organization, so identical
+	// uploads share a file but tenants never share storage
+	ident, _ := identity.FromContext(r.Context())
+	key := fmt.Sprintf("org-%d/%s/%s", ident.OrgID, sum[:2], sum)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to read attachment"})
+		return
+	}
+	if err := h.store.Put(r.Context(), key, file); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to store attachment"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	attachment := &repository.Attachment{
+		LedgerID:    int(ledgerID),
+		Filename:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		SizeBytes:   size,
+		SHA256:      sum,
+		StorageKey:  key,
+	}
+	if err := h.attachments.Create(r.Context(), attachment, actor); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+func (h *AttachmentHandler) List(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	data, err := h.attachments.ListByLedger(r.Context(), ledgerID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Download streams an attachment after re-verifying its recorded hash
+func (h *AttachmentHandler) Download(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+	attachmentID, err := strconv.ParseInt(r.PathValue("attachmentID"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid attachment id"})
+		return
+	}
+
+	attachment, err := h.attachments.GetByID(r.Context(), ledgerID, attachmentID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "attachment not found"})
+		return
+	}
+
+	if err := h.verify(r, attachment); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	blob, err := h.store.Open(r.Context(), attachment.StorageKey)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to open attachment"})
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-SHA256", attachment.SHA256)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// verify hashes the stored blob and compares it with the hash recorded at upload
+func (h *AttachmentHandler) verify(r *http.Request, attachment *repository.Attachment) error {
+	blob, err := h.store.Open(r.Context(), attachment.StorageKey)
+	if err != nil {
+		return fmt.Errorf("failed to open attachment")
+	}
+	defer blob.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, blob); err != nil {
+		return fmt.Errorf("failed to read attachment")
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != attachment.SHA256 {
+		return fmt.Errorf("attachment failed integrity check")
+	}
+	return nil
+}
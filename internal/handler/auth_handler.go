@@ -23,13 +23,16 @@ func NewAuthHandler(authManager *auth.AuthManager, userRepository *auth.UserRepo
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OrgID    int    `json:"org_id,omitempty"`
 }
 
 type LoginResponse struct {
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
-	Role         string `json:"role"`
-	ExpiresIn    int    `json:"expires_in"`
+	Token         string              `json:"token"`
+	RefreshToken  string              `json:"refresh_token"`
+	Role          string              `json:"role"`
+	OrgID         int                 `json:"org_id"`
+	Organizations []auth.Organization `json:"organizations"`
+	ExpiresIn     int                 `json:"expires_in"`
 }
 
 // Login authenticates user with username and password, returns JWT token and refresh token
@@ -66,8 +69,31 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Resolve the active organization: the requested one, or the user's first membership
+	orgs, err := h.userRepository.GetUserOrganizations(user.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to load organizations"})
+		return
+	}
+
+	orgID := 0
+	for _, org := range orgs {
+		if body.OrgID == 0 || org.ID == body.OrgID {
+			orgID = org.ID
+			break
+		}
+	}
+	if orgID == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "user is not a member of the requested organization"})
+		return
+	}
+
 	// Generate access token (1 hour)
-	token, err := h.authManager.GenerateToken(user.Role, user.ID)
+	token, err := h.authManager.GenerateToken(user.Role, user.ID, orgID)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -76,7 +102,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate refresh token (7 days)
-	refreshToken, err := h.authManager.GenerateRefreshToken(user.ID, user.Role)
+	refreshToken, err := h.authManager.GenerateRefreshToken(user.ID, user.Role, orgID)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -96,10 +122,12 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(LoginResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-		Role:         user.Role,
-		ExpiresIn:    3600, // 1 hour in seconds
+		Token:         token,
+		RefreshToken:  refreshToken,
+		Role:          user.Role,
+		OrgID:         orgID,
+		Organizations: orgs,
+		ExpiresIn:     3600, // 1 hour in seconds
 	})
 }
 
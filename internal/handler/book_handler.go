@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ledger-go-system/internal/repository"
+)
+
+type BookHandler struct {
+	repo *repository.BookRepository
+}
+
+func NewBookHandler(db *sql.DB) *BookHandler {
+	return &BookHandler{repo: repository.NewBookRepository(db)}
+}
+
+// CreateBookRequest registers a book. The fiscal year starts on the first of
+// fiscal_year_start_month (1-12, default January).
+type CreateBookRequest struct {
+	Code                 string `json:"code"`
+	Name                 string `json:"name"`
+	FiscalYearStartMonth int    `json:"fiscal_year_start_month,omitempty"`
+}
+
+func (h *BookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateBookRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Code = strings.TrimSpace(body.Code)
+	if !validBookCode(body.Code) || strings.TrimSpace(body.Name) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "code (1-16 upper-case letters or digits) and name are required"})
+		return
+	}
+
+	if body.FiscalYearStartMonth == 0 {
+		body.FiscalYearStartMonth = 1
+	}
+	if body.FiscalYearStartMonth < 1 || body.FiscalYearStartMonth > 12 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "fiscal_year_start_month must be between 1 and 12"})
+		return
+	}
+
+	b, err := h.repo.Create(r.Context(), repository.Book{Code: body.Code, Name: body.Name, FiscalYearStartMonth: body.FiscalYearStartMonth})
+	if errors.Is(err, repository.ErrBookExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(b)
+}
+
+func (h *BookHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// validBookCode keeps book codes short and safe to embed in voucher numbers
+func validBookCode(code string) bool {
+	if code == "" || len(code) > 16 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type BudgetHandler struct {
+	repo *repository.BudgetRepository
+}
+
+func NewBudgetHandler(db *sql.DB) *BudgetHandler {
+	return &BudgetHandler{repo: repository.NewBudgetRepository(db)}
+}
+
+// CreateBudgetRequest budgets an account over the days from..to inclusive.
+// Amount is signed like postings; thresholds are percentages of it.
+type CreateBudgetRequest struct {
+	Name       string            `json:"name"`
+	Account    string            `json:"account"`
+	From       string            `json:"from"`
+	To         string            `json:"to"`
+	Amount     float64           `json:"amount"`
+	Thresholds []float64         `json:"thresholds"`
+	Dimensions map[string]string `json:"dimensions"`
+}
+
+func (h *BudgetHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Account = strings.TrimSpace(body.Account)
+	if strings.TrimSpace(body.Name) == "" || body.Account == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "name and account are required"})
+		return
+	}
+	if body.Amount == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "amount must be non-zero"})
+		return
+	}
+
+	from, err := parseDate("from", body.From)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	to, err := parseDate("to", body.To)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if from == nil || to == nil || to.Before(*from) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "from and to are required and to cannot be before from"})
+		return
+	}
+
+	thresholds := body.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = repository.DefaultBudgetThresholds
+	}
+	seen := map[float64]bool{}
+	var unique []float64
+	for _, t := range thresholds {
+		if t <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "thresholds must be positive percentages"})
+			return
+		}
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	sort.Float64s(unique)
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	created, err := h.repo.Create(r.Context(), repository.Budget{
+		Name:       body.Name,
+		Account:    body.Account,
+		Dimensions: body.Dimensions,
+		From:       *from,
+		To:         *to,
+		Amount:     body.Amount,
+		Thresholds: unique,
+		CreatedBy:  actor,
+	})
+	if errors.Is(err, repository.ErrUnknownDimension) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *BudgetHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Variance reports budget against actual for every budget started by ?as_of=
+func (h *BudgetHandler) Variance(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Variance(r.Context(), asOf)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *BudgetHandler) Alerts(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Alerts(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type CategorizationHandler struct {
+	repo *repository.CategorizationRepository
+}
+
+func NewCategorizationHandler(db *sql.DB) *CategorizationHandler {
+	return &CategorizationHandler{repo: repository.NewCategorizationRepository(db)}
+}
+
+// CreateCategorizationRequest defines a rule: conditions on the entry and the
+// account, tags and dimensions it assigns. Priority defaults to 100; lower
+// runs first.
+type CreateCategorizationRequest struct {
+	Name               string            `json:"name"`
+	Priority           *int              `json:"priority"`
+	DescriptionPattern string            `json:"description_pattern"`
+	MinAmount          *float64          `json:"min_amount"`
+	MaxAmount          *float64          `json:"max_amount"`
+	Metadata           map[string]string `json:"metadata"`
+	Account            string            `json:"account"`
+	Tags               []string          `json:"tags"`
+	Dimensions         map[string]string `json:"dimensions"`
+}
+
+func (h *CategorizationHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateCategorizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "name is required"})
+		return
+	}
+	if _, err := regexp.Compile(body.DescriptionPattern); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "description_pattern is not a valid regular expression: " + err.Error()})
+		return
+	}
+	if body.MinAmount != nil && body.MaxAmount != nil && *body.MaxAmount < *body.MinAmount {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "max_amount cannot be below min_amount"})
+		return
+	}
+
+	body.Account = strings.TrimSpace(body.Account)
+	if body.Account == "" && len(body.Tags) == 0 && len(body.Dimensions) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "a rule must assign an account, tags or dimensions"})
+		return
+	}
+
+	priority := 100
+	if body.Priority != nil {
+		priority = *body.Priority
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	created, err := h.repo.Create(r.Context(), repository.CategorizationRule{
+		Name:               body.Name,
+		Priority:           priority,
+		DescriptionPattern: body.DescriptionPattern,
+		MinAmount:          body.MinAmount,
+		MaxAmount:          body.MaxAmount,
+		Metadata:           body.Metadata,
+		Account:            body.Account,
+		Tags:               body.Tags,
+		Dimensions:         body.Dimensions,
+		CreatedBy:          actor,
+	})
+	if errors.Is(err, repository.ErrUnknownDimension) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *CategorizationHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *CategorizationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid rule id"})
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), ruleID)
+	if errors.Is(err, repository.ErrNoCategorizationRule) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": ruleID})
+}
+
+// Test takes an entry in the same shape as POST /ledger and shows which rule
+// would fire and the tags and postings it would end up with
+func (h *CategorizationHandler) Test(w http.ResponseWriter, r *http.Request) {
+	var body CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	data, err := h.repo.Test(r.Context(), repository.NewEntry{
+		Amount:      body.Amount,
+		Description: body.Description,
+		Postings:    body.Postings,
+		Metadata:    body.Metadata,
+		Tags:        body.Tags,
+	})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,418 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type ConsolidationHandler struct {
+	repo *repository.ConsolidationRepository
+}
+
+func NewConsolidationHandler(db *sql.DB) *ConsolidationHandler {
+	return &ConsolidationHandler{repo: repository.NewConsolidationRepository(db)}
+}
+
+type CreateGroupRequest struct {
+	Name              string `json:"name"`
+	Currency          string `json:"currency"`
+	CTAAccount        string `json:"cta_account"`
+	DifferenceAccount string `json:"difference_account"`
+}
+
+type AddMemberRequest struct {
+	OrgID    int    `json:"org_id"`
+	Currency string `json:"currency"`
+}
+
+type TagIntercompanyRequest struct {
+	Account           string `json:"account"`
+	CounterpartyOrgID int    `json:"counterparty_org_id"`
+}
+
+type FXRateRequest struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Date string  `json:"date"`
+	Type string  `json:"type"`
+	Rate float64 `json:"rate"`
+}
+
+// TrialBalance returns the organization's account balances as of ?as_of=
+// (default now), rolled up to ?level= of the chart of accounts
+func (h *ConsolidationHandler) TrialBalance(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	at := time.Now().UTC()
+	if asOf != nil {
+		at = *asOf
+	}
+
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.TrialBalance(r.Context(), at, level)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *ConsolidationHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var body CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || !validCurrency(body.Currency) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "name and a 3-letter currency are required"})
+		return
+	}
+	if body.CTAAccount == "" {
+		body.CTAAccount = "Equity:TranslationAdjustment"
+	}
+	if body.DifferenceAccount == "" {
+		body.DifferenceAccount = "Equity:IntercompanyDifference"
+	}
+
+	g, err := h.repo.CreateGroup(r.Context(), repository.ConsolidationGroup{
+		Name:              body.Name,
+		Currency:          body.Currency,
+		CTAAccount:        body.CTAAccount,
+		DifferenceAccount: body.DifferenceAccount,
+	})
+	if errors.Is(err, repository.ErrGroupExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+func (h *ConsolidationHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Groups(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// AddMember adds an entity to the group in the path
+func (h *ConsolidationHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid group id"})
+		return
+	}
+
+	var body AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if body.OrgID <= 0 || !validCurrency(body.Currency) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "org_id and a 3-letter currency are required"})
+		return
+	}
+
+	err = h.repo.AddMember(r.Context(), groupID, repository.ConsolidationMember{OrgID: body.OrgID, Currency: body.Currency})
+	if errors.Is(err, repository.ErrNoGroup) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownOrganization) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicateMember) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Consolidate returns the group's trial balance as of ?as_of= (default now).
+// ?tolerance= is how far apart intercompany balances may be and still be eliminated.
+func (h *ConsolidationHandler) Consolidate(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid group id"})
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	at := time.Now().UTC()
+	if asOf != nil {
+		at = *asOf
+	}
+
+	tolerance := 0.01
+	if v := r.URL.Query().Get("tolerance"); v != "" {
+		tolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil || tolerance < 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "tolerance must be a non-negative number"})
+			return
+		}
+	}
+
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Consolidate(r.Context(), groupID, at, tolerance, level)
+	if errors.Is(err, repository.ErrNoGroup) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrNotMember) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrMissingRate) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// TagIntercompany tags one of the organization's accounts as a balance with another entity
+func (h *ConsolidationHandler) TagIntercompany(w http.ResponseWriter, r *http.Request) {
+	var body TagIntercompanyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Account = strings.TrimSpace(body.Account)
+	if body.Account == "" || body.CounterpartyOrgID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "account and counterparty_org_id are required"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	a, err := h.repo.TagIntercompany(r.Context(), repository.IntercompanyAccount{
+		Account:           body.Account,
+		CounterpartyOrgID: body.CounterpartyOrgID,
+		CreatedBy:         actor,
+	})
+	if errors.Is(err, repository.ErrUnknownOrganization) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrIntercompanyTagged) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+func (h *ConsolidationHandler) ListIntercompany(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.IntercompanyAccounts(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// AddRates loads a JSON array of period rates
+func (h *ConsolidationHandler) AddRates(w http.ResponseWriter, r *http.Request) {
+	var body []FXRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	rates := make([]repository.FXRate, 0, len(body))
+	for i, b := range body {
+		date, err := time.Parse("2006-01-02", b.Date)
+		valid := err == nil && validCurrency(b.From) && validCurrency(b.To) && b.From != b.To && b.Rate > 0 &&
+			(b.Type == repository.RateClosing || b.Type == repository.RateAverage)
+		if !valid {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "rate " + strconv.Itoa(i+1) + ": needs from and to currencies, a YYYY-MM-DD date, a type of closing or average and a positive rate"})
+			return
+		}
+		rates = append(rates, repository.FXRate{From: b.From, To: b.To, Date: date, Type: b.Type, Rate: b.Rate})
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	n, err := h.repo.AddRates(r.Context(), rates, actor)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "loaded", "count": n})
+}
+
+// ListRates returns the rates in effect on ?date= (default today)
+func (h *ConsolidationHandler) ListRates(w http.ResponseWriter, r *http.Request) {
+	date, err := parseDate("date", r.URL.Query().Get("date"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	day := valuationDate(date)
+
+	data, err := h.repo.Rates(r.Context(), day)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// validCurrency reports whether code looks like an ISO 4217 code
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type CounterpartyHandler struct {
+	repo *repository.CounterpartyRepository
+}
+
+func NewCounterpartyHandler(db *sql.DB) *CounterpartyHandler {
+	return &CounterpartyHandler{repo: repository.NewCounterpartyRepository(db)}
+}
+
+type CreateCounterpartyRequest struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	LEI  string `json:"lei"`
+}
+
+// SetLimitRequest sets a counterparty's exposure limit; a null limit removes it
+type SetLimitRequest struct {
+	Limit  *float64 `json:"limit"`
+	Action string   `json:"action"`
+}
+
+func (h *CounterpartyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateCounterpartyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Code = strings.TrimSpace(body.Code)
+	if body.Code == "" || strings.TrimSpace(body.Name) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "code and name are required"})
+		return
+	}
+
+	if body.LEI != "" && len(body.LEI) != 20 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "lei must be 20 characters"})
+		return
+	}
+
+	c, err := h.repo.Create(r.Context(), repository.Counterparty{Code: body.Code, Name: body.Name, LEI: body.LEI})
+	if errors.Is(err, repository.ErrCounterpartyExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (h *CounterpartyHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// SetLimit records a new exposure limit for the counterparty in the path
+func (h *CounterpartyHandler) SetLimit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid counterparty id"})
+		return
+	}
+
+	var body SetLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if body.Action == "" {
+		body.Action = repository.LimitReject
+	}
+	if body.Action != repository.LimitReject && body.Action != repository.LimitFlag {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "action must be reject or flag"})
+		return
+	}
+
+	if body.Limit != nil && *body.Limit < 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "limit must not be negative"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	limit, err := h.repo.SetLimit(r.Context(), id, body.Limit, body.Action, actor)
+	if errors.Is(err, repository.ErrUnknownCounterparty) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "counterparty not found"})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(limit)
+}
+
+// Exposure reports current exposure against limits for every counterparty
+func (h *CounterpartyHandler) Exposure(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Exposures(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
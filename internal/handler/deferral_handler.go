@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type DeferralHandler struct {
+	repo *repository.DeferralRepository
+}
+
+// NewDeferralHandler returns a handler whose release entries must pass the
+// given validators before they are posted
+func NewDeferralHandler(db *sql.DB, validators ...repository.Validator) *DeferralHandler {
+	return &DeferralHandler{repo: repository.NewDeferralRepository(db, validators...)}
+}
+
+type DeferralLineRequest struct {
+	Date   string  `json:"date"`
+	Amount float64 `json:"amount"`
+}
+
+// CreateDeferralRequest attaches a schedule to the entry in the path.
+// straight_line uses start_date and periods (months); custom uses lines.
+type CreateDeferralRequest struct {
+	DeferredAccount    string                `json:"deferred_account"`
+	RecognitionAccount string                `json:"recognition_account"`
+	Method             string                `json:"method"`
+	StartDate          string                `json:"start_date"`
+	Periods            int                   `json:"periods"`
+	Lines              []DeferralLineRequest `json:"lines"`
+}
+
+// ReleaseRequest releases every deferral line recognized up to Through
+type ReleaseRequest struct {
+	Through string `json:"through"`
+}
+
+func (h *DeferralHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	var body CreateDeferralRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	d := repository.NewDeferral{
+		DeferredAccount:    strings.TrimSpace(body.DeferredAccount),
+		RecognitionAccount: strings.TrimSpace(body.RecognitionAccount),
+		Method:             body.Method,
+		Periods:            body.Periods,
+	}
+	if d.DeferredAccount == "" || d.RecognitionAccount == "" || d.DeferredAccount == d.RecognitionAccount {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "deferred_account and recognition_account are required and must differ"})
+		return
+	}
+
+	switch d.Method {
+	case repository.DeferralStraightLine:
+		start, err := time.Parse("2006-01-02", body.StartDate)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "start_date must be YYYY-MM-DD"})
+			return
+		}
+		if d.Periods < 1 || d.Periods > 600 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "periods must be between 1 and 600"})
+			return
+		}
+		d.StartDate = start
+	case repository.DeferralCustom:
+		if len(body.Lines) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "custom schedules need lines"})
+			return
+		}
+		for _, l := range body.Lines {
+			date, err := time.Parse("2006-01-02", l.Date)
+			if err != nil || l.Amount <= 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "each line needs a YYYY-MM-DD date and a positive amount"})
+				return
+			}
+			d.Lines = append(d.Lines, repository.DeferralLine{Date: date, Amount: l.Amount})
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "method must be straight_line or custom"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	s, err := h.repo.Create(r.Context(), ledgerID, d, actor)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "ledger entry not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrNoDeferredAmount) || errors.Is(err, repository.ErrDeferralLines) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrDeferralExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// Get returns the deferral schedules of the entry in the path
+func (h *DeferralHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	data, err := h.repo.ForEntry(r.Context(), ledgerID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Release posts every deferral line recognized on or before the requested date
+func (h *DeferralHandler) Release(w http.ResponseWriter, r *http.Request) {
+	var body ReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	through, err := time.Parse("2006-01-02", body.Through)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "through must be YYYY-MM-DD"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	run, err := h.repo.Release(r.Context(), through, actor)
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: violation.Error(), Rule: violation.Rule})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(run)
+}
+
+// Waterfall reports deferred balances by month for ?from=YYYY-MM&to=YYYY-MM
+// (default: this month and the next eleven), optionally for one ?account= and
+// its sub-accounts, with accounts rolled up to ?level= of the chart of accounts
+func (h *DeferralHandler) Waterfall(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	today := valuationDate(nil)
+	from := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse("2006-01", v)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "from must be YYYY-MM"})
+			return
+		}
+		from = t
+	}
+	to := from.AddDate(0, 11, 0)
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse("2006-01", v)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "to must be YYYY-MM"})
+			return
+		}
+		to = t
+	}
+
+	if to.Before(from) || to.After(from.AddDate(10, 0, 0)) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "to must be after from and within ten years of it"})
+		return
+	}
+
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Waterfall(r.Context(), from, to, q.Get("account"), level)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
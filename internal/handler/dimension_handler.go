@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type DimensionHandler struct {
+	repo *repository.DimensionRepository
+}
+
+func NewDimensionHandler(db *sql.DB) *DimensionHandler {
+	return &DimensionHandler{repo: repository.NewDimensionRepository(db)}
+}
+
+type DimensionValueRequest struct {
+	Value string `json:"value"`
+	Name  string `json:"name"`
+}
+
+// CreateDimensionRequest configures a dimension with its allowed values
+type CreateDimensionRequest struct {
+	Code   string                  `json:"code"`
+	Name   string                  `json:"name"`
+	Values []DimensionValueRequest `json:"values"`
+}
+
+type DimensionRuleRequest struct {
+	Account   string `json:"account"`
+	Dimension string `json:"dimension"`
+	Rule      string `json:"rule"`
+}
+
+func (h *DimensionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body CreateDimensionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Code = strings.TrimSpace(body.Code)
+	if body.Code == "" || body.Code == repository.ReportByAccount || strings.Contains(body.Code, ",") || strings.TrimSpace(body.Name) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "code and name are required; code cannot be \"account\" or contain commas"})
+		return
+	}
+
+	d := repository.Dimension{Code: body.Code, Name: body.Name}
+	for _, v := range body.Values {
+		value, ok := h.value(w, v)
+		if !ok {
+			return
+		}
+		d.Values = append(d.Values, value)
+	}
+
+	created, err := h.repo.Create(r.Context(), d)
+	if errors.Is(err, repository.ErrDimensionExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *DimensionHandler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// AddValue allows another value for the dimension in the path
+func (h *DimensionHandler) AddValue(w http.ResponseWriter, r *http.Request) {
+	var body DimensionValueRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	value, ok := h.value(w, body)
+	if !ok {
+		return
+	}
+
+	created, err := h.repo.AddValue(r.Context(), r.PathValue("code"), value)
+	if errors.Is(err, repository.ErrUnknownDimension) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrDimensionExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// SetRule makes a dimension required or optional on an account
+func (h *DimensionHandler) SetRule(w http.ResponseWriter, r *http.Request) {
+	var body DimensionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Account = strings.TrimSpace(body.Account)
+	if body.Account == "" || body.Dimension == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "account and dimension are required"})
+		return
+	}
+	if body.Rule != repository.DimensionRequired && body.Rule != repository.DimensionOptional {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "rule must be required or optional"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	rule, err := h.repo.SetRule(r.Context(), repository.DimensionRule{Account: body.Account, Dimension: body.Dimension, Rule: body.Rule, CreatedBy: actor})
+	if errors.Is(err, repository.ErrUnknownDimension) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (h *DimensionHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Rules(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *DimensionHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid rule id"})
+		return
+	}
+
+	err = h.repo.DeleteRule(r.Context(), ruleID)
+	if errors.Is(err, repository.ErrNoDimensionRule) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": ruleID})
+}
+
+// Report pivots postings by ?by=desk,project (and optionally account, rolled
+// up to ?level=), created between ?from= and ?to= (YYYY-MM-DD, inclusive),
+// optionally under one ?account= of the chart
+func (h *DimensionHandler) Report(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var by []string
+	for _, code := range strings.Split(q.Get("by"), ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			by = append(by, code)
+		}
+	}
+	if len(by) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "by must name at least one dimension"})
+		return
+	}
+
+	from, err := parseDate("from", q.Get("from"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	to, err := parseDate("to", q.Get("to"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if to != nil {
+		end := to.Add(24*time.Hour - time.Microsecond)
+		to = &end
+	}
+
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Report(r.Context(), repository.DimensionReportQuery{
+		By:      by,
+		From:    from,
+		To:      to,
+		Account: q.Get("account"),
+		Level:   level,
+	})
+	if errors.Is(err, repository.ErrUnknownDimension) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *DimensionHandler) value(w http.ResponseWriter, v DimensionValueRequest) (repository.DimensionValue, bool) {
+	v.Value = strings.TrimSpace(v.Value)
+	if v.Value == "" || strings.TrimSpace(v.Name) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "each value needs a value and a name"})
+		return repository.DimensionValue{}, false
+	}
+	return repository.DimensionValue{Value: v.Value, Name: v.Name}, true
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/daycount"
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type InterestHandler struct {
+	repo *repository.InterestRepository
+}
+
+// NewInterestHandler returns a handler whose accrual entries must pass the
+// given validators before they are posted
+func NewInterestHandler(db *sql.DB, validators ...repository.Validator) *InterestHandler {
+	return &InterestHandler{repo: repository.NewInterestRepository(db, validators...)}
+}
+
+type CreateInterestScheduleRequest struct {
+	Account         string  `json:"account"`
+	AnnualRate      float64 `json:"annual_rate"`
+	DayCount        string  `json:"day_count"`
+	AccruedAccount  string  `json:"accrued_account"`
+	InterestAccount string  `json:"interest_account"`
+	Capitalization  string  `json:"capitalization"`
+	EffectiveFrom   string  `json:"effective_from"`
+}
+
+// AccrueRequest accrues interest for every day not yet accrued up to Through
+type AccrueRequest struct {
+	Through string `json:"through"`
+}
+
+func (h *InterestHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var body CreateInterestScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Account = strings.TrimSpace(body.Account)
+	body.AccruedAccount = strings.TrimSpace(body.AccruedAccount)
+	body.InterestAccount = strings.TrimSpace(body.InterestAccount)
+	if body.Account == "" || body.AccruedAccount == "" || body.InterestAccount == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "account, accrued_account and interest_account are required"})
+		return
+	}
+
+	if body.AccruedAccount == body.Account || body.InterestAccount == body.Account {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "accrued_account and interest_account must differ from account"})
+		return
+	}
+
+	if !daycount.Valid(body.DayCount) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "day_count must be ACT/360, ACT/365 or 30/360"})
+		return
+	}
+
+	if body.Capitalization == "" {
+		body.Capitalization = repository.CapitalizeNone
+	}
+	switch body.Capitalization {
+	case repository.CapitalizeNone, repository.CapitalizeMonthly, repository.CapitalizeQuarterly, repository.CapitalizeAnnually:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "capitalization must be none, monthly, quarterly or annually"})
+		return
+	}
+
+	effectiveFrom, err := time.Parse("2006-01-02", body.EffectiveFrom)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "effective_from must be YYYY-MM-DD"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	s, err := h.repo.AddSchedule(r.Context(), repository.InterestSchedule{
+		Account:         body.Account,
+		AnnualRate:      body.AnnualRate,
+		DayCount:        body.DayCount,
+		AccruedAccount:  body.AccruedAccount,
+		InterestAccount: body.InterestAccount,
+		Capitalization:  body.Capitalization,
+		EffectiveFrom:   effectiveFrom,
+	}, actor)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *InterestHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Schedules(r.Context(), r.URL.Query().Get("account"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// ListAccruals returns daily accruals, filtered by account, from and to
+func (h *InterestHandler) ListAccruals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := parseDate("from", q.Get("from"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	to, err := parseDate("to", q.Get("to"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Accruals(r.Context(), q.Get("account"), from, to)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Accrue runs the accrual for every day not yet accrued up to the requested
+// date. Only completed days can be accrued, since a day's balance is final
+// once it has ended.
+func (h *InterestHandler) Accrue(w http.ResponseWriter, r *http.Request) {
+	var body AccrueRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	through, err := time.Parse("2006-01-02", body.Through)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "through must be YYYY-MM-DD"})
+		return
+	}
+
+	if !through.Before(valuationDate(nil)) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "through must be before today"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	run, err := h.repo.Accrue(r.Context(), through, actor)
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: violation.Error(), Rule: violation.Rule})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(run)
+}
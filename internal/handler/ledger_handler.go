@@ -3,31 +3,74 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"ledger-go-system/internal/archive"
+	"ledger-go-system/internal/calendar"
+	"ledger-go-system/internal/journal"
 	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/query"
 	"ledger-go-system/internal/repository"
 )
 
+// maxJournalImportBytes bounds a single journal import
+const maxJournalImportBytes = 10 << 20
+
 type LedgerHandler struct {
-	repo *repository.LedgerRepository
+	repo       *repository.LedgerRepository
+	archives   *repository.ArchiveRepository
+	accounts   *repository.AccountRepository
+	settlement calendar.SettlementCycle
 }
 
-func NewLedgerHandler(db *sql.DB) *LedgerHandler {
+// NewLedgerHandler returns a handler whose entries must pass the given
+// validators before they are posted
+func NewLedgerHandler(db *sql.DB, archives *archive.Store, settlement calendar.SettlementCycle, validators ...repository.Validator) *LedgerHandler {
 	return &LedgerHandler{
-		repo: repository.NewLedgerRepository(db),
+		repo:       repository.NewLedgerRepository(db, validators...),
+		archives:   repository.NewArchiveRepository(db, archives),
+		accounts:   repository.NewAccountRepository(db),
+		settlement: settlement,
 	}
 }
 
 type CreateRequest struct {
-	Amount      float64 `json:"amount"`
-	Description string  `json:"description"`
+	Amount      float64              `json:"amount"`
+	Description string               `json:"description"`
+	Postings    []repository.Posting `json:"postings,omitempty"`
+	LotMethod   string               `json:"lot_method,omitempty"`
+	LotIDs      []int                `json:"lot_ids,omitempty"`
+
+	// TradeDate and SettlementDate are YYYY-MM-DD; a trade date alone
+	// settles on the server's settlement cycle (T+SETTLEMENT_LAG_DAYS)
+	TradeDate      string `json:"trade_date,omitempty"`
+	SettlementDate string `json:"settlement_date,omitempty"`
+
+	CounterpartyID *int `json:"counterparty_id,omitempty"`
+
+	// Book numbers the entry; empty means the default JV book
+	Book string `json:"book,omitempty"`
+
+	// Metadata and Tags are free-form labels categorization rules can match
+	// on and add to; postings without an account are left to the rules
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
 }
 
+// ErrorResponse carries the error message, and for policy rule violations
+// the name of the rule that rejected the request
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error    string `json:"error"`
+	Rule     string `json:"rule,omitempty"`
+	Position int    `json:"position,omitempty"`
+	Line     int    `json:"line,omitempty"`
 }
 
 func (h *LedgerHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +102,85 @@ func (h *LedgerHandler) Create(w http.ResponseWriter, r *http.Request) {
 		actor = "unknown"
 	}
 
-	if err := h.repo.Create(r.Context(), body.Amount, body.Description, actor); err != nil {
+	tradeDate, err := parseDate("trade_date", body.TradeDate)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	settlementDate, err := parseDate("settlement_date", body.SettlementDate)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if tradeDate != nil && settlementDate == nil {
+		d := h.settlement.SettlementDate(*tradeDate)
+		settlementDate = &d
+	}
+
+	entry := repository.NewEntry{
+		Amount:         body.Amount,
+		Description:    body.Description,
+		Postings:       body.Postings,
+		LotMethod:      body.LotMethod,
+		LotIDs:         body.LotIDs,
+		TradeDate:      tradeDate,
+		SettlementDate: settlementDate,
+		CounterpartyID: body.CounterpartyID,
+		Book:           body.Book,
+		Metadata:       body.Metadata,
+		Tags:           body.Tags,
+	}
+	err = entry.Validate()
+	var invalid *repository.RuleViolation
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: invalid.Error(), Rule: invalid.Rule})
+		return
+	}
+	if err != nil && !errors.Is(err, repository.ErrUncategorized) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	id, err := h.repo.Create(r.Context(), entry, actor)
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: violation.Error(), Rule: violation.Rule})
+		return
+	}
+	var held *repository.EntryHeld
+	if errors.As(err, &held) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "held", "anomaly_id": held.AnomalyID, "reasons": held.Reasons})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownCounterparty) || errors.Is(err, repository.ErrUnknownTaxCode) ||
+		errors.Is(err, repository.ErrNoTaxRate) || errors.Is(err, repository.ErrUnbalanced) ||
+		errors.Is(err, repository.ErrUnknownBook) || errors.Is(err, repository.ErrHeaderAccount) ||
+		errors.Is(err, repository.ErrUnknownDimension) || errors.Is(err, repository.ErrDimensionRule) ||
+		errors.Is(err, repository.ErrUncategorized) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrInsufficientLots) || errors.Is(err, repository.ErrExposureLimit) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
@@ -68,11 +189,24 @@ func (h *LedgerHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "created", "id": id})
 }
 
 func (h *LedgerHandler) List(w http.ResponseWriter, r *http.Request) {
-	data, err := h.repo.List(r.Context())
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var data []repository.Ledger
+	if asOf != nil {
+		data, err = h.listAsOf(r, *asOf)
+	} else {
+		data, err = h.repo.List(r.Context())
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -85,6 +219,23 @@ func (h *LedgerHandler) List(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// listAsOf reconstructs the ledger at asOf from live and archived entries, in
+// id order. Archived periods left the ledger table after they were posted, so
+// a point in time before the archiving run would be missing them otherwise.
+func (h *LedgerHandler) listAsOf(r *http.Request, asOf time.Time) ([]repository.Ledger, error) {
+	archived, err := h.archives.ListAsOf(r.Context(), asOf)
+	if err != nil {
+		return nil, err
+	}
+	live, err := h.repo.ListAsOf(r.Context(), asOf)
+	if err != nil {
+		return nil, err
+	}
+	data := append(archived, live...)
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data, nil
+}
+
 func (h *LedgerHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	idStr := parts[len(parts)-1]
@@ -96,7 +247,27 @@ func (h *LedgerHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := h.repo.GetByID(r.Context(), id)
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var data *repository.Ledger
+	if asOf != nil {
+		data, err = h.repo.GetByIDAsOf(r.Context(), id, *asOf)
+		if err != nil {
+			data, err = h.archives.GetByIDAsOf(r.Context(), id, *asOf)
+		}
+	} else {
+		data, err = h.repo.GetByID(r.Context(), id)
+		if err != nil {
+			// Entries from closed periods may have been moved to archive segments
+			data, err = h.archives.GetByID(r.Context(), id)
+		}
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -108,3 +279,360 @@ func (h *LedgerHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(data)
 }
+
+// Stats totals, counts, averages and bounds entry amounts by ?group_by=day,
+// week, month or tag (day by default) over ?from= and ?to= dates, both
+// inclusive. Dates and buckets follow ?timezone=, UTC by default.
+func (h *LedgerHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	groupBy := q.Get("group_by")
+	switch groupBy {
+	case "":
+		groupBy = repository.StatsByDay
+	case repository.StatsByDay, repository.StatsByWeek, repository.StatsByMonth, repository.StatsByTag:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "group_by must be day, week, month or tag"})
+		return
+	}
+
+	location := time.UTC
+	if v := q.Get("timezone"); v != "" {
+		var err error
+		if location, err = time.LoadLocation(v); err != nil || v == "Local" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "timezone must be an IANA time zone such as Europe/London"})
+			return
+		}
+	}
+
+	from, err := parseDate("from", q.Get("from"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	to, err := parseDate("to", q.Get("to"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "to cannot be before from"})
+		return
+	}
+
+	// The range runs from local midnight on from to the end of to
+	if from != nil {
+		start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location).UTC()
+		from = &start
+	}
+	if to != nil {
+		end := time.Date(to.Year(), to.Month(), to.Day()+1, 0, 0, 0, 0, location).Add(-time.Microsecond).UTC()
+		to = &end
+	}
+
+	data, err := h.repo.Stats(r.Context(), repository.StatsQuery{
+		GroupBy:  groupBy,
+		From:     from,
+		To:       to,
+		Location: location,
+	})
+	if errors.Is(err, repository.ErrArchivedRange) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// QueryRequest is a query in the ledger query language
+type QueryRequest struct {
+	Query string `json:"query"`
+}
+
+// Query answers an ad-hoc question in the ledger query language. Syntax
+// errors are returned with the position of the problem.
+func (h *LedgerHandler) Query(w http.ResponseWriter, r *http.Request) {
+	var body QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if strings.TrimSpace(body.Query) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "query is required"})
+		return
+	}
+
+	plan, err := query.Parse(body.Query)
+	var syntaxErr *query.Error
+	if errors.As(err, &syntaxErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Position: syntaxErr.Pos})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Query(r.Context(), plan)
+	if errors.Is(err, repository.ErrArchivedRange) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrQueryTimeout) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Search ranks live entries matching ?q= in their description or metadata,
+// with matched terms highlighted, paged by ?limit= and ?offset=
+func (h *LedgerHandler) Search(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if errors.Is(err, repository.ErrInvalidSearch) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Export streams every entry, archived segments first and then the live
+// ledger, as newline-delimited JSON, or with ?format= as a journal
+func (h *LedgerHandler) Export(w http.ResponseWriter, r *http.Request) {
+	// Read segment metadata up front so a broken archive fails before any output
+	if _, err := h.archives.Segments(r.Context()); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" {
+		h.exportJournal(w, r, format)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="ledger.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	write := func(l repository.Ledger) error { return enc.Encode(l) }
+	if err := h.archives.Each(r.Context(), write); err != nil {
+		log.Printf("ledger export failed: %v", err)
+		return
+	}
+	if err := h.repo.Each(r.Context(), write); err != nil {
+		log.Printf("ledger export failed: %v", err)
+	}
+}
+
+// exportJournal streams every entry as a Beancount or ledger-cli journal in
+// ?currency= (USD by default), with the chart of accounts declared first
+func (h *LedgerHandler) exportJournal(w http.ResponseWriter, r *http.Request, format string) {
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = journal.DefaultCurrency
+	}
+
+	accounts, err := h.accounts.List(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	filename := "ledger.journal"
+	if format == journal.FormatBeancount {
+		filename = "ledger.beancount"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	jw, err := journal.NewWriter(w, format, currency, accounts)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.archives.Each(r.Context(), jw.Write); err != nil {
+		log.Printf("ledger export failed: %v", err)
+		return
+	}
+	if err := h.repo.Each(r.Context(), jw.Write); err != nil {
+		log.Printf("ledger export failed: %v", err)
+		return
+	}
+	if err := jw.Close(); err != nil {
+		log.Printf("ledger export failed: %v", err)
+	}
+}
+
+// Import posts the entries of a Beancount or ledger-cli journal, adding the
+// accounts it declares that the chart does not have, all or nothing. Entries
+// the anomaly screen holds are listed with their anomaly.
+func (h *LedgerHandler) Import(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != journal.FormatBeancount && format != journal.FormatLedger {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "format must be beancount or ledger"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	j, err := journal.Parse(http.MaxBytesReader(w, r.Body, maxJournalImportBytes), format, r.URL.Query().Get("currency"))
+	var journalErr *journal.Error
+	if errors.As(err, &journalErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: journalErr.Error(), Line: journalErr.Line})
+		return
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("journal exceeds %d bytes", maxJournalImportBytes)})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if len(j.Accounts) == 0 && len(j.Entries) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "journal has no accounts or transactions"})
+		return
+	}
+
+	entries := make([]repository.NewEntry, len(j.Entries))
+	for i, e := range j.Entries {
+		entries[i] = e.NewEntry
+	}
+	result, err := h.repo.Import(r.Context(), j.Accounts, entries, actor)
+
+	// Point entry errors at the transaction's line in the journal
+	resp := ErrorResponse{}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	var importErr *repository.ImportError
+	if errors.As(err, &importErr) {
+		resp.Line = j.Entries[importErr.Index].Line
+		resp.Error = fmt.Sprintf("line %d: %v", resp.Line, importErr.Err)
+	}
+
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		resp.Rule = violation.Rule
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownCounterparty) || errors.Is(err, repository.ErrUnknownTaxCode) ||
+		errors.Is(err, repository.ErrNoTaxRate) || errors.Is(err, repository.ErrUnbalanced) ||
+		errors.Is(err, repository.ErrUnknownBook) || errors.Is(err, repository.ErrHeaderAccount) ||
+		errors.Is(err, repository.ErrUnknownDimension) || errors.Is(err, repository.ErrDimensionRule) ||
+		errors.Is(err, repository.ErrUncategorized) || errors.Is(err, repository.ErrUnknownParent) ||
+		errors.Is(err, repository.ErrParentNotHeader) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if errors.Is(err, repository.ErrInsufficientLots) || errors.Is(err, repository.ErrExposureLimit) ||
+		errors.Is(err, repository.ErrAccountInUse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Held entries are pointed at by their line, like failed ones
+	held := make([]map[string]interface{}, len(result.Held))
+	for i, e := range result.Held {
+		held[i] = map[string]interface{}{"line": j.Entries[e.Index].Line, "anomaly_id": e.AnomalyID, "reasons": e.Reasons}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "imported", "accounts": result.Accounts, "entries": result.Entries, "held": held})
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseAsOf reads the optional as_of query parameter. RFC 3339 timestamps are
+// taken as the exact instant; a bare date (2006-01-02) means the end of that day in UTC.
+func parseAsOf(r *http.Request) (*time.Time, error) {
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		t = t.UTC()
+		return &t, nil
+	}
+	if d, err := time.Parse("2006-01-02", v); err == nil {
+		t := d.Add(24*time.Hour - time.Microsecond)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("as_of must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+}
+
+// parseDate reads an optional YYYY-MM-DD field; empty means unset
+func parseDate(field, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	d, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a YYYY-MM-DD date", field)
+	}
+	return &d, nil
+}
+
+// parseLevel reads the optional level query parameter: the depth of the chart
+// of accounts balances are rolled up to, 1 being the top level. Zero, the
+// default, reports every account separately.
+func parseLevel(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("level")
+	if v == "" {
+		return 0, nil
+	}
+	level, err := strconv.Atoi(v)
+	if err != nil || level < 0 {
+		return 0, fmt.Errorf("level must be a non-negative integer")
+	}
+	return level, nil
+}
+
+// parsePage reads the optional limit and offset query parameters. limit
+// defaults to 50 and may not exceed 200.
+func parsePage(r *http.Request) (int, int, error) {
+	limit, offset := 50, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			return 0, 0, fmt.Errorf("limit must be between 1 and 200")
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
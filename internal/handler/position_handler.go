@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ledger-go-system/internal/repository"
+)
+
+type PositionHandler struct {
+	repo *repository.PositionRepository
+}
+
+func NewPositionHandler(db *sql.DB) *PositionHandler {
+	return &PositionHandler{repo: repository.NewPositionRepository(db)}
+}
+
+type CreateInstrumentRequest struct {
+	Symbol     string `json:"symbol"`
+	Name       string `json:"name"`
+	AssetClass string `json:"asset_class"`
+	Currency   string `json:"currency"`
+}
+
+// Positions returns open quantity, remaining cost and realized P&L per account
+// and instrument, with accounts rolled up to ?level= of the chart of accounts
+func (h *PositionHandler) Positions(w http.ResponseWriter, r *http.Request) {
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Positions(r.Context(), level)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Lots returns open lots, optionally filtered by ?account= and ?instrument=
+func (h *PositionHandler) Lots(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data, err := h.repo.OpenLots(r.Context(), q.Get("account"), q.Get("instrument"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *PositionHandler) ListInstruments(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Instruments(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *PositionHandler) CreateInstrument(w http.ResponseWriter, r *http.Request) {
+	var body CreateInstrumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Symbol = strings.TrimSpace(body.Symbol)
+	if body.Symbol == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "symbol is required"})
+		return
+	}
+
+	if body.Currency != "" && len(body.Currency) != 3 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "currency must be a 3-letter code"})
+		return
+	}
+
+	in, err := h.repo.CreateInstrument(r.Context(), repository.Instrument{
+		Symbol:     body.Symbol,
+		Name:       body.Name,
+		AssetClass: body.AssetClass,
+		Currency:   strings.ToUpper(body.Currency),
+	})
+	if errors.Is(err, repository.ErrInstrumentExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(in)
+}
@@ -16,6 +16,7 @@ type RefreshTokenRequest struct {
 type RefreshTokenResponse struct {
 	Token   string `json:"token"`
 	Role    string `json:"role"`
+	OrgID   int    `json:"org_id"`
 	Message string `json:"message,omitempty"`
 }
 
@@ -65,8 +66,23 @@ func (h *RefreshHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Membership may have been revoked since the refresh token was issued
+	member, err := h.userRepository.IsMember(claims.UserID, claims.OrgID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to verify organization membership"})
+		return
+	}
+	if !member {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "user is no longer a member of the organization"})
+		return
+	}
+
 	// Generate new access token
-	newToken, err := h.authManager.GenerateToken(claims.Role, claims.UserID)
+	newToken, err := h.authManager.GenerateToken(claims.Role, claims.UserID, claims.OrgID)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -79,6 +95,7 @@ func (h *RefreshHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(RefreshTokenResponse{
 		Token:   newToken,
 		Role:    claims.Role,
+		OrgID:   claims.OrgID,
 		Message: "token refreshed successfully",
 	})
 }
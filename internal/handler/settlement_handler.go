@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ledger-go-system/internal/archive"
+	"ledger-go-system/internal/calendar"
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type SettlementHandler struct {
+	repo     *repository.SettlementRepository
+	calendar *calendar.Calendar
+}
+
+func NewSettlementHandler(db *sql.DB, archives *archive.Store, cal *calendar.Calendar) *SettlementHandler {
+	return &SettlementHandler{repo: repository.NewSettlementRepository(db, archives), calendar: cal}
+}
+
+type SettlementRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// Transition moves an entry to settled or failed
+func (h *SettlementHandler) Transition(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	var body SettlementRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if body.Status != repository.SettlementSettled && body.Status != repository.SettlementFailed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "status must be settled or failed"})
+		return
+	}
+
+	if body.Status == repository.SettlementFailed && body.Reason == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "reason is required for a failed settlement"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	ev, err := h.repo.Transition(r.Context(), ledgerID, body.Status, body.Reason, actor)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "ledger entry not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidTransition) || errors.Is(err, repository.ErrNoSettlement) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ev)
+}
+
+// Get returns an entry's settlement status and history
+func (h *SettlementHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ledgerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid ledger id"})
+		return
+	}
+
+	data, err := h.repo.Get(r.Context(), ledgerID)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, repository.ErrNoSettlement) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Aging reports unsettled entries as of ?as_of= (default today) by business days past due
+func (h *SettlementHandler) Aging(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	day := time.Now().UTC()
+	if asOf != nil {
+		day = *asOf
+	}
+
+	data, err := h.repo.Aging(r.Context(), day, h.calendar)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
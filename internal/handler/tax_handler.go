@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/repository"
+)
+
+type TaxHandler struct {
+	repo *repository.TaxRepository
+}
+
+func NewTaxHandler(db *sql.DB) *TaxHandler {
+	return &TaxHandler{repo: repository.NewTaxRepository(db)}
+}
+
+// CreateTaxCodeRequest registers a code with its first rate (0.2 is 20%)
+type CreateTaxCodeRequest struct {
+	Code          string  `json:"code"`
+	Name          string  `json:"name"`
+	TaxAccount    string  `json:"tax_account"`
+	Rate          float64 `json:"rate"`
+	EffectiveFrom string  `json:"effective_from"`
+}
+
+type AddTaxRateRequest struct {
+	Rate          float64 `json:"rate"`
+	EffectiveFrom string  `json:"effective_from"`
+}
+
+func (h *TaxHandler) CreateCode(w http.ResponseWriter, r *http.Request) {
+	var body CreateTaxCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	body.Code = strings.TrimSpace(body.Code)
+	body.TaxAccount = strings.TrimSpace(body.TaxAccount)
+	if body.Code == "" || strings.TrimSpace(body.Name) == "" || body.TaxAccount == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "code, name and tax_account are required"})
+		return
+	}
+
+	rate, ok := h.parseRate(w, body.Rate, body.EffectiveFrom)
+	if !ok {
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+	rate.CreatedBy = actor
+
+	c, err := h.repo.CreateCode(r.Context(), repository.TaxCode{Code: body.Code, Name: body.Name, TaxAccount: body.TaxAccount}, rate)
+	if errors.Is(err, repository.ErrTaxCodeExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (h *TaxHandler) ListCodes(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Codes(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// AddRate appends a rate to the code in the path
+func (h *TaxHandler) AddRate(w http.ResponseWriter, r *http.Request) {
+	var body AddTaxRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	rate, ok := h.parseRate(w, body.Rate, body.EffectiveFrom)
+	if !ok {
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+	rate.CreatedBy = actor
+
+	created, err := h.repo.AddRate(r.Context(), r.PathValue("code"), rate)
+	if errors.Is(err, repository.ErrUnknownTaxCode) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// Return summarizes tax lines dated ?from= to ?to= by ?period=month|quarter
+func (h *TaxHandler) Return(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := parseDate("from", q.Get("from"))
+	if err == nil && from == nil {
+		err = errors.New("from is required")
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	to, err := parseDate("to", q.Get("to"))
+	if err == nil && to == nil {
+		err = errors.New("to is required")
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	period := q.Get("period")
+	if period == "" {
+		period = repository.TaxPeriodMonth
+	}
+	if period != repository.TaxPeriodMonth && period != repository.TaxPeriodQuarter {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "period must be month or quarter"})
+		return
+	}
+
+	data, err := h.repo.Return(r.Context(), *from, *to, period)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// parseRate validates a rate and its effective date, writing a 400 when invalid
+func (h *TaxHandler) parseRate(w http.ResponseWriter, rate float64, effectiveFrom string) (repository.TaxRate, bool) {
+	if rate < 0 || rate >= 1 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "rate must be a fraction between 0 and 1"})
+		return repository.TaxRate{}, false
+	}
+	from, err := time.Parse("2006-01-02", effectiveFrom)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "effective_from must be YYYY-MM-DD"})
+		return repository.TaxRate{}, false
+	}
+	return repository.TaxRate{Rate: rate, EffectiveFrom: from}, true
+}
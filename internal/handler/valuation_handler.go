@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"ledger-go-system/internal/middleware"
+	"ledger-go-system/internal/prices"
+	"ledger-go-system/internal/repository"
+)
+
+// maxPriceUploadBytes bounds a single price upload
+const maxPriceUploadBytes = 5 << 20
+
+type ValuationHandler struct {
+	repo     *repository.ValuationRepository
+	accounts repository.RevaluationAccounts
+}
+
+// NewValuationHandler returns a handler whose revaluation entries must pass
+// the given validators before they are posted
+func NewValuationHandler(db *sql.DB, accounts repository.RevaluationAccounts, validators ...repository.Validator) *ValuationHandler {
+	return &ValuationHandler{repo: repository.NewValuationRepository(db, validators...), accounts: accounts}
+}
+
+type MarkToMarketRequest struct {
+	Date string `json:"date"`
+	Book bool   `json:"book"`
+}
+
+// UploadPrices loads prices from a JSON array, or from CSV when the body is sent as text/csv
+func (h *ValuationHandler) UploadPrices(w http.ResponseWriter, r *http.Request) {
+	format := prices.FormatJSON
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "text/csv" {
+		format = prices.FormatCSV
+	}
+
+	ps, err := prices.Parse(http.MaxBytesReader(w, r.Body, maxPriceUploadBytes), format)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if len(ps) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "no prices in request"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	n, err := h.repo.AddPrices(r.Context(), ps, "api:"+actor)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "loaded", "count": n})
+}
+
+// ListPrices returns the latest price per instrument on or before ?as_of= (default today)
+func (h *ValuationHandler) ListPrices(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Prices(r.Context(), valuationDate(asOf))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Valuation marks positions to market as of ?as_of= (default today), with
+// accounts rolled up to ?level= of the chart of accounts
+func (h *ValuationHandler) Valuation(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	level, err := parseLevel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := h.repo.Valuation(r.Context(), valuationDate(asOf), level)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (h *ValuationHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	data, err := h.repo.Runs(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+// RunMarkToMarket records a valuation run for a date, booking the revaluation when asked.
+// Rerunning a date returns the existing run with 200 instead of 201.
+func (h *ValuationHandler) RunMarkToMarket(w http.ResponseWriter, r *http.Request) {
+	var body MarkToMarketRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	date, err := time.Parse("2006-01-02", body.Date)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "date must be YYYY-MM-DD"})
+		return
+	}
+
+	actor := middleware.GetRoleFromContext(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	var accounts *repository.RevaluationAccounts
+	if body.Book {
+		accounts = &h.accounts
+	}
+
+	run, created, err := h.repo.MarkToMarket(r.Context(), date, accounts, actor)
+	var violation *repository.RuleViolation
+	if errors.As(err, &violation) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: violation.Error(), Rule: violation.Rule})
+		return
+	}
+	if errors.Is(err, repository.ErrValuationOrder) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(run)
+}
+
+// valuationDate is the UTC calendar date of asOf, or today when it is unset
+func valuationDate(asOf *time.Time) time.Time {
+	t := time.Now().UTC()
+	if asOf != nil {
+		t = asOf.UTC()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
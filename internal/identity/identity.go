@@ -0,0 +1,23 @@
+package identity
+
+import "context"
+
+// Identity is the authenticated principal a request acts on behalf of
+type Identity struct {
+	UserID int
+	Role   string
+	OrgID  int
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given identity
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext retrieves the identity stored by NewContext
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
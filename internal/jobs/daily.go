@@ -0,0 +1,44 @@
+// Package jobs runs the server's recurring per-organization ledger jobs.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ledger-go-system/internal/identity"
+)
+
+// Daily runs Run for each organization for the last completed UTC day. It
+// fires on Start and then every interval, so Run must be idempotent per day:
+// most ticks find the day already done and change nothing.
+type Daily struct {
+	Name   string
+	OrgIDs []int
+	Run    func(ctx context.Context, date time.Time) error
+}
+
+// Start launches the job in the background
+func (j Daily) Start(interval time.Duration) {
+	go func() {
+		j.tick()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			j.tick()
+		}
+	}()
+}
+
+func (j Daily) tick() {
+	now := time.Now().UTC()
+	date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+
+	for _, orgID := range j.OrgIDs {
+		// Jobs act as an admin of each organization they are configured for
+		ctx := identity.NewContext(context.Background(), identity.Identity{Role: "admin", OrgID: orgID})
+		if err := j.Run(ctx, date); err != nil {
+			log.Printf("%s for org %d on %s failed: %v", j.Name, orgID, date.Format("2006-01-02"), err)
+		}
+	}
+}
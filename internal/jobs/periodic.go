@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ledger-go-system/internal/identity"
+)
+
+// Periodic runs Run for each organization on Start and then every interval.
+// Unlike Daily it has no date: Run picks up whatever work is pending.
+type Periodic struct {
+	Name   string
+	OrgIDs []int
+	Run    func(ctx context.Context) error
+}
+
+// Start launches the job in the background
+func (j Periodic) Start(interval time.Duration) {
+	go func() {
+		j.tick()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			j.tick()
+		}
+	}()
+}
+
+func (j Periodic) tick() {
+	for _, orgID := range j.OrgIDs {
+		ctx := identity.NewContext(context.Background(), identity.Identity{Role: "admin", OrgID: orgID})
+		if err := j.Run(ctx); err != nil {
+			log.Printf("%s for org %d failed: %v", j.Name, orgID, err)
+		}
+	}
+}
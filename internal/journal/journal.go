@@ -0,0 +1,212 @@
+// Package journal renders the ledger as plain-text accounting journals for
+// Beancount and ledger-cli (and hledger), and reads such journals back.
+//
+// Entries are dated by their trade date, or the day they were posted when
+// they have none, and amounts are written in full precision, so a journal
+// read back has the same amounts and dates. Everything else the ledger keeps
+// travels as metadata:
+//
+//	2026-01-15 * "Wire transfer to Acme" #fx
+//	  ledger-id: 42
+//	  created-at: "2026-01-15T10:30:45Z"
+//	  book: "JV"
+//	  voucher: "JV-000042"
+//	  cost_center: "CC1"
+//	  Expenses:Travel  100 USD
+//	    dimensions: "{\"desk\":\"EQ\"}"
+//	  Assets:Cash  -100 USD
+//
+// Amounts are in a single currency. Postings that move an instrument are
+// written as a quantity of it at their total value (100 AAPL @@ 15025 USD).
+// Names the target format cannot hold, such as Beancount accounts outside
+// Assets, Liabilities, Equity, Income and Expenses, are written in a form it
+// accepts with the original kept as metadata.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"ledger-go-system/internal/repository"
+)
+
+// Formats
+const (
+	FormatBeancount = "beancount"
+	FormatLedger    = "ledger"
+)
+
+// DefaultCurrency is the currency of amounts when none is given
+const DefaultCurrency = "USD"
+
+// Metadata keys the ledger's own fields are written under. User metadata
+// under one of these keys is written to keyMetadataJSON instead.
+const (
+	keyLedgerID        = "ledger-id"
+	keyCreatedAt       = "created-at"
+	keyArchived        = "archived"
+	keyVoucher         = "voucher"
+	keyBook            = "book"
+	keySettlementDate  = "settlement-date"
+	keyCounterpartyID  = "counterparty-id"
+	keyAmount          = "amount" // entries recorded without postings
+	keyDescriptionJSON = "description-json"
+	keyMetadataJSON    = "metadata-json"
+	keyTagsJSON        = "tags-json"
+
+	// Postings
+	keyAccount    = "account"
+	keyInstrument = "instrument"
+	keyDimensions = "dimensions"
+
+	// Account declarations
+	keyCode   = "code"
+	keyName   = "name"
+	keyParent = "parent"
+	keyHeader = "header"
+	keyChart  = "chart" // false for accounts posted to but missing from the chart
+)
+
+var reservedKeys = map[string]bool{
+	keyLedgerID: true, keyCreatedAt: true, keyArchived: true, keyVoucher: true, keyBook: true,
+	keySettlementDate: true, keyCounterpartyID: true, keyAmount: true, keyDescriptionJSON: true,
+	keyMetadataJSON: true, keyTagsJSON: true,
+}
+
+var (
+	beancountAccount   = regexp.MustCompile(`^(Assets|Liabilities|Equity|Income|Expenses)(:[\p{Lu}\p{Nd}][\p{L}\p{Nd}-]*)+$`)
+	beancountCommodity = regexp.MustCompile(`^[A-Z][A-Z0-9'._-]{0,22}[A-Z0-9]$`)
+	beancountKey       = regexp.MustCompile(`^[a-z][a-zA-Z0-9_-]*$`)
+	beancountTag       = regexp.MustCompile(`^[A-Za-z0-9_/.-]+$`)
+	ledgerKey          = regexp.MustCompile(`^[^\s:;,]+$`)
+	ledgerTag          = regexp.MustCompile(`^[^\s:;,]+$`)
+	ledgerCommodity    = regexp.MustCompile(`^\p{L}+$`)
+)
+
+// Journal is what a journal file declares and records
+type Journal struct {
+	// Accounts are the declared chart accounts, with Parent set
+	Accounts []repository.Account
+	Entries  []Entry
+}
+
+// Entry is a transaction of a journal ready to post, with the line it starts on
+type Entry struct {
+	repository.NewEntry
+	Line int
+}
+
+// Error is a journal that cannot be read, at a 1-based line
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func errorAt(line int, format string, args ...interface{}) *Error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// formatNumber writes v in the fewest digits that read back as v
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// roundNumber drops float noise from a computed amount
+func roundNumber(v float64) float64 {
+	return math.Round(v*1e9) / 1e9
+}
+
+// sanitizeAccount turns an account code Beancount does not accept into one it
+// does; the original is kept as metadata
+func sanitizeAccount(code string) string {
+	parts := strings.Split(code, ":")
+	for i, p := range parts {
+		p = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+				return r
+			}
+			return '-'
+		}, p)
+		runes := []rune(p)
+		if len(runes) == 0 || !(unicode.IsLetter(runes[0]) || unicode.IsDigit(runes[0])) {
+			runes = append([]rune{'X'}, runes...)
+		}
+		runes[0] = unicode.ToUpper(runes[0])
+		parts[i] = string(runes)
+	}
+	name := strings.Join(parts, ":")
+	if !beancountAccount.MatchString(name) {
+		name = "Equity:Unmapped:" + name
+	}
+	return name
+}
+
+// sanitizeCommodity turns an instrument symbol Beancount does not accept into
+// a commodity it does; the original is kept as metadata
+func sanitizeCommodity(symbol string) string {
+	s := strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("'._-", r) {
+			return r
+		}
+		return -1
+	}, symbol)
+	s = strings.TrimRight(strings.TrimLeft(s, "0123456789'._-"), "'._-")
+	if len(s) < 2 {
+		s = "X" + s + "X"
+	}
+	if len(s) > 24 {
+		s = s[:24]
+	}
+	return s
+}
+
+// splitMetadata separates metadata the format can hold under its own key
+// from the rest, which is returned as JSON, or "" when there is none
+func splitMetadata(metadata map[string]string, valid func(k, v string) bool) (map[string]string, string) {
+	own := map[string]string{}
+	rest := map[string]string{}
+	for k, v := range metadata {
+		if valid(k, v) && !reservedKeys[k] {
+			own[k] = v
+		} else {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		return own, ""
+	}
+	b, _ := json.Marshal(rest)
+	return own, string(b)
+}
+
+// splitTags separates tags the format can hold from the rest, as JSON
+func splitTags(tags []string, valid *regexp.Regexp) ([]string, string) {
+	var own, rest []string
+	for _, t := range tags {
+		if valid.MatchString(t) {
+			own = append(own, t)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	if len(rest) == 0 {
+		return own, ""
+	}
+	b, _ := json.Marshal(rest)
+	return own, string(b)
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,164 @@
+package journal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ledger-go-system/internal/repository"
+)
+
+func date(y int, m time.Month, d int) *time.Time {
+	t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+// chart has accounts each format renames: Beancount needs a capital after
+// each colon and one of its five roots, ledger-cli cannot read parentheses
+var chart = []repository.Account{
+	{Code: "Assets", Name: "Assets", Header: true},
+	{Code: "Assets:Cash", Name: "Cash", Parent: "Assets"},
+	{Code: "assets:petty cash", Name: "Petty cash", Parent: "Assets"},
+	{Code: "Assets:Equities", Name: "Equities", Parent: "Assets"},
+	{Code: "Revenue:Sales", Name: "Sales"},
+	{Code: "Expenses:Meals (team)", Name: "Team meals"},
+}
+
+var counterparty = 7
+
+var ledgers = []repository.Ledger{
+	{
+		ID:             1,
+		Amount:         100,
+		Description:    `Pay "Acme" \ 50%; fees`,
+		CreatedAt:      time.Date(2026, 1, 16, 9, 30, 0, 0, time.UTC),
+		TradeDate:      date(2026, 1, 15),
+		SettlementDate: date(2026, 1, 17),
+		CounterpartyID: &counterparty,
+		Book:           "JV",
+		VoucherNumber:  "JV-000001",
+		Metadata: map[string]string{
+			"cost_center": "CC1",
+			"note":        `say "hi" \ ok`,
+			"Cost Center": "two words",
+			"book":        "shadows the book",
+			"memo":        "line one\nline two",
+		},
+		Tags: []string{"fx", "q1 close"},
+		Postings: []repository.Posting{
+			{Account: "Expenses:Meals (team)", Amount: 100, Dimensions: map[string]string{"desk": "EQ", "project": `"alpha"`}},
+			{Account: "Assets:Cash", Amount: -100},
+		},
+	},
+	{
+		ID:          2,
+		Amount:      15025.75,
+		Description: "Buy\tshares\n",
+		CreatedAt:   time.Date(2026, 2, 3, 23, 59, 59, 0, time.UTC),
+		Postings: []repository.Posting{
+			{Account: "Assets:Equities", Amount: 15025.5, Instrument: "BRK.B", Quantity: 100},
+			{Account: "Assets:Equities", Amount: 0.25, Instrument: "brk b", Quantity: 0.5},
+			{Account: "assets:petty cash", Amount: -15025.75},
+		},
+	},
+	{
+		ID:          3,
+		Amount:      1502.55,
+		Description: "Sell shares",
+		CreatedAt:   time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC),
+		TradeDate:   date(2026, 2, 9),
+		Postings: []repository.Posting{
+			{Account: "Assets:Cash", Amount: 1502.55},
+			{Account: "Assets:Equities", Amount: -1502.55, Instrument: "BRK.B", Quantity: -10},
+			{Account: "Revenue:Sales", Amount: 0},
+			{Account: "Expenses:Misc", Amount: 0},
+		},
+	},
+}
+
+// want is what posting a ledger read back from a journal amounts to
+func want(l repository.Ledger) repository.NewEntry {
+	tradeDate := l.TradeDate
+	if tradeDate == nil {
+		tradeDate = date(l.CreatedAt.Year(), l.CreatedAt.Month(), l.CreatedAt.Day())
+	}
+	return repository.NewEntry{
+		Amount:         l.Amount,
+		Description:    l.Description,
+		Postings:       l.Postings,
+		TradeDate:      tradeDate,
+		SettlementDate: l.SettlementDate,
+		CounterpartyID: l.CounterpartyID,
+		Book:           l.Book,
+		Metadata:       l.Metadata,
+		Tags:           l.Tags,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatBeancount, FormatLedger} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			jw, err := NewWriter(&buf, format, "EUR", chart)
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			for _, l := range ledgers {
+				if err := jw.Write(l); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := jw.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			// Beancount reads the currency from the journal, ledger-cli is told it
+			currency := ""
+			if format == FormatLedger {
+				currency = "EUR"
+			}
+			j, err := Parse(strings.NewReader(buf.String()), format, currency)
+			if err != nil {
+				t.Fatalf("Parse: %v\n%s", err, buf.String())
+			}
+
+			if !reflect.DeepEqual(j.Accounts, chart) {
+				t.Errorf("Accounts:\n got %+v\nwant %+v", j.Accounts, chart)
+			}
+			if len(j.Entries) != len(ledgers) {
+				t.Fatalf("read %d entries, want %d\n%s", len(j.Entries), len(ledgers), buf.String())
+			}
+			for i, e := range j.Entries {
+				if w := want(ledgers[i]); !reflect.DeepEqual(e.NewEntry, w) {
+					t.Errorf("entry %d:\n got %+v\nwant %+v\n%s", ledgers[i].ID, e.NewEntry, w, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRenamed(t *testing.T) {
+	tests := []struct {
+		format string
+		code   string
+		name   string
+	}{
+		{FormatBeancount, "Assets:Cash", "Assets:Cash"},
+		{FormatBeancount, "assets:petty cash", "Assets:Petty-cash"},
+		{FormatBeancount, "Revenue:Sales", "Equity:Unmapped:Revenue:Sales"},
+		{FormatLedger, "assets:petty cash", "assets:petty cash"},
+		{FormatLedger, "Expenses:Meals (team)", "Expenses:Meals -team-"},
+	}
+	for _, tt := range tests {
+		jw, err := NewWriter(&bytes.Buffer{}, tt.format, DefaultCurrency, nil)
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		name, renamed := jw.account(tt.code)
+		if name != tt.name || renamed != (tt.code != tt.name) {
+			t.Errorf("%s account %q = %q, %v; want %q", tt.format, tt.code, name, renamed, tt.name)
+		}
+	}
+}
@@ -0,0 +1,801 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ledger-go-system/internal/repository"
+)
+
+// Block kinds: what indented lines belong to
+const (
+	blockNone = iota
+	blockTxn
+	blockAccount
+	blockSkip    // a directive that is read past
+	blockComment // a ledger-cli comment ... end comment block
+)
+
+var (
+	operatingCurrency = regexp.MustCompile(`^option\s+"operating_currency"\s+"([^"]+)"`)
+	beancountDated    = regexp.MustCompile(`^(\d{4}[-/]\d{2}[-/]\d{2})\s+(\S+)\s*(.*)$`)
+	beancountMeta     = regexp.MustCompile(`^([a-z][a-zA-Z0-9_-]*):(?:\s+(.*))?$`)
+	ledgerDated       = regexp.MustCompile(`^(\d{4}[-/.]\d{1,2}[-/.]\d{1,2})(?:=\S+)?(?:\s+(.*))?$`)
+	ledgerMeta        = regexp.MustCompile(`^([^\s:]+)::?(?:\s+(.*))?$`)
+	ledgerSeparator   = regexp.MustCompile(`\s{2,}|\t`)
+	ledgerNoteStart   = regexp.MustCompile(`(\s{2,}|\t);`)
+)
+
+// beancountFlags are the transaction and posting flags Beancount accepts
+const beancountFlags = "*!&#?%PSTCURM"
+
+// beancountSkipped are Beancount directives that carry nothing to post
+var beancountSkipped = map[string]bool{
+	"close": true, "balance": true, "note": true, "document": true, "price": true,
+	"event": true, "query": true, "custom": true, "commodity": true,
+}
+
+// ledgerSkipped are ledger-cli directives that carry nothing to post
+var ledgerSkipped = map[string]bool{
+	"commodity": true, "payee": true, "tag": true, "P": true, "D": true, "N": true,
+	"Y": true, "year": true, "bucket": true, "A": true, "C": true, "define": true,
+	"assert": true, "check": true, "expr": true, "value": true, "python": true, "eval": true,
+}
+
+type posting struct {
+	account    string
+	amount     *float64 // nil when left for the journal to balance
+	instrument string
+	quantity   float64
+	meta       map[string]string
+}
+
+type txn struct {
+	line        int
+	date        time.Time
+	description string
+	meta        map[string]string
+	tags        []string
+	postings    []*posting
+}
+
+type accountDecl struct {
+	code string
+	meta map[string]string
+}
+
+type reader struct {
+	format   string
+	currency string
+	journal  *Journal
+	declared map[string]bool
+
+	block   int
+	txn     *txn
+	account *accountDecl
+}
+
+// Parse reads a journal in format. Amounts in currency are cash; when
+// currency is empty it is the journal's Beancount operating_currency, or
+// DefaultCurrency. ledger-cli's $ stands for currency. Amounts of any other
+// commodity are instrument quantities, valued by their cost or price in
+// currency. Transactions are dated by their trade date.
+func Parse(r io.Reader, format, currency string) (*Journal, error) {
+	if format != FormatBeancount && format != FormatLedger {
+		return nil, fmt.Errorf("unsupported journal format %q", format)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if currency == "" && format == FormatBeancount {
+		for _, line := range lines {
+			if m := operatingCurrency.FindStringSubmatch(line); m != nil {
+				currency = m[1]
+				break
+			}
+		}
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	p := &reader{format: format, currency: currency, journal: &Journal{}, declared: map[string]bool{}}
+	for i, line := range lines {
+		if err := p.line(i+1, line); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return p.journal, nil
+}
+
+func (p *reader) line(n int, s string) error {
+	trimmed := strings.TrimSpace(s)
+	if p.block == blockComment {
+		if trimmed == "end comment" || trimmed == "end test" {
+			p.block = blockNone
+		}
+		return nil
+	}
+	if trimmed == "" {
+		return nil
+	}
+
+	if s[0] == ' ' || s[0] == '\t' {
+		switch p.block {
+		case blockTxn:
+			if p.format == FormatBeancount {
+				return p.beancountTxnLine(n, trimmed)
+			}
+			return p.ledgerTxnLine(n, trimmed)
+		case blockAccount:
+			p.accountLine(trimmed)
+			return nil
+		case blockSkip:
+			return nil
+		}
+		if trimmed[0] == ';' {
+			return nil
+		}
+		return errorAt(n, "indented line outside a transaction")
+	}
+
+	if err := p.finish(); err != nil {
+		return err
+	}
+	if strings.ContainsRune(";#%|*", rune(trimmed[0])) {
+		return nil
+	}
+	if p.format == FormatBeancount {
+		return p.beancountDirective(n, s)
+	}
+	return p.ledgerDirective(n, s)
+}
+
+// finish ends the block in progress
+func (p *reader) finish() error {
+	defer func() {
+		p.block, p.txn, p.account = blockNone, nil, nil
+	}()
+
+	switch {
+	case p.txn != nil:
+		e, err := p.txn.entry()
+		if err != nil {
+			return err
+		}
+		p.journal.Entries = append(p.journal.Entries, e)
+	case p.account != nil:
+		a := p.account
+		code := a.code
+		if v, ok := a.meta[keyCode]; ok {
+			code = v
+		}
+		if strings.EqualFold(a.meta[keyChart], "false") || p.declared[code] {
+			return nil
+		}
+		p.declared[code] = true
+
+		name := a.meta[keyName]
+		if name == "" {
+			name = code[strings.LastIndex(code, ":")+1:]
+		}
+		p.journal.Accounts = append(p.journal.Accounts, repository.Account{
+			Code:   code,
+			Name:   name,
+			Parent: a.meta[keyParent],
+			Header: strings.EqualFold(a.meta[keyHeader], "true"),
+		})
+	}
+	return nil
+}
+
+func (p *reader) beancountDirective(n int, s string) error {
+	word := strings.Fields(s)[0]
+	switch word {
+	case "option", "plugin":
+		p.block = blockSkip
+		return nil
+	case "include", "pushtag", "poptag", "pushmeta", "popmeta":
+		return errorAt(n, "%s is not supported", word)
+	}
+
+	m := beancountDated.FindStringSubmatch(s)
+	if m == nil {
+		return errorAt(n, "expected a dated directive, found %q", word)
+	}
+	date, err := parseDate(m[1])
+	if err != nil {
+		return errorAt(n, "%v", err)
+	}
+
+	kind := m[2]
+	switch {
+	case kind == "open":
+		fields := strings.Fields(m[3])
+		if len(fields) == 0 {
+			return errorAt(n, "open needs an account")
+		}
+		p.block, p.account = blockAccount, &accountDecl{code: fields[0], meta: map[string]string{}}
+		return nil
+	case kind == "pad":
+		return errorAt(n, "pad is not supported; write the padding transaction out")
+	case kind == "txn" || (len(kind) == 1 && strings.Contains(beancountFlags, kind)):
+		return p.beancountTxn(n, date, m[3])
+	case beancountSkipped[kind]:
+		p.block = blockSkip
+		return nil
+	}
+	return errorAt(n, "unknown directive %q", kind)
+}
+
+func (p *reader) beancountTxn(n int, date time.Time, rest string) error {
+	tokens, err := beancountTokens(rest)
+	if err != nil {
+		return errorAt(n, "%v", err)
+	}
+
+	t := &txn{line: n, date: date, meta: map[string]string{}}
+	var strs []string
+	for _, tok := range tokens {
+		switch {
+		case tok.quoted:
+			if len(t.tags) > 0 {
+				return errorAt(n, "the narration must come before tags")
+			}
+			strs = append(strs, tok.text)
+		case strings.HasPrefix(tok.text, "#") && len(tok.text) > 1:
+			t.tags = append(t.tags, tok.text[1:])
+		case strings.HasPrefix(tok.text, "^"):
+			// Links have no counterpart in the ledger
+		default:
+			return errorAt(n, "unexpected %q in transaction", tok.text)
+		}
+	}
+	switch len(strs) {
+	case 0:
+	case 1:
+		t.description = strs[0]
+	case 2:
+		t.meta["payee"], t.description = strs[0], strs[1]
+	default:
+		return errorAt(n, "a transaction has at most a payee and a narration")
+	}
+
+	p.block, p.txn = blockTxn, t
+	return nil
+}
+
+func (p *reader) beancountTxnLine(n int, s string) error {
+	t := p.txn
+	if m := beancountMeta.FindStringSubmatch(s); m != nil {
+		value, err := beancountValue(m[2])
+		if err != nil {
+			return errorAt(n, "%v", err)
+		}
+		if len(t.postings) == 0 {
+			t.meta[m[1]] = value
+		} else {
+			t.postings[len(t.postings)-1].meta[m[1]] = value
+		}
+		return nil
+	}
+	if s[0] == ';' {
+		return nil
+	}
+
+	if len(s) > 1 && strings.ContainsRune(beancountFlags, rune(s[0])) && s[1] == ' ' {
+		s = strings.TrimSpace(s[1:])
+	}
+	account, rest := s, ""
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		account, rest = s[:i], s[i:]
+	}
+	if i := strings.Index(rest, ";"); i >= 0 {
+		rest = rest[:i]
+	}
+	return p.addPosting(n, account, rest, map[string]string{})
+}
+
+func (p *reader) ledgerDirective(n int, s string) error {
+	if m := ledgerDated.FindStringSubmatch(s); m != nil {
+		date, err := parseDate(m[1])
+		if err != nil {
+			return errorAt(n, "%v", err)
+		}
+		return p.ledgerTxn(n, date, m[2])
+	}
+
+	word := strings.Fields(s)[0]
+	switch {
+	case word == "account":
+		name, note := splitLedgerNote(strings.TrimSpace(s[len(word):]))
+		if name == "" {
+			return errorAt(n, "account needs a name")
+		}
+		p.block, p.account = blockAccount, &accountDecl{code: name, meta: map[string]string{}}
+		if note != "" {
+			ledgerNote(p.account.meta, nil, note)
+		}
+		return nil
+	case word == "comment" || word == "test":
+		p.block = blockComment
+		return nil
+	case ledgerSkipped[word]:
+		p.block = blockSkip
+		return nil
+	case word == "include" || word == "alias" || word == "apply" || word == "end" || word == "~" || word == "=":
+		return errorAt(n, "%s is not supported", word)
+	}
+	return errorAt(n, "unknown directive %q", word)
+}
+
+func (p *reader) ledgerTxn(n int, date time.Time, rest string) error {
+	t := &txn{line: n, date: date, meta: map[string]string{}}
+	payee, note := splitLedgerNote(strings.TrimSpace(rest))
+	if strings.HasPrefix(payee, "*") || strings.HasPrefix(payee, "!") {
+		payee = strings.TrimSpace(payee[1:])
+	}
+	if strings.HasPrefix(payee, "(") {
+		if end := strings.Index(payee, ")"); end > 0 {
+			t.meta["code"] = payee[1:end]
+			payee = strings.TrimSpace(payee[end+1:])
+		}
+	}
+	t.description = payee
+	if note != "" {
+		ledgerNote(t.meta, &t.tags, note)
+	}
+
+	p.block, p.txn = blockTxn, t
+	return nil
+}
+
+func (p *reader) ledgerTxnLine(n int, s string) error {
+	t := p.txn
+	if s[0] == ';' {
+		if len(t.postings) == 0 {
+			ledgerNote(t.meta, &t.tags, s)
+		} else {
+			ledgerNote(t.postings[len(t.postings)-1].meta, nil, s)
+		}
+		return nil
+	}
+
+	if len(s) > 1 && (s[0] == '*' || s[0] == '!') && (s[1] == ' ' || s[1] == '\t') {
+		s = strings.TrimSpace(s[1:])
+	}
+	account, rest := s, ""
+	if loc := ledgerSeparator.FindStringIndex(s); loc != nil {
+		account, rest = s[:loc[0]], s[loc[1]:]
+	}
+	if strings.HasPrefix(account, "(") || strings.HasPrefix(account, "[") {
+		return errorAt(n, "virtual postings are not supported")
+	}
+
+	meta := map[string]string{}
+	if i := strings.Index(rest, ";"); i >= 0 {
+		ledgerNote(meta, nil, rest[i:])
+		rest = rest[:i]
+	}
+	return p.addPosting(n, account, rest, meta)
+}
+
+// accountLine reads metadata of an account declaration
+func (p *reader) accountLine(s string) {
+	a := p.account
+	if p.format == FormatBeancount {
+		if m := beancountMeta.FindStringSubmatch(s); m != nil {
+			if v, err := beancountValue(m[2]); err == nil {
+				a.meta[m[1]] = v
+			}
+		}
+		return
+	}
+	if s[0] == ';' {
+		ledgerNote(a.meta, nil, s)
+	} else if strings.HasPrefix(s, "note ") && a.meta[keyName] == "" {
+		a.meta[keyName] = strings.TrimSpace(s[len("note "):])
+	}
+}
+
+// splitLedgerNote splits a payee or account name from a note after it
+func splitLedgerNote(s string) (string, string) {
+	if loc := ledgerNoteStart.FindStringIndex(s); loc != nil {
+		return strings.TrimSpace(s[:loc[0]]), s[loc[1]-1:]
+	}
+	if strings.HasPrefix(s, ";") {
+		return "", s
+	}
+	return s, ""
+}
+
+// ledgerNote reads a ledger-cli note: :tag1:tag2: or key: value. Other notes are free text.
+func ledgerNote(meta map[string]string, tags *[]string, note string) {
+	note = strings.TrimSpace(strings.TrimLeft(note, ";"))
+	if len(note) > 1 && strings.HasPrefix(note, ":") && strings.HasSuffix(note, ":") && !strings.ContainsAny(note, " \t") {
+		if tags != nil {
+			for _, t := range strings.Split(note[1:len(note)-1], ":") {
+				if t != "" {
+					*tags = append(*tags, t)
+				}
+			}
+		}
+		return
+	}
+	if m := ledgerMeta.FindStringSubmatch(note); m != nil {
+		meta[m[1]] = strings.TrimSpace(m[2])
+	}
+}
+
+// addPosting adds a posting of account to the transaction in progress. rest
+// is its amount, with any cost and price, or empty for the journal to balance.
+func (p *reader) addPosting(n int, account, rest string, meta map[string]string) error {
+	ps := &posting{account: account, meta: meta}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		if err := p.postingAmount(ps, rest); err != nil {
+			return errorAt(n, "%v", err)
+		}
+	}
+	p.txn.postings = append(p.txn.postings, ps)
+	return nil
+}
+
+func (p *reader) postingAmount(ps *posting, s string) error {
+	number, commodity, rest, err := scanAmount(s)
+	if err != nil {
+		return err
+	}
+	commodity = p.commodity(commodity)
+
+	// The value of an instrument amount: its cost, else its price
+	var value *float64
+	at := func(v float64, total bool) {
+		if !total {
+			v *= number
+		} else if (v < 0) != (number < 0) {
+			v = -v
+		}
+		if value == nil {
+			value = &v
+		}
+	}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		switch {
+		case strings.HasPrefix(rest, "{"):
+			total := strings.HasPrefix(rest, "{{")
+			closing := "}"
+			if total {
+				closing = "}}"
+			}
+			end := strings.Index(rest, closing)
+			if end < 0 {
+				return fmt.Errorf("unterminated cost in %q", s)
+			}
+			inner := strings.Trim(rest[:end], "{")
+			rest = rest[end+len(closing):]
+			for _, part := range strings.Split(inner, ",") {
+				part = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part), "="))
+				v, c, tail, err := scanAmount(part)
+				if err != nil || strings.TrimSpace(tail) != "" {
+					// Lot dates and labels
+					continue
+				}
+				if p.commodity(c) != p.currency {
+					return fmt.Errorf("cost of %s must be in %s", commodity, p.currency)
+				}
+				at(v, total)
+			}
+		case strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, "("):
+			// ledger-cli lot dates and notes
+			end := strings.IndexAny(rest, "])")
+			if end < 0 {
+				return fmt.Errorf("unterminated lot annotation in %q", s)
+			}
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "@"):
+			total := strings.HasPrefix(rest, "@@")
+			rest = strings.TrimLeft(rest, "@")
+			v, c, tail, err := scanAmount(strings.TrimPrefix(strings.TrimSpace(rest), "="))
+			if err != nil {
+				return err
+			}
+			if p.commodity(c) != p.currency {
+				return fmt.Errorf("price of %s must be in %s", commodity, p.currency)
+			}
+			at(v, total)
+			rest = tail
+		case strings.HasPrefix(rest, "=") && p.format == FormatLedger:
+			// A balance assertion checks nothing the ledger needs
+			rest = ""
+		default:
+			return fmt.Errorf("unexpected %q after the amount", rest)
+		}
+	}
+
+	if commodity == p.currency {
+		ps.amount = &number
+		return nil
+	}
+	if value == nil {
+		return fmt.Errorf("%s %s needs a cost or price in %s", formatNumber(number), commodity, p.currency)
+	}
+	v := roundNumber(*value)
+	ps.amount, ps.instrument, ps.quantity = &v, commodity, number
+	return nil
+}
+
+// commodity resolves the currency placeholders: no commodity and $
+func (p *reader) commodity(c string) string {
+	if c == "" || c == "$" {
+		return p.currency
+	}
+	return c
+}
+
+// scanAmount reads an amount at the start of s: a number with a commodity
+// before or after it, as in 100.50 USD, $-12, -$12 or 10 "BRK.B"
+func scanAmount(s string) (float64, string, string, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative, s = s[0] == '-', s[1:]
+	}
+
+	commodity, s, err := scanCommodity(s, false)
+	if err != nil {
+		return 0, "", "", err
+	}
+	s = strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative, s = negative != (s[0] == '-'), s[1:]
+	}
+
+	i := 0
+	digits := false
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == ',' || s[i] == '.') {
+		digits = digits || (s[i] >= '0' && s[i] <= '9')
+		i++
+	}
+	if !digits {
+		return 0, "", "", fmt.Errorf("expected an amount, found %q", strings.TrimSpace(orig))
+	}
+	number, err := strconv.ParseFloat(strings.ReplaceAll(s[:i], ",", ""), 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid number %q", s[:i])
+	}
+	if negative {
+		number = -number
+	}
+	s = s[i:]
+
+	if commodity == "" {
+		if commodity, s, err = scanCommodity(strings.TrimLeft(s, " \t"), true); err != nil {
+			return 0, "", "", err
+		}
+	}
+	return number, commodity, s, nil
+}
+
+// scanCommodity reads a commodity at the start of s, quoted or bare. A
+// commodity after the number may contain digits.
+func scanCommodity(s string, suffix bool) (string, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		end := strings.Index(s[1:], `"`)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated commodity in %q", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	i := 0
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune(`-+,@{}()[]=;"*/`, r) ||
+			(r >= '0' && r <= '9' && (!suffix || i == 0)) || (r == '.' && i == 0) {
+			break
+		}
+		i += len(string(r))
+	}
+	return s[:i], s[i:], nil
+}
+
+type beancountToken struct {
+	text   string
+	quoted bool
+}
+
+// beancountTokens splits a line into strings and bare words, up to a comment
+func beancountTokens(s string) ([]beancountToken, error) {
+	var tokens []beancountToken
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		switch s[0] {
+		case ';':
+			return tokens, nil
+		case '"':
+			text, rest, err := beancountString(s)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, beancountToken{text: text, quoted: true})
+			s = rest
+		default:
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			tokens = append(tokens, beancountToken{text: s[:end]})
+			s = s[end:]
+		}
+	}
+	return tokens, nil
+}
+
+// beancountString reads the string s starts with, returning it unescaped and what follows
+func beancountString(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+// beancountValue reads a metadata value: a string, or a bare number, date,
+// boolean or name as written
+func beancountValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		v, _, err := beancountString(s)
+		return v, err
+	}
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	s = strings.NewReplacer("/", "-", ".", "-").Replace(s)
+	d, err := time.Parse("2006-1-2", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return d, nil
+}
+
+// entry turns a transaction into an entry to post, restoring what the
+// writer kept as metadata
+func (t *txn) entry() (Entry, error) {
+	date := t.date
+	e := repository.NewEntry{Description: t.description, TradeDate: &date, Tags: t.tags}
+	amount := ""
+
+	for k, v := range t.meta {
+		var err error
+		switch k {
+		case keyLedgerID, keyCreatedAt, keyArchived, keyVoucher:
+			// Describe the entry's old place in a ledger; it gets new ones when posted
+		case keyBook:
+			e.Book = v
+		case keySettlementDate:
+			var d time.Time
+			if d, err = parseDate(v); err == nil {
+				e.SettlementDate = &d
+			}
+		case keyCounterpartyID:
+			var id int
+			if id, err = strconv.Atoi(v); err == nil {
+				e.CounterpartyID = &id
+			}
+		case keyAmount:
+			amount = v
+		case keyDescriptionJSON:
+			err = json.Unmarshal([]byte(v), &e.Description)
+		case keyTagsJSON:
+			var tags []string
+			if err = json.Unmarshal([]byte(v), &tags); err == nil {
+				e.Tags = append(e.Tags, tags...)
+			}
+		case keyMetadataJSON:
+			var metadata map[string]string
+			if err = json.Unmarshal([]byte(v), &metadata); err == nil {
+				for mk, mv := range metadata {
+					if e.Metadata == nil {
+						e.Metadata = map[string]string{}
+					}
+					e.Metadata[mk] = mv
+				}
+			}
+		default:
+			if e.Metadata == nil {
+				e.Metadata = map[string]string{}
+			}
+			if _, ok := e.Metadata[k]; !ok {
+				e.Metadata[k] = v
+			}
+		}
+		if err != nil {
+			return Entry{}, errorAt(t.line, "invalid %s %q", k, v)
+		}
+	}
+
+	var sum, debits float64
+	elided := -1
+	for i, ps := range t.postings {
+		p := repository.Posting{Account: ps.account, Instrument: ps.instrument, Quantity: ps.quantity}
+		if v, ok := ps.meta[keyAccount]; ok {
+			p.Account = v
+		}
+		if v, ok := ps.meta[keyInstrument]; ok {
+			p.Instrument = v
+		}
+		if v, ok := ps.meta[keyDimensions]; ok {
+			if err := json.Unmarshal([]byte(v), &p.Dimensions); err != nil {
+				return Entry{}, errorAt(t.line, "invalid %s %q on %s", keyDimensions, v, ps.account)
+			}
+		}
+		if ps.amount == nil {
+			if elided >= 0 {
+				return Entry{}, errorAt(t.line, "only one posting can leave out its amount")
+			}
+			elided = i
+		} else {
+			p.Amount = *ps.amount
+			sum += p.Amount
+		}
+		e.Postings = append(e.Postings, p)
+	}
+	if elided >= 0 {
+		e.Postings[elided].Amount = roundNumber(-sum)
+	}
+	for _, p := range e.Postings {
+		if p.Amount > 0 {
+			debits += p.Amount
+		}
+	}
+
+	switch {
+	case amount != "":
+		v, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return Entry{}, errorAt(t.line, "invalid %s %q", keyAmount, amount)
+		}
+		e.Amount = v
+	case len(e.Postings) > 0:
+		e.Amount = roundNumber(debits)
+	default:
+		return Entry{}, errorAt(t.line, "transaction has no postings")
+	}
+
+	if err := e.Validate(); err != nil {
+		return Entry{}, errorAt(t.line, "%v", err)
+	}
+	return Entry{NewEntry: e, Line: t.line}, nil
+}
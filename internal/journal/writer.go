@@ -0,0 +1,333 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ledger-go-system/internal/repository"
+)
+
+// openDate is the date Beancount accounts are opened on, before any entry
+const openDate = "1970-01-01"
+
+// ledgerAccount is an account name ledger-cli reads back whole: no
+// double spaces, tabs, comments or virtual-account brackets
+var ledgerAccount = regexp.MustCompile(`^[^\s;()\[\]]+( [^\s;()\[\]]+)*$`)
+
+// meta is one metadata line; quote writes the value as a Beancount string
+type meta struct {
+	key, value string
+	quote      bool
+}
+
+// Writer writes entries as a journal. Accounts are declared up front from
+// the chart; accounts posted to but missing from it are declared by Close.
+type Writer struct {
+	w        io.Writer
+	format   string
+	currency string
+	declared map[string]bool
+	missing  []string
+	err      error
+}
+
+// NewWriter starts a journal in format with amounts in currency, declaring
+// the chart of accounts. It fails only on the format or currency; errors
+// writing to w are returned by Write and Close.
+func NewWriter(w io.Writer, format, currency string, accounts []repository.Account) (*Writer, error) {
+	if format != FormatBeancount && format != FormatLedger {
+		return nil, fmt.Errorf("unsupported journal format %q", format)
+	}
+	if !beancountCommodity.MatchString(currency) {
+		return nil, fmt.Errorf("invalid currency %q", currency)
+	}
+
+	jw := &Writer{w: w, format: format, currency: currency, declared: map[string]bool{}}
+	jw.printf("; Exported from ledger-go-system\n")
+	if format == FormatBeancount {
+		jw.printf("option \"operating_currency\" %s\n", jsonString(currency))
+	} else {
+		jw.printf("commodity %s\n", currency)
+	}
+	jw.printf("\n")
+
+	for _, a := range accounts {
+		jw.declare(a.Code, a.Name, a.Parent, a.Header, true)
+	}
+	return jw, nil
+}
+
+func (jw *Writer) printf(format string, args ...interface{}) {
+	if jw.err == nil {
+		_, jw.err = fmt.Fprintf(jw.w, format, args...)
+	}
+}
+
+// account returns the name an account is written as, and whether it differs from code
+func (jw *Writer) account(code string) (string, bool) {
+	if jw.format == FormatBeancount {
+		if beancountAccount.MatchString(code) {
+			return code, false
+		}
+		return sanitizeAccount(code), true
+	}
+	if ledgerAccount.MatchString(code) {
+		return code, false
+	}
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(";()[]", r) {
+			return '-'
+		}
+		return r
+	}, strings.Join(strings.Fields(code), " "))
+	if name == "" {
+		name = "Unmapped"
+	}
+	return name, true
+}
+
+// commodity returns the name an instrument is written as, and whether it differs from symbol
+func (jw *Writer) commodity(symbol string) (string, bool) {
+	if jw.format == FormatBeancount {
+		if beancountCommodity.MatchString(symbol) {
+			return symbol, false
+		}
+		return sanitizeCommodity(symbol), true
+	}
+	if ledgerCommodity.MatchString(symbol) {
+		return symbol, false
+	}
+	if symbol != "" && !strings.ContainsAny(symbol, "\"\n") {
+		return `"` + symbol + `"`, false
+	}
+	return sanitizeCommodity(symbol), true
+}
+
+func (jw *Writer) declare(code, name, parent string, header, chart bool) {
+	jw.declared[code] = true
+	written, renamed := jw.account(code)
+
+	var lines []meta
+	if renamed {
+		lines = append(lines, meta{keyCode, code, true})
+	}
+	if name != "" {
+		lines = append(lines, meta{keyName, name, true})
+	}
+	if parent != "" {
+		lines = append(lines, meta{keyParent, parent, true})
+	}
+	if header {
+		lines = append(lines, meta{keyHeader, "TRUE", false})
+	}
+	if !chart {
+		lines = append(lines, meta{keyChart, "FALSE", false})
+	}
+
+	if jw.format == FormatBeancount {
+		jw.printf("%s open %s\n", openDate, written)
+	} else {
+		jw.printf("account %s\n", written)
+	}
+	jw.metadata("", lines)
+	jw.printf("\n")
+}
+
+// metadata writes metadata lines under an entry, or under a posting when
+// indent is set. ledger-cli values are written bare, with TRUE and FALSE in
+// its lower case.
+func (jw *Writer) metadata(indent string, lines []meta) {
+	for _, m := range lines {
+		value := strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return ' '
+			}
+			return r
+		}, m.value)
+		if jw.format == FormatBeancount {
+			if m.quote {
+				value = quote(value)
+			}
+			jw.printf("  %s%s: %s\n", indent, m.key, value)
+		} else {
+			if !m.quote {
+				value = strings.ToLower(value)
+			}
+			jw.printf("    %s; %s: %s\n", indent, m.key, value)
+		}
+	}
+}
+
+// quote writes s as a Beancount string
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Write writes an entry as a transaction
+func (jw *Writer) Write(l repository.Ledger) error {
+	date := l.CreatedAt.UTC()
+	if l.TradeDate != nil {
+		date = *l.TradeDate
+	}
+
+	var lines []meta
+	lines = append(lines, meta{keyLedgerID, strconv.Itoa(l.ID), false})
+	lines = append(lines, meta{keyCreatedAt, l.CreatedAt.UTC().Format(time.RFC3339Nano), true})
+	if l.Archived {
+		lines = append(lines, meta{keyArchived, "TRUE", false})
+	}
+	if l.Book != "" {
+		lines = append(lines, meta{keyBook, l.Book, true})
+	}
+	if l.VoucherNumber != "" {
+		lines = append(lines, meta{keyVoucher, l.VoucherNumber, true})
+	}
+	if l.SettlementDate != nil {
+		lines = append(lines, meta{keySettlementDate, l.SettlementDate.Format("2006-01-02"), false})
+	}
+	if l.CounterpartyID != nil {
+		lines = append(lines, meta{keyCounterpartyID, strconv.Itoa(*l.CounterpartyID), false})
+	}
+	var debits float64
+	for _, p := range l.Postings {
+		if p.Amount > 0 {
+			debits += p.Amount
+		}
+	}
+	if len(l.Postings) == 0 || roundNumber(debits) != l.Amount {
+		// Read back, an entry's amount is its debits
+		lines = append(lines, meta{keyAmount, formatNumber(l.Amount), false})
+	}
+
+	description := l.Description
+	if !jw.plainText(description) {
+		lines = append(lines, meta{keyDescriptionJSON, jsonString(description), true})
+		description = strings.Join(strings.Fields(strings.ReplaceAll(description, ";", ",")), " ")
+		if description == "" && jw.format == FormatLedger {
+			description = "-"
+		}
+	}
+
+	metadata, rest := splitMetadata(l.Metadata, func(k, v string) bool {
+		if jw.format == FormatBeancount {
+			return beancountKey.MatchString(k) && jw.plainText(v)
+		}
+		return ledgerKey.MatchString(k) && jw.plainText(v)
+	})
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, meta{k, metadata[k], true})
+	}
+	if rest != "" {
+		lines = append(lines, meta{keyMetadataJSON, rest, true})
+	}
+
+	var tags []string
+	if jw.format == FormatBeancount {
+		tags, rest = splitTags(l.Tags, beancountTag)
+	} else {
+		tags, rest = splitTags(l.Tags, ledgerTag)
+	}
+	if rest != "" {
+		lines = append(lines, meta{keyTagsJSON, rest, true})
+	}
+
+	if jw.format == FormatBeancount {
+		jw.printf("%s * %s", date.Format("2006-01-02"), quote(description))
+		for _, t := range tags {
+			jw.printf(" #%s", t)
+		}
+		jw.printf("\n")
+	} else {
+		jw.printf("%s * %s\n", date.Format("2006-01-02"), description)
+		if len(tags) > 0 {
+			jw.printf("    ; :%s:\n", strings.Join(tags, ":"))
+		}
+	}
+	jw.metadata("", lines)
+
+	for _, p := range l.Postings {
+		jw.posting(p)
+	}
+	jw.printf("\n")
+	return jw.err
+}
+
+// plainText reports whether s can be written as is on one line
+func (jw *Writer) plainText(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	if jw.format == FormatLedger {
+		// A semicolon would start a note and a double space end the payee
+		return !strings.Contains(s, ";") && !strings.Contains(s, "  ")
+	}
+	return true
+}
+
+func (jw *Writer) posting(p repository.Posting) {
+	if !jw.declared[p.Account] {
+		jw.declared[p.Account] = true
+		jw.missing = append(jw.missing, p.Account)
+	}
+	account, renamed := jw.account(p.Account)
+
+	var lines []meta
+	if renamed {
+		lines = append(lines, meta{keyAccount, p.Account, true})
+	}
+
+	amount := formatNumber(p.Amount) + " " + jw.currency
+	if p.Quantity != 0 {
+		commodity, renamed := jw.commodity(p.Instrument)
+		if renamed {
+			lines = append(lines, meta{keyInstrument, p.Instrument, true})
+		}
+		value := p.Amount
+		if value < 0 {
+			value = -value
+		}
+		amount = formatNumber(p.Quantity) + " " + commodity + " @@ " + formatNumber(value) + " " + jw.currency
+	} else if p.Instrument != "" {
+		lines = append(lines, meta{keyInstrument, p.Instrument, true})
+	}
+	if len(p.Dimensions) > 0 {
+		b, _ := json.Marshal(p.Dimensions)
+		lines = append(lines, meta{keyDimensions, string(b), true})
+	}
+
+	indent := "  "
+	if jw.format == FormatLedger {
+		indent = "    "
+	}
+	pad := 48 - len([]rune(account))
+	if pad < 2 {
+		pad = 2
+	}
+	jw.printf("%s%s%s%s\n", indent, account, strings.Repeat(" ", pad), amount)
+	jw.metadata(indent, lines)
+}
+
+// Close declares the accounts posted to that the chart does not have
+func (jw *Writer) Close() error {
+	for _, code := range jw.missing {
+		jw.declare(code, "", "", false, false)
+	}
+	return jw.err
+}
@@ -3,9 +3,11 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"ledger-go-system/internal/auth"
+	"ledger-go-system/internal/identity"
 )
 
 // RoleKey is used for context values
@@ -39,9 +41,8 @@ func JWTMiddleware(authManager *auth.AuthManager, next http.Handler) http.Handle
 			return
 		}
 
-		// Store role in context for downstream handlers
-		ctx := context.WithValue(r.Context(), RoleKey, claims.Role)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// Store role and identity in context for downstream handlers
+		next.ServeHTTP(w, withIdentity(r, claims))
 	})
 }
 
@@ -77,9 +78,8 @@ func RequireRole(requiredRole string, authManager *auth.AuthManager, next http.H
 			return
 		}
 
-		// Store role in context for downstream handlers
-		ctx := context.WithValue(r.Context(), RoleKey, claims.Role)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// Store role and identity in context for downstream handlers
+		next.ServeHTTP(w, withIdentity(r, claims))
 	})
 }
 
@@ -123,12 +123,23 @@ func AllowRoles(allowedRoles []string, authManager *auth.AuthManager, next http.
 			return
 		}
 
-		// Store role in context for downstream handlers
-		ctx := context.WithValue(r.Context(), RoleKey, claims.Role)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// Store role and identity in context for downstream handlers
+		next.ServeHTTP(w, withIdentity(r, claims))
 	})
 }
 
+// withIdentity stores the token's role and identity (user, role, active org) in the request context
+func withIdentity(r *http.Request, claims *auth.Claims) *http.Request {
+	userID, _ := strconv.Atoi(claims.Subject)
+	ctx := context.WithValue(r.Context(), RoleKey, claims.Role)
+	ctx = identity.NewContext(ctx, identity.Identity{
+		UserID: userID,
+		Role:   claims.Role,
+		OrgID:  claims.OrgID,
+	})
+	return r.WithContext(ctx)
+}
+
 // GetRoleFromContext retrieves the role from request context
 func GetRoleFromContext(r *http.Request) string {
 	role, ok := r.Context().Value(RoleKey).(string)
@@ -0,0 +1,167 @@
+// Package prices reads end-of-day instrument prices from CSV and JSON price files.
+//
+// CSV files have a header row naming at least instrument, date and price
+// (currency is optional), in any column order:
+//
+//	instrument,date,price,currency
+//	AAPL,2026-03-31,171.48,USD
+//
+// JSON files hold an array of objects with the same fields:
+//
+//	[{"instrument": "AAPL", "date": "2026-03-31", "price": 171.48, "currency": "USD"}]
+package prices
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats accepted by Parse
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Price is the closing price of an instrument on a date
+type Price struct {
+	Instrument string    `json:"instrument"`
+	Date       time.Time `json:"date"`
+	Price      float64   `json:"price"`
+	Currency   string    `json:"currency,omitempty"`
+}
+
+// jsonPrice is the file representation, with the date as YYYY-MM-DD
+type jsonPrice struct {
+	Instrument string  `json:"instrument"`
+	Date       string  `json:"date"`
+	Price      float64 `json:"price"`
+	Currency   string  `json:"currency"`
+}
+
+// ParseFile reads a price file, choosing the format from its extension
+func ParseFile(path string) ([]Price, error) {
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		format = FormatCSV
+	case ".json":
+		format = FormatJSON
+	default:
+		return nil, fmt.Errorf("%s: unsupported price file type (use .csv or .json)", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out, err := Parse(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// Parse reads prices in the given format and validates every row
+func Parse(r io.Reader, format string) ([]Price, error) {
+	var rows []jsonPrice
+	switch format {
+	case FormatCSV:
+		var err error
+		if rows, err = readCSV(r); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON price file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported price format %q", format)
+	}
+
+	out := make([]Price, 0, len(rows))
+	for i, row := range rows {
+		p, err := row.validate()
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func readCSV(r io.Reader) ([]jsonPrice, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV price file: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"instrument", "date", "price"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing %q", required)
+		}
+	}
+
+	var rows []jsonPrice
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV price file: %w", err)
+		}
+
+		price, err := strconv.ParseFloat(strings.TrimSpace(rec[cols["price"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid price %q", len(rows)+1, rec[cols["price"]])
+		}
+		row := jsonPrice{
+			Instrument: rec[cols["instrument"]],
+			Date:       rec[cols["date"]],
+			Price:      price,
+		}
+		if i, ok := cols["currency"]; ok {
+			row.Currency = rec[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (row jsonPrice) validate() (Price, error) {
+	p := Price{
+		Instrument: strings.TrimSpace(row.Instrument),
+		Price:      row.Price,
+		Currency:   strings.ToUpper(strings.TrimSpace(row.Currency)),
+	}
+	if p.Instrument == "" {
+		return p, fmt.Errorf("instrument is required")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(row.Date))
+	if err != nil {
+		return p, fmt.Errorf("date must be YYYY-MM-DD, got %q", row.Date)
+	}
+	p.Date = d
+	if p.Price <= 0 {
+		return p, fmt.Errorf("price for %s must be positive", p.Instrument)
+	}
+	if p.Currency != "" && len(p.Currency) != 3 {
+		return p, fmt.Errorf("currency must be a 3-letter code, got %q", p.Currency)
+	}
+	return p, nil
+}
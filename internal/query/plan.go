@@ -0,0 +1,464 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field types
+const (
+	typeNumber = iota
+	typeText
+	typeDate
+	typeTag     // where only: the entry has the tag
+	typeAccount // where only: a posting is to the account or an account under it
+)
+
+type field struct {
+	sql string
+	typ int
+	key string // metadata key, bound when the field is used
+}
+
+// fields are the names a query can use. Buckets of created_at (day to year)
+// are text, so they sort and compare in time order.
+var fields = map[string]field{
+	"id":              {sql: "l.id", typ: typeNumber},
+	"amount":          {sql: "l.amount", typ: typeNumber},
+	"description":     {sql: "l.description", typ: typeText},
+	"book":            {sql: "l.book", typ: typeText},
+	"voucher":         {sql: "l.voucher_number", typ: typeText},
+	"counterparty":    {sql: "l.counterparty_id", typ: typeNumber},
+	"rule":            {sql: "l.rule_id", typ: typeNumber},
+	"date":            {sql: "l.created_at::date", typ: typeDate},
+	"trade_date":      {sql: "l.trade_date", typ: typeDate},
+	"settlement_date": {sql: "l.settlement_date", typ: typeDate},
+	"day":             {sql: "to_char(l.created_at, 'YYYY-MM-DD')", typ: typeText},
+	"week":            {sql: `to_char(l.created_at, 'IYYY-"W"IW')`, typ: typeText},
+	"month":           {sql: "to_char(l.created_at, 'YYYY-MM')", typ: typeText},
+	"quarter":         {sql: `to_char(l.created_at, 'YYYY-"Q"Q')`, typ: typeText},
+	"year":            {sql: "to_char(l.created_at, 'YYYY')", typ: typeText},
+	"tag":             {typ: typeTag},
+	"account":         {typ: typeAccount},
+}
+
+// metadataPrefix names a metadata value, as in metadata.cost_center
+const metadataPrefix = "metadata."
+
+// defaultColumns are returned when a query without by lists nothing to return
+var defaultColumns = []string{"id", "date", "amount", "description"}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Column is a column of a query's result
+type Column struct {
+	Name string
+	// Number columns scan as float64, the rest as strings
+	Number bool
+}
+
+// Plan is a query planned into SQL over the live ledger entries of one
+// organization. The SQL takes the organization id as $1 followed by Args,
+// and returns at most Limit+1 rows so a caller can tell the result was cut off.
+// From is the earliest day the where clause lets an entry be created on, nil
+// when it does not bound date from below, so a caller can tell whether the
+// query reaches entries that are no longer live.
+type Plan struct {
+	SQL     string
+	Args    []interface{}
+	Columns []Column
+	Limit   int
+	From    *time.Time
+}
+
+// Parse parses and plans a query. Every problem with the query is an *Error.
+func Parse(q string) (*Plan, error) {
+	s, err := parse(q)
+	if err != nil {
+		return nil, err
+	}
+	return (&planner{}).plan(s)
+}
+
+type planner struct {
+	args []interface{}
+}
+
+// arg binds v and returns its placeholder; $1 is the organization
+func (p *planner) arg(v interface{}) string {
+	p.args = append(p.args, v)
+	return "$" + strconv.Itoa(len(p.args)+1)
+}
+
+// field resolves a field name to its canonical name and definition
+func (p *planner) field(name string, pos int) (string, field, error) {
+	if len(name) > len(metadataPrefix) && strings.EqualFold(name[:len(metadataPrefix)], metadataPrefix) {
+		key := name[len(metadataPrefix):]
+		return metadataPrefix + key, field{typ: typeText, key: key}, nil
+	}
+	name = strings.ToLower(name)
+	f, ok := fields[name]
+	if !ok {
+		return "", field{}, errorAt(pos, "unknown field %q", name)
+	}
+	return name, f, nil
+}
+
+// expr is the SQL for a field
+func (p *planner) expr(f field) string {
+	if f.key != "" {
+		return "(l.metadata->>" + p.arg(f.key) + "::text)"
+	}
+	return f.sql
+}
+
+// output is the SQL returning sql of type typ: numbers as float8 and dates as text
+func output(sql string, typ int) string {
+	switch typ {
+	case typeNumber:
+		return sql + "::float8"
+	case typeDate:
+		return "to_char(" + sql + ", 'YYYY-MM-DD')"
+	}
+	return sql
+}
+
+func (p *planner) plan(s *statement) (*Plan, error) {
+	aggregated := len(s.groups) > 0
+	for _, c := range s.columns {
+		if c.agg != "" {
+			aggregated = true
+		}
+	}
+	if !aggregated && len(s.columns) == 0 {
+		for _, name := range defaultColumns {
+			s.columns = append(s.columns, column{field: name})
+		}
+	}
+	if len(s.groups) > 0 {
+		counted := false
+		for _, c := range s.columns {
+			counted = counted || c.agg != ""
+		}
+		if !counted {
+			s.columns = append(s.columns, column{agg: "count"})
+		}
+	}
+
+	plan := &Plan{Limit: DefaultLimit}
+	if s.limit > 0 {
+		plan.Limit = s.limit
+	}
+	var selects []string
+	names := map[string]int{}
+	add := func(name string, sql string, number bool, pos int) error {
+		if _, ok := names[name]; ok {
+			return errorAt(pos, "%s is returned more than once", name)
+		}
+		names[name] = len(selects) + 1
+		selects = append(selects, sql)
+		plan.Columns = append(plan.Columns, Column{Name: name, Number: number})
+		return nil
+	}
+
+	// Groups come first so GROUP BY can name them by position
+	grouped := map[string]bool{}
+	for _, g := range s.groups {
+		name, f, err := p.field(g.field, g.pos)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == typeTag || f.typ == typeAccount {
+			return nil, errorAt(g.pos, "%s can only be used in where", name)
+		}
+		if err := add(name, output(p.expr(f), f.typ), f.typ == typeNumber, g.pos); err != nil {
+			return nil, err
+		}
+		grouped[name] = true
+	}
+
+	for _, c := range s.columns {
+		if c.agg != "" {
+			name, sql, number, err := p.aggregate(c)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(name, sql, number, c.pos); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		name, f, err := p.field(c.field, c.pos)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == typeTag || f.typ == typeAccount {
+			return nil, errorAt(c.pos, "%s can only be used in where", name)
+		}
+		if aggregated {
+			if !grouped[name] {
+				return nil, errorAt(c.pos, "%s must be aggregated or listed after by", name)
+			}
+			// Already returned as a group
+			continue
+		}
+		if err := add(name, output(p.expr(f), f.typ), f.typ == typeNumber, c.pos); err != nil {
+			return nil, err
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT " + strings.Join(selects, ", ") + " FROM ledger l WHERE l.org_id = $1")
+	if s.where != nil {
+		where, err := p.condition(s.where)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(" AND " + where)
+	}
+
+	if len(s.groups) > 0 {
+		positions := make([]string, len(s.groups))
+		for i := range s.groups {
+			positions[i] = strconv.Itoa(i + 1)
+		}
+		b.WriteString(" GROUP BY " + strings.Join(positions, ", "))
+	}
+
+	var order []string
+	for _, o := range s.order {
+		term, err := p.ordering(o.col, names, aggregated)
+		if err != nil {
+			return nil, err
+		}
+		if o.desc {
+			term += " DESC"
+		}
+		order = append(order, term)
+	}
+	if len(order) == 0 {
+		if aggregated {
+			for i := range s.groups {
+				order = append(order, strconv.Itoa(i+1))
+			}
+		} else {
+			order = append(order, "l.id")
+		}
+	}
+	if len(order) > 0 {
+		b.WriteString(" ORDER BY " + strings.Join(order, ", "))
+	}
+
+	b.WriteString(" LIMIT " + p.arg(plan.Limit+1))
+	plan.SQL = b.String()
+	plan.From = lowerBound(s.where)
+	plan.Args = p.args
+	return plan, nil
+}
+
+func (p *planner) aggregate(c column) (string, string, bool, error) {
+	if c.field == "" {
+		return c.agg, "count(*)::float8", true, nil
+	}
+	name, f, err := p.field(c.field, c.pos)
+	if err != nil {
+		return "", "", false, err
+	}
+	if f.typ == typeTag || f.typ == typeAccount {
+		return "", "", false, errorAt(c.pos, "%s can only be used in where", name)
+	}
+	name = c.agg + "_" + name
+
+	switch c.agg {
+	case "count":
+		return name, "count(" + p.expr(f) + ")::float8", true, nil
+	case "sum", "avg":
+		if f.typ != typeNumber {
+			return "", "", false, errorAt(c.pos, "%s needs a number field", c.agg)
+		}
+	}
+	return name, output(c.agg+"("+p.expr(f)+")", f.typ), f.typ == typeNumber, nil
+}
+
+// ordering is the ORDER BY term for c: a returned column by position, or when
+// nothing is aggregated any field
+func (p *planner) ordering(c column, names map[string]int, aggregated bool) (string, error) {
+	name := c.name()
+	if c.agg == "" || c.field != "" {
+		// Canonicalize the field part of the name the way columns are named
+		canonical, f, err := p.field(c.field, c.pos)
+		if err != nil {
+			return "", err
+		}
+		if c.agg != "" {
+			canonical = c.agg + "_" + canonical
+		}
+		name = canonical
+
+		if i, ok := names[name]; ok {
+			return strconv.Itoa(i), nil
+		}
+		if !aggregated && c.agg == "" {
+			if f.typ == typeTag || f.typ == typeAccount {
+				return "", errorAt(c.pos, "%s can only be used in where", name)
+			}
+			return p.expr(f), nil
+		}
+	} else if i, ok := names[name]; ok {
+		return strconv.Itoa(i), nil
+	}
+	return "", errorAt(c.pos, "cannot order by %s: it is not returned", name)
+}
+
+func (p *planner) condition(c condition) (string, error) {
+	switch c := c.(type) {
+	case *logical:
+		left, err := p.condition(c.left)
+		if err != nil {
+			return "", err
+		}
+		right, err := p.condition(c.right)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + strings.ToUpper(c.op) + " " + right + ")", nil
+	case *negation:
+		inner, err := p.condition(c.c)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case *comparison:
+		return p.comparison(c)
+	}
+	return "", fmt.Errorf("unknown condition %T", c)
+}
+
+func (p *planner) comparison(c *comparison) (string, error) {
+	name, f, err := p.field(c.field, c.pos)
+	if err != nil {
+		return "", err
+	}
+	negated := c.op == "!=" || c.op == "not in"
+
+	switch f.typ {
+	case typeTag, typeAccount:
+		if c.op != "=" && c.op != "!=" && c.op != "in" && c.op != "not in" {
+			return "", errorAt(c.pos, "%s can only be compared with =, !=, in and not in", name)
+		}
+		var values []string
+		for _, v := range c.values {
+			values = append(values, p.arg(v.text)+"::text")
+		}
+		var sql string
+		if f.typ == typeTag {
+			sql = "COALESCE(l.tags, '{}') && ARRAY[" + strings.Join(values, ", ") + "]"
+		} else {
+			var accounts []string
+			for _, v := range values {
+				accounts = append(accounts, "p.account = "+v+" OR starts_with(p.account, "+v+" || ':')")
+			}
+			sql = "EXISTS (SELECT 1 FROM ledger_postings p WHERE p.org_id = l.org_id AND p.ledger_id = l.id AND (" +
+				strings.Join(accounts, " OR ") + "))"
+		}
+		if negated {
+			return "NOT (" + sql + ")", nil
+		}
+		return "(" + sql + ")", nil
+	}
+
+	if c.op == "contains" {
+		if f.typ != typeText {
+			return "", errorAt(c.pos, "contains needs a text field, %s is not one", name)
+		}
+		return "(" + p.expr(f) + " ILIKE '%' || " + p.arg(likeEscaper.Replace(c.values[0].text)) + "::text || '%')", nil
+	}
+
+	sql := p.expr(f)
+	var values []string
+	for _, v := range c.values {
+		value, err := p.value(name, f, v)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	switch c.op {
+	case "in":
+		return "(" + sql + " IN (" + strings.Join(values, ", ") + "))", nil
+	case "not in":
+		// Entries without the field are not in the list
+		return "((" + sql + " IN (" + strings.Join(values, ", ") + ")) IS NOT TRUE)", nil
+	case "!=":
+		return "(" + sql + " IS DISTINCT FROM " + values[0] + ")", nil
+	}
+	return "(" + sql + " " + c.op + " " + values[0] + ")", nil
+}
+
+// value binds v as a value of f's type
+func (p *planner) value(name string, f field, v token) (string, error) {
+	switch f.typ {
+	case typeNumber:
+		if _, err := strconv.ParseFloat(v.text, 64); err != nil {
+			return "", errorAt(v.pos, "%s needs a number, found %s", name, v)
+		}
+		return p.arg(v.text) + "::numeric", nil
+	case typeDate:
+		if _, err := time.Parse("2006-01-02", v.text); err != nil {
+			return "", errorAt(v.pos, "%s needs a date like 2026-01-31, found %s", name, v)
+		}
+		return p.arg(v.text) + "::date", nil
+	}
+	return p.arg(v.text) + "::text", nil
+}
+
+// lowerBound is the earliest date a condition lets an entry be created on,
+// or nil when it sets none. Only comparisons of date count: the text buckets
+// and negations are treated as unbounded.
+func lowerBound(c condition) *time.Time {
+	switch c := c.(type) {
+	case *logical:
+		left, right := lowerBound(c.left), lowerBound(c.right)
+		if c.op == "and" {
+			// Both must hold, so the later bound applies
+			if left == nil || (right != nil && right.After(*left)) {
+				return right
+			}
+			return left
+		}
+		if left == nil || right == nil {
+			return nil
+		}
+		if right.Before(*left) {
+			return right
+		}
+		return left
+	case *comparison:
+		if !strings.EqualFold(c.field, "date") {
+			return nil
+		}
+		switch c.op {
+		case "=", ">=", ">", "in":
+		default:
+			return nil
+		}
+		var bound *time.Time
+		for _, v := range c.values {
+			d, err := time.Parse("2006-01-02", v.text)
+			if err != nil {
+				return nil
+			}
+			if c.op == ">" {
+				d = d.AddDate(0, 0, 1)
+			}
+			if bound == nil || d.Before(*bound) {
+				bound = &d
+			}
+		}
+		return bound
+	}
+	return nil
+}
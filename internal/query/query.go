@@ -0,0 +1,540 @@
+// Package query implements the ledger query language, a small read-only
+// language for ad-hoc questions about the entries of an organization:
+//
+//	sum amount by month where tag = fx and amount > 1000
+//	count, avg(amount) by book, metadata.desk order by count desc limit 10
+//	id, description, amount where description contains "wire" and date >= 2026-01-01
+//
+// A query lists what to return, then any of these clauses in any order:
+//
+//	by f1, f2           group by fields (also "group by")
+//	where condition     =, !=, <, <=, >, >=, contains, in (...), not in (...),
+//	                    combined with and, or, not and parentheses
+//	order by c [desc]   sort by a returned column
+//	limit n             at most n rows (default 100, at most 1000)
+//
+// What to return is a list of fields and aggregates: count, sum, avg, min and
+// max, written sum(amount) or sum amount. With by and nothing to return, the
+// groups are counted. Values are numbers, dates (2026-01-31), single words or
+// quoted strings.
+//
+// Queries are planned into parameterized SQL: user input only ever reaches the
+// database as bind parameters, and only fields from a fixed list can be named.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Resource limits on a query
+const (
+	MaxLength     = 2000 // characters of query text
+	MaxColumns    = 10   // returned fields and aggregates
+	MaxGroups     = 4    // fields after by
+	MaxConditions = 32   // comparisons in where
+	MaxDepth      = 8    // nesting of not and parentheses
+	MaxValues     = 50   // values in an in (...) list
+	DefaultLimit  = 100
+	MaxLimit      = 1000
+)
+
+// Error is a query that cannot be parsed or planned. Pos is the 1-based
+// character offset of the problem.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+func errorAt(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Token kinds
+const (
+	tokEnd = iota
+	tokWord
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEnd:
+		return "end of query"
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// keywords cannot be used as field names or unquoted values
+var keywords = map[string]bool{
+	"select": true, "by": true, "group": true, "where": true, "and": true, "or": true,
+	"not": true, "in": true, "contains": true, "order": true, "asc": true, "desc": true,
+	"limit": true, "count": true, "sum": true, "avg": true, "min": true, "max": true,
+}
+
+var comparators = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.:-/+", r)
+}
+
+func lex(q string) ([]token, error) {
+	var toks []token
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, errorAt(start, "unterminated string")
+				}
+				if runes[i] == r {
+					// A doubled quote stands for itself
+					if i+1 < len(runes) && runes[i+1] == r {
+						b.WriteRune(r)
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start})
+			i++
+		case strings.ContainsRune("(),*=", r):
+			toks = append(toks, token{kind: tokPunct, text: string(r), pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				op += string(runes[i+1])
+			}
+			if op == "!" {
+				return nil, errorAt(i, "unexpected '!', did you mean '!='?")
+			}
+			toks = append(toks, token{kind: tokPunct, text: strings.Replace(op, "<>", "!=", 1), pos: i})
+			i += len(op)
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokWord, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, errorAt(i, "unexpected character %q", r)
+		}
+	}
+	return append(toks, token{kind: tokEnd, pos: len(runes)}), nil
+}
+
+// column is a returned field or aggregate; agg is empty for a plain field and
+// field is empty for count
+type column struct {
+	agg   string
+	field string
+	pos   int
+}
+
+func (c column) name() string {
+	switch {
+	case c.agg == "":
+		return c.field
+	case c.field == "":
+		return c.agg
+	}
+	return c.agg + "_" + c.field
+}
+
+// Conditions of a where clause
+type (
+	logical struct {
+		op          string // and, or
+		left, right condition
+	}
+	negation struct {
+		c condition
+	}
+	comparison struct {
+		field  string
+		op     string // =, !=, <, <=, >, >=, contains, in, not in
+		values []token
+		pos    int
+	}
+	condition interface{}
+)
+
+type ordering struct {
+	col  column
+	desc bool
+}
+
+type statement struct {
+	columns []column
+	groups  []column
+	where   condition
+	order   []ordering
+	limit   int
+}
+
+type parser struct {
+	toks       []token
+	i          int
+	conditions int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEnd {
+		p.i++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw, consuming it if so
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) punct(s string) bool {
+	t := p.peek()
+	if t.kind == tokPunct && t.text == s {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if p.punct(s) {
+		return nil
+	}
+	t := p.peek()
+	return errorAt(t.pos, "expected '%s', found %s", s, t)
+}
+
+func isKeyword(t token) bool {
+	return t.kind == tokWord && keywords[strings.ToLower(t.text)]
+}
+
+func parse(q string) (*statement, error) {
+	if len([]rune(q)) > MaxLength {
+		return nil, errorAt(MaxLength, "query is longer than %d characters", MaxLength)
+	}
+	toks, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	s := &statement{}
+
+	p.keyword("select")
+	if t := p.peek(); t.kind != tokEnd && !isClause(t) {
+		if s.columns, err = p.columns(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]bool{}
+	for p.peek().kind != tokEnd {
+		t := p.next()
+		clause := strings.ToLower(t.text)
+		if t.kind != tokWord || !isClause(t) {
+			return nil, errorAt(t.pos, "expected by, where, order by or limit, found %s", t)
+		}
+		if clause == "group" || clause == "order" {
+			if !p.keyword("by") {
+				return nil, errorAt(p.peek().pos, "expected 'by' after %s", clause)
+			}
+		}
+		if clause == "group" {
+			clause = "by"
+		}
+		if seen[clause] {
+			return nil, errorAt(t.pos, "%s is given more than once", t.text)
+		}
+		seen[clause] = true
+
+		switch clause {
+		case "by":
+			if s.groups, err = p.groups(); err != nil {
+				return nil, err
+			}
+		case "where":
+			if s.where, err = p.or(0); err != nil {
+				return nil, err
+			}
+		case "order":
+			if s.order, err = p.orderings(); err != nil {
+				return nil, err
+			}
+		case "limit":
+			if s.limit, err = p.limit(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func isClause(t token) bool {
+	if t.kind != tokWord {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "by", "group", "where", "order", "limit":
+		return true
+	}
+	return false
+}
+
+func (p *parser) columns() ([]column, error) {
+	var cols []column
+	for {
+		c, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		if len(cols) == MaxColumns {
+			return nil, errorAt(c.pos, "at most %d columns can be returned", MaxColumns)
+		}
+		cols = append(cols, c)
+		if !p.punct(",") {
+			return cols, nil
+		}
+	}
+}
+
+func (p *parser) column() (column, error) {
+	t := p.next()
+	if t.kind != tokWord {
+		return column{}, errorAt(t.pos, "expected a field or aggregate, found %s", t)
+	}
+	word := strings.ToLower(t.text)
+	switch word {
+	case "count", "sum", "avg", "min", "max":
+	default:
+		if isKeyword(t) {
+			return column{}, errorAt(t.pos, "expected a field or aggregate, found %s", t)
+		}
+		return column{field: t.text, pos: t.pos}, nil
+	}
+
+	c := column{agg: word, pos: t.pos}
+	paren := p.punct("(")
+	switch {
+	case paren && word == "count" && p.punct("*"):
+	case paren && word == "count" && p.peek().kind == tokPunct && p.peek().text == ")":
+	case !paren && word == "count":
+		// count on its own counts entries; count f counts entries where f is set
+		if f := p.peek(); f.kind == tokWord && !isKeyword(f) {
+			c.field = p.next().text
+		}
+	default:
+		f := p.next()
+		if f.kind != tokWord || isKeyword(f) {
+			return column{}, errorAt(f.pos, "expected a field after %s, found %s", word, f)
+		}
+		c.field = f.text
+	}
+	if paren {
+		if err := p.expect(")"); err != nil {
+			return column{}, err
+		}
+	}
+	return c, nil
+}
+
+func (p *parser) groups() ([]column, error) {
+	var groups []column
+	for {
+		t := p.next()
+		if t.kind != tokWord || isKeyword(t) {
+			return nil, errorAt(t.pos, "expected a field to group by, found %s", t)
+		}
+		if len(groups) == MaxGroups {
+			return nil, errorAt(t.pos, "at most %d fields can be grouped by", MaxGroups)
+		}
+		groups = append(groups, column{field: t.text, pos: t.pos})
+		if !p.punct(",") {
+			return groups, nil
+		}
+	}
+}
+
+func (p *parser) orderings() ([]ordering, error) {
+	var order []ordering
+	for {
+		c, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		o := ordering{col: c}
+		if p.keyword("desc") {
+			o.desc = true
+		} else {
+			p.keyword("asc")
+		}
+		order = append(order, o)
+		if !p.punct(",") {
+			return order, nil
+		}
+	}
+}
+
+func (p *parser) limit() (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokWord || err != nil || n < 1 || n > MaxLimit {
+		return 0, errorAt(t.pos, "limit must be a whole number from 1 to %d, found %s", MaxLimit, t)
+	}
+	return n, nil
+}
+
+// or parses: and {or and}
+func (p *parser) or(depth int) (condition, error) {
+	left, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+// and parses: unary {and unary}
+func (p *parser) and(depth int) (condition, error) {
+	left, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary parses: not unary | ( or ) | comparison
+func (p *parser) unary(depth int) (condition, error) {
+	t := p.peek()
+	if depth > MaxDepth {
+		return nil, errorAt(t.pos, "conditions are nested more than %d deep", MaxDepth)
+	}
+	if p.keyword("not") {
+		c, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &negation{c: c}, nil
+	}
+	if p.punct("(") {
+		c, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (condition, error) {
+	f := p.next()
+	if f.kind != tokWord || isKeyword(f) {
+		return nil, errorAt(f.pos, "expected a field, found %s", f)
+	}
+	p.conditions++
+	if p.conditions > MaxConditions {
+		return nil, errorAt(f.pos, "at most %d comparisons are allowed", MaxConditions)
+	}
+	c := &comparison{field: f.text, pos: f.pos}
+
+	op := p.next()
+	switch {
+	case op.kind == tokPunct && comparators[op.text]:
+		c.op = op.text
+	case op.kind == tokWord && strings.EqualFold(op.text, "contains"):
+		c.op = "contains"
+	case op.kind == tokWord && strings.EqualFold(op.text, "in"):
+		c.op = "in"
+	case op.kind == tokWord && strings.EqualFold(op.text, "not") && p.keyword("in"):
+		c.op = "not in"
+	default:
+		return nil, errorAt(op.pos, "expected a comparison after %s, found %s", f.text, op)
+	}
+
+	if c.op != "in" && c.op != "not in" {
+		v, err := p.value(c.op)
+		if err != nil {
+			return nil, err
+		}
+		c.values = []token{v}
+		return c, nil
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		v, err := p.value(c.op)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.values) == MaxValues {
+			return nil, errorAt(v.pos, "at most %d values can be listed", MaxValues)
+		}
+		c.values = append(c.values, v)
+		if !p.punct(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *parser) value(op string) (token, error) {
+	v := p.next()
+	if v.kind == tokString || (v.kind == tokWord && !isKeyword(v)) {
+		return v, nil
+	}
+	return token{}, errorAt(v.pos, "expected a value after %s, found %s", op, v)
+}
@@ -0,0 +1,221 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	entryColumns := []Column{{"id", true}, {"date", false}, {"amount", true}, {"description", false}}
+	const entrySelect = "SELECT l.id::float8, to_char(l.created_at::date, 'YYYY-MM-DD'), l.amount::float8, l.description FROM ledger l WHERE l.org_id = $1"
+
+	tests := []struct {
+		query   string
+		sql     string
+		args    []interface{}
+		columns []Column
+		limit   int
+	}{
+		{
+			query:   "",
+			sql:     entrySelect + " ORDER BY l.id LIMIT $2",
+			args:    []interface{}{101},
+			columns: entryColumns,
+			limit:   100,
+		},
+		{
+			query: "sum amount by month where tag = fx and amount > 1000",
+			sql: "SELECT to_char(l.created_at, 'YYYY-MM'), sum(l.amount)::float8 FROM ledger l WHERE l.org_id = $1" +
+				" AND ((COALESCE(l.tags, '{}') && ARRAY[$2::text]) AND (l.amount > $3::numeric)) GROUP BY 1 ORDER BY 1 LIMIT $4",
+			args:    []interface{}{"fx", "1000", 101},
+			columns: []Column{{"month", false}, {"sum_amount", true}},
+			limit:   100,
+		},
+		{
+			query: "count, avg(amount) by book, metadata.desk order by count desc limit 10",
+			sql: "SELECT l.book, (l.metadata->>$2::text), count(*)::float8, avg(l.amount)::float8 FROM ledger l WHERE l.org_id = $1" +
+				" GROUP BY 1, 2 ORDER BY 3 DESC LIMIT $3",
+			args:    []interface{}{"desk", 11},
+			columns: []Column{{"book", false}, {"metadata.desk", false}, {"count", true}, {"avg_amount", true}},
+			limit:   10,
+		},
+		{
+			query: `id, description, amount where description contains "wire" and date >= 2026-01-01`,
+			sql: "SELECT l.id::float8, l.description, l.amount::float8 FROM ledger l WHERE l.org_id = $1" +
+				" AND ((l.description ILIKE '%' || $2::text || '%') AND (l.created_at::date >= $3::date)) ORDER BY l.id LIMIT $4",
+			args:    []interface{}{"wire", "2026-01-01", 101},
+			columns: []Column{{"id", true}, {"description", false}, {"amount", true}},
+			limit:   100,
+		},
+		{
+			query:   "by book",
+			sql:     "SELECT l.book, count(*)::float8 FROM ledger l WHERE l.org_id = $1 GROUP BY 1 ORDER BY 1 LIMIT $2",
+			args:    []interface{}{101},
+			columns: []Column{{"book", false}, {"count", true}},
+			limit:   100,
+		},
+		{
+			query: "where account in (Assets:Cash, 'Assets:Bank') or not book != main",
+			sql: entrySelect + " AND ((EXISTS (SELECT 1 FROM ledger_postings p WHERE p.org_id = l.org_id AND p.ledger_id = l.id" +
+				" AND (p.account = $2::text OR starts_with(p.account, $2::text || ':') OR p.account = $3::text OR starts_with(p.account, $3::text || ':'))))" +
+				" OR NOT (l.book IS DISTINCT FROM $4::text)) ORDER BY l.id LIMIT $5",
+			args:    []interface{}{"Assets:Cash", "Assets:Bank", "main", 101},
+			columns: entryColumns,
+			limit:   100,
+		},
+		{
+			query: "where description contains '50%_off' and metadata.desk not in (EQ, FX)",
+			sql: entrySelect + " AND ((l.description ILIKE '%' || $2::text || '%')" +
+				" AND (((l.metadata->>$3::text) IN ($4::text, $5::text)) IS NOT TRUE)) ORDER BY l.id LIMIT $6",
+			args:    []interface{}{`50\%\_off`, "desk", "EQ", "FX", 101},
+			columns: entryColumns,
+			limit:   100,
+		},
+		{
+			query: "select max(date), min trade_date by counterparty",
+			sql: "SELECT l.counterparty_id::float8, to_char(max(l.created_at::date), 'YYYY-MM-DD'), to_char(min(l.trade_date), 'YYYY-MM-DD')" +
+				" FROM ledger l WHERE l.org_id = $1 GROUP BY 1 ORDER BY 1 LIMIT $2",
+			args:    []interface{}{101},
+			columns: []Column{{"counterparty", true}, {"max_date", false}, {"min_trade_date", false}},
+			limit:   100,
+		},
+		{
+			query:   "id where voucher = 'it''s' order by amount desc",
+			sql:     "SELECT l.id::float8 FROM ledger l WHERE l.org_id = $1 AND (l.voucher_number = $2::text) ORDER BY l.amount DESC LIMIT $3",
+			args:    []interface{}{"it's", 101},
+			columns: []Column{{"id", true}},
+			limit:   100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			plan, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if plan.SQL != tt.sql {
+				t.Errorf("SQL:\n got %s\nwant %s", plan.SQL, tt.sql)
+			}
+			if !reflect.DeepEqual(plan.Args, tt.args) {
+				t.Errorf("Args = %#v, want %#v", plan.Args, tt.args)
+			}
+			if !reflect.DeepEqual(plan.Columns, tt.columns) {
+				t.Errorf("Columns = %v, want %v", plan.Columns, tt.columns)
+			}
+			if plan.Limit != tt.limit {
+				t.Errorf("Limit = %d, want %d", plan.Limit, tt.limit)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		// Syntax
+		{"sum(amount", 11, "expected ')'"},
+		{"amount >", 8, "expected by, where, order by or limit"},
+		{"where amount ! 5", 14, "did you mean '!='?"},
+		{"where 'x", 7, "unterminated string"},
+		{"where amount in 1", 17, "expected '('"},
+		{"where amount = 1 and", 21, "expected a field, found end of query"},
+		{"where description contains 5 where", 30, "where is given more than once"},
+		{"group book", 7, "expected 'by' after group"},
+		{"count(*) foo", 10, "expected by, where, order by or limit"},
+		{"desc", 1, "expected a field or aggregate"},
+		{"limit 0", 7, "limit must be a whole number"},
+
+		// Fields
+		{"where foo = 1", 7, `unknown field "foo"`},
+		{"id, bar", 5, `unknown field "bar"`},
+		{"sum(total) by book", 1, `unknown field "total"`},
+		{"by desk", 4, `unknown field "desk"`},
+		{"id order by size", 13, `unknown field "size"`},
+		{"by tag", 4, "tag can only be used in where"},
+		{"where tag > x", 7, "tag can only be compared with =, !=, in and not in"},
+		{"sum description", 1, "sum needs a number field"},
+		{"id by book", 1, "id must be aggregated or listed after by"},
+		{"id, id", 5, "id is returned more than once"},
+		{"order by count", 10, "cannot order by count: it is not returned"},
+
+		// Values
+		{"id where amount = abc", 19, "amount needs a number, found 'abc'"},
+		{"where date = 2026-13-01", 14, "date needs a date like 2026-01-31"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var qerr *Error
+			if !errors.As(err, &qerr) {
+				t.Fatalf("Parse error = %v, want an *Error", err)
+			}
+			if qerr.Pos != tt.pos {
+				t.Errorf("Pos = %d, want %d (%v)", qerr.Pos, tt.pos, err)
+			}
+			if !strings.Contains(qerr.Msg, tt.msg) {
+				t.Errorf("Msg = %q, want it to contain %q", qerr.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	long := "where description contains '" + strings.Repeat("x", MaxLength) + "'"
+	if _, err := Parse(long); err == nil {
+		t.Errorf("a query longer than %d characters parsed", MaxLength)
+	}
+
+	values := strings.TrimSuffix(strings.Repeat("1, ", MaxValues+1), ", ")
+	if _, err := Parse("where id in (" + values + ")"); err == nil {
+		t.Errorf("an in list of %d values parsed", MaxValues+1)
+	}
+
+	nested := "where " + strings.Repeat("not ", MaxDepth+1) + "id = 1"
+	if _, err := Parse(nested); err == nil {
+		t.Errorf("conditions nested %d deep parsed", MaxDepth+1)
+	}
+}
+
+func TestPlanFrom(t *testing.T) {
+	tests := []struct {
+		query string
+		from  string // empty when date is not bounded below
+	}{
+		{"count", ""},
+		{"where amount > 5", ""},
+		{"where date >= 2026-01-01", "2026-01-01"},
+		{"where date > 2026-01-31", "2026-02-01"},
+		{"where date = 2026-03-15", "2026-03-15"},
+		{"where date in (2026-03-15, 2026-02-01)", "2026-02-01"},
+		{"where date <= 2026-01-01", ""},
+		{"where not date < 2026-01-01", ""},
+		{"where date >= 2026-01-01 and date >= 2026-02-01", "2026-02-01"},
+		{"where date >= 2026-02-01 and amount > 5", "2026-02-01"},
+		{"where date >= 2026-02-01 or date = 2026-01-10", "2026-01-10"},
+		{"where date >= 2026-02-01 or amount > 5", ""},
+		{"where month = '2026-02'", ""},
+		{"where trade_date >= 2026-01-01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			plan, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			got := ""
+			if plan.From != nil {
+				got = plan.From.Format("2006-01-02")
+			}
+			if got != tt.from {
+				t.Errorf("From = %q, want %q", got, tt.from)
+			}
+		})
+	}
+}
@@ -0,0 +1,521 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrAccountExists is returned when creating an account the chart already has
+var ErrAccountExists = errors.New("account already exists")
+
+// ErrUnknownAccount is returned when naming an account that is not in the chart
+var ErrUnknownAccount = errors.New("unknown account")
+
+// ErrUnknownParent is returned when placing an account under one that is not in the chart
+var ErrUnknownParent = errors.New("unknown parent account")
+
+// ErrParentNotHeader is returned when placing an account under one that is not a header
+var ErrParentNotHeader = errors.New("parent account is not a header account")
+
+// ErrAccountCycle is returned when moving an account under itself or one of its descendants
+var ErrAccountCycle = errors.New("account cannot be moved under itself or its descendants")
+
+// ErrAccountInUse is returned when a change would orphan children or strand postings
+var ErrAccountInUse = errors.New("account is in use")
+
+// ErrHeaderAccount is returned when an entry posts to a header account
+var ErrHeaderAccount = errors.New("cannot post to a header account")
+
+// Account is a node of the chart of accounts. Code is the name postings use;
+// Depth is 1 for top-level accounts.
+type Account struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	Parent    string    `json:"parent,omitempty"`
+	Header    bool      `json:"header"`
+	Depth     int       `json:"depth"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AccountUpdate changes an account; nil fields are left as they are and an
+// empty Parent moves the account to the top level. Codes cannot change,
+// since postings refer to accounts by code.
+type AccountUpdate struct {
+	Name   *string
+	Parent *string
+	Header *bool
+}
+
+// AccountNode is an account with its own balance and the total rolled up
+// from its descendants. Accounts posted to but missing from the chart are
+// top-level leaves with ID 0.
+type AccountNode struct {
+	Account
+	Balance  float64        `json:"balance"`
+	Total    float64        `json:"total"`
+	Children []*AccountNode `json:"children,omitempty"`
+}
+
+// AccountTree is the chart of accounts with balances as of an instant
+type AccountTree struct {
+	AsOf     time.Time      `json:"as_of"`
+	Accounts []*AccountNode `json:"accounts"`
+}
+
+type AccountRepository struct {
+	db *sql.DB
+}
+
+func NewAccountRepository(db *sql.DB) *AccountRepository {
+	return &AccountRepository{db: db}
+}
+
+// Create adds an account to the chart, under parent when it is not empty.
+// An account that has been posted to cannot be created as a header.
+func (r *AccountRepository) Create(ctx context.Context, a Account, parent string) (*Account, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertAccount(ctx, tx, id.OrgID, &a, parent); err != nil {
+		return nil, err
+	}
+
+	accounts, err := chartOfAccounts(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return findAccount(accounts, a.ID), nil
+}
+
+// Update renames, moves or changes the header flag of an account. An account
+// with postings cannot become a header, and one with children cannot stop being one.
+func (r *AccountRepository) Update(ctx context.Context, accountID int, u AccountUpdate) (*Account, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	a, err := lockAccount(ctx, tx, id.OrgID, "id = $2", accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Name != nil {
+		a.Name = *u.Name
+	}
+	if u.Parent != nil {
+		a.ParentID = nil
+		if *u.Parent != "" {
+			p, err := lockParent(ctx, tx, id.OrgID, *u.Parent)
+			if err != nil {
+				return nil, err
+			}
+			tree, err := loadAccountTree(ctx, tx, id.OrgID)
+			if err != nil {
+				return nil, err
+			}
+			if tree.within(p.Code, a.Code) {
+				return nil, ErrAccountCycle
+			}
+			a.ParentID = &p.ID
+		}
+	}
+	if u.Header != nil && *u.Header != a.Header {
+		if *u.Header {
+			if err := checkNoPostings(ctx, tx, id.OrgID, a.Code); err != nil {
+				return nil, err
+			}
+		} else if err := checkNoChildren(ctx, tx, id.OrgID, a); err != nil {
+			return nil, err
+		}
+		a.Header = *u.Header
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE accounts SET name = $3, parent_id = $4, header = $5, updated_at = CURRENT_TIMESTAMP
+		 WHERE org_id = $1 AND id = $2`,
+		id.OrgID, a.ID, a.Name, a.ParentID, a.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+
+	accounts, err := chartOfAccounts(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return findAccount(accounts, a.ID), nil
+}
+
+// Delete removes an account that has neither children nor postings
+func (r *AccountRepository) Delete(ctx context.Context, accountID int) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	a, err := lockAccount(ctx, tx, id.OrgID, "id = $2", accountID)
+	if err != nil {
+		return err
+	}
+	if err := checkNoChildren(ctx, tx, id.OrgID, a); err != nil {
+		return err
+	}
+	if err := checkNoPostings(ctx, tx, id.OrgID, a.Code); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM accounts WHERE org_id = $1 AND id = $2", id.OrgID, a.ID); err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// List returns the chart of accounts in tree order
+func (r *AccountRepository) List(ctx context.Context) ([]Account, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return chartOfAccounts(ctx, tx, id.OrgID)
+}
+
+// Tree returns the chart of accounts with every account's balance as of asOf
+// and the totals rolled up to each header
+func (r *AccountRepository) Tree(ctx context.Context, asOf time.Time) (*AccountTree, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	accounts, err := chartOfAccounts(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := trialBalance(ctx, tx, id.OrgID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AccountTree{AsOf: asOf, Accounts: []*AccountNode{}}
+	nodes := map[string]*AccountNode{}
+	for _, a := range accounts {
+		n := &AccountNode{Account: a}
+		nodes[a.Code] = n
+		if a.Parent == "" {
+			result.Accounts = append(result.Accounts, n)
+		} else {
+			parent := nodes[a.Parent]
+			parent.Children = append(parent.Children, n)
+		}
+	}
+	for _, l := range lines {
+		n, ok := nodes[l.Account]
+		if !ok {
+			n = &AccountNode{Account: Account{Code: l.Account, Depth: 1}}
+			nodes[l.Account] = n
+			result.Accounts = append(result.Accounts, n)
+		}
+		n.Balance = l.Balance
+	}
+	sort.SliceStable(result.Accounts, func(i, j int) bool { return result.Accounts[i].Code < result.Accounts[j].Code })
+
+	var total func(n *AccountNode) float64
+	total = func(n *AccountNode) float64 {
+		sum := n.Balance
+		for _, c := range n.Children {
+			sum += total(c)
+		}
+		n.Total = round2(sum)
+		return sum
+	}
+	for _, n := range result.Accounts {
+		total(n)
+	}
+	return result, nil
+}
+
+// accountTree maps each account in the chart to its parent, so reports can
+// aggregate balances at any level
+type accountTree map[string]string
+
+func loadAccountTree(ctx context.Context, tx *sql.Tx, orgID int) (accountTree, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT a.code, COALESCE(p.code, '') FROM accounts a
+		 LEFT JOIN accounts p ON p.org_id = a.org_id AND p.id = a.parent_id
+		 WHERE a.org_id = $1`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch accounts: %w", err)
+	}
+	defer rows.Close()
+
+	tree := accountTree{}
+	for rows.Next() {
+		var code, parent string
+		if err := rows.Scan(&code, &parent); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		tree[code] = parent
+	}
+	return tree, rows.Err()
+}
+
+// path lists code's ancestors from the top level down, ending with code.
+// Accounts missing from the chart are their own top level.
+func (t accountTree) path(code string) []string {
+	path := []string{code}
+	for parent := t[code]; parent != "" && len(path) <= len(t); parent = t[parent] {
+		path = append([]string{parent}, path...)
+	}
+	return path
+}
+
+// at is the account code's balance is reported under when rolling up to
+// level: its ancestor at that depth, or code itself when it is no deeper
+func (t accountTree) at(code string, level int) string {
+	if level <= 0 {
+		return code
+	}
+	path := t.path(code)
+	if len(path) <= level {
+		return code
+	}
+	return path[level-1]
+}
+
+// within reports whether code is ancestor or one of its descendants
+func (t accountTree) within(code, ancestor string) bool {
+	for _, a := range t.path(code) {
+		if a == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// rollUpTrialBalance aggregates trial balance lines at level of the tree
+func rollUpTrialBalance(tree accountTree, lines []TrialBalanceLine, level int) []TrialBalanceLine {
+	if level <= 0 {
+		return lines
+	}
+	totals := map[string]float64{}
+	for _, l := range lines {
+		totals[tree.at(l.Account, level)] += l.Balance
+	}
+	result := []TrialBalanceLine{}
+	for account, balance := range totals {
+		l := TrialBalanceLine{Account: account, Balance: round2(balance)}
+		if l.Balance == 0 {
+			continue
+		}
+		if l.Balance > 0 {
+			l.Debit = l.Balance
+		} else {
+			l.Credit = -l.Balance
+		}
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Account < result[j].Account })
+	return result
+}
+
+// checkPostingAccounts rejects postings to header accounts. The accounts are
+// locked FOR SHARE so none can become a header while the entry commits.
+func checkPostingAccounts(ctx context.Context, tx *sql.Tx, orgID int, postings []Posting) error {
+	if len(postings) == 0 {
+		return nil
+	}
+	codes := make([]string, len(postings))
+	for i, p := range postings {
+		codes[i] = p.Account
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT code, header FROM accounts WHERE org_id = $1 AND code = ANY($2) ORDER BY code FOR SHARE",
+		orgID, pq.Array(codes))
+	if err != nil {
+		return fmt.Errorf("failed to check accounts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var code string
+		var header bool
+		if err := rows.Scan(&code, &header); err != nil {
+			return fmt.Errorf("failed to scan account: %w", err)
+		}
+		if header {
+			return fmt.Errorf("%w: %s", ErrHeaderAccount, code)
+		}
+	}
+	return rows.Err()
+}
+
+// chartOfAccounts lists the accounts depth-first, each after its parent
+func chartOfAccounts(ctx context.Context, tx *sql.Tx, orgID int) ([]Account, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT a.id, a.code, a.name, a.parent_id, COALESCE(p.code, ''), a.header, a.created_at, a.updated_at
+		 FROM accounts a LEFT JOIN accounts p ON p.org_id = a.org_id AND p.id = a.parent_id
+		 WHERE a.org_id = $1 ORDER BY a.code`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch accounts: %w", err)
+	}
+	defer rows.Close()
+
+	children := map[string][]Account{}
+	for rows.Next() {
+		var a Account
+		var parentID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.Code, &a.Name, &parentID, &a.Parent, &a.Header, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		if parentID.Valid {
+			v := int(parentID.Int64)
+			a.ParentID = &v
+		}
+		children[a.Parent] = append(children[a.Parent], a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []Account{}
+	var walk func(parent string, depth int)
+	walk = func(parent string, depth int) {
+		for _, a := range children[parent] {
+			a.Depth = depth
+			result = append(result, a)
+			walk(a.Code, depth+1)
+		}
+	}
+	walk("", 1)
+	return result, nil
+}
+
+func findAccount(accounts []Account, id int) *Account {
+	for i := range accounts {
+		if accounts[i].ID == id {
+			return &accounts[i]
+		}
+	}
+	return nil
+}
+
+// lockAccount fetches one account FOR UPDATE by the condition on $2
+func lockAccount(ctx context.Context, tx *sql.Tx, orgID int, where string, arg interface{}) (*Account, error) {
+	var a Account
+	var parentID sql.NullInt64
+	err := tx.QueryRowContext(ctx,
+		`SELECT id, code, name, parent_id, header, created_at, updated_at
+		 FROM accounts WHERE org_id = $1 AND `+where+` FOR UPDATE`, orgID, arg,
+	).Scan(&a.ID, &a.Code, &a.Name, &parentID, &a.Header, &a.CreatedAt, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAccount, arg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account: %w", err)
+	}
+	if parentID.Valid {
+		v := int(parentID.Int64)
+		a.ParentID = &v
+	}
+	return &a, nil
+}
+
+// lockParent fetches the account an account is placed under, which must be a header
+func lockParent(ctx context.Context, tx *sql.Tx, orgID int, code string) (*Account, error) {
+	p, err := lockAccount(ctx, tx, orgID, "code = $2", code)
+	if errors.Is(err, ErrUnknownAccount) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownParent, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.Header {
+		return nil, fmt.Errorf("%w: %s", ErrParentNotHeader, code)
+	}
+	return p, nil
+}
+
+// insertAccount adds a to the chart under parent, setting its ID and timestamps
+func insertAccount(ctx context.Context, tx *sql.Tx, orgID int, a *Account, parent string) error {
+	if parent != "" {
+		p, err := lockParent(ctx, tx, orgID, parent)
+		if err != nil {
+			return err
+		}
+		a.ParentID = &p.ID
+	}
+	if a.Header {
+		if err := checkNoPostings(ctx, tx, orgID, a.Code); err != nil {
+			return err
+		}
+	}
+
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO accounts (org_id, code, name, parent_id, header) VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, created_at, updated_at`,
+		orgID, a.Code, a.Name, a.ParentID, a.Header,
+	).Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrAccountExists
+		}
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+	return nil
+}
+
+func checkNoPostings(ctx context.Context, tx *sql.Tx, orgID int, code string) error {
+	var posted bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM ledger_postings WHERE org_id = $1 AND account = $2)", orgID, code,
+	).Scan(&posted)
+	if err != nil {
+		return fmt.Errorf("failed to check postings: %w", err)
+	}
+	if posted {
+		return fmt.Errorf("%w: %s has postings", ErrAccountInUse, code)
+	}
+	return nil
+}
+
+func checkNoChildren(ctx context.Context, tx *sql.Tx, orgID int, a *Account) error {
+	var children int
+	err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM accounts WHERE org_id = $1 AND parent_id = $2", orgID, a.ID,
+	).Scan(&children)
+	if err != nil {
+		return fmt.Errorf("failed to check child accounts: %w", err)
+	}
+	if children > 0 {
+		return fmt.Errorf("%w: %s has %d child accounts", ErrAccountInUse, a.Code, children)
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Anomaly actions: what happens to a new entry that looks suspicious
+const (
+	AnomalyFlag = "flag"
+	AnomalyHold = "hold"
+)
+
+// Anomaly statuses
+const (
+	AnomalyFlagged  = "flagged"
+	AnomalyHeld     = "held"
+	AnomalyApproved = "approved"
+	AnomalyRejected = "rejected"
+)
+
+// Anomaly reason kinds
+const (
+	AnomalyDuplicate = "duplicate"
+	AnomalyOutlier   = "outlier"
+)
+
+// ErrUnknownAnomaly is returned when reviewing an anomaly that does not exist
+var ErrUnknownAnomaly = errors.New("anomaly not found")
+
+// ErrAnomalyReviewed is returned when reviewing an anomaly a second time
+var ErrAnomalyReviewed = errors.New("anomaly already reviewed")
+
+// DefaultAnomalySettings apply until an organization sets its own
+var DefaultAnomalySettings = AnomalySettings{
+	DuplicateWindowSeconds: 60,
+	OutlierFactor:          10,
+	MinHistory:             10,
+	Action:                 AnomalyFlag,
+}
+
+// AnomalySettings say how new entries are screened. An entry is a duplicate
+// of one with the same amount and description posted (or held) within
+// DuplicateWindowSeconds; a posting is an outlier at OutlierFactor times the
+// median size of its account's last 100 postings, once the account has
+// MinHistory of them.
+type AnomalySettings struct {
+	DuplicateWindowSeconds int        `json:"duplicate_window_seconds"`
+	OutlierFactor          float64    `json:"outlier_factor"`
+	MinHistory             int        `json:"min_history"`
+	Action                 string     `json:"action"`
+	SetBy                  string     `json:"set_by,omitempty"`
+	CreatedAt              *time.Time `json:"created_at,omitempty"`
+}
+
+// AnomalyReason is one finding against an entry. Score is relative to the
+// threshold it crossed: 1 is exactly at it.
+type AnomalyReason struct {
+	Kind     string  `json:"kind"`
+	Score    float64 `json:"score"`
+	Detail   string  `json:"detail"`
+	Account  string  `json:"account,omitempty"`
+	LedgerID *int    `json:"ledger_id,omitempty"`
+}
+
+// HeldEntry is an entry held for approval, as it will be posted
+type HeldEntry struct {
+	Amount         float64           `json:"amount"`
+	Description    string            `json:"description"`
+	Postings       []Posting         `json:"postings,omitempty"`
+	LotMethod      string            `json:"lot_method,omitempty"`
+	LotIDs         []int             `json:"lot_ids,omitempty"`
+	PnLAccount     string            `json:"pnl_account,omitempty"`
+	TradeDate      *time.Time        `json:"trade_date,omitempty"`
+	SettlementDate *time.Time        `json:"settlement_date,omitempty"`
+	CounterpartyID *int              `json:"counterparty_id,omitempty"`
+	Book           string            `json:"book,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	RuleID         *int              `json:"rule_id,omitempty"`
+}
+
+func heldEntry(e NewEntry) HeldEntry {
+	return HeldEntry{
+		Amount:         e.Amount,
+		Description:    e.Description,
+		Postings:       e.Postings,
+		LotMethod:      e.LotMethod,
+		LotIDs:         e.LotIDs,
+		PnLAccount:     e.PnLAccount,
+		TradeDate:      e.TradeDate,
+		SettlementDate: e.SettlementDate,
+		CounterpartyID: e.CounterpartyID,
+		Book:           e.Book,
+		Metadata:       e.Metadata,
+		Tags:           e.Tags,
+		RuleID:         e.ruleID,
+	}
+}
+
+func (h HeldEntry) entry() NewEntry {
+	return NewEntry{
+		Amount:         h.Amount,
+		Description:    h.Description,
+		Postings:       h.Postings,
+		LotMethod:      h.LotMethod,
+		LotIDs:         h.LotIDs,
+		PnLAccount:     h.PnLAccount,
+		TradeDate:      h.TradeDate,
+		SettlementDate: h.SettlementDate,
+		CounterpartyID: h.CounterpartyID,
+		Book:           h.Book,
+		Metadata:       h.Metadata,
+		Tags:           h.Tags,
+		ruleID:         h.RuleID,
+	}
+}
+
+// Anomaly is a suspicious entry and its review. Flagged entries are already
+// posted; held ones carry the Entry that approval will post.
+type Anomaly struct {
+	ID         int             `json:"id"`
+	LedgerID   *int            `json:"ledger_id,omitempty"`
+	Entry      *HeldEntry      `json:"entry,omitempty"`
+	Reasons    []AnomalyReason `json:"reasons"`
+	Score      float64         `json:"score"`
+	Status     string          `json:"status"`
+	CreatedBy  string          `json:"created_by"`
+	ReviewedBy string          `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time      `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// EntryHeld is returned by LedgerRepository.Create and
+// FIXRepository.RecordExecution when an entry was held for approval instead
+// of posted. The hold itself is committed.
+type EntryHeld struct {
+	AnomalyID int
+	Reasons   []AnomalyReason
+}
+
+func (e *EntryHeld) Error() string {
+	return fmt.Sprintf("entry held for approval as anomaly %d", e.AnomalyID)
+}
+
+type AnomalyRepository struct {
+	db         *sql.DB
+	validators []Validator
+}
+
+// NewAnomalyRepository returns a repository whose entries must pass the given
+// validators before they are posted
+func NewAnomalyRepository(db *sql.DB, validators ...Validator) *AnomalyRepository {
+	return &AnomalyRepository{db: db, validators: validators}
+}
+
+// Settings returns the settings in force
+func (r *AnomalyRepository) Settings(ctx context.Context) (*AnomalySettings, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return anomalySettings(ctx, tx, id.OrgID)
+}
+
+// SetSettings replaces the settings in force
+func (r *AnomalyRepository) SetSettings(ctx context.Context, s AnomalySettings, actor string) (*AnomalySettings, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO anomaly_settings (org_id, duplicate_window_seconds, outlier_factor, min_history, action, set_by)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`,
+		id.OrgID, s.DuplicateWindowSeconds, s.OutlierFactor, s.MinHistory, s.Action, actor,
+	).Scan(&createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set anomaly settings: %w", err)
+	}
+	s.SetBy, s.CreatedAt = actor, &createdAt
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &s, nil
+}
+
+// List returns anomalies with the given status (all when empty), newest first
+func (r *AnomalyRepository) List(ctx context.Context, status string) ([]Anomaly, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+anomalyColumns+` FROM anomalies
+		 WHERE org_id = $1 AND ($2 = '' OR status = $2) ORDER BY id DESC`, id.OrgID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Anomaly{}
+	for rows.Next() {
+		var a Anomaly
+		if err := scanAnomaly(rows, &a); err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// Approve clears an anomaly. A held entry is posted now, on behalf of whoever
+// submitted it; a flagged one is only marked as reviewed.
+func (r *AnomalyRepository) Approve(ctx context.Context, anomalyID int, actor string) (*Anomaly, error) {
+	return r.review(ctx, anomalyID, AnomalyApproved, actor)
+}
+
+// Reject closes an anomaly. A held entry is discarded without being posted; a
+// flagged one stays in the ledger and must be reversed separately.
+func (r *AnomalyRepository) Reject(ctx context.Context, anomalyID int, actor string) (*Anomaly, error) {
+	return r.review(ctx, anomalyID, AnomalyRejected, actor)
+}
+
+func (r *AnomalyRepository) review(ctx context.Context, anomalyID int, status, actor string) (*Anomaly, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var a Anomaly
+	row := tx.QueryRowContext(ctx,
+		`SELECT `+anomalyColumns+` FROM anomalies WHERE org_id = $1 AND id = $2 FOR UPDATE`, id.OrgID, anomalyID)
+	if err := scanAnomaly(row, &a); err == sql.ErrNoRows {
+		return nil, ErrUnknownAnomaly
+	} else if err != nil {
+		return nil, err
+	}
+	if a.Status != AnomalyFlagged && a.Status != AnomalyHeld {
+		return nil, fmt.Errorf("%w: anomaly %d is %s", ErrAnomalyReviewed, a.ID, a.Status)
+	}
+
+	if a.Status == AnomalyHeld && status == AnomalyApproved {
+		ledgerID, err := insertEntry(ctx, tx, id.OrgID, a.Entry.entry(), r.validators, a.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		a.LedgerID = &ledgerID
+	}
+	if a.LedgerID != nil {
+		action := "ANOMALY_APPROVED"
+		if status == AnomalyRejected {
+			action = "ANOMALY_REJECTED"
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+			id.OrgID, *a.LedgerID, actor, action,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit log: %w", err)
+		}
+	}
+
+	var reviewedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`UPDATE anomalies SET status = $3, ledger_id = $4, reviewed_by = $5, reviewed_at = CURRENT_TIMESTAMP
+		 WHERE org_id = $1 AND id = $2 RETURNING reviewed_at`,
+		id.OrgID, a.ID, status, a.LedgerID, actor,
+	).Scan(&reviewedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review anomaly: %w", err)
+	}
+	a.Status, a.ReviewedBy, a.ReviewedAt = status, actor, &reviewedAt
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &a, nil
+}
+
+// screenEntry scores an entry about to be posted against recent history and
+// returns what it found, with the settings that decide what to do about it.
+// Entries with the same description are serialized so two identical posts
+// racing each other cannot both miss the other.
+func screenEntry(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry) ([]AnomalyReason, *AnomalySettings, error) {
+	s, err := anomalySettings(ctx, tx, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reasons []AnomalyReason
+	if s.DuplicateWindowSeconds > 0 {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('duplicate'), hashtext($1))", e.Description); err != nil {
+			return nil, nil, fmt.Errorf("failed to lock description: %w", err)
+		}
+
+		var count int
+		var lastID sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*), MAX(ledger_id) FROM (
+			   SELECT id AS ledger_id FROM ledger
+			   WHERE org_id = $1 AND amount = $2 AND description = $3
+			     AND created_at >= CURRENT_TIMESTAMP - make_interval(secs => $4)
+			   UNION ALL
+			   SELECT NULL FROM anomalies
+			   WHERE org_id = $1 AND status = 'held' AND (entry->>'amount')::numeric = $2 AND entry->>'description' = $3
+			     AND created_at >= CURRENT_TIMESTAMP - make_interval(secs => $4)
+			 ) d`,
+			orgID, e.Amount, e.Description, s.DuplicateWindowSeconds,
+		).Scan(&count, &lastID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check for duplicates: %w", err)
+		}
+		if count > 0 {
+			reason := AnomalyReason{
+				Kind:   AnomalyDuplicate,
+				Score:  float64(count),
+				Detail: fmt.Sprintf("%d entries with the same amount and description in the last %d seconds", count, s.DuplicateWindowSeconds),
+			}
+			if lastID.Valid {
+				id := int(lastID.Int64)
+				reason.LedgerID = &id
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+
+	for _, p := range e.Postings {
+		var history int
+		var median sql.NullFloat64
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*), percentile_cont(0.5) WITHIN GROUP (ORDER BY size) FROM (
+			   SELECT ABS(amount) AS size FROM ledger_postings
+			   WHERE org_id = $1 AND account = $2 ORDER BY id DESC LIMIT 100
+			 ) h`,
+			orgID, p.Account,
+		).Scan(&history, &median)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch account history: %w", err)
+		}
+		if history < s.MinHistory || !median.Valid || median.Float64 == 0 {
+			continue
+		}
+		ratio := math.Abs(p.Amount) / median.Float64
+		if ratio < s.OutlierFactor {
+			continue
+		}
+		reasons = append(reasons, AnomalyReason{
+			Kind:    AnomalyOutlier,
+			Score:   round2(ratio / s.OutlierFactor),
+			Detail:  fmt.Sprintf("%.2f is %.0fx the median %.2f of the last %d postings", math.Abs(p.Amount), ratio, median.Float64, history),
+			Account: p.Account,
+		})
+	}
+	return reasons, s, nil
+}
+
+// recordAnomaly stores a flagged entry (ledgerID set) or a held one (held set)
+func recordAnomaly(ctx context.Context, tx *sql.Tx, orgID int, ledgerID *int, held *HeldEntry, reasons []AnomalyReason, actor string) (int, error) {
+	status := AnomalyFlagged
+	var entry interface{}
+	if held != nil {
+		status = AnomalyHeld
+		raw, err := json.Marshal(held)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode held entry: %w", err)
+		}
+		entry = string(raw)
+	}
+	rawReasons, err := json.Marshal(reasons)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode anomaly reasons: %w", err)
+	}
+	var score float64
+	for _, reason := range reasons {
+		score += reason.Score
+	}
+
+	var anomalyID int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO anomalies (org_id, ledger_id, entry, reasons, score, status, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		orgID, ledgerID, entry, string(rawReasons), round2(score), status, actor,
+	).Scan(&anomalyID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record anomaly: %w", err)
+	}
+
+	if ledgerID != nil {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+			orgID, *ledgerID, actor, "ANOMALY",
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create audit log: %w", err)
+		}
+	}
+	return anomalyID, nil
+}
+
+func anomalySettings(ctx context.Context, tx *sql.Tx, orgID int) (*AnomalySettings, error) {
+	var s AnomalySettings
+	var createdAt time.Time
+	err := tx.QueryRowContext(ctx,
+		`SELECT duplicate_window_seconds, outlier_factor, min_history, action, set_by, created_at
+		 FROM anomaly_settings WHERE org_id = $1 ORDER BY id DESC LIMIT 1`, orgID,
+	).Scan(&s.DuplicateWindowSeconds, &s.OutlierFactor, &s.MinHistory, &s.Action, &s.SetBy, &createdAt)
+	if err == sql.ErrNoRows {
+		s = DefaultAnomalySettings
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anomaly settings: %w", err)
+	}
+	s.CreatedAt = &createdAt
+	return &s, nil
+}
+
+const anomalyColumns = "id, ledger_id, entry, reasons, score, status, created_by, reviewed_by, reviewed_at, created_at"
+
+func scanAnomaly(row interface{ Scan(...interface{}) error }, a *Anomaly) error {
+	var ledgerID sql.NullInt64
+	var entry sql.NullString
+	var reasons string
+	var reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	err := row.Scan(&a.ID, &ledgerID, &entry, &reasons, &a.Score, &a.Status, &a.CreatedBy, &reviewedBy, &reviewedAt, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan anomaly: %w", err)
+	}
+	if ledgerID.Valid {
+		id := int(ledgerID.Int64)
+		a.LedgerID = &id
+	}
+	if entry.Valid {
+		a.Entry = &HeldEntry{}
+		if err := json.Unmarshal([]byte(entry.String), a.Entry); err != nil {
+			return fmt.Errorf("failed to decode held entry: %w", err)
+		}
+	}
+	if err := json.Unmarshal([]byte(reasons), &a.Reasons); err != nil {
+		return fmt.Errorf("failed to decode anomaly reasons: %w", err)
+	}
+	a.ReviewedBy = reviewedBy.String
+	if reviewedAt.Valid {
+		a.ReviewedAt = &reviewedAt.Time
+	}
+	return nil
+}
@@ -0,0 +1,478 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"ledger-go-system/internal/archive"
+)
+
+// ErrOpenPeriod is returned when archiving would reach into the current month,
+// which is still open for posting
+var ErrOpenPeriod = errors.New("only closed periods can be archived")
+
+// ErrArchivedRange is returned when a read of the live ledger would reach into
+// an archived period, whose entries it does not see
+var ErrArchivedRange = errors.New("range reaches into an archived period")
+
+// ErrUnsettledEntries is returned when a period still has entries pending or
+// failed settlement. Exposure and the settlement reports read only the live
+// ledger, so such entries must stay in it until they settle.
+var ErrUnsettledEntries = errors.New("period has unsettled entries")
+
+type ArchiveRepository struct {
+	db    *sql.DB
+	store *archive.Store
+}
+
+func NewArchiveRepository(db *sql.DB, store *archive.Store) *ArchiveRepository {
+	return &ArchiveRepository{db: db, store: store}
+}
+
+// ArchivePeriod moves every entry created before periodEnd into compressed
+// segments of at most segmentSize entries, then rewrites the manifest.
+// periodEnd cannot be later than the start of the current month in UTC, and
+// every entry of the period with a settlement date must have settled.
+func (r *ArchiveRepository) ArchivePeriod(ctx context.Context, periodEnd time.Time, segmentSize int, actor string) ([]archive.Segment, error) {
+	now := time.Now().UTC()
+	if open := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC); periodEnd.After(open) {
+		return nil, fmt.Errorf("%w: %s is after the start of the current month, %s",
+			ErrOpenPeriod, periodEnd.Format("2006-01-02"), open.Format("2006-01-02"))
+	}
+
+	var created []archive.Segment
+	for {
+		seg, err := r.archiveSegment(ctx, periodEnd, segmentSize, actor)
+		if err != nil {
+			return created, err
+		}
+		if seg == nil {
+			break
+		}
+		created = append(created, *seg)
+	}
+
+	if len(created) > 0 {
+		if err := r.WriteManifest(ctx); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// archiveSegment writes one segment and moves its entries out of the ledger in a
+// single transaction. It returns nil when nothing is left to archive.
+func (r *ArchiveRepository) archiveSegment(ctx context.Context, periodEnd time.Time, limit int, actor string) (*archive.Segment, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize archivers per organization so sequences and chain links never race
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('ledger_archive'), $1)", id.OrgID); err != nil {
+		return nil, fmt.Errorf("failed to lock archive: %w", err)
+	}
+
+	// Settled is final, so entries that pass this check never change status again
+	var unsettled int
+	var firstUnsettled sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*), MIN(l.id) FROM ledger l
+		 WHERE l.org_id = $1 AND l.created_at < $2 AND l.settlement_date IS NOT NULL
+		   AND (SELECT s.status FROM settlement_events s
+		        WHERE s.org_id = l.org_id AND s.ledger_id = l.id
+		        ORDER BY s.id DESC LIMIT 1) IS DISTINCT FROM $3`,
+		id.OrgID, periodEnd, SettlementSettled,
+	).Scan(&unsettled, &firstUnsettled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check settlement: %w", err)
+	}
+	if unsettled > 0 {
+		return nil, fmt.Errorf("%w: %d, starting with entry %d", ErrUnsettledEntries, unsettled, firstUnsettled.Int64)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+ledgerColumns+` FROM ledger l
+		 WHERE l.org_id = $1 AND l.created_at < $2 ORDER BY l.id LIMIT $3`,
+		id.OrgID, periodEnd, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch entries to archive: %w", err)
+	}
+	var entries []Ledger
+	var ids []int64
+	for rows.Next() {
+		var l Ledger
+		if err := scanLedger(rows, &l); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		entries = append(entries, l)
+		ids = append(ids, int64(l.ID))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch entries to archive: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Postings are copied into the segment but stay in ledger_postings, so
+	// balances and positions remain correct across archived periods
+	if err := attachPostings(ctx, tx, id.OrgID, entries); err != nil {
+		return nil, err
+	}
+	records := make([]archive.Record, len(entries))
+	for i, l := range entries {
+		records[i] = recordFromLedger(id.OrgID, l)
+	}
+
+	lastSequence, prevChain := 0, archive.GenesisHash
+	err = tx.QueryRowContext(ctx,
+		"SELECT sequence, chain_hash FROM ledger_archive_segments WHERE org_id = $1 ORDER BY sequence DESC LIMIT 1",
+		id.OrgID,
+	).Scan(&lastSequence, &prevChain)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch previous segment: %w", err)
+	}
+
+	seg, err := r.store.WriteSegment(id.OrgID, lastSequence+1, periodEnd, prevChain, records)
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			r.store.RemoveSegment(seg)
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO ledger_archive_segments
+		     (org_id, sequence, file_name, period_end, first_ledger_id, last_ledger_id, entry_count,
+		      sha256, content_sha256, prev_chain_hash, chain_hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at`,
+		seg.OrgID, seg.Sequence, seg.FileName, seg.PeriodEnd, seg.FirstLedgerID, seg.LastLedgerID, seg.EntryCount,
+		seg.SHA256, seg.ContentSHA256, seg.PrevChainHash, seg.ChainHash,
+	).Scan(&seg.ID, &seg.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record segment: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO ledger_archive_index (org_id, ledger_id, segment_id) SELECT $1, unnest($2::INTEGER[]), $3",
+		id.OrgID, pq.Array(ids), seg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index archived entries: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) SELECT $1, unnest($2::INTEGER[]), $3, 'ARCHIVE'",
+		id.OrgID, pq.Array(ids), actor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	// Entries are removed by a SECURITY DEFINER function that only deletes
+	// rows already present in the archive index for this segment
+	var moved int
+	if err := tx.QueryRowContext(ctx, "SELECT archive_ledger_segment($1)", seg.ID).Scan(&moved); err != nil {
+		return nil, fmt.Errorf("failed to move archived entries: %w", err)
+	}
+	if moved != len(records) {
+		return nil, fmt.Errorf("moved %d entries, expected %d", moved, len(records))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+	return seg, nil
+}
+
+// Segments lists the organization's segments in sequence order
+func (r *ArchiveRepository) Segments(ctx context.Context) ([]archive.Segment, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, org_id, sequence, file_name, period_end, first_ledger_id, last_ledger_id, entry_count,
+		        sha256, content_sha256, prev_chain_hash, chain_hash, created_at
+		 FROM ledger_archive_segments WHERE org_id = $1 ORDER BY sequence`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archive segments: %w", err)
+	}
+	defer rows.Close()
+
+	var segments []archive.Segment
+	for rows.Next() {
+		var s archive.Segment
+		if err := rows.Scan(&s.ID, &s.OrgID, &s.Sequence, &s.FileName, &s.PeriodEnd, &s.FirstLedgerID, &s.LastLedgerID,
+			&s.EntryCount, &s.SHA256, &s.ContentSHA256, &s.PrevChainHash, &s.ChainHash, &s.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		segments = append(segments, s)
+	}
+	return segments, rows.Err()
+}
+
+// WriteManifest regenerates the on-disk manifest from the segments recorded in Postgres
+func (r *ArchiveRepository) WriteManifest(ctx context.Context) error {
+	id, err := identityOf(ctx)
+	if err != nil {
+		return err
+	}
+	segments, err := r.Segments(ctx)
+	if err != nil {
+		return err
+	}
+	return r.store.WriteManifest(id.OrgID, segments)
+}
+
+// GetByID serves an archived entry from its segment file
+func (r *ArchiveRepository) GetByID(ctx context.Context, ledgerID int64) (*Ledger, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var s archive.Segment
+	err = tx.QueryRowContext(ctx,
+		`SELECT s.id, s.org_id, s.sequence, s.file_name, s.sha256, s.content_sha256
+		 FROM ledger_archive_index i JOIN ledger_archive_segments s ON s.id = i.segment_id
+		 WHERE i.org_id = $1 AND i.ledger_id = $2`, id.OrgID, ledgerID,
+	).Scan(&s.ID, &s.OrgID, &s.Sequence, &s.FileName, &s.SHA256, &s.ContentSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("ledger entry not found: %w", err)
+	}
+
+	rec, err := r.store.FindRecord(s, int(ledgerID))
+	if err != nil {
+		return nil, err
+	}
+	l := ledgerFromRecord(*rec)
+	return &l, nil
+}
+
+// ListAsOf returns the archived entries that existed at asOf, the way
+// LedgerRepository.ListAsOf does for live ones: an entry is visible once its
+// INSERT audit row exists, and carries the audit events recorded up to asOf
+func (r *ArchiveRepository) ListAsOf(ctx context.Context, asOf time.Time) ([]Ledger, error) {
+	segments, err := r.Segments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT i.ledger_id FROM ledger_archive_index i
+		 WHERE i.org_id = $1 AND EXISTS (
+		     SELECT 1 FROM audit_ledger a
+		     WHERE a.org_id = i.org_id AND a.ledger_id = i.ledger_id AND a.action = 'INSERT' AND a.timestamp <= $2)`,
+		id.OrgID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived entries: %w", err)
+	}
+	visible := map[int]bool{}
+	for rows.Next() {
+		var ledgerID int
+		if err := rows.Scan(&ledgerID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		visible[ledgerID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch archived entries: %w", err)
+	}
+
+	result := []Ledger{}
+	for _, s := range segments {
+		if len(visible) == 0 {
+			break
+		}
+		records, err := r.store.ReadSegment(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			if visible[rec.ID] {
+				result = append(result, ledgerFromRecord(rec))
+			}
+		}
+	}
+
+	if err := attachHistory(ctx, tx, id.OrgID, asOf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetByIDAsOf serves an archived entry as it stood at asOf, or an error if it did not exist yet
+func (r *ArchiveRepository) GetByIDAsOf(ctx context.Context, ledgerID int64, asOf time.Time) (*Ledger, error) {
+	l, err := r.GetByID(ctx, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var existed bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM audit_ledger
+		 WHERE org_id = $1 AND ledger_id = $2 AND action = 'INSERT' AND timestamp <= $3)`,
+		id.OrgID, ledgerID, asOf,
+	).Scan(&existed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check entry history: %w", err)
+	}
+	if !existed {
+		return nil, fmt.Errorf("ledger entry not found: %w", sql.ErrNoRows)
+	}
+
+	entries := []Ledger{*l}
+	if err := attachHistory(ctx, tx, id.OrgID, asOf, entries); err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// Each streams every archived entry, segment by segment, to fn
+func (r *ArchiveRepository) Each(ctx context.Context, fn func(Ledger) error) error {
+	segments, err := r.Segments(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		records, err := r.store.ReadSegment(s)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := fn(ledgerFromRecord(rec)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks segment files, the manifest and the hash chain, and that the
+// archive index agrees with each segment and no archived entry is still live
+func (r *ArchiveRepository) Verify(ctx context.Context) ([]archive.Segment, []error, error) {
+	id, err := identityOf(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	segments, err := r.Segments(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	problems := r.store.Verify(id.OrgID, segments)
+
+	tx, _, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, s := range segments {
+		var indexed, live int
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*), COUNT(l.id) FROM ledger_archive_index i
+			 LEFT JOIN ledger l ON l.id = i.ledger_id AND l.org_id = i.org_id
+			 WHERE i.org_id = $1 AND i.segment_id = $2`, id.OrgID, s.ID,
+		).Scan(&indexed, &live)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check archive index: %w", err)
+		}
+		if indexed != s.EntryCount {
+			problems = append(problems, fmt.Errorf("segment %d: %d indexed entries, expected %d", s.Sequence, indexed, s.EntryCount))
+		}
+		if live != 0 {
+			problems = append(problems, fmt.Errorf("segment %d: %d archived entries are still in the ledger table", s.Sequence, live))
+		}
+	}
+	return segments, problems, nil
+}
+
+func recordFromLedger(orgID int, l Ledger) archive.Record {
+	rec := archive.Record{
+		ID:             l.ID,
+		OrgID:          orgID,
+		Amount:         l.Amount,
+		Description:    l.Description,
+		CreatedAt:      l.CreatedAt,
+		TradeDate:      l.TradeDate,
+		SettlementDate: l.SettlementDate,
+		CounterpartyID: l.CounterpartyID,
+		Book:           l.Book,
+		VoucherNumber:  l.VoucherNumber,
+		Metadata:       l.Metadata,
+		Tags:           l.Tags,
+		RuleID:         l.RuleID,
+	}
+	for _, p := range l.Postings {
+		rec.Postings = append(rec.Postings, archive.Posting{Account: p.Account, Amount: p.Amount, Instrument: p.Instrument, Quantity: p.Quantity, Dimensions: p.Dimensions})
+	}
+	return rec
+}
+
+func ledgerFromRecord(rec archive.Record) Ledger {
+	l := Ledger{
+		ID:             rec.ID,
+		Amount:         rec.Amount,
+		Description:    rec.Description,
+		CreatedAt:      rec.CreatedAt,
+		TradeDate:      rec.TradeDate,
+		SettlementDate: rec.SettlementDate,
+		CounterpartyID: rec.CounterpartyID,
+		Book:           rec.Book,
+		VoucherNumber:  rec.VoucherNumber,
+		Metadata:       rec.Metadata,
+		Tags:           rec.Tags,
+		RuleID:         rec.RuleID,
+		Archived:       true,
+	}
+	for _, p := range rec.Postings {
+		l.Postings = append(l.Postings, Posting{Account: p.Account, Amount: p.Amount, Instrument: p.Instrument, Quantity: p.Quantity, Dimensions: p.Dimensions})
+	}
+	return l
+}
+
+// archivedBefore returns the end of the organization's latest archived
+// period: every archived entry was created before it. It is nil when nothing
+// has been archived.
+func archivedBefore(ctx context.Context, tx *sql.Tx, orgID int) (*time.Time, error) {
+	var end sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT MAX(period_end) FROM ledger_archive_segments WHERE org_id = $1", orgID,
+	).Scan(&end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived periods: %w", err)
+	}
+	if !end.Valid {
+		return nil, nil
+	}
+	return &end.Time, nil
+}
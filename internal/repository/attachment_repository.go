@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Attachment struct {
+	ID          int       `json:"id"`
+	LedgerID    int       `json:"ledger_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	SHA256      string    `json:"sha256"`
+	StorageKey  string    `json:"-"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AttachmentRepository struct {
+	db *sql.DB
+}
+
+func NewAttachmentRepository(db *sql.DB) *AttachmentRepository {
+	return &AttachmentRepository{db: db}
+}
+
+// Create records attachment metadata and its audit entry atomically
+func (r *AttachmentRepository) Create(ctx context.Context, a *Attachment, actor string) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO ledger_attachments (org_id, ledger_id, filename, content_type, size_bytes, sha256, storage_key, uploaded_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		id.OrgID, a.LedgerID, a.Filename, a.ContentType, a.SizeBytes, a.SHA256, a.StorageKey, actor,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+	a.UploadedBy = actor
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+		id.OrgID, a.LedgerID, actor, "ATTACH",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *AttachmentRepository) ListByLedger(ctx context.Context, ledgerID int64) ([]Attachment, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, ledger_id, filename, content_type, size_bytes, sha256, storage_key, uploaded_by, created_at
+		 FROM ledger_attachments WHERE org_id = $1 AND ledger_id = $2 ORDER BY id`, id.OrgID, ledgerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.LedgerID, &a.Filename, &a.ContentType, &a.SizeBytes,
+			&a.SHA256, &a.StorageKey, &a.UploadedBy, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+func (r *AttachmentRepository) GetByID(ctx context.Context, ledgerID, id int64) (*Attachment, error) {
+	tx, ident, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx,
+		`SELECT id, ledger_id, filename, content_type, size_bytes, sha256, storage_key, uploaded_by, created_at
+		 FROM ledger_attachments WHERE org_id = $1 AND ledger_id = $2 AND id = $3`, ident.OrgID, ledgerID, id)
+
+	var a Attachment
+	if err := row.Scan(&a.ID, &a.LedgerID, &a.Filename, &a.ContentType, &a.SizeBytes,
+		&a.SHA256, &a.StorageKey, &a.UploadedBy, &a.CreatedAt); err != nil {
+		return nil, fmt.Errorf("attachment not found: %w", err)
+	}
+	return &a, nil
+}
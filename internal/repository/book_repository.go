@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultBook numbers entries posted without a book. It exists in every
+// organization and its fiscal year is the calendar year.
+const DefaultBook = "JV"
+
+// ErrUnknownBook is returned when an entry names a book the organization does not have
+var ErrUnknownBook = errors.New("unknown book")
+
+// ErrBookExists is returned when creating a book the organization already has
+var ErrBookExists = errors.New("book already exists")
+
+// Book is a journal with its own voucher number sequence per fiscal year.
+// The fiscal year starts on the first of FiscalYearStartMonth and is named
+// after the calendar year it ends in.
+type Book struct {
+	ID                   int       `json:"id"`
+	Code                 string    `json:"code"`
+	Name                 string    `json:"name"`
+	FiscalYearStartMonth int       `json:"fiscal_year_start_month"`
+	LastNumbers          []Voucher `json:"last_numbers"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// Voucher is the last number a book issued in a fiscal year
+type Voucher struct {
+	FiscalYear int    `json:"fiscal_year"`
+	LastNumber int    `json:"last_number"`
+	Last       string `json:"last"`
+}
+
+type BookRepository struct {
+	db *sql.DB
+}
+
+func NewBookRepository(db *sql.DB) *BookRepository {
+	return &BookRepository{db: db}
+}
+
+// Create registers a book
+func (r *BookRepository) Create(ctx context.Context, b Book) (*Book, error) {
+	if b.Code == DefaultBook {
+		return nil, ErrBookExists
+	}
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO books (org_id, code, name, fiscal_year_start_month) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		id.OrgID, b.Code, b.Name, b.FiscalYearStartMonth,
+	).Scan(&b.ID, &b.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrBookExists
+		}
+		return nil, fmt.Errorf("failed to create book: %w", err)
+	}
+	b.LastNumbers = []Voucher{}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &b, nil
+}
+
+// List returns the default book and the organization's books with the last
+// number each has issued per fiscal year
+func (r *BookRepository) List(ctx context.Context) ([]Book, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, code, name, fiscal_year_start_month, created_at FROM books WHERE org_id = $1 ORDER BY code", id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch books: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Book{{Code: DefaultBook, Name: "Journal vouchers", FiscalYearStartMonth: 1, LastNumbers: []Voucher{}}}
+	index := map[string]int{DefaultBook: 0}
+	for rows.Next() {
+		b := Book{LastNumbers: []Voucher{}}
+		if err := rows.Scan(&b.ID, &b.Code, &b.Name, &b.FiscalYearStartMonth, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan book: %w", err)
+		}
+		index[b.Code] = len(result)
+		result = append(result, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		"SELECT book, fiscal_year, last_number FROM voucher_sequences WHERE org_id = $1 ORDER BY book, fiscal_year", id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch voucher sequences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var book string
+		var v Voucher
+		if err := rows.Scan(&book, &v.FiscalYear, &v.LastNumber); err != nil {
+			return nil, fmt.Errorf("failed to scan voucher sequence: %w", err)
+		}
+		i, ok := index[book]
+		if !ok {
+			continue
+		}
+		v.Last = voucherNumber(book, v.FiscalYear, v.LastNumber)
+		result[i].LastNumbers = append(result[i].LastNumbers, v)
+	}
+	return result, rows.Err()
+}
+
+// fiscalYear names the fiscal year containing date for a year starting in
+// startMonth: the calendar year the fiscal year ends in
+func fiscalYear(date time.Time, startMonth int) int {
+	if startMonth == 1 || int(date.Month()) < startMonth {
+		return date.Year()
+	}
+	return date.Year() + 1
+}
+
+func voucherNumber(book string, fiscalYear, n int) string {
+	return fmt.Sprintf("%s-%d-%06d", book, fiscalYear, n)
+}
+
+// allocateVoucher issues the next voucher number of the entry's book for the
+// fiscal year of its trade date (today without one). The sequence row is
+// incremented in the posting transaction: concurrent postings to the same
+// book and year queue on its row lock, and a rollback gives the number back,
+// so numbers are gapless.
+func allocateVoucher(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry) (string, string, error) {
+	book := e.Book
+	if book == "" {
+		book = DefaultBook
+	}
+
+	startMonth := 1
+	if book != DefaultBook {
+		err := tx.QueryRowContext(ctx,
+			"SELECT fiscal_year_start_month FROM books WHERE org_id = $1 AND code = $2", orgID, book,
+		).Scan(&startMonth)
+		if err == sql.ErrNoRows {
+			return "", "", fmt.Errorf("%w: %s", ErrUnknownBook, book)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to fetch book: %w", err)
+		}
+	}
+
+	date := time.Now().UTC()
+	if e.TradeDate != nil {
+		date = *e.TradeDate
+	}
+	year := fiscalYear(date, startMonth)
+
+	var n int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO voucher_sequences (org_id, book, fiscal_year, last_number) VALUES ($1, $2, $3, 1)
+		 ON CONFLICT (org_id, book, fiscal_year) DO UPDATE SET last_number = voucher_sequences.last_number + 1
+		 RETURNING last_number`,
+		orgID, book, year,
+	).Scan(&n)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to allocate voucher number: %w", err)
+	}
+	return book, voucherNumber(book, year, n), nil
+}
@@ -0,0 +1,420 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultBudgetThresholds are the percentages of budget that raise an alert
+// when a budget is created without its own
+var DefaultBudgetThresholds = []float64{80, 100}
+
+// budgetAlertAttempts is how many failed webhook deliveries an alert gets
+// before it is left for someone to read from the alert list instead
+const budgetAlertAttempts = 10
+
+// Budget is a budgeted amount for an account, and the accounts under it in
+// the chart, over the days From..To inclusive. Amount is signed like
+// postings: positive for a debit balance such as an expense. Dimensions
+// narrow the budget to postings tagged with those values.
+type Budget struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	Account    string            `json:"account"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Amount     float64           `json:"amount"`
+	Thresholds []float64         `json:"thresholds"`
+	CreatedBy  string            `json:"created_by"`
+	CreatedAt  time.Time         `json:"created_at"`
+
+	dims postingDimensions
+}
+
+// BudgetVariance compares a budget with the postings made against it.
+// Variance is what remains of the budget; Utilization is the share of it
+// used, in percent.
+type BudgetVariance struct {
+	Budget
+	Actual      float64 `json:"actual"`
+	Variance    float64 `json:"variance"`
+	Utilization float64 `json:"utilization"`
+}
+
+// BudgetVarianceReport is the variance of every budget started by AsOf
+type BudgetVarianceReport struct {
+	AsOf    time.Time        `json:"as_of"`
+	Budgets []BudgetVariance `json:"budgets"`
+}
+
+// BudgetAlert records the entry that took a budget's actuals across one of
+// its thresholds. DeliveredAt is set once the webhook has accepted it.
+type BudgetAlert struct {
+	ID          int        `json:"id"`
+	BudgetID    int        `json:"budget_id"`
+	Budget      string     `json:"budget"`
+	Account     string     `json:"account"`
+	Amount      float64    `json:"amount"`
+	Threshold   float64    `json:"threshold"`
+	Actual      float64    `json:"actual"`
+	LedgerID    int        `json:"ledger_id"`
+	Attempts    int        `json:"attempts"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type BudgetRepository struct {
+	db *sql.DB
+}
+
+func NewBudgetRepository(db *sql.DB) *BudgetRepository {
+	return &BudgetRepository{db: db}
+}
+
+// Create records a budget, resolving its dimension values
+func (r *BudgetRepository) Create(ctx context.Context, b Budget) (*Budget, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b.dims = postingDimensions{}
+	for dimension, value := range b.Dimensions {
+		dimensionID, valueID, err := dimensionValueID(ctx, tx, id.OrgID, dimension, value)
+		if err != nil {
+			return nil, err
+		}
+		b.dims[dimensionID] = valueID
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO budgets (org_id, name, account, period_start, period_end, amount, thresholds, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		id.OrgID, b.Name, b.Account, b.From, b.To, b.Amount, pq.Array(b.Thresholds), b.CreatedBy,
+	).Scan(&b.ID, &b.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create budget: %w", err)
+	}
+
+	for dimensionID, valueID := range b.dims {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO budget_dimensions (org_id, budget_id, dimension_id, value_id) VALUES ($1, $2, $3, $4)",
+			id.OrgID, b.ID, dimensionID, valueID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record budget dimension: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &b, nil
+}
+
+// List returns every budget, latest period first
+func (r *BudgetRepository) List(ctx context.Context) ([]Budget, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return loadBudgets(ctx, tx, id.OrgID, "TRUE")
+}
+
+// Variance reports actuals against every budget whose period had started by
+// asOf (nil for now), counting postings made up to asOf
+func (r *BudgetRepository) Variance(ctx context.Context, asOf *time.Time) (*BudgetVarianceReport, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	through := time.Now().UTC()
+	if asOf != nil {
+		through = *asOf
+	}
+
+	budgets, err := loadBudgets(ctx, tx, id.OrgID, "period_start <= $2::date", through)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadAccountTree(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BudgetVarianceReport{AsOf: through, Budgets: []BudgetVariance{}}
+	for _, b := range budgets {
+		actual, err := budgetActual(ctx, tx, id.OrgID, tree, b, through)
+		if err != nil {
+			return nil, err
+		}
+		result.Budgets = append(result.Budgets, BudgetVariance{
+			Budget:      b,
+			Actual:      actual,
+			Variance:    round2(b.Amount - actual),
+			Utilization: round2(actual / b.Amount * 100),
+		})
+	}
+	return result, nil
+}
+
+// Alerts lists raised alerts, newest first
+func (r *BudgetRepository) Alerts(ctx context.Context) ([]BudgetAlert, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return budgetAlerts(ctx, tx, id.OrgID, "TRUE ORDER BY a.created_at DESC, a.id DESC")
+}
+
+// DeliverAlerts hands each undelivered alert to send, marking the ones it
+// accepts as delivered and counting an attempt against the others. Alerts
+// being delivered by another worker are skipped.
+func (r *BudgetRepository) DeliverAlerts(ctx context.Context, send func(context.Context, interface{}) error) (int, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	alerts, err := budgetAlerts(ctx, tx, id.OrgID,
+		fmt.Sprintf("a.delivered_at IS NULL AND a.attempts < %d ORDER BY a.id LIMIT 50 FOR UPDATE OF a SKIP LOCKED", budgetAlertAttempts))
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, alert := range alerts {
+		update := "UPDATE budget_alerts SET attempts = attempts + 1 WHERE org_id = $1 AND id = $2"
+		if err := send(ctx, alert); err == nil {
+			update = "UPDATE budget_alerts SET attempts = attempts + 1, delivered_at = CURRENT_TIMESTAMP WHERE org_id = $1 AND id = $2"
+			delivered++
+		}
+		if _, err := tx.ExecContext(ctx, update, id.OrgID, alert.ID); err != nil {
+			return 0, fmt.Errorf("failed to update budget alert: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return delivered, nil
+}
+
+// checkBudgets runs in the posting path once an entry's postings are written.
+// Every budget running today that one of the postings counts against is
+// re-totalled, and each threshold the actuals have now reached raises an
+// alert, once per budget and threshold, with an audit row on the entry.
+func checkBudgets(ctx context.Context, tx *sql.Tx, orgID, ledgerID int, postings []Posting, dims []postingDimensions, actor string) error {
+	budgets, err := loadBudgets(ctx, tx, orgID, "period_start <= CURRENT_DATE AND period_end >= CURRENT_DATE")
+	if err != nil || len(budgets) == 0 {
+		return err
+	}
+	tree, err := loadAccountTree(ctx, tx, orgID)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range budgets {
+		if !budgetTouched(tree, b, postings, dims) {
+			continue
+		}
+
+		// Serialize postings against one budget so two entries crossing a
+		// threshold together cannot both miss it
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('budget'), $1)", b.ID); err != nil {
+			return fmt.Errorf("failed to lock budget: %w", err)
+		}
+		actual, err := budgetActual(ctx, tx, orgID, tree, b, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		utilization := actual / b.Amount * 100
+
+		for _, threshold := range b.Thresholds {
+			if utilization < threshold {
+				continue
+			}
+			result, err := tx.ExecContext(ctx,
+				`INSERT INTO budget_alerts (org_id, budget_id, threshold, actual, ledger_id) VALUES ($1, $2, $3, $4, $5)
+				 ON CONFLICT (org_id, budget_id, threshold) DO NOTHING`,
+				orgID, b.ID, threshold, actual, ledgerID,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to record budget alert: %w", err)
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+				orgID, ledgerID, actor, "BUDGET_ALERT",
+			)
+			if err != nil {
+				return fmt.Errorf("failed to create audit log: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// budgetTouched reports whether any of an entry's postings counts against b
+func budgetTouched(tree accountTree, b Budget, postings []Posting, dims []postingDimensions) bool {
+	for i, p := range postings {
+		if !tree.within(p.Account, b.Account) {
+			continue
+		}
+		matched := true
+		for dimensionID, valueID := range b.dims {
+			matched = matched && dims[i][dimensionID] == valueID
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// budgetActual totals the postings counted against b that were made during
+// its period and before through
+func budgetActual(ctx context.Context, tx *sql.Tx, orgID int, tree accountTree, b Budget, through time.Time) (float64, error) {
+	end := b.To.AddDate(0, 0, 1)
+	if through.Before(end) {
+		end = through
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT p.account, SUM(p.amount) FROM ledger_postings p
+		 WHERE p.org_id = $1 AND p.created_at >= $2 AND p.created_at < $3
+		   AND NOT EXISTS (
+		     SELECT 1 FROM budget_dimensions bd
+		     WHERE bd.org_id = p.org_id AND bd.budget_id = $4
+		       AND NOT EXISTS (
+		         SELECT 1 FROM posting_dimensions pd
+		         WHERE pd.org_id = p.org_id AND pd.posting_id = p.id
+		           AND pd.dimension_id = bd.dimension_id AND pd.value_id = bd.value_id))
+		 GROUP BY p.account`,
+		orgID, b.From, end, b.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute budget actual: %w", err)
+	}
+	defer rows.Close()
+
+	var actual float64
+	for rows.Next() {
+		var account string
+		var amount float64
+		if err := rows.Scan(&account, &amount); err != nil {
+			return 0, fmt.Errorf("failed to scan budget actual: %w", err)
+		}
+		if tree.within(account, b.Account) {
+			actual += amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to compute budget actual: %w", err)
+	}
+	return round2(actual), nil
+}
+
+// loadBudgets fetches the budgets matching where, which may use $2 onwards
+func loadBudgets(ctx context.Context, tx *sql.Tx, orgID int, where string, args ...interface{}) ([]Budget, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name, account, period_start, period_end, amount, thresholds, created_by, created_at
+		 FROM budgets WHERE org_id = $1 AND `+where+` ORDER BY period_start DESC, id`,
+		append([]interface{}{orgID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch budgets: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Budget{}
+	index := map[int]int{}
+	for rows.Next() {
+		var b Budget
+		var thresholds pq.Float64Array
+		if err := rows.Scan(&b.ID, &b.Name, &b.Account, &b.From, &b.To, &b.Amount, &thresholds, &b.CreatedBy, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan budget: %w", err)
+		}
+		b.Thresholds = []float64(thresholds)
+		b.dims = postingDimensions{}
+		index[b.ID] = len(result)
+		result = append(result, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch budgets: %w", err)
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int64, 0, len(result))
+	for _, b := range result {
+		ids = append(ids, int64(b.ID))
+	}
+	dimRows, err := tx.QueryContext(ctx,
+		`SELECT bd.budget_id, bd.dimension_id, bd.value_id, d.code, v.value
+		 FROM budget_dimensions bd
+		 JOIN dimensions d ON d.org_id = bd.org_id AND d.id = bd.dimension_id
+		 JOIN dimension_values v ON v.org_id = bd.org_id AND v.id = bd.value_id
+		 WHERE bd.org_id = $1 AND bd.budget_id = ANY($2)`, orgID, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch budget dimensions: %w", err)
+	}
+	defer dimRows.Close()
+
+	for dimRows.Next() {
+		var budgetID, dimensionID, valueID int
+		var code, value string
+		if err := dimRows.Scan(&budgetID, &dimensionID, &valueID, &code, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan budget dimension: %w", err)
+		}
+		b := &result[index[budgetID]]
+		if b.Dimensions == nil {
+			b.Dimensions = map[string]string{}
+		}
+		b.Dimensions[code] = value
+		b.dims[dimensionID] = valueID
+	}
+	return result, dimRows.Err()
+}
+
+// budgetAlerts fetches the alerts matching where, which may add ordering and locking
+func budgetAlerts(ctx context.Context, tx *sql.Tx, orgID int, where string) ([]BudgetAlert, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT a.id, a.budget_id, b.name, b.account, b.amount, a.threshold, a.actual, a.ledger_id,
+		        a.attempts, a.delivered_at, a.created_at
+		 FROM budget_alerts a JOIN budgets b ON b.org_id = a.org_id AND b.id = a.budget_id
+		 WHERE a.org_id = $1 AND `+where, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch budget alerts: %w", err)
+	}
+	defer rows.Close()
+
+	result := []BudgetAlert{}
+	for rows.Next() {
+		var a BudgetAlert
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.BudgetID, &a.Budget, &a.Account, &a.Amount, &a.Threshold, &a.Actual, &a.LedgerID,
+			&a.Attempts, &deliveredAt, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan budget alert: %w", err)
+		}
+		if deliveredAt.Valid {
+			a.DeliveredAt = &deliveredAt.Time
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNoCategorizationRule is returned when removing a rule that does not exist
+var ErrNoCategorizationRule = errors.New("categorization rule not found")
+
+// CategorizationRule matches entries as they are created and fills in what
+// they arrived without. Every condition set must hold: DescriptionPattern is
+// a regular expression, MinAmount and MaxAmount bound the entry amount
+// inclusively, and Metadata values must equal the entry's. The first matching
+// rule by Priority, then ID, assigns Account to the postings that have no
+// account, adds Dimensions to every posting that lacks them and adds Tags to
+// the entry.
+type CategorizationRule struct {
+	ID                 int               `json:"id"`
+	Name               string            `json:"name"`
+	Priority           int               `json:"priority"`
+	DescriptionPattern string            `json:"description_pattern,omitempty"`
+	MinAmount          *float64          `json:"min_amount,omitempty"`
+	MaxAmount          *float64          `json:"max_amount,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Account            string            `json:"account,omitempty"`
+	Tags               []string          `json:"tags,omitempty"`
+	Dimensions         map[string]string `json:"dimensions,omitempty"`
+	CreatedBy          string            `json:"created_by"`
+	CreatedAt          time.Time         `json:"created_at"`
+
+	pattern *regexp.Regexp
+}
+
+// CategorizationResult is what the rules would do to an entry. Rule is nil
+// when none matches.
+type CategorizationResult struct {
+	Rule     *CategorizationRule `json:"rule"`
+	Tags     []string            `json:"tags"`
+	Postings []Posting           `json:"postings"`
+}
+
+type CategorizationRepository struct {
+	db *sql.DB
+}
+
+func NewCategorizationRepository(db *sql.DB) *CategorizationRepository {
+	return &CategorizationRepository{db: db}
+}
+
+// Create records a rule. Its dimension values must already be configured.
+func (r *CategorizationRepository) Create(ctx context.Context, rule CategorizationRule) (*CategorizationRule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for dimension, value := range rule.Dimensions {
+		if _, _, err := dimensionValueID(ctx, tx, id.OrgID, dimension, value); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata, err := jsonOrNull(rule.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	dimensions, err := jsonOrNull(rule.Dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO categorization_rules
+		   (org_id, name, priority, description_pattern, min_amount, max_amount, metadata, account, tags, dimensions, created_by)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, NULLIF($8, ''), $9, $10, $11) RETURNING id, created_at`,
+		id.OrgID, rule.Name, rule.Priority, rule.DescriptionPattern, rule.MinAmount, rule.MaxAmount,
+		metadata, rule.Account, pq.Array(rule.Tags), dimensions, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create categorization rule: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &rule, nil
+}
+
+// List returns the rules in the order they are evaluated
+func (r *CategorizationRepository) List(ctx context.Context) ([]CategorizationRule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return categorizationRules(ctx, tx, id.OrgID)
+}
+
+// Delete removes a rule
+func (r *CategorizationRepository) Delete(ctx context.Context, ruleID int) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM categorization_rules WHERE org_id = $1 AND id = $2", id.OrgID, ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete categorization rule: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoCategorizationRule
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Test shows which rule would fire for an entry and what it would change,
+// without posting anything
+func (r *CategorizationRepository) Test(ctx context.Context, e NewEntry) (*CategorizationResult, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	categorized, rule, err := categorize(ctx, tx, id.OrgID, e)
+	if err != nil {
+		return nil, err
+	}
+	result := &CategorizationResult{Rule: rule, Tags: categorized.Tags, Postings: categorized.Postings}
+	if result.Tags == nil {
+		result.Tags = []string{}
+	}
+	if result.Postings == nil {
+		result.Postings = []Posting{}
+	}
+	return result, nil
+}
+
+// categorize applies the first rule matching e, returning the categorized
+// entry and the rule (nil if none matched). The caller's postings are not modified.
+func categorize(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry) (NewEntry, *CategorizationRule, error) {
+	rules, err := categorizationRules(ctx, tx, orgID)
+	if err != nil {
+		return e, nil, err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(e) {
+			continue
+		}
+
+		e.ruleID = &rule.ID
+		e.Tags = mergeTags(e.Tags, rule.Tags)
+		// The account fills postings that have none; the dimensions apply to
+		// every posting, without replacing a value the posting already has
+		if rule.Account != "" || len(rule.Dimensions) > 0 {
+			postings := make([]Posting, len(e.Postings))
+			for j, p := range e.Postings {
+				if p.Account == "" {
+					p.Account = rule.Account
+				}
+				if len(rule.Dimensions) > 0 {
+					dims := map[string]string{}
+					for code, value := range rule.Dimensions {
+						dims[code] = value
+					}
+					for code, value := range p.Dimensions {
+						dims[code] = value
+					}
+					p.Dimensions = dims
+				}
+				postings[j] = p
+			}
+			e.Postings = postings
+		}
+		return e, rule, nil
+	}
+	return e, nil, nil
+}
+
+func (rule *CategorizationRule) matches(e NewEntry) bool {
+	if rule.pattern != nil && !rule.pattern.MatchString(e.Description) {
+		return false
+	}
+	if rule.MinAmount != nil && e.Amount < *rule.MinAmount {
+		return false
+	}
+	if rule.MaxAmount != nil && e.Amount > *rule.MaxAmount {
+		return false
+	}
+	for key, value := range rule.Metadata {
+		if v, ok := e.Metadata[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeTags appends the tags not already present, keeping their order
+func mergeTags(tags, more []string) []string {
+	seen := map[string]bool{}
+	for _, t := range tags {
+		seen[t] = true
+	}
+	for _, t := range more {
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func categorizationRules(ctx context.Context, tx *sql.Tx, orgID int) ([]CategorizationRule, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name, priority, COALESCE(description_pattern, ''), min_amount, max_amount, metadata,
+		        COALESCE(account, ''), tags, dimensions, created_by, created_at
+		 FROM categorization_rules WHERE org_id = $1 ORDER BY priority, id`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch categorization rules: %w", err)
+	}
+	defer rows.Close()
+
+	result := []CategorizationRule{}
+	for rows.Next() {
+		var rule CategorizationRule
+		var minAmount, maxAmount sql.NullFloat64
+		var metadata, dimensions sql.NullString
+		var tags pq.StringArray
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Priority, &rule.DescriptionPattern, &minAmount, &maxAmount, &metadata,
+			&rule.Account, &tags, &dimensions, &rule.CreatedBy, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan categorization rule: %w", err)
+		}
+		if minAmount.Valid {
+			rule.MinAmount = &minAmount.Float64
+		}
+		if maxAmount.Valid {
+			rule.MaxAmount = &maxAmount.Float64
+		}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &rule.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode rule metadata: %w", err)
+			}
+		}
+		if dimensions.Valid {
+			if err := json.Unmarshal([]byte(dimensions.String), &rule.Dimensions); err != nil {
+				return nil, fmt.Errorf("failed to decode rule dimensions: %w", err)
+			}
+		}
+		rule.Tags = []string(tags)
+		if rule.DescriptionPattern != "" {
+			if rule.pattern, err = regexp.Compile(rule.DescriptionPattern); err != nil {
+				return nil, fmt.Errorf("categorization rule %d has an invalid pattern: %w", rule.ID, err)
+			}
+		}
+		result = append(result, rule)
+	}
+	return result, rows.Err()
+}
+
+// jsonOrNull encodes a map for a JSONB column, NULL when it is empty
+func jsonOrNull(m map[string]string) (interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode json: %w", err)
+	}
+	return string(raw), nil
+}
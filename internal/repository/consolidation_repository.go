@@ -0,0 +1,658 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"ledger-go-system/internal/identity"
+)
+
+// Rate types: closing rates translate balance sheet accounts, average rates income and expenses
+const (
+	RateClosing = "closing"
+	RateAverage = "average"
+)
+
+// ErrGroupExists is returned when creating a group name the organization already has
+var ErrGroupExists = errors.New("consolidation group already exists")
+
+// ErrNoGroup is returned when a group does not exist in the organization
+var ErrNoGroup = errors.New("consolidation group not found")
+
+// ErrDuplicateMember is returned when adding an entity a group already has
+var ErrDuplicateMember = errors.New("entity is already a member of the group")
+
+// ErrIntercompanyTagged is returned when tagging an account that is already tagged
+var ErrIntercompanyTagged = errors.New("account is already tagged intercompany")
+
+// ErrUnknownOrganization is returned when naming an organization that does not exist
+var ErrUnknownOrganization = errors.New("unknown organization")
+
+// ErrNotMember is returned when consolidating an entity the caller does not belong to
+var ErrNotMember = errors.New("not a member of every entity in the group")
+
+// ErrMissingRate is returned when an entity cannot be translated for lack of a rate
+var ErrMissingRate = errors.New("missing fx rate")
+
+// TrialBalanceLine is the balance of one account; debits are positive
+type TrialBalanceLine struct {
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+	Balance float64 `json:"balance"`
+}
+
+// TrialBalance is every account's balance as of an instant
+type TrialBalance struct {
+	AsOf   time.Time          `json:"as_of"`
+	Lines  []TrialBalanceLine `json:"lines"`
+	Debit  float64            `json:"debit"`
+	Credit float64            `json:"credit"`
+}
+
+// ConsolidationGroup is a set of entities reported together in Currency
+type ConsolidationGroup struct {
+	ID                int                   `json:"id"`
+	Name              string                `json:"name"`
+	Currency          string                `json:"currency"`
+	CTAAccount        string                `json:"cta_account"`
+	DifferenceAccount string                `json:"difference_account"`
+	Members           []ConsolidationMember `json:"members"`
+	CreatedAt         time.Time             `json:"created_at"`
+}
+
+// ConsolidationMember is an entity of a group and its functional currency
+type ConsolidationMember struct {
+	OrgID    int    `json:"org_id"`
+	Currency string `json:"currency"`
+}
+
+// IntercompanyAccount tags an account as a balance with another entity
+type IntercompanyAccount struct {
+	Account           string    `json:"account"`
+	CounterpartyOrgID int       `json:"counterparty_org_id"`
+	CreatedBy         string    `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// FXRate converts one unit of From into Rate units of To for the period ending Date
+type FXRate struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Date time.Time `json:"date"`
+	Type string    `json:"type"`
+	Rate float64   `json:"rate"`
+}
+
+// EntityBalance is one entity's trial balance in its own and the group's currency
+type EntityBalance struct {
+	OrgID       int                `json:"org_id"`
+	Currency    string             `json:"currency"`
+	ClosingRate float64            `json:"closing_rate"`
+	AverageRate float64            `json:"average_rate"`
+	Lines       []TrialBalanceLine `json:"lines"`
+	Translated  map[string]float64 `json:"translated"`
+	CTA         float64            `json:"cta"`
+}
+
+// Elimination is the intercompany position between two entities of a group.
+// Balances within tolerance of each other are eliminated; others are left in
+// the consolidated balances and reported unmatched.
+type Elimination struct {
+	OrgID          int     `json:"org_id"`
+	CounterOrgID   int     `json:"counter_org_id"`
+	Balance        float64 `json:"balance"`
+	CounterBalance float64 `json:"counter_balance"`
+	Difference     float64 `json:"difference"`
+	Eliminated     bool    `json:"eliminated"`
+}
+
+// ConsolidatedLine is one account across the group after eliminations
+type ConsolidatedLine struct {
+	Account    string          `json:"account"`
+	Entities   map[int]float64 `json:"entities"`
+	Eliminated float64         `json:"eliminated"`
+	Balance    float64         `json:"balance"`
+}
+
+// ConsolidatedTrialBalance is the group's trial balance in its currency
+type ConsolidatedTrialBalance struct {
+	GroupID      int                `json:"group_id"`
+	Currency     string             `json:"currency"`
+	AsOf         time.Time          `json:"as_of"`
+	Entities     []EntityBalance    `json:"entities"`
+	Eliminations []Elimination      `json:"eliminations"`
+	Lines        []ConsolidatedLine `json:"lines"`
+	Debit        float64            `json:"debit"`
+	Credit       float64            `json:"credit"`
+}
+
+type ConsolidationRepository struct {
+	db *sql.DB
+}
+
+func NewConsolidationRepository(db *sql.DB) *ConsolidationRepository {
+	return &ConsolidationRepository{db: db}
+}
+
+// TrialBalance returns the organization's account balances as of asOf,
+// rolled up to level of the chart of accounts when level is positive
+func (r *ConsolidationRepository) TrialBalance(ctx context.Context, asOf time.Time, level int) (*TrialBalance, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lines, err := trialBalance(ctx, tx, id.OrgID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if level > 0 {
+		tree, err := loadAccountTree(ctx, tx, id.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		lines = rollUpTrialBalance(tree, lines, level)
+	}
+	tb := &TrialBalance{AsOf: asOf, Lines: lines}
+	for _, l := range lines {
+		tb.Debit += l.Debit
+		tb.Credit += l.Credit
+	}
+	tb.Debit = round2(tb.Debit)
+	tb.Credit = round2(tb.Credit)
+	return tb, nil
+}
+
+// CreateGroup creates a consolidation group owned by the organization
+func (r *ConsolidationRepository) CreateGroup(ctx context.Context, g ConsolidationGroup) (*ConsolidationGroup, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO consolidation_groups (org_id, name, currency, cta_account, difference_account)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		id.OrgID, g.Name, g.Currency, g.CTAAccount, g.DifferenceAccount,
+	).Scan(&g.ID, &g.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrGroupExists
+		}
+		return nil, fmt.Errorf("failed to create consolidation group: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	g.Members = []ConsolidationMember{}
+	return &g, nil
+}
+
+// Groups lists the organization's consolidation groups with their members
+func (r *ConsolidationRepository) Groups(ctx context.Context) ([]ConsolidationGroup, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name, currency, cta_account, difference_account, created_at
+		 FROM consolidation_groups WHERE org_id = $1 ORDER BY name`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch consolidation groups: %w", err)
+	}
+	result := []ConsolidationGroup{}
+	for rows.Next() {
+		var g ConsolidationGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Currency, &g.CTAAccount, &g.DifferenceAccount, &g.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan consolidation group: %w", err)
+		}
+		result = append(result, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch consolidation groups: %w", err)
+	}
+
+	for i := range result {
+		if result[i].Members, err = groupMembers(ctx, tx, id.OrgID, result[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// AddMember adds an entity to a group with the currency its books are kept in
+func (r *ConsolidationRepository) AddMember(ctx context.Context, groupID int, m ConsolidationMember) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := consolidationGroup(ctx, tx, id.OrgID, groupID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO consolidation_members (org_id, group_id, member_org_id, currency) VALUES ($1, $2, $3, $4)`,
+		id.OrgID, groupID, m.OrgID, m.Currency,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateMember
+		}
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return fmt.Errorf("%w: %d", ErrUnknownOrganization, m.OrgID)
+		}
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// TagIntercompany tags one of the organization's accounts as a balance with another entity
+func (r *ConsolidationRepository) TagIntercompany(ctx context.Context, a IntercompanyAccount) (*IntercompanyAccount, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO intercompany_accounts (org_id, account, counterparty_org_id, created_by)
+		 VALUES ($1, $2, $3, $4) RETURNING created_at`,
+		id.OrgID, a.Account, a.CounterpartyOrgID, a.CreatedBy,
+	).Scan(&a.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrIntercompanyTagged
+		}
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownOrganization, a.CounterpartyOrgID)
+		}
+		return nil, fmt.Errorf("failed to tag intercompany account: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &a, nil
+}
+
+// IntercompanyAccounts lists the organization's intercompany tags
+func (r *ConsolidationRepository) IntercompanyAccounts(ctx context.Context) ([]IntercompanyAccount, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return intercompanyAccounts(ctx, tx, id.OrgID)
+}
+
+// AddRates stores fx rates append-only; a later row for the same pair, type and date supersedes
+func (r *ConsolidationRepository) AddRates(ctx context.Context, rates []FXRate, actor string) (int, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO fx_rates (org_id, from_currency, to_currency, rate_date, rate_type, rate, created_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			id.OrgID, rate.From, rate.To, rate.Date, rate.Type, rate.Rate, actor,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store fx rate: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(rates), nil
+}
+
+// Rates returns the latest rate of each pair and type on or before date
+func (r *ConsolidationRepository) Rates(ctx context.Context, date time.Time) ([]FXRate, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT DISTINCT ON (from_currency, to_currency, rate_type) from_currency, to_currency, rate_date, rate_type, rate
+		 FROM fx_rates WHERE org_id = $1 AND rate_date <= $2
+		 ORDER BY from_currency, to_currency, rate_type, rate_date DESC, id DESC`, id.OrgID, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch fx rates: %w", err)
+	}
+	defer rows.Close()
+
+	result := []FXRate{}
+	for rows.Next() {
+		var rate FXRate
+		if err := rows.Scan(&rate.From, &rate.To, &rate.Date, &rate.Type, &rate.Rate); err != nil {
+			return nil, fmt.Errorf("failed to scan fx rate: %w", err)
+		}
+		result = append(result, rate)
+	}
+	return result, rows.Err()
+}
+
+// Consolidate builds the group's trial balance as of asOf. Each entity is read
+// in its own scoped transaction, so the caller must belong to every entity;
+// entities in another currency are translated at the period's rates with the
+// translation difference posted to the group's CTA account, and matching
+// intercompany balances between entities are eliminated. A positive level
+// rolls the lines up along the owning organization's chart of accounts.
+func (r *ConsolidationRepository) Consolidate(ctx context.Context, groupID int, asOf time.Time, tolerance float64, level int) (*ConsolidatedTrialBalance, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	g, err := consolidationGroup(ctx, tx, id.OrgID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if g.Members, err = groupMembers(ctx, tx, id.OrgID, groupID); err != nil {
+		return nil, err
+	}
+
+	date := time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	inGroup := map[int]bool{}
+	for _, m := range g.Members {
+		var member bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM user_organizations WHERE user_id = $1 AND org_id = $2)", id.UserID, m.OrgID,
+		).Scan(&member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check organization membership: %w", err)
+		}
+		if !member {
+			return nil, fmt.Errorf("%w: organization %d", ErrNotMember, m.OrgID)
+		}
+		inGroup[m.OrgID] = true
+	}
+
+	result := &ConsolidatedTrialBalance{GroupID: g.ID, Currency: g.Currency, AsOf: asOf, Entities: []EntityBalance{}, Eliminations: []Elimination{}}
+
+	// intercompany[org][counterparty] holds the translated balance per account
+	intercompany := map[int]map[int]map[string]float64{}
+	for _, m := range g.Members {
+		e := EntityBalance{OrgID: m.OrgID, Currency: m.Currency, ClosingRate: 1, AverageRate: 1, Translated: map[string]float64{}}
+		if m.Currency != g.Currency {
+			if e.ClosingRate, err = fxRate(ctx, tx, id.OrgID, m.Currency, g.Currency, RateClosing, date); err != nil {
+				return nil, err
+			}
+			if e.AverageRate, err = fxRate(ctx, tx, id.OrgID, m.Currency, g.Currency, RateAverage, date); err != nil {
+				return nil, err
+			}
+		}
+
+		memberCtx := identity.NewContext(ctx, identity.Identity{UserID: id.UserID, Role: id.Role, OrgID: m.OrgID})
+		mtx, _, err := beginScopedTx(memberCtx, r.db)
+		if err != nil {
+			return nil, err
+		}
+		lines, err := trialBalance(memberCtx, mtx, m.OrgID, asOf)
+		if err != nil {
+			mtx.Rollback()
+			return nil, err
+		}
+		tags, err := intercompanyAccounts(memberCtx, mtx, m.OrgID)
+		mtx.Rollback()
+		if err != nil {
+			return nil, err
+		}
+		e.Lines = lines
+
+		var total float64
+		for _, l := range lines {
+			rate := e.ClosingRate
+			if incomeStatementAccount(l.Account) {
+				rate = e.AverageRate
+			}
+			t := round2(l.Balance * rate)
+			e.Translated[l.Account] = t
+			total += t
+		}
+		// Balance sheet and income statement at different rates no longer
+		// balance; the difference is the cumulative translation adjustment
+		if cta := round2(-total); cta != 0 {
+			e.CTA = cta
+			e.Translated[g.CTAAccount] = round2(e.Translated[g.CTAAccount] + cta)
+		}
+
+		for _, tag := range tags {
+			if !inGroup[tag.CounterpartyOrgID] || tag.CounterpartyOrgID == m.OrgID {
+				continue
+			}
+			if intercompany[m.OrgID] == nil {
+				intercompany[m.OrgID] = map[int]map[string]float64{}
+			}
+			if intercompany[m.OrgID][tag.CounterpartyOrgID] == nil {
+				intercompany[m.OrgID][tag.CounterpartyOrgID] = map[string]float64{}
+			}
+			intercompany[m.OrgID][tag.CounterpartyOrgID][tag.Account] = e.Translated[tag.Account]
+		}
+		result.Entities = append(result.Entities, e)
+	}
+
+	tree := accountTree{}
+	if level > 0 {
+		if tree, err = loadAccountTree(ctx, tx, id.OrgID); err != nil {
+			return nil, err
+		}
+	}
+	byAccount := map[string]*ConsolidatedLine{}
+	line := func(account string) *ConsolidatedLine {
+		account = tree.at(account, level)
+		l, ok := byAccount[account]
+		if !ok {
+			l = &ConsolidatedLine{Account: account, Entities: map[int]float64{}}
+			byAccount[account] = l
+		}
+		return l
+	}
+	for _, e := range result.Entities {
+		for account, amount := range e.Translated {
+			line(account).Entities[e.OrgID] += amount
+		}
+	}
+
+	for i, a := range g.Members {
+		for _, b := range g.Members[i+1:] {
+			ab, ba := intercompany[a.OrgID][b.OrgID], intercompany[b.OrgID][a.OrgID]
+			if len(ab) == 0 && len(ba) == 0 {
+				continue
+			}
+			el := Elimination{OrgID: a.OrgID, CounterOrgID: b.OrgID}
+			for _, v := range ab {
+				el.Balance += v
+			}
+			for _, v := range ba {
+				el.CounterBalance += v
+			}
+			el.Balance = round2(el.Balance)
+			el.CounterBalance = round2(el.CounterBalance)
+			el.Difference = round2(el.Balance + el.CounterBalance)
+			el.Eliminated = math.Abs(el.Difference) <= tolerance+0.000001
+			if el.Eliminated {
+				for account, v := range ab {
+					line(account).Eliminated -= v
+				}
+				for account, v := range ba {
+					line(account).Eliminated -= v
+				}
+				if el.Difference != 0 {
+					line(g.DifferenceAccount).Eliminated += el.Difference
+				}
+			}
+			result.Eliminations = append(result.Eliminations, el)
+		}
+	}
+
+	for _, l := range byAccount {
+		var total float64
+		for org, v := range l.Entities {
+			l.Entities[org] = round2(v)
+			total += v
+		}
+		l.Eliminated = round2(l.Eliminated)
+		l.Balance = round2(total + l.Eliminated)
+		result.Lines = append(result.Lines, *l)
+		if l.Balance > 0 {
+			result.Debit += l.Balance
+		} else {
+			result.Credit -= l.Balance
+		}
+	}
+	sort.Slice(result.Lines, func(i, j int) bool { return result.Lines[i].Account < result.Lines[j].Account })
+	result.Debit = round2(result.Debit)
+	result.Credit = round2(result.Credit)
+	return result, nil
+}
+
+// incomeStatementAccount reports whether an account is translated at the
+// average rate, judged by its top-level name
+func incomeStatementAccount(account string) bool {
+	top, _, _ := strings.Cut(account, ":")
+	switch strings.ToLower(top) {
+	case "income", "revenue", "revenues", "expense", "expenses":
+		return true
+	}
+	return false
+}
+
+// fxRate finds the latest rate on or before date, using the inverse pair when only that is loaded
+func fxRate(ctx context.Context, tx *sql.Tx, orgID int, from, to, rateType string, date time.Time) (float64, error) {
+	var rate, inverse sql.NullFloat64
+	err := tx.QueryRowContext(ctx,
+		`SELECT
+		    (SELECT rate FROM fx_rates WHERE org_id = $1 AND from_currency = $2 AND to_currency = $3
+		        AND rate_type = $4 AND rate_date <= $5 ORDER BY rate_date DESC, id DESC LIMIT 1),
+		    (SELECT rate FROM fx_rates WHERE org_id = $1 AND from_currency = $3 AND to_currency = $2
+		        AND rate_type = $4 AND rate_date <= $5 ORDER BY rate_date DESC, id DESC LIMIT 1)`,
+		orgID, from, to, rateType, date,
+	).Scan(&rate, &inverse)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch fx rate: %w", err)
+	}
+	if rate.Valid {
+		return rate.Float64, nil
+	}
+	if inverse.Valid {
+		return 1 / inverse.Float64, nil
+	}
+	return 0, fmt.Errorf("%w: %s %s/%s on %s", ErrMissingRate, rateType, from, to, date.Format("2006-01-02"))
+}
+
+// trialBalance sums the organization's postings per account up to asOf
+func trialBalance(ctx context.Context, tx *sql.Tx, orgID int, asOf time.Time) ([]TrialBalanceLine, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT account, SUM(amount) FROM ledger_postings
+		 WHERE org_id = $1 AND created_at <= $2
+		 GROUP BY account HAVING SUM(amount) <> 0 ORDER BY account`, orgID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trial balance: %w", err)
+	}
+	defer rows.Close()
+
+	result := []TrialBalanceLine{}
+	for rows.Next() {
+		var l TrialBalanceLine
+		if err := rows.Scan(&l.Account, &l.Balance); err != nil {
+			return nil, fmt.Errorf("failed to scan trial balance: %w", err)
+		}
+		l.Balance = round2(l.Balance)
+		if l.Balance > 0 {
+			l.Debit = l.Balance
+		} else {
+			l.Credit = -l.Balance
+		}
+		result = append(result, l)
+	}
+	return result, rows.Err()
+}
+
+func consolidationGroup(ctx context.Context, tx *sql.Tx, orgID, groupID int) (*ConsolidationGroup, error) {
+	var g ConsolidationGroup
+	err := tx.QueryRowContext(ctx,
+		`SELECT id, name, currency, cta_account, difference_account, created_at
+		 FROM consolidation_groups WHERE org_id = $1 AND id = $2`, orgID, groupID,
+	).Scan(&g.ID, &g.Name, &g.Currency, &g.CTAAccount, &g.DifferenceAccount, &g.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoGroup
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch consolidation group: %w", err)
+	}
+	return &g, nil
+}
+
+func groupMembers(ctx context.Context, tx *sql.Tx, orgID, groupID int) ([]ConsolidationMember, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT member_org_id, currency FROM consolidation_members
+		 WHERE org_id = $1 AND group_id = $2 ORDER BY member_org_id`, orgID, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch group members: %w", err)
+	}
+	defer rows.Close()
+
+	result := []ConsolidationMember{}
+	for rows.Next() {
+		var m ConsolidationMember
+		if err := rows.Scan(&m.OrgID, &m.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+func intercompanyAccounts(ctx context.Context, tx *sql.Tx, orgID int) ([]IntercompanyAccount, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT account, counterparty_org_id, created_by, created_at FROM intercompany_accounts
+		 WHERE org_id = $1 ORDER BY account`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch intercompany accounts: %w", err)
+	}
+	defer rows.Close()
+
+	result := []IntercompanyAccount{}
+	for rows.Next() {
+		var a IntercompanyAccount
+		if err := rows.Scan(&a.Account, &a.CounterpartyOrgID, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan intercompany account: %w", err)
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Limit actions: what happens to an entry that would take exposure over the limit
+const (
+	LimitReject = "reject"
+	LimitFlag   = "flag"
+)
+
+// ErrExposureLimit is returned when an entry would breach a counterparty limit set to reject
+var ErrExposureLimit = errors.New("counterparty exposure limit exceeded")
+
+// ErrUnknownCounterparty is returned when an entry names a counterparty the organization does not have
+var ErrUnknownCounterparty = errors.New("unknown counterparty")
+
+// ErrCounterpartyExists is returned when registering a code the organization already has
+var ErrCounterpartyExists = errors.New("counterparty already exists")
+
+// Counterparty is master data for the other side of an entry
+type Counterparty struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	LEI       string    `json:"lei,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExposureLimit caps a counterparty's exposure. A nil Limit means unlimited.
+type ExposureLimit struct {
+	Limit     *float64  `json:"limit"`
+	Action    string    `json:"action"`
+	SetBy     string    `json:"set_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Exposure is a counterparty's current exposure against its limit
+type Exposure struct {
+	Counterparty
+	Exposure    float64  `json:"exposure"`
+	OpenEntries int      `json:"open_entries"`
+	Limit       *float64 `json:"limit"`
+	Action      string   `json:"action,omitempty"`
+	Headroom    *float64 `json:"headroom,omitempty"`
+	Utilization *float64 `json:"utilization,omitempty"`
+	Breaches    int      `json:"breaches"`
+}
+
+// exposureQuery sums the entries of a counterparty that settle later and
+// have not settled yet: that is the amount at risk if the counterparty
+// defaults. Reading the live ledger is enough because ArchivePeriod refuses
+// periods with unsettled entries.
+const exposureQuery = `
+	SELECT COALESCE(SUM(l.amount), 0), COUNT(*) FROM ledger l
+	WHERE l.org_id = $1 AND l.counterparty_id = $2 AND l.id <> $3 AND l.settlement_date IS NOT NULL
+	  AND (SELECT s.status FROM settlement_events s
+	       WHERE s.org_id = l.org_id AND s.ledger_id = l.id
+	       ORDER BY s.id DESC LIMIT 1) <> 'settled'`
+
+type CounterpartyRepository struct {
+	db *sql.DB
+}
+
+func NewCounterpartyRepository(db *sql.DB) *CounterpartyRepository {
+	return &CounterpartyRepository{db: db}
+}
+
+// Create registers a counterparty
+func (r *CounterpartyRepository) Create(ctx context.Context, c Counterparty) (*Counterparty, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO counterparties (org_id, code, name, lei) VALUES ($1, $2, $3, NULLIF($4, ''))
+		 RETURNING id, created_at`,
+		id.OrgID, c.Code, c.Name, c.LEI,
+	).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrCounterpartyExists
+		}
+		return nil, fmt.Errorf("failed to create counterparty: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &c, nil
+}
+
+// List returns the organization's counterparties by code
+func (r *CounterpartyRepository) List(ctx context.Context) ([]Counterparty, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, code, name, COALESCE(lei, ''), created_at FROM counterparties WHERE org_id = $1 ORDER BY code", id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch counterparties: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Counterparty{}
+	for rows.Next() {
+		var c Counterparty
+		if err := rows.Scan(&c.ID, &c.Code, &c.Name, &c.LEI, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan counterparty: %w", err)
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// GetByCode looks up a counterparty by its code
+func (r *CounterpartyRepository) GetByCode(ctx context.Context, code string) (*Counterparty, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var c Counterparty
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, code, name, COALESCE(lei, ''), created_at FROM counterparties WHERE org_id = $1 AND code = $2",
+		id.OrgID, code,
+	).Scan(&c.ID, &c.Code, &c.Name, &c.LEI, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownCounterparty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch counterparty: %w", err)
+	}
+	return &c, nil
+}
+
+// SetLimit appends a new limit for a counterparty; the latest one applies
+func (r *CounterpartyRepository) SetLimit(ctx context.Context, counterpartyID int, limit *float64, action, actor string) (*ExposureLimit, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM counterparties WHERE org_id = $1 AND id = $2)", id.OrgID, counterpartyID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch counterparty: %w", err)
+	}
+	if !exists {
+		return nil, ErrUnknownCounterparty
+	}
+
+	l := &ExposureLimit{Limit: limit, Action: action, SetBy: actor}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO counterparty_limits (org_id, counterparty_id, exposure_limit, action, set_by)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING created_at`,
+		id.OrgID, counterpartyID, limit, action, actor,
+	).Scan(&l.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set exposure limit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return l, nil
+}
+
+// Exposures reports every counterparty's current exposure, limit and flagged breaches
+func (r *CounterpartyRepository) Exposures(ctx context.Context) ([]Exposure, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT c.id, c.code, c.name, COALESCE(c.lei, ''), c.created_at,
+		        lim.exposure_limit, COALESCE(lim.action, ''),
+		        (SELECT COUNT(*) FROM counterparty_limit_breaches b WHERE b.org_id = c.org_id AND b.counterparty_id = c.id)
+		 FROM counterparties c
+		 LEFT JOIN LATERAL (
+		     SELECT exposure_limit, action FROM counterparty_limits cl
+		     WHERE cl.org_id = c.org_id AND cl.counterparty_id = c.id
+		     ORDER BY cl.id DESC LIMIT 1
+		 ) lim ON true
+		 WHERE c.org_id = $1 ORDER BY c.code`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch counterparties: %w", err)
+	}
+
+	result := []Exposure{}
+	for rows.Next() {
+		var e Exposure
+		var limit sql.NullFloat64
+		if err := rows.Scan(&e.ID, &e.Code, &e.Name, &e.LEI, &e.CreatedAt, &limit, &e.Action, &e.Breaches); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan counterparty: %w", err)
+		}
+		if limit.Valid {
+			e.Limit = &limit.Float64
+		}
+		result = append(result, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch counterparties: %w", err)
+	}
+
+	for i := range result {
+		e := &result[i]
+		if err := tx.QueryRowContext(ctx, exposureQuery, id.OrgID, e.ID, 0).Scan(&e.Exposure, &e.OpenEntries); err != nil {
+			return nil, fmt.Errorf("failed to compute exposure: %w", err)
+		}
+		if e.Limit != nil {
+			headroom := round2(*e.Limit - e.Exposure)
+			e.Headroom = &headroom
+			if *e.Limit > 0 {
+				utilization := round2(e.Exposure / *e.Limit * 100)
+				e.Utilization = &utilization
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkExposure runs in the posting path once an entry linked to a
+// counterparty is inserted. Entries that settle later add to exposure; if the
+// total would exceed the counterparty's limit the entry is rejected or, when
+// the limit is set to flag, recorded as a breach and let through.
+func checkExposure(ctx context.Context, tx *sql.Tx, orgID, ledgerID, counterpartyID int, e NewEntry, actor string) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM counterparties WHERE org_id = $1 AND id = $2)", orgID, counterpartyID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to fetch counterparty: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrUnknownCounterparty, counterpartyID)
+	}
+
+	if e.SettlementDate == nil {
+		return nil
+	}
+
+	// Serialize postings against one counterparty so concurrent entries cannot
+	// each see headroom the other is about to use
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('exposure'), $1)", counterpartyID); err != nil {
+		return fmt.Errorf("failed to lock counterparty: %w", err)
+	}
+
+	var limit sql.NullFloat64
+	var action string
+	err = tx.QueryRowContext(ctx,
+		`SELECT exposure_limit, action FROM counterparty_limits
+		 WHERE org_id = $1 AND counterparty_id = $2 ORDER BY id DESC LIMIT 1`, orgID, counterpartyID,
+	).Scan(&limit, &action)
+	if err == sql.ErrNoRows || (err == nil && !limit.Valid) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch exposure limit: %w", err)
+	}
+
+	var current float64
+	var open int
+	if err := tx.QueryRowContext(ctx, exposureQuery, orgID, counterpartyID, ledgerID).Scan(&current, &open); err != nil {
+		return fmt.Errorf("failed to compute exposure: %w", err)
+	}
+
+	after := round2(current + e.Amount)
+	if after <= limit.Float64 {
+		return nil
+	}
+
+	if action == LimitReject {
+		return fmt.Errorf("%w: exposure would be %.2f against a limit of %.2f", ErrExposureLimit, after, limit.Float64)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO counterparty_limit_breaches (org_id, counterparty_id, ledger_id, exposure, exposure_limit)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		orgID, counterpartyID, ledgerID, after, limit.Float64,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record limit breach: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+		orgID, ledgerID, actor, "LIMIT_BREACH",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+	return nil
+}
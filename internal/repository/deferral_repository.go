@@ -0,0 +1,497 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Deferral methods
+const (
+	DeferralStraightLine = "straight_line"
+	DeferralCustom       = "custom"
+)
+
+// ErrNoDeferredAmount is returned when an entry posted nothing to the deferred account
+var ErrNoDeferredAmount = errors.New("entry has no amount on the deferred account")
+
+// ErrDeferralExists is returned when an entry's deferred account already has a schedule
+var ErrDeferralExists = errors.New("deferral schedule already exists")
+
+// ErrDeferralLines is returned when custom lines do not add up to the deferred amount
+var ErrDeferralLines = errors.New("deferral lines do not add up to the deferred amount")
+
+// DeferralLine is the amount of a schedule recognized on a date
+type DeferralLine struct {
+	ID       int       `json:"id"`
+	Date     time.Time `json:"date"`
+	Amount   float64   `json:"amount"`
+	LedgerID *int      `json:"ledger_id,omitempty"`
+}
+
+// DeferralSchedule recognizes what an entry posted to DeferredAccount over time.
+// Total is positive for deferred revenue and negative for a prepaid expense.
+type DeferralSchedule struct {
+	ID                 int            `json:"id"`
+	LedgerID           int            `json:"ledger_id"`
+	DeferredAccount    string         `json:"deferred_account"`
+	RecognitionAccount string         `json:"recognition_account"`
+	Method             string         `json:"method"`
+	Total              float64        `json:"total"`
+	StartDate          time.Time      `json:"start_date"`
+	Lines              []DeferralLine `json:"lines"`
+	Released           float64        `json:"released"`
+	Remaining          float64        `json:"remaining"`
+	CreatedBy          string         `json:"created_by"`
+	CreatedAt          time.Time      `json:"created_at"`
+}
+
+// NewDeferral describes a schedule to attach to an entry. Straight-line
+// schedules recognize Total evenly at the end of Periods months from
+// StartDate's month; custom schedules use Lines, given as positive amounts.
+type NewDeferral struct {
+	DeferredAccount    string
+	RecognitionAccount string
+	Method             string
+	StartDate          time.Time
+	Periods            int
+	Lines              []DeferralLine
+}
+
+// DeferralRelease summarizes what one release run posted
+type DeferralRelease struct {
+	Through  time.Time `json:"through"`
+	Released int       `json:"released"`
+	Amount   float64   `json:"amount"`
+}
+
+// WaterfallRow is one month of the deferred balance of an account
+type WaterfallRow struct {
+	Account    string  `json:"account"`
+	Period     string  `json:"period"`
+	Opening    float64 `json:"opening"`
+	Additions  float64 `json:"additions"`
+	Recognized float64 `json:"recognized"`
+	Closing    float64 `json:"closing"`
+	Released   float64 `json:"released"`
+}
+
+type DeferralRepository struct {
+	db         *sql.DB
+	validators []Validator
+}
+
+// NewDeferralRepository returns a repository whose entries must pass the given
+// validators before they are posted
+func NewDeferralRepository(db *sql.DB, validators ...Validator) *DeferralRepository {
+	return &DeferralRepository{db: db, validators: validators}
+}
+
+// Create attaches a deferral schedule to an entry. The amount to defer is
+// what the entry posted to the deferred account, so the schedule always
+// matches the books.
+func (r *DeferralRepository) Create(ctx context.Context, ledgerID int, d NewDeferral, actor string) (*DeferralSchedule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM ledger WHERE org_id = $1 AND id = $2)", id.OrgID, ledgerID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entry: %w", err)
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	var posted float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM ledger_postings WHERE org_id = $1 AND ledger_id = $2 AND account = $3",
+		id.OrgID, ledgerID, d.DeferredAccount,
+	).Scan(&posted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch postings: %w", err)
+	}
+	total := round2(-posted)
+	if math.Abs(total) < 0.005 {
+		return nil, fmt.Errorf("%w: %s", ErrNoDeferredAmount, d.DeferredAccount)
+	}
+
+	lines, err := deferralLines(d, total)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DeferralSchedule{
+		LedgerID:           ledgerID,
+		DeferredAccount:    d.DeferredAccount,
+		RecognitionAccount: d.RecognitionAccount,
+		Method:             d.Method,
+		Total:              total,
+		StartDate:          lines[0].Date,
+		Remaining:          total,
+		CreatedBy:          actor,
+	}
+	if d.Method == DeferralStraightLine {
+		s.StartDate = d.StartDate
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO deferral_schedules (org_id, ledger_id, deferred_account, recognition_account, method, total, start_date, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		id.OrgID, ledgerID, s.DeferredAccount, s.RecognitionAccount, s.Method, s.Total, s.StartDate, actor,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrDeferralExists
+		}
+		return nil, fmt.Errorf("failed to create deferral schedule: %w", err)
+	}
+
+	for _, l := range lines {
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO deferral_lines (org_id, schedule_id, recognition_date, amount) VALUES ($1, $2, $3, $4) RETURNING id`,
+			id.OrgID, s.ID, l.Date, l.Amount,
+		).Scan(&l.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create deferral line: %w", err)
+		}
+		s.Lines = append(s.Lines, l)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return s, nil
+}
+
+// ForEntry returns the deferral schedules of an entry with their lines and releases
+func (r *DeferralRepository) ForEntry(ctx context.Context, ledgerID int) ([]DeferralSchedule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, ledger_id, deferred_account, recognition_account, method, total, start_date, created_by, created_at
+		 FROM deferral_schedules WHERE org_id = $1 AND ledger_id = $2 ORDER BY id`, id.OrgID, ledgerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral schedules: %w", err)
+	}
+
+	result := []DeferralSchedule{}
+	for rows.Next() {
+		var s DeferralSchedule
+		if err := rows.Scan(&s.ID, &s.LedgerID, &s.DeferredAccount, &s.RecognitionAccount, &s.Method, &s.Total,
+			&s.StartDate, &s.CreatedBy, &s.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deferral schedule: %w", err)
+		}
+		result = append(result, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral schedules: %w", err)
+	}
+
+	for i := range result {
+		s := &result[i]
+		lines, err := tx.QueryContext(ctx,
+			`SELECT dl.id, dl.recognition_date, dl.amount, dr.ledger_id
+			 FROM deferral_lines dl
+			 LEFT JOIN deferral_releases dr ON dr.org_id = dl.org_id AND dr.line_id = dl.id
+			 WHERE dl.org_id = $1 AND dl.schedule_id = $2 ORDER BY dl.recognition_date`, id.OrgID, s.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch deferral lines: %w", err)
+		}
+		s.Lines = []DeferralLine{}
+		for lines.Next() {
+			var l DeferralLine
+			var released sql.NullInt64
+			if err := lines.Scan(&l.ID, &l.Date, &l.Amount, &released); err != nil {
+				lines.Close()
+				return nil, fmt.Errorf("failed to scan deferral line: %w", err)
+			}
+			if released.Valid {
+				lid := int(released.Int64)
+				l.LedgerID = &lid
+				s.Released += l.Amount
+			}
+			s.Lines = append(s.Lines, l)
+		}
+		lines.Close()
+		if err := lines.Err(); err != nil {
+			return nil, fmt.Errorf("failed to fetch deferral lines: %w", err)
+		}
+		s.Released = round2(s.Released)
+		s.Remaining = round2(s.Total - s.Released)
+	}
+	return result, nil
+}
+
+// Release posts every unreleased line recognized on or before through. Each
+// line is released at most once, so reruns post nothing new and a job that
+// missed periods catches up.
+func (r *DeferralRepository) Release(ctx context.Context, through time.Time, actor string) (*DeferralRelease, error) {
+	through = time.Date(through.Year(), through.Month(), through.Day(), 0, 0, 0, 0, time.UTC)
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// One run at a time per organization, so two schedulers cannot both release a line
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", fmt.Sprintf("deferral:%d", id.OrgID)); err != nil {
+		return nil, fmt.Errorf("failed to lock deferral release: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT dl.id, dl.recognition_date, dl.amount, s.ledger_id, s.deferred_account, s.recognition_account
+		 FROM deferral_lines dl
+		 JOIN deferral_schedules s ON s.org_id = dl.org_id AND s.id = dl.schedule_id
+		 WHERE dl.org_id = $1 AND dl.recognition_date <= $2
+		   AND NOT EXISTS (SELECT 1 FROM deferral_releases dr WHERE dr.org_id = dl.org_id AND dr.line_id = dl.id)
+		 ORDER BY dl.recognition_date, dl.id`, id.OrgID, through)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch due deferral lines: %w", err)
+	}
+
+	type dueLine struct {
+		id                  int
+		date                time.Time
+		amount              float64
+		ledgerID            int
+		deferred, recognize string
+	}
+	var due []dueLine
+	for rows.Next() {
+		var l dueLine
+		if err := rows.Scan(&l.id, &l.date, &l.amount, &l.ledgerID, &l.deferred, &l.recognize); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deferral line: %w", err)
+		}
+		due = append(due, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch due deferral lines: %w", err)
+	}
+
+	run := &DeferralRelease{Through: through}
+	for _, l := range due {
+		entry := NewEntry{
+			Amount:      math.Abs(l.amount),
+			Description: fmt.Sprintf("Deferral release for entry %d %s", l.ledgerID, l.date.Format("2006-01-02")),
+			Postings: []Posting{
+				{Account: l.deferred, Amount: l.amount},
+				{Account: l.recognize, Amount: -l.amount},
+			},
+		}
+		lid, err := insertEntry(ctx, tx, id.OrgID, entry, r.validators, actor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to book deferral release: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO deferral_releases (org_id, line_id, ledger_id, created_by) VALUES ($1, $2, $3, $4)",
+			id.OrgID, l.id, lid, actor,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record deferral release: %w", err)
+		}
+		run.Released++
+		run.Amount += l.amount
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	run.Amount = round2(run.Amount)
+	return run, nil
+}
+
+// Waterfall reports the deferred balance of each deferred account month by
+// month from the month of from to the month of to: the opening balance,
+// schedules starting in the month, what the schedules recognize in it, the
+// closing balance, and how much of that has actually been released.
+// account narrows the report to that account and its descendants in the
+// chart of accounts, and a positive level rolls accounts up to that depth.
+func (r *DeferralRepository) Waterfall(ctx context.Context, from, to time.Time, account string, level int) ([]WaterfallRow, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tree, err := loadAccountTree(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	type movement struct {
+		account  string
+		date     time.Time
+		amount   float64
+		addition bool
+		released bool
+	}
+	var moves []movement
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT deferred_account, start_date, total FROM deferral_schedules WHERE org_id = $1", id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral schedules: %w", err)
+	}
+	for rows.Next() {
+		m := movement{addition: true}
+		if err := rows.Scan(&m.account, &m.date, &m.amount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deferral schedule: %w", err)
+		}
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral schedules: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`SELECT s.deferred_account, dl.recognition_date, dl.amount,
+		        EXISTS (SELECT 1 FROM deferral_releases dr WHERE dr.org_id = dl.org_id AND dr.line_id = dl.id)
+		 FROM deferral_lines dl
+		 JOIN deferral_schedules s ON s.org_id = dl.org_id AND s.id = dl.schedule_id
+		 WHERE dl.org_id = $1`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral lines: %w", err)
+	}
+	for rows.Next() {
+		var m movement
+		if err := rows.Scan(&m.account, &m.date, &m.amount, &m.released); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deferral line: %w", err)
+		}
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch deferral lines: %w", err)
+	}
+
+	first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	accounts := map[string]bool{}
+	kept := moves[:0]
+	for _, m := range moves {
+		if account != "" && !tree.within(m.account, account) {
+			continue
+		}
+		m.account = tree.at(m.account, level)
+		accounts[m.account] = true
+		kept = append(kept, m)
+	}
+	moves = kept
+	names := make([]string, 0, len(accounts))
+	for a := range accounts {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+
+	result := []WaterfallRow{}
+	for _, a := range names {
+		var opening float64
+		for _, m := range moves {
+			if m.account != a || !m.date.Before(first) {
+				continue
+			}
+			if m.addition {
+				opening += m.amount
+			} else {
+				opening -= m.amount
+			}
+		}
+
+		for month := first; !month.After(last); month = month.AddDate(0, 1, 0) {
+			next := month.AddDate(0, 1, 0)
+			row := WaterfallRow{Account: a, Period: month.Format("2006-01"), Opening: round2(opening)}
+			for _, m := range moves {
+				if m.account != a || m.date.Before(month) || !m.date.Before(next) {
+					continue
+				}
+				if m.addition {
+					row.Additions += m.amount
+				} else {
+					row.Recognized += m.amount
+					if m.released {
+						row.Released += m.amount
+					}
+				}
+			}
+			opening += row.Additions - row.Recognized
+			row.Additions = round2(row.Additions)
+			row.Recognized = round2(row.Recognized)
+			row.Released = round2(row.Released)
+			row.Closing = round2(opening)
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+// deferralLines builds the signed lines of a schedule for total
+func deferralLines(d NewDeferral, total float64) ([]DeferralLine, error) {
+	sign := 1.0
+	if total < 0 {
+		sign = -1
+	}
+
+	if d.Method == DeferralStraightLine {
+		start := time.Date(d.StartDate.Year(), d.StartDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+		per := round2(math.Abs(total) / float64(d.Periods))
+		var lines []DeferralLine
+		var allocated float64
+		for i := 0; i < d.Periods; i++ {
+			amount := per
+			if i == d.Periods-1 {
+				amount = round2(math.Abs(total) - allocated)
+			}
+			if amount == 0 {
+				continue
+			}
+			allocated = round2(allocated + amount)
+			// Recognized at the end of each month
+			lines = append(lines, DeferralLine{Date: start.AddDate(0, i+1, -1), Amount: sign * amount})
+		}
+		return lines, nil
+	}
+
+	lines := make([]DeferralLine, len(d.Lines))
+	var sum float64
+	seen := map[string]bool{}
+	for i, l := range d.Lines {
+		day := l.Date.Format("2006-01-02")
+		if seen[day] {
+			return nil, fmt.Errorf("%w: %s appears twice", ErrDeferralLines, day)
+		}
+		seen[day] = true
+		sum += l.Amount
+		lines[i] = DeferralLine{Date: l.Date, Amount: sign * l.Amount}
+	}
+	if math.Abs(round2(sum)-math.Abs(total)) >= 0.005 {
+		return nil, fmt.Errorf("%w: lines total %.2f, deferred amount is %.2f", ErrDeferralLines, round2(sum), math.Abs(total))
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Date.Before(lines[j].Date) })
+	return lines, nil
+}
@@ -0,0 +1,546 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dimension rules for an account
+const (
+	DimensionRequired = "required"
+	DimensionOptional = "optional"
+)
+
+// ReportByAccount groups a dimension report by account alongside dimensions
+const ReportByAccount = "account"
+
+// ErrUnknownDimension is returned when naming a dimension, or a value of one, that is not configured
+var ErrUnknownDimension = errors.New("unknown dimension")
+
+// ErrDimensionExists is returned when creating a dimension or value that already exists
+var ErrDimensionExists = errors.New("dimension already exists")
+
+// ErrDimensionRule is returned when a posting's dimensions break its account's rules
+var ErrDimensionRule = errors.New("dimension rule violated")
+
+// ErrNoDimensionRule is returned when removing a rule that does not exist
+var ErrNoDimensionRule = errors.New("dimension rule not found")
+
+// DimensionValue is one allowed value of a dimension
+type DimensionValue struct {
+	Value     string    `json:"value"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dimension is an analytical axis postings can be tagged with
+type Dimension struct {
+	ID        int              `json:"id"`
+	Code      string           `json:"code"`
+	Name      string           `json:"name"`
+	Values    []DimensionValue `json:"values"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// DimensionRule makes a dimension required or optional on an account and the
+// accounts under it. Once an account has rules, only the dimensions they
+// name may be used on it.
+type DimensionRule struct {
+	ID        int       `json:"id"`
+	Account   string    `json:"account"`
+	Dimension string    `json:"dimension"`
+	Rule      string    `json:"rule"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DimensionReportQuery selects postings created from..to (either may be nil)
+// under Account (empty for all) and groups them by the dimensions in By,
+// which may include ReportByAccount rolled up to Level.
+type DimensionReportQuery struct {
+	By      []string
+	From    *time.Time
+	To      *time.Time
+	Account string
+	Level   int
+}
+
+// DimensionReportRow totals the postings of one combination of values.
+// Postings without a value for a dimension are grouped under "".
+type DimensionReportRow struct {
+	Group    map[string]string `json:"group"`
+	Debit    float64           `json:"debit"`
+	Credit   float64           `json:"credit"`
+	Balance  float64           `json:"balance"`
+	Postings int               `json:"postings"`
+}
+
+// DimensionReport is a pivot of postings by dimension values
+type DimensionReport struct {
+	By     []string             `json:"by"`
+	From   *time.Time           `json:"from,omitempty"`
+	To     *time.Time           `json:"to,omitempty"`
+	Rows   []DimensionReportRow `json:"rows"`
+	Debit  float64              `json:"debit"`
+	Credit float64              `json:"credit"`
+}
+
+// postingDimensions resolves the dimension values of one posting to ids
+type postingDimensions map[int]int
+
+type DimensionRepository struct {
+	db *sql.DB
+}
+
+func NewDimensionRepository(db *sql.DB) *DimensionRepository {
+	return &DimensionRepository{db: db}
+}
+
+// Create configures a dimension with its initial allowed values
+func (r *DimensionRepository) Create(ctx context.Context, d Dimension) (*Dimension, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO dimensions (org_id, code, name) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		id.OrgID, d.Code, d.Name,
+	).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrDimensionExists
+		}
+		return nil, fmt.Errorf("failed to create dimension: %w", err)
+	}
+
+	for i := range d.Values {
+		if err := insertDimensionValue(ctx, tx, id.OrgID, d.ID, &d.Values[i]); err != nil {
+			return nil, err
+		}
+	}
+	if d.Values == nil {
+		d.Values = []DimensionValue{}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &d, nil
+}
+
+// AddValue allows another value for a dimension
+func (r *DimensionRepository) AddValue(ctx context.Context, code string, v DimensionValue) (*DimensionValue, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dimensionID, err := dimensionID(ctx, tx, id.OrgID, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertDimensionValue(ctx, tx, id.OrgID, dimensionID, &v); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &v, nil
+}
+
+// List returns the organization's dimensions with their values
+func (r *DimensionRepository) List(ctx context.Context) ([]Dimension, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT d.id, d.code, d.name, d.created_at, v.value, v.name, v.created_at
+		 FROM dimensions d
+		 LEFT JOIN dimension_values v ON v.org_id = d.org_id AND v.dimension_id = d.id
+		 WHERE d.org_id = $1 ORDER BY d.code, v.value`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dimensions: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Dimension{}
+	for rows.Next() {
+		var d Dimension
+		var value, name sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.Code, &d.Name, &d.CreatedAt, &value, &name, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dimension: %w", err)
+		}
+		if n := len(result); n == 0 || result[n-1].ID != d.ID {
+			d.Values = []DimensionValue{}
+			result = append(result, d)
+		}
+		if value.Valid {
+			last := &result[len(result)-1]
+			last.Values = append(last.Values, DimensionValue{Value: value.String, Name: name.String, CreatedAt: createdAt.Time})
+		}
+	}
+	return result, rows.Err()
+}
+
+// SetRule makes a dimension required or optional on an account, replacing
+// any rule the account already has for it
+func (r *DimensionRepository) SetRule(ctx context.Context, rule DimensionRule) (*DimensionRule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dimensionID, err := dimensionID(ctx, tx, id.OrgID, rule.Dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO dimension_rules (org_id, account, dimension_id, rule, created_by) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (org_id, account, dimension_id)
+		 DO UPDATE SET rule = EXCLUDED.rule, created_by = EXCLUDED.created_by, created_at = CURRENT_TIMESTAMP
+		 RETURNING id, created_at`,
+		id.OrgID, rule.Account, dimensionID, rule.Rule, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set dimension rule: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &rule, nil
+}
+
+// DeleteRule removes a rule
+func (r *DimensionRepository) DeleteRule(ctx context.Context, ruleID int) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM dimension_rules WHERE org_id = $1 AND id = $2", id.OrgID, ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete dimension rule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNoDimensionRule
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rules lists the dimension rules by account
+func (r *DimensionRepository) Rules(ctx context.Context) ([]DimensionRule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return dimensionRules(ctx, tx, id.OrgID)
+}
+
+// Report totals postings by every combination of the requested dimensions
+func (r *DimensionRepository) Report(ctx context.Context, q DimensionReportQuery) (*DimensionReport, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Each requested dimension joins its own copy of posting_dimensions; the
+	// SQL only ever contains placeholders and aliases built from indexes
+	args := []interface{}{id.OrgID, q.From, q.To}
+	var columns, joins, groups []string
+	var dims []string
+	for _, code := range q.By {
+		if code == ReportByAccount {
+			continue
+		}
+		dimensionID, err := dimensionID(ctx, tx, id.OrgID, code)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, dimensionID)
+		n := len(dims) + 1
+		joins = append(joins, fmt.Sprintf(
+			`LEFT JOIN posting_dimensions pd%[1]d ON pd%[1]d.org_id = p.org_id AND pd%[1]d.posting_id = p.id AND pd%[1]d.dimension_id = $%[2]d
+			 LEFT JOIN dimension_values v%[1]d ON v%[1]d.org_id = pd%[1]d.org_id AND v%[1]d.id = pd%[1]d.value_id`, n, len(args)))
+		columns = append(columns, fmt.Sprintf("COALESCE(v%d.value, '')", n))
+		groups = append(groups, fmt.Sprintf("%d", n+1))
+		dims = append(dims, code)
+	}
+
+	query := `SELECT p.account`
+	for _, c := range columns {
+		query += ", " + c
+	}
+	query += `, COALESCE(SUM(p.amount) FILTER (WHERE p.amount > 0), 0), COALESCE(-SUM(p.amount) FILTER (WHERE p.amount < 0), 0), COUNT(*)
+		 FROM ledger_postings p ` + strings.Join(joins, " ") + `
+		 WHERE p.org_id = $1 AND ($2::timestamp IS NULL OR p.created_at >= $2) AND ($3::timestamp IS NULL OR p.created_at <= $3)
+		 GROUP BY 1`
+	for _, g := range groups {
+		query += ", " + g
+	}
+
+	tree, err := loadAccountTree(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dimension report: %w", err)
+	}
+	defer rows.Close()
+
+	byAccount := false
+	for _, code := range q.By {
+		byAccount = byAccount || code == ReportByAccount
+	}
+
+	result := &DimensionReport{By: q.By, From: q.From, To: q.To, Rows: []DimensionReportRow{}}
+	index := map[string]int{}
+	for rows.Next() {
+		var account string
+		values := make([]string, len(dims))
+		var debit, credit float64
+		var postings int
+		dest := []interface{}{&account}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &debit, &credit, &postings)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan dimension report: %w", err)
+		}
+		if q.Account != "" && !tree.within(account, q.Account) {
+			continue
+		}
+
+		group := map[string]string{}
+		for i, code := range dims {
+			group[code] = values[i]
+		}
+		if byAccount {
+			group[ReportByAccount] = tree.at(account, q.Level)
+		}
+		key := make([]string, len(q.By))
+		for i, code := range q.By {
+			key[i] = group[code]
+		}
+		k := strings.Join(key, "\x00")
+
+		i, ok := index[k]
+		if !ok {
+			i = len(result.Rows)
+			index[k] = i
+			result.Rows = append(result.Rows, DimensionReportRow{Group: group})
+		}
+		row := &result.Rows[i]
+		row.Debit += debit
+		row.Credit += credit
+		row.Postings += postings
+		result.Debit += debit
+		result.Credit += credit
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch dimension report: %w", err)
+	}
+
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		row.Debit, row.Credit = round2(row.Debit), round2(row.Credit)
+		row.Balance = round2(row.Debit - row.Credit)
+	}
+	sort.Slice(result.Rows, func(i, j int) bool {
+		for _, code := range q.By {
+			a, b := result.Rows[i].Group[code], result.Rows[j].Group[code]
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+	result.Debit, result.Credit = round2(result.Debit), round2(result.Credit)
+	return result, nil
+}
+
+// resolveDimensions checks each posting's dimension values against the
+// configured values and its account's rules, returning the value ids to record
+func resolveDimensions(ctx context.Context, tx *sql.Tx, orgID int, postings []Posting) ([]postingDimensions, error) {
+	rules, err := dimensionRules(ctx, tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	tagged := false
+	for _, p := range postings {
+		tagged = tagged || len(p.Dimensions) > 0
+	}
+	if !tagged && len(rules) == 0 {
+		return make([]postingDimensions, len(postings)), nil
+	}
+
+	var tree accountTree
+	if len(rules) > 0 {
+		if tree, err = loadAccountTree(ctx, tx, orgID); err != nil {
+			return nil, err
+		}
+	}
+	byAccount := map[string][]DimensionRule{}
+	for _, rule := range rules {
+		byAccount[rule.Account] = append(byAccount[rule.Account], rule)
+	}
+
+	result := make([]postingDimensions, len(postings))
+	for i, p := range postings {
+		// The rule nearest the account wins for each dimension
+		effective := map[string]string{}
+		if len(rules) > 0 {
+			for _, a := range tree.path(p.Account) {
+				for _, rule := range byAccount[a] {
+					effective[rule.Dimension] = rule.Rule
+				}
+			}
+		}
+		for dimension, rule := range effective {
+			if rule == DimensionRequired && p.Dimensions[dimension] == "" {
+				return nil, fmt.Errorf("%w: %s requires %s", ErrDimensionRule, p.Account, dimension)
+			}
+		}
+
+		result[i] = postingDimensions{}
+		for dimension, value := range p.Dimensions {
+			if len(effective) > 0 && effective[dimension] == "" {
+				return nil, fmt.Errorf("%w: %s does not take %s", ErrDimensionRule, p.Account, dimension)
+			}
+			dimensionID, valueID, err := dimensionValueID(ctx, tx, orgID, dimension, value)
+			if err != nil {
+				return nil, err
+			}
+			result[i][dimensionID] = valueID
+		}
+	}
+	return result, nil
+}
+
+func insertPostingDimensions(ctx context.Context, tx *sql.Tx, orgID, postingID int, dims postingDimensions) error {
+	for dimensionID, valueID := range dims {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO posting_dimensions (org_id, posting_id, dimension_id, value_id) VALUES ($1, $2, $3, $4)",
+			orgID, postingID, dimensionID, valueID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record posting dimension: %w", err)
+		}
+	}
+	return nil
+}
+
+// postingDimensionsColumn selects a posting's dimensions as a JSON object,
+// for queries aliasing ledger_postings as p
+const postingDimensionsColumn = `(SELECT json_object_agg(d.code, v.value)
+	 FROM posting_dimensions pd
+	 JOIN dimensions d ON d.org_id = pd.org_id AND d.id = pd.dimension_id
+	 JOIN dimension_values v ON v.org_id = pd.org_id AND v.id = pd.value_id
+	 WHERE pd.org_id = p.org_id AND pd.posting_id = p.id)`
+
+// scanPostingDimensions decodes postingDimensionsColumn
+func scanPostingDimensions(raw sql.NullString) (map[string]string, error) {
+	if !raw.Valid {
+		return nil, nil
+	}
+	var dims map[string]string
+	if err := json.Unmarshal([]byte(raw.String), &dims); err != nil {
+		return nil, fmt.Errorf("failed to decode posting dimensions: %w", err)
+	}
+	return dims, nil
+}
+
+func dimensionRules(ctx context.Context, tx *sql.Tx, orgID int) ([]DimensionRule, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT r.id, r.account, d.code, r.rule, r.created_by, r.created_at
+		 FROM dimension_rules r JOIN dimensions d ON d.org_id = r.org_id AND d.id = r.dimension_id
+		 WHERE r.org_id = $1 ORDER BY r.account, d.code`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dimension rules: %w", err)
+	}
+	defer rows.Close()
+
+	result := []DimensionRule{}
+	for rows.Next() {
+		var rule DimensionRule
+		if err := rows.Scan(&rule.ID, &rule.Account, &rule.Dimension, &rule.Rule, &rule.CreatedBy, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dimension rule: %w", err)
+		}
+		result = append(result, rule)
+	}
+	return result, rows.Err()
+}
+
+func dimensionID(ctx context.Context, tx *sql.Tx, orgID int, code string) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM dimensions WHERE org_id = $1 AND code = $2", orgID, code).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownDimension, code)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch dimension: %w", err)
+	}
+	return id, nil
+}
+
+// dimensionValueID resolves a dimension code and one of its values to ids
+func dimensionValueID(ctx context.Context, tx *sql.Tx, orgID int, dimension, value string) (int, int, error) {
+	var dimensionID, valueID int
+	err := tx.QueryRowContext(ctx,
+		`SELECT d.id, v.id FROM dimensions d
+		 JOIN dimension_values v ON v.org_id = d.org_id AND v.dimension_id = d.id
+		 WHERE d.org_id = $1 AND d.code = $2 AND v.value = $3`, orgID, dimension, value,
+	).Scan(&dimensionID, &valueID)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("%w: %s=%s", ErrUnknownDimension, dimension, value)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch dimension value: %w", err)
+	}
+	return dimensionID, valueID, nil
+}
+
+func insertDimensionValue(ctx context.Context, tx *sql.Tx, orgID, dimensionID int, v *DimensionValue) error {
+	err := tx.QueryRowContext(ctx,
+		"INSERT INTO dimension_values (org_id, dimension_id, value, name) VALUES ($1, $2, $3, $4) RETURNING created_at",
+		orgID, dimensionID, v.Value, v.Name,
+	).Scan(&v.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return fmt.Errorf("%w: value %s", ErrDimensionExists, v.Value)
+		}
+		return fmt.Errorf("failed to create dimension value: %w", err)
+	}
+	return nil
+}
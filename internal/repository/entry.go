@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnbalanced is returned when an entry's postings do not sum to zero
+var ErrUnbalanced = errors.New("postings do not balance")
+
+// ErrUncategorized is returned when a posting has no account and no
+// categorization rule assigned one
+var ErrUncategorized = errors.New("posting account is required")
+
+// Posting is one leg of a balanced entry. Amounts are signed: debits are
+// positive and credits negative. Instrument and Quantity are set on legs that
+// move a position rather than cash. A leg with a TaxCode is a net amount; the
+// tax on it is added as a further leg when the entry is posted. Dimensions
+// maps dimension codes to values, e.g. {"desk": "EQ"}.
+type Posting struct {
+	Account    string            `json:"account"`
+	Amount     float64           `json:"amount"`
+	Instrument string            `json:"instrument,omitempty"`
+	Quantity   float64           `json:"quantity,omitempty"`
+	TaxCode    string            `json:"tax_code,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+// NewEntry is everything needed to post a ledger entry. Entries without
+// postings are recorded as a single amount, as before postings existed.
+// LotMethod, LotIDs and PnLAccount only matter when a posting reduces a
+// position; they default to FIFO and DefaultRealizedPnLAccount. Entries with
+// a SettlementDate start out pending settlement, and entries linked to a
+// counterparty are checked against its exposure limit. Each entry is given the
+// next voucher number of its Book, DefaultBook when empty. Metadata and Tags
+// are free-form labels kept with the entry.
+type NewEntry struct {
+	Amount         float64
+	Description    string
+	Postings       []Posting
+	LotMethod      string
+	LotIDs         []int
+	PnLAccount     string
+	TradeDate      *time.Time
+	SettlementDate *time.Time
+	CounterpartyID *int
+	Book           string
+	Metadata       map[string]string
+	Tags           []string
+
+	// ruleID is the categorization rule applied to the entry, if any
+	ruleID *int
+}
+
+func (e NewEntry) lotMethod() string {
+	if e.LotMethod == "" {
+		return LotFIFO
+	}
+	return e.LotMethod
+}
+
+func (e NewEntry) pnlAccount() string {
+	if e.PnLAccount == "" {
+		return DefaultRealizedPnLAccount
+	}
+	return e.PnLAccount
+}
+
+// hasTaxCodes reports whether any posting still needs its tax legs generated
+func (e NewEntry) hasTaxCodes() bool {
+	for _, p := range e.Postings {
+		if p.TaxCode != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that postings balance and that the entry amount equals the
+// total debits. Entries with tax codes cannot balance until their tax legs
+// are generated, so insertEntry checks their balance after doing so. A
+// posting without an account is reported as ErrUncategorized only once the
+// rest of the entry is known to be valid, so callers that categorize entries
+// can tell it apart.
+func (e NewEntry) Validate() error {
+	if e.TradeDate != nil && e.SettlementDate != nil && e.SettlementDate.Before(*e.TradeDate) {
+		return fmt.Errorf("settlement date cannot be before trade date")
+	}
+	if len(e.Postings) == 0 {
+		return nil
+	}
+	switch e.lotMethod() {
+	case LotFIFO, LotLIFO:
+	case LotSpecific:
+		if len(e.LotIDs) == 0 {
+			return fmt.Errorf("lot_ids are required for specific lot relief")
+		}
+		// A lot listed twice would be relieved twice from what it has left
+		seen := make(map[int]bool, len(e.LotIDs))
+		for _, id := range e.LotIDs {
+			if seen[id] {
+				return &RuleViolation{Rule: RuleUniqueLotIDs, Message: fmt.Sprintf("lot %d is listed more than once", id)}
+			}
+			seen[id] = true
+		}
+	default:
+		return fmt.Errorf("unknown lot method %q", e.LotMethod)
+	}
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: an entry needs at least two postings", ErrUnbalanced)
+	}
+
+	uncategorized := false
+	for _, p := range e.Postings {
+		uncategorized = uncategorized || p.Account == ""
+		if p.Quantity != 0 && p.Instrument == "" {
+			return fmt.Errorf("posting with a quantity needs an instrument")
+		}
+		if (p.Quantity > 0 && p.Amount < 0) || (p.Quantity < 0 && p.Amount > 0) {
+			return fmt.Errorf("posting amount and quantity for %s must have the same sign", p.Instrument)
+		}
+	}
+	if uncategorized {
+		return ErrUncategorized
+	}
+	if e.hasTaxCodes() {
+		return nil
+	}
+	return e.balanced()
+}
+
+// balanced checks that postings sum to zero and the amount equals the total debits
+func (e NewEntry) balanced() error {
+	var sum, debits float64
+	for _, p := range e.Postings {
+		sum += p.Amount
+		if p.Amount > 0 {
+			debits += p.Amount
+		}
+	}
+	if math.Abs(sum) >= 0.005 {
+		return fmt.Errorf("%w: postings sum to %.2f", ErrUnbalanced, sum)
+	}
+	if math.Abs(debits-e.Amount) >= 0.005 {
+		return fmt.Errorf("%w: amount %.2f does not equal total debits %.2f", ErrUnbalanced, e.Amount, debits)
+	}
+	return nil
+}
+
+// insertEntry writes an entry, its postings and its INSERT audit row inside an
+// existing scoped transaction. Every path that posts to the ledger goes through
+// it, so callers can add their own rows (idempotency keys, lot records) atomically.
+// The entry must first pass the pre-commit validators.
+// The entry takes the next voucher number of its book in the same transaction,
+// so a rollback leaves no gap. Postings with a tax code get their tax legs
+// first; none may go to a header account, and their dimensions must satisfy
+// their accounts' rules. Quantity-bearing postings are
+// passed through the lot engine, and budgets the postings count against are
+// checked for crossed alert thresholds, before returning.
+func insertEntry(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry, validators []Validator, actor string) (int, error) {
+	if err := runValidators(ctx, validators, e); err != nil {
+		return 0, err
+	}
+	if err := e.Validate(); err != nil {
+		return 0, err
+	}
+
+	var taxes []taxLine
+	if e.hasTaxCodes() {
+		var err error
+		if e.Postings, taxes, err = applyTaxCodes(ctx, tx, orgID, e); err != nil {
+			return 0, err
+		}
+		if err := e.balanced(); err != nil {
+			return 0, err
+		}
+	}
+	if err := checkPostingAccounts(ctx, tx, orgID, e.Postings); err != nil {
+		return 0, err
+	}
+	dims, err := resolveDimensions(ctx, tx, orgID, e.Postings)
+	if err != nil {
+		return 0, err
+	}
+
+	book, voucher, err := allocateVoucher(ctx, tx, orgID, e)
+	if err != nil {
+		return 0, err
+	}
+
+	metadata, err := jsonOrNull(e.Metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	var ledgerID int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO ledger (org_id, amount, description, trade_date, settlement_date, counterparty_id, book, voucher_number, metadata, tags, rule_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		orgID, e.Amount, e.Description, e.TradeDate, e.SettlementDate, e.CounterpartyID, book, voucher, metadata, pq.Array(e.Tags), e.ruleID,
+	).Scan(&ledgerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ledger entry: %w", err)
+	}
+
+	for i, p := range e.Postings {
+		var postingID int
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO ledger_postings (org_id, ledger_id, account, amount, instrument, quantity)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0)) RETURNING id`,
+			orgID, ledgerID, p.Account, p.Amount, p.Instrument, p.Quantity,
+		).Scan(&postingID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create posting: %w", err)
+		}
+		if err := insertPostingDimensions(ctx, tx, orgID, postingID, dims[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := insertTaxLines(ctx, tx, orgID, ledgerID, taxes); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+		orgID, ledgerID, actor, "INSERT",
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	if e.SettlementDate != nil {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO settlement_events (org_id, ledger_id, status, actor) VALUES ($1, $2, $3, $4)",
+			orgID, ledgerID, SettlementPending, actor,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to record settlement status: %w", err)
+		}
+	}
+
+	if err := applyLots(ctx, tx, orgID, ledgerID, e, actor); err != nil {
+		return 0, err
+	}
+
+	if e.CounterpartyID != nil {
+		if err := checkExposure(ctx, tx, orgID, ledgerID, *e.CounterpartyID, e, actor); err != nil {
+			return 0, err
+		}
+	}
+	if err := checkBudgets(ctx, tx, orgID, ledgerID, e.Postings, dims, actor); err != nil {
+		return 0, err
+	}
+	return ledgerID, nil
+}
+
+// attachPostings fills in the postings of each entry
+func attachPostings(ctx context.Context, tx *sql.Tx, orgID int, entries []Ledger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(entries))
+	index := make(map[int]int, len(entries))
+	for i, l := range entries {
+		ids[i] = int64(l.ID)
+		index[l.ID] = i
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT p.ledger_id, p.account, p.amount, COALESCE(p.instrument, ''), COALESCE(p.quantity, 0), `+postingDimensionsColumn+`
+		 FROM ledger_postings p WHERE p.org_id = $1 AND p.ledger_id = ANY($2) ORDER BY p.id`,
+		orgID, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to fetch postings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ledgerID int
+		var p Posting
+		var dims sql.NullString
+		if err := rows.Scan(&ledgerID, &p.Account, &p.Amount, &p.Instrument, &p.Quantity, &dims); err != nil {
+			return fmt.Errorf("failed to scan posting: %w", err)
+		}
+		if p.Dimensions, err = scanPostingDimensions(dims); err != nil {
+			return err
+		}
+		i := index[ledgerID]
+		entries[i].Postings = append(entries[i].Postings, p)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateLotIDs(t *testing.T) {
+	sell := []Posting{
+		{Account: "Assets:Cash", Amount: 1800},
+		{Account: "Assets:Positions", Amount: -1800, Instrument: "AAPL", Quantity: -10},
+	}
+
+	tests := []struct {
+		name   string
+		lotIDs []int
+		rule   string // empty when the entry is valid
+	}{
+		{"distinct", []int{3, 7}, ""},
+		{"duplicate", []int{7, 7}, RuleUniqueLotIDs},
+		{"duplicate apart", []int{7, 3, 7}, RuleUniqueLotIDs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEntry{Amount: 1800, Description: "Sell 10 AAPL", Postings: sell, LotMethod: LotSpecific, LotIDs: tt.lotIDs}
+			err := e.Validate()
+
+			var violation *RuleViolation
+			switch {
+			case tt.rule == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.rule != "" && !errors.As(err, &violation):
+				t.Errorf("Validate() = %v, want a *RuleViolation", err)
+			case tt.rule != "" && violation.Rule != tt.rule:
+				t.Errorf("Rule = %q, want %q", violation.Rule, tt.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FIXSession is the persisted sequence state of one counterparty session
+type FIXSession struct {
+	LocalCompID     string
+	RemoteCompID    string
+	NextIncomingSeq int
+	NextOutgoingSeq int
+}
+
+// FIXExecution is the capture record of a booked fill
+type FIXExecution struct {
+	LocalCompID  string
+	RemoteCompID string
+	MsgSeqNum    int
+	ExecID       string
+	OrderID      string
+	Symbol       string
+	Side         string
+	Quantity     float64
+	Price        float64
+	Commission   float64
+	Currency     string
+	TransactTime time.Time
+}
+
+// Metadata keys a fill's entry carries, so a replay of a fill held for
+// approval, which has no fix_executions row, is still recognized
+const (
+	fixMetaSender = "fix_sender_comp_id"
+	fixMetaExecID = "fix_exec_id"
+)
+
+type FIXRepository struct {
+	db         *sql.DB
+	validators []Validator
+}
+
+// NewFIXRepository returns a repository whose fills must pass the given validators
+func NewFIXRepository(db *sql.DB, validators ...Validator) *FIXRepository {
+	return &FIXRepository{db: db, validators: validators}
+}
+
+// Session loads the sequence state for a counterparty, creating it on first logon
+func (r *FIXRepository) Session(ctx context.Context, local, remote string) (*FIXSession, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s := &FIXSession{LocalCompID: local, RemoteCompID: remote}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO fix_sessions (org_id, local_comp_id, remote_comp_id) VALUES ($1, $2, $3)
+		 ON CONFLICT (org_id, local_comp_id, remote_comp_id) DO UPDATE SET updated_at = CURRENT_TIMESTAMP
+		 RETURNING next_incoming_seq, next_outgoing_seq`,
+		id.OrgID, local, remote,
+	).Scan(&s.NextIncomingSeq, &s.NextOutgoingSeq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FIX session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return s, nil
+}
+
+// ResetSequences restarts both directions at 1 (Logon with ResetSeqNumFlag=Y)
+func (r *FIXRepository) ResetSequences(ctx context.Context, local, remote string) error {
+	return r.updateSession(ctx,
+		"UPDATE fix_sessions SET next_incoming_seq = 1, next_outgoing_seq = 1, updated_at = CURRENT_TIMESTAMP WHERE org_id = $1 AND local_comp_id = $2 AND remote_comp_id = $3",
+		local, remote)
+}
+
+// SetNextIncoming records the next expected incoming MsgSeqNum
+func (r *FIXRepository) SetNextIncoming(ctx context.Context, local, remote string, next int) error {
+	return r.updateSession(ctx,
+		"UPDATE fix_sessions SET next_incoming_seq = $4, updated_at = CURRENT_TIMESTAMP WHERE org_id = $1 AND local_comp_id = $2 AND remote_comp_id = $3",
+		local, remote, next)
+}
+
+// NextOutgoing reserves and returns the MsgSeqNum for the next outgoing message
+func (r *FIXRepository) NextOutgoing(ctx context.Context, local, remote string) (int, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE fix_sessions SET next_outgoing_seq = next_outgoing_seq + 1, updated_at = CURRENT_TIMESTAMP
+		 WHERE org_id = $1 AND local_comp_id = $2 AND remote_comp_id = $3
+		 RETURNING next_outgoing_seq - 1`,
+		id.OrgID, local, remote,
+	).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve outgoing sequence number: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return seq, nil
+}
+
+// RecordExecution books a fill and advances the incoming sequence number in one
+// transaction, so a fill is booked exactly when its message is consumed. Fills
+// are posted like LedgerRepository.Create posts entries: categorized,
+// validated and screened, and a fill the organization holds is reported as
+// *EntryHeld once the hold and the sequence number are committed. An ExecID
+// already captured or held for this counterparty is reported as a duplicate
+// and only the sequence number advances.
+func (r *FIXRepository) RecordExecution(ctx context.Context, exec FIXExecution, e NewEntry, actor string) (int, bool, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE fix_sessions SET next_incoming_seq = $4, updated_at = CURRENT_TIMESTAMP WHERE org_id = $1 AND local_comp_id = $2 AND remote_comp_id = $3",
+		id.OrgID, exec.LocalCompID, exec.RemoteCompID, exec.MsgSeqNum+1)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to advance sequence number: %w", err)
+	}
+
+	var duplicate bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM fix_executions WHERE org_id = $1 AND remote_comp_id = $2 AND exec_id = $3)
+		     OR EXISTS (SELECT 1 FROM anomalies WHERE org_id = $1 AND entry IS NOT NULL
+		                AND entry->'metadata'->>'`+fixMetaSender+`' = $2 AND entry->'metadata'->>'`+fixMetaExecID+`' = $3)`,
+		id.OrgID, exec.RemoteCompID, exec.ExecID,
+	).Scan(&duplicate)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check for duplicate execution: %w", err)
+	}
+
+	ledgerID := 0
+	var held *EntryHeld
+	if !duplicate {
+		metadata := map[string]string{fixMetaSender: exec.RemoteCompID, fixMetaExecID: exec.ExecID}
+		for k, v := range e.Metadata {
+			metadata[k] = v
+		}
+		e.Metadata = metadata
+
+		ledgerID, held, err = postEntry(ctx, tx, id.OrgID, e, r.validators, actor)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if ledgerID != 0 {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO fix_executions
+			     (org_id, ledger_id, local_comp_id, remote_comp_id, msg_seq_num, exec_id, order_id,
+			      symbol, side, quantity, price, commission, currency, transact_time)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14)`,
+			id.OrgID, ledgerID, exec.LocalCompID, exec.RemoteCompID, exec.MsgSeqNum, exec.ExecID, exec.OrderID,
+			exec.Symbol, exec.Side, exec.Quantity, exec.Price, exec.Commission, exec.Currency, nullTime(exec.TransactTime))
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			// A concurrent session captured the same ExecID first; discard the
+			// booking but still consume the message
+			tx.Rollback()
+			return 0, true, r.SetNextIncoming(ctx, exec.LocalCompID, exec.RemoteCompID, exec.MsgSeqNum+1)
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to record execution: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if held != nil {
+		return 0, false, held
+	}
+	return ledgerID, duplicate, nil
+}
+
+func (r *FIXRepository) updateSession(ctx context.Context, query, local, remote string, args ...interface{}) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, append([]interface{}{id.OrgID, local, remote}, args...)...); err != nil {
+		return fmt.Errorf("failed to update FIX session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
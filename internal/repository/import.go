@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// ImportError is an imported entry that could not be posted, by its index
+type ImportError struct {
+	Index int
+	Err   error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("entry %d: %v", e.Index+1, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportHeld is an imported entry held for approval, by its index
+type ImportHeld struct {
+	Index     int
+	AnomalyID int
+	Reasons   []AnomalyReason
+}
+
+// ImportResult is what an import added: the number of new chart accounts, the
+// IDs of the posted entries, in order, and the entries held for approval
+type ImportResult struct {
+	Accounts int
+	Entries  []int
+	Held     []ImportHeld
+}
+
+// Import adds the accounts the chart does not have yet, parents first, and
+// posts the entries, all or nothing. Each entry goes through the same steps as
+// Create: categorization rules, the pre-commit validators and anomaly
+// screening. A held entry is recorded as an anomaly and does not fail the import.
+func (r *LedgerRepository) Import(ctx context.Context, accounts []Account, entries []NewEntry, actor string) (*ImportResult, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	chart, err := chartOfAccounts(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	inChart := map[string]bool{}
+	for _, a := range chart {
+		inChart[a.Code] = true
+	}
+
+	result := &ImportResult{Entries: []int{}, Held: []ImportHeld{}}
+	var pending []Account
+	waiting := map[string]bool{}
+	for _, a := range accounts {
+		if !inChart[a.Code] && !waiting[a.Code] {
+			waiting[a.Code] = true
+			pending = append(pending, a)
+		}
+	}
+	for len(pending) > 0 {
+		// An account waits for a parent that is still to be added. When all
+		// of them wait, they form a cycle and the first fails on its parent.
+		var next []Account
+		for _, a := range pending {
+			if waiting[a.Parent] && len(next) < len(pending)-1 {
+				next = append(next, a)
+				continue
+			}
+			if err := insertAccount(ctx, tx, id.OrgID, &a, a.Parent); err != nil {
+				return nil, fmt.Errorf("account %s: %w", a.Code, err)
+			}
+			delete(waiting, a.Code)
+			result.Accounts++
+		}
+		pending = next
+	}
+
+	for i, e := range entries {
+		ledgerID, held, err := postEntry(ctx, tx, id.OrgID, e, r.validators, actor)
+		if err != nil {
+			return nil, &ImportError{Index: i, Err: err}
+		}
+		if held != nil {
+			result.Held = append(result.Held, ImportHeld{Index: i, AnomalyID: held.AnomalyID, Reasons: held.Reasons})
+			continue
+		}
+		result.Entries = append(result.Entries, ledgerID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,375 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"ledger-go-system/internal/daycount"
+)
+
+// Capitalization frequencies: how often accrued interest is moved into the account
+const (
+	CapitalizeNone      = "none"
+	CapitalizeMonthly   = "monthly"
+	CapitalizeQuarterly = "quarterly"
+	CapitalizeAnnually  = "annually"
+)
+
+// InterestSchedule is the interest terms of an account from EffectiveFrom on.
+// AnnualRate is a fraction (0.05 is 5%); a negative balance accrues the other way.
+type InterestSchedule struct {
+	ID              int       `json:"id"`
+	Account         string    `json:"account"`
+	AnnualRate      float64   `json:"annual_rate"`
+	DayCount        string    `json:"day_count"`
+	AccruedAccount  string    `json:"accrued_account"`
+	InterestAccount string    `json:"interest_account"`
+	Capitalization  string    `json:"capitalization"`
+	EffectiveFrom   time.Time `json:"effective_from"`
+	CreatedBy       string    `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// InterestAccrual is one day of interest on one account
+type InterestAccrual struct {
+	ID           int       `json:"id"`
+	ScheduleID   int       `json:"schedule_id"`
+	Account      string    `json:"account"`
+	Date         time.Time `json:"date"`
+	Balance      float64   `json:"balance"`
+	AnnualRate   float64   `json:"annual_rate"`
+	DayCount     string    `json:"day_count"`
+	YearFraction float64   `json:"year_fraction"`
+	Amount       float64   `json:"amount"`
+	Booked       float64   `json:"booked"`
+	LedgerID     *int      `json:"ledger_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AccrualRun summarizes what one call to Accrue posted
+type AccrualRun struct {
+	Through         time.Time `json:"through"`
+	Accruals        int       `json:"accruals"`
+	Booked          float64   `json:"booked"`
+	Capitalizations int       `json:"capitalizations"`
+	Capitalized     float64   `json:"capitalized"`
+}
+
+type InterestRepository struct {
+	db         *sql.DB
+	validators []Validator
+}
+
+// NewInterestRepository returns a repository whose entries must pass the given
+// validators before they are posted
+func NewInterestRepository(db *sql.DB, validators ...Validator) *InterestRepository {
+	return &InterestRepository{db: db, validators: validators}
+}
+
+// AddSchedule appends interest terms for an account; they apply from EffectiveFrom
+func (r *InterestRepository) AddSchedule(ctx context.Context, s InterestSchedule, actor string) (*InterestSchedule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s.CreatedBy = actor
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO interest_schedules (org_id, account, annual_rate, day_count, accrued_account, interest_account, capitalization, effective_from, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+		id.OrgID, s.Account, s.AnnualRate, s.DayCount, s.AccruedAccount, s.InterestAccount, s.Capitalization, s.EffectiveFrom, actor,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create interest schedule: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &s, nil
+}
+
+// Schedules lists interest schedules, optionally for one account
+func (r *InterestRepository) Schedules(ctx context.Context, account string) ([]InterestSchedule, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return interestSchedules(ctx, tx, id.OrgID, account)
+}
+
+// Accruals lists daily accruals, optionally for one account and date range
+func (r *InterestRepository) Accruals(ctx context.Context, account string, from, to *time.Time) ([]InterestAccrual, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, schedule_id, account, accrual_date, balance, annual_rate, day_count, year_fraction, amount, booked, ledger_id, created_at
+		 FROM interest_accruals
+		 WHERE org_id = $1 AND ($2 = '' OR account = $2)
+		   AND ($3::date IS NULL OR accrual_date >= $3) AND ($4::date IS NULL OR accrual_date <= $4)
+		 ORDER BY account, accrual_date`,
+		id.OrgID, account, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch interest accruals: %w", err)
+	}
+	defer rows.Close()
+
+	result := []InterestAccrual{}
+	for rows.Next() {
+		var a InterestAccrual
+		var ledgerID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.ScheduleID, &a.Account, &a.Date, &a.Balance, &a.AnnualRate, &a.DayCount,
+			&a.YearFraction, &a.Amount, &a.Booked, &ledgerID, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan interest accrual: %w", err)
+		}
+		if ledgerID.Valid {
+			l := int(ledgerID.Int64)
+			a.LedgerID = &l
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// Accrue books interest for every day up to and including through that has
+// not been accrued yet, for every account with a schedule, and capitalizes at
+// each period end on the way. Each account and day is accrued at most once, so
+// reruns post nothing new and a job that missed days catches up.
+func (r *InterestRepository) Accrue(ctx context.Context, through time.Time, actor string) (*AccrualRun, error) {
+	through = time.Date(through.Year(), through.Month(), through.Day(), 0, 0, 0, 0, time.UTC)
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// One run at a time per organization, so two schedulers cannot both accrue a day
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", fmt.Sprintf("interest:%d", id.OrgID)); err != nil {
+		return nil, fmt.Errorf("failed to lock interest accrual: %w", err)
+	}
+
+	schedules, err := interestSchedules(ctx, tx, id.OrgID, "")
+	if err != nil {
+		return nil, err
+	}
+	byAccount := map[string][]InterestSchedule{}
+	var accounts []string
+	for _, s := range schedules {
+		if _, ok := byAccount[s.Account]; !ok {
+			accounts = append(accounts, s.Account)
+		}
+		byAccount[s.Account] = append(byAccount[s.Account], s)
+	}
+
+	run := &AccrualRun{Through: through}
+	for _, account := range accounts {
+		if err := r.accrueAccount(ctx, tx, id.OrgID, account, byAccount[account], through, actor, run); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	run.Booked = round2(run.Booked)
+	run.Capitalized = round2(run.Capitalized)
+	return run, nil
+}
+
+// accrueAccount accrues one account day by day from the day after its last
+// accrual. schedules are ordered by effective_from.
+func (r *InterestRepository) accrueAccount(ctx context.Context, tx *sql.Tx, orgID int, account string, schedules []InterestSchedule, through time.Time, actor string, run *AccrualRun) error {
+	var last, lastCap sql.NullTime
+	var sumAmount, sumBooked float64
+	err := tx.QueryRowContext(ctx,
+		`SELECT MAX(accrual_date), COALESCE(SUM(amount), 0), COALESCE(SUM(booked), 0)
+		 FROM interest_accruals WHERE org_id = $1 AND account = $2`, orgID, account,
+	).Scan(&last, &sumAmount, &sumBooked)
+	if err != nil {
+		return fmt.Errorf("failed to fetch interest accruals: %w", err)
+	}
+	err = tx.QueryRowContext(ctx,
+		"SELECT MAX(period_end) FROM interest_capitalizations WHERE org_id = $1 AND account = $2", orgID, account,
+	).Scan(&lastCap)
+	if err != nil {
+		return fmt.Errorf("failed to fetch interest capitalizations: %w", err)
+	}
+
+	// Interest booked since the last capitalization, waiting to be capitalized
+	var uncapitalized float64
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(booked), 0) FROM interest_accruals
+		 WHERE org_id = $1 AND account = $2 AND ($3::date IS NULL OR accrual_date > $3)`, orgID, account, lastCap,
+	).Scan(&uncapitalized)
+	if err != nil {
+		return fmt.Errorf("failed to fetch interest accruals: %w", err)
+	}
+
+	start := schedules[0].EffectiveFrom
+	if last.Valid {
+		start = last.Time.AddDate(0, 0, 1)
+	}
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+
+	// Balances are read from postings booked by the end of each day. Interest
+	// capitalized earlier in this run is posted now, after those days ended,
+	// so it is carried here to keep compounding right while catching up.
+	var capitalizedThisRun float64
+
+	for day := start; !day.After(through); day = day.AddDate(0, 0, 1) {
+		s := scheduleOn(schedules, day)
+		if s == nil {
+			continue
+		}
+		next := day.AddDate(0, 0, 1)
+
+		var balance float64
+		err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(SUM(amount), 0) FROM ledger_postings
+			 WHERE org_id = $1 AND account = $2 AND created_at < $3`, orgID, account, next,
+		).Scan(&balance)
+		if err != nil {
+			return fmt.Errorf("failed to fetch account balance: %w", err)
+		}
+		balance = round2(balance + capitalizedThisRun)
+
+		fraction, err := daycount.YearFraction(s.DayCount, day, next)
+		if err != nil {
+			return err
+		}
+		amount := balance * s.AnnualRate * fraction
+
+		// Book whatever brings the cumulative booked total to the cumulative
+		// exact accrual rounded to the cent, so sub-cent days are not lost
+		booked := round2(round2(sumAmount+amount) - sumBooked)
+		sumAmount += amount
+		sumBooked = round2(sumBooked + booked)
+
+		var ledgerID sql.NullInt64
+		if math.Abs(booked) >= 0.005 {
+			entry := NewEntry{
+				Amount:      math.Abs(booked),
+				Description: fmt.Sprintf("Interest accrual %s %s", account, day.Format("2006-01-02")),
+				Postings: []Posting{
+					{Account: s.AccruedAccount, Amount: booked},
+					{Account: s.InterestAccount, Amount: -booked},
+				},
+			}
+			lid, err := insertEntry(ctx, tx, orgID, entry, r.validators, actor)
+			if err != nil {
+				return fmt.Errorf("failed to book interest accrual: %w", err)
+			}
+			ledgerID = sql.NullInt64{Int64: int64(lid), Valid: true}
+			run.Booked += booked
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO interest_accruals (org_id, schedule_id, account, accrual_date, balance, annual_rate, day_count, year_fraction, amount, booked, ledger_id, created_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			orgID, s.ID, account, day, balance, s.AnnualRate, s.DayCount, fraction, amount, booked, ledgerID, actor,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record interest accrual: %w", err)
+		}
+		run.Accruals++
+		uncapitalized = round2(uncapitalized + booked)
+
+		if !periodEnd(s.Capitalization, day) {
+			continue
+		}
+
+		var capLedgerID sql.NullInt64
+		if math.Abs(uncapitalized) >= 0.005 {
+			entry := NewEntry{
+				Amount:      math.Abs(uncapitalized),
+				Description: fmt.Sprintf("Interest capitalization %s %s", account, day.Format("2006-01-02")),
+				Postings: []Posting{
+					{Account: account, Amount: uncapitalized},
+					{Account: s.AccruedAccount, Amount: -uncapitalized},
+				},
+			}
+			lid, err := insertEntry(ctx, tx, orgID, entry, r.validators, actor)
+			if err != nil {
+				return fmt.Errorf("failed to book interest capitalization: %w", err)
+			}
+			capLedgerID = sql.NullInt64{Int64: int64(lid), Valid: true}
+			capitalizedThisRun += uncapitalized
+			run.Capitalized += uncapitalized
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO interest_capitalizations (org_id, account, period_end, amount, ledger_id, created_by)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			orgID, account, day, uncapitalized, capLedgerID, actor,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record interest capitalization: %w", err)
+		}
+		run.Capitalizations++
+		uncapitalized = 0
+	}
+	return nil
+}
+
+// scheduleOn returns the schedule in effect on day, nil before the first one
+func scheduleOn(schedules []InterestSchedule, day time.Time) *InterestSchedule {
+	var current *InterestSchedule
+	for i := range schedules {
+		if schedules[i].EffectiveFrom.After(day) {
+			break
+		}
+		current = &schedules[i]
+	}
+	return current
+}
+
+// periodEnd reports whether day is the last day of a capitalization period
+func periodEnd(capitalization string, day time.Time) bool {
+	next := day.AddDate(0, 0, 1)
+	if next.Day() != 1 {
+		return false
+	}
+	switch capitalization {
+	case CapitalizeMonthly:
+		return true
+	case CapitalizeQuarterly:
+		return (next.Month()-1)%3 == 0
+	case CapitalizeAnnually:
+		return next.Month() == time.January
+	}
+	return false
+}
+
+func interestSchedules(ctx context.Context, tx *sql.Tx, orgID int, account string) ([]InterestSchedule, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, account, annual_rate, day_count, accrued_account, interest_account, capitalization, effective_from, created_by, created_at
+		 FROM interest_schedules WHERE org_id = $1 AND ($2 = '' OR account = $2)
+		 ORDER BY account, effective_from, id`, orgID, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch interest schedules: %w", err)
+	}
+	defer rows.Close()
+
+	result := []InterestSchedule{}
+	for rows.Next() {
+		var s InterestSchedule
+		if err := rows.Scan(&s.ID, &s.Account, &s.AnnualRate, &s.DayCount, &s.AccruedAccount, &s.InterestAccount,
+			&s.Capitalization, &s.EffectiveFrom, &s.CreatedBy, &s.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan interest schedule: %w", err)
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
@@ -3,62 +3,165 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Ledger struct {
-	ID          int       `json:"id"`
-	Amount      float64   `json:"amount"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             int               `json:"id"`
+	Amount         float64           `json:"amount"`
+	Description    string            `json:"description"`
+	CreatedAt      time.Time         `json:"created_at"`
+	TradeDate      *time.Time        `json:"trade_date,omitempty"`
+	SettlementDate *time.Time        `json:"settlement_date,omitempty"`
+	CounterpartyID *int              `json:"counterparty_id,omitempty"`
+	Book           string            `json:"book,omitempty"`
+	VoucherNumber  string            `json:"voucher_number,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	RuleID         *int              `json:"rule_id,omitempty"`
+	Postings       []Posting         `json:"postings,omitempty"`
+	Archived       bool              `json:"archived,omitempty"`
+	History        []AuditEvent      `json:"history,omitempty"`
+}
+
+// ledgerColumns is the select list scanLedger reads, for queries aliasing ledger as l
+const ledgerColumns = "l.id, l.amount, l.description, l.created_at, l.trade_date, l.settlement_date, l.counterparty_id, l.book, l.voucher_number, l.metadata, l.tags, l.rule_id"
+
+// scanLedger scans ledgerColumns followed by any extra destinations
+func scanLedger(row interface{ Scan(...interface{}) error }, l *Ledger, extra ...interface{}) error {
+	var tradeDate, settlementDate sql.NullTime
+	var counterpartyID sql.NullInt64
+	var book, voucher, metadata sql.NullString
+	var tags pq.StringArray
+	var ruleID sql.NullInt64
+	dest := append([]interface{}{&l.ID, &l.Amount, &l.Description, &l.CreatedAt, &tradeDate, &settlementDate, &counterpartyID, &book, &voucher,
+		&metadata, &tags, &ruleID}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if tradeDate.Valid {
+		l.TradeDate = &tradeDate.Time
+	}
+	if settlementDate.Valid {
+		l.SettlementDate = &settlementDate.Time
+	}
+	if counterpartyID.Valid {
+		id := int(counterpartyID.Int64)
+		l.CounterpartyID = &id
+	}
+	l.Book = book.String
+	l.VoucherNumber = voucher.String
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &l.Metadata); err != nil {
+			return fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+	l.Tags = []string(tags)
+	if ruleID.Valid {
+		id := int(ruleID.Int64)
+		l.RuleID = &id
+	}
+	return nil
+}
+
+// AuditEvent is one row of an entry's append-only audit trail
+type AuditEvent struct {
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 type LedgerRepository struct {
-	db *sql.DB
+	db         *sql.DB
+	validators []Validator
 }
 
-func NewLedgerRepository(db *sql.DB) *LedgerRepository {
-	return &LedgerRepository{db: db}
+// NewLedgerRepository returns a repository whose Create runs the given validators
+func NewLedgerRepository(db *sql.DB, validators ...Validator) *LedgerRepository {
+	return &LedgerRepository{db: db, validators: validators}
 }
 
-func (r *LedgerRepository) Create(ctx context.Context, amount float64, desc string, actor string) error {
-	tx, err := r.db.BeginTx(ctx, nil)
+// Create categorizes a new entry by the organization's rules, checks it
+// against the repository's validators, posts it and returns its id. Entries
+// screened as anomalies are flagged once posted or, if the organization holds
+// them, recorded for approval instead and reported as *EntryHeld.
+func (r *LedgerRepository) Create(ctx context.Context, e NewEntry, actor string) (int, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	result, err := tx.ExecContext(ctx,
-		"INSERT INTO ledger (amount, description) VALUES ($1, $2)",
-		amount, desc,
-	)
+	ledgerID, held, err := postEntry(ctx, tx, id.OrgID, e, r.validators, actor)
 	if err != nil {
-		return fmt.Errorf("failed to create ledger entry: %w", err)
+		return 0, err
 	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if held != nil {
+		return 0, held
+	}
+	return ledgerID, nil
+}
 
-	id, err := result.LastInsertId()
+// postEntry takes a new entry through the same steps wherever it comes from:
+// categorization rules, the pre-commit validators, anomaly screening, then
+// insertEntry. An entry the organization holds is recorded for approval
+// instead of posted, and returned as held with ledger ID 0; the caller
+// commits tx either way.
+func postEntry(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry, validators []Validator, actor string) (int, *EntryHeld, error) {
+	e, _, err := categorize(ctx, tx, orgID, e)
 	if err != nil {
-		id = 1
+		return 0, nil, err
+	}
+	// insertEntry validates too, but an entry the rules refuse is not screened
+	if err := runValidators(ctx, validators, e); err != nil {
+		return 0, nil, err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO audit_ledger (ledger_id, actor, action) VALUES ($1, $2, $3)",
-		id, actor, "INSERT",
-	)
+	reasons, settings, err := screenEntry(ctx, tx, orgID, e)
 	if err != nil {
-		return fmt.Errorf("failed to create audit log: %w", err)
+		return 0, nil, err
+	}
+	if len(reasons) > 0 && settings.Action == AnomalyHold {
+		// Check the entry could be posted at all before holding it
+		if err := e.Validate(); err != nil {
+			return 0, nil, err
+		}
+		held := heldEntry(e)
+		anomalyID, err := recordAnomaly(ctx, tx, orgID, nil, &held, reasons, actor)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, &EntryHeld{AnomalyID: anomalyID, Reasons: reasons}, nil
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	ledgerID, err := insertEntry(ctx, tx, orgID, e, nil, actor)
+	if err != nil {
+		return 0, nil, err
 	}
-	return nil
+	if len(reasons) > 0 {
+		if _, err := recordAnomaly(ctx, tx, orgID, &ledgerID, nil, reasons, actor); err != nil {
+			return 0, nil, err
+		}
+	}
+	return ledgerID, nil, nil
 }
 
 func (r *LedgerRepository) List(ctx context.Context) ([]Ledger, error) {
-	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, amount, description, created_at FROM ledger ORDER BY id")
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+ledgerColumns+" FROM ledger l WHERE l.org_id = $1 ORDER BY l.id", id.OrgID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch ledger entries: %w", err)
 	}
@@ -67,21 +170,201 @@ func (r *LedgerRepository) List(ctx context.Context) ([]Ledger, error) {
 	var result []Ledger
 	for rows.Next() {
 		var l Ledger
-		if err := rows.Scan(&l.ID, &l.Amount, &l.Description, &l.CreatedAt); err != nil {
+		if err := scanLedger(rows, &l); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 		result = append(result, l)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+
+	if err := attachPostings(ctx, tx, id.OrgID, result); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
+// Each streams live entries with their postings in id order to fn without
+// loading the whole table
+func (r *LedgerRepository) Each(ctx context.Context, fn func(Ledger) error) error {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+ledgerColumns+`,
+		        p.account, p.amount, COALESCE(p.instrument, ''), COALESCE(p.quantity, 0), `+postingDimensionsColumn+`
+		 FROM ledger l
+		 LEFT JOIN ledger_postings p ON p.org_id = l.org_id AND p.ledger_id = l.id
+		 WHERE l.org_id = $1 ORDER BY l.id, p.id`, id.OrgID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	var current *Ledger
+	for rows.Next() {
+		var l Ledger
+		var account sql.NullString
+		var p Posting
+		var amount sql.NullFloat64
+		var dims sql.NullString
+		if err := scanLedger(rows, &l, &account, &amount, &p.Instrument, &p.Quantity, &dims); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		if p.Dimensions, err = scanPostingDimensions(dims); err != nil {
+			return err
+		}
+
+		if current == nil || current.ID != l.ID {
+			if current != nil {
+				if err := fn(*current); err != nil {
+					return err
+				}
+			}
+			current = &l
+		}
+		if account.Valid {
+			p.Account, p.Amount = account.String, amount.Float64
+			current.Postings = append(current.Postings, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
+
 func (r *LedgerRepository) GetByID(ctx context.Context, id int64) (*Ledger, error) {
-	row := r.db.QueryRowContext(ctx,
-		"SELECT id, amount, description, created_at FROM ledger WHERE id=$1", id)
+	tx, ident, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx,
+		"SELECT "+ledgerColumns+" FROM ledger l WHERE l.org_id = $1 AND l.id = $2", ident.OrgID, id)
 
 	var l Ledger
-	if err := row.Scan(&l.ID, &l.Amount, &l.Description, &l.CreatedAt); err != nil {
+	if err := scanLedger(row, &l); err != nil {
 		return nil, fmt.Errorf("ledger entry not found: %w", err)
 	}
-	return &l, nil
+
+	entries := []Ledger{l}
+	if err := attachPostings(ctx, tx, ident.OrgID, entries); err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// ListAsOf reconstructs the ledger as it stood at asOf. An entry is visible once
+// its INSERT audit row exists, and each entry carries the audit events (attachments,
+// later status changes) recorded up to that instant.
+func (r *LedgerRepository) ListAsOf(ctx context.Context, asOf time.Time) ([]Ledger, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+ledgerColumns+` FROM ledger l
+		 WHERE l.org_id = $1 AND EXISTS (
+		     SELECT 1 FROM audit_ledger a
+		     WHERE a.org_id = l.org_id AND a.ledger_id = l.id AND a.action = 'INSERT' AND a.timestamp <= $2)
+		 ORDER BY l.id`, id.OrgID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Ledger{}
+	for rows.Next() {
+		var l Ledger
+		if err := scanLedger(rows, &l); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		result = append(result, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+
+	if err := attachPostings(ctx, tx, id.OrgID, result); err != nil {
+		return nil, err
+	}
+	if err := attachHistory(ctx, tx, id.OrgID, asOf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetByIDAsOf returns a single entry as it stood at asOf, or an error if it did not exist yet
+func (r *LedgerRepository) GetByIDAsOf(ctx context.Context, id int64, asOf time.Time) (*Ledger, error) {
+	tx, ident, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx,
+		`SELECT `+ledgerColumns+` FROM ledger l
+		 WHERE l.org_id = $1 AND l.id = $2 AND EXISTS (
+		     SELECT 1 FROM audit_ledger a
+		     WHERE a.org_id = l.org_id AND a.ledger_id = l.id AND a.action = 'INSERT' AND a.timestamp <= $3)`,
+		ident.OrgID, id, asOf)
+
+	var l Ledger
+	if err := scanLedger(row, &l); err != nil {
+		return nil, fmt.Errorf("ledger entry not found: %w", err)
+	}
+
+	entries := []Ledger{l}
+	if err := attachPostings(ctx, tx, ident.OrgID, entries); err != nil {
+		return nil, err
+	}
+	if err := attachHistory(ctx, tx, ident.OrgID, asOf, entries); err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// attachHistory fills in the audit events recorded up to asOf for each entry
+func attachHistory(ctx context.Context, tx *sql.Tx, orgID int, asOf time.Time, entries []Ledger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(entries))
+	index := make(map[int]int, len(entries))
+	for i, l := range entries {
+		ids[i] = int64(l.ID)
+		index[l.ID] = i
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT ledger_id, action, actor, timestamp FROM audit_ledger
+		 WHERE org_id = $1 AND ledger_id = ANY($2) AND timestamp <= $3
+		 ORDER BY timestamp, id`, orgID, pq.Array(ids), asOf)
+	if err != nil {
+		return fmt.Errorf("failed to fetch audit history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ledgerID int
+		var e AuditEvent
+		if err := rows.Scan(&ledgerID, &e.Action, &e.Actor, &e.Timestamp); err != nil {
+			return fmt.Errorf("failed to scan audit row: %w", err)
+		}
+		i := index[ledgerID]
+		entries[i].History = append(entries[i].History, e)
+	}
+	return rows.Err()
 }
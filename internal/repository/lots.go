@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ErrInsufficientLots is returned when a sale needs more quantity than the
+// account holds in open lots. Short positions are not supported.
+var ErrInsufficientLots = errors.New("insufficient open lots")
+
+// Lot relief methods for postings that reduce a position
+const (
+	LotFIFO     = "fifo"
+	LotLIFO     = "lifo"
+	LotSpecific = "specific"
+)
+
+// DefaultRealizedPnLAccount receives realized gains and losses when an entry does not name one
+const DefaultRealizedPnLAccount = "Income:RealizedPnL"
+
+// quantityEpsilon absorbs float noise when comparing instrument quantities
+const quantityEpsilon = 1e-9
+
+// openLot is a lot with the quantity and cost not yet relieved by closures
+type openLot struct {
+	ID            int
+	Remaining     float64
+	RemainingCost float64
+}
+
+// applyLots runs the lot engine for an entry that was just inserted as
+// ledgerID. Postings with a positive quantity open a lot at the posted cost;
+// postings with a negative quantity consume open lots by the entry's method
+// and book the difference between proceeds and relieved cost as a realized
+// P&L entry, so the position account is left carrying the cost of what remains.
+func applyLots(ctx context.Context, tx *sql.Tx, orgID, ledgerID int, e NewEntry, actor string) error {
+	for _, p := range e.Postings {
+		if p.Instrument == "" || p.Quantity == 0 {
+			continue
+		}
+		if err := ensureInstrument(ctx, tx, orgID, p.Instrument); err != nil {
+			return err
+		}
+
+		if p.Quantity > 0 {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO lots (org_id, ledger_id, account, instrument, quantity, cost_basis) VALUES ($1, $2, $3, $4, $5, $6)",
+				orgID, ledgerID, p.Account, p.Instrument, p.Quantity, p.Amount,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to open lot: %w", err)
+			}
+			continue
+		}
+
+		if err := closeLots(ctx, tx, orgID, ledgerID, e, p, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeLots relieves -p.Quantity from the open lots of p's account and instrument
+func closeLots(ctx context.Context, tx *sql.Tx, orgID, ledgerID int, e NewEntry, p Posting, actor string) error {
+	// Serialize sales of the same position so two sells cannot consume the same lot
+	key := fmt.Sprintf("lots:%d:%s:%s", orgID, p.Account, p.Instrument)
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", key); err != nil {
+		return fmt.Errorf("failed to lock position: %w", err)
+	}
+
+	lots, err := openLots(ctx, tx, orgID, p.Account, p.Instrument, e.lotMethod(), e.LotIDs)
+	if err != nil {
+		return err
+	}
+
+	need := -p.Quantity
+	proceeds := -p.Amount
+	type closure struct {
+		lotID    int
+		quantity float64
+		cost     float64
+	}
+	var closures []closure
+	var totalCost float64
+	for _, lot := range lots {
+		if need <= quantityEpsilon {
+			break
+		}
+		take := math.Min(need, lot.Remaining)
+		cost := lot.RemainingCost
+		if lot.Remaining-take > quantityEpsilon {
+			cost = round2(lot.RemainingCost * take / lot.Remaining)
+		}
+		closures = append(closures, closure{lotID: lot.ID, quantity: take, cost: cost})
+		totalCost += cost
+		need -= take
+	}
+	if need > quantityEpsilon {
+		return fmt.Errorf("%w: %s in %s is short by %g", ErrInsufficientLots, p.Instrument, p.Account, need)
+	}
+	totalCost = round2(totalCost)
+
+	var pnlLedgerID sql.NullInt64
+	if realized := round2(proceeds - totalCost); math.Abs(realized) >= 0.005 {
+		pnl := NewEntry{
+			Amount:      math.Abs(realized),
+			Description: fmt.Sprintf("Realized P&L on %g %s (entry %d)", -p.Quantity, p.Instrument, ledgerID),
+			Postings: []Posting{
+				{Account: p.Account, Amount: realized},
+				{Account: e.pnlAccount(), Amount: -realized},
+			},
+		}
+		// Part of the entry being posted, which has passed the validators
+		id, err := insertEntry(ctx, tx, orgID, pnl, nil, actor)
+		if err != nil {
+			return fmt.Errorf("failed to book realized P&L: %w", err)
+		}
+		pnlLedgerID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	// Proceeds are allocated to each lot in proportion to the quantity taken from it
+	var allocated float64
+	for i, c := range closures {
+		share := round2(proceeds * c.quantity / -p.Quantity)
+		if i == len(closures)-1 {
+			share = round2(proceeds - allocated)
+		}
+		allocated += share
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO lot_closures (org_id, lot_id, ledger_id, pnl_ledger_id, method, quantity, cost, proceeds)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			orgID, c.lotID, ledgerID, pnlLedgerID, e.lotMethod(), c.quantity, c.cost, share,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to close lot: %w", err)
+		}
+	}
+	return nil
+}
+
+// openLots returns the lots of a position that still have quantity, in the
+// order the method consumes them
+func openLots(ctx context.Context, tx *sql.Tx, orgID int, account, instrument, method string, lotIDs []int) ([]openLot, error) {
+	query := `SELECT l.id, l.quantity - COALESCE(SUM(c.quantity), 0), l.cost_basis - COALESCE(SUM(c.cost), 0)
+		 FROM lots l
+		 LEFT JOIN lot_closures c ON c.org_id = l.org_id AND c.lot_id = l.id
+		 WHERE l.org_id = $1 AND l.account = $2 AND l.instrument = $3`
+	args := []interface{}{orgID, account, instrument}
+	if method == LotSpecific {
+		ids := make([]int64, len(lotIDs))
+		for i, id := range lotIDs {
+			ids[i] = int64(id)
+		}
+		query += " AND l.id = ANY($4)"
+		args = append(args, pq.Array(ids))
+	}
+	query += " GROUP BY l.id HAVING l.quantity - COALESCE(SUM(c.quantity), 0) > 0 ORDER BY l.opened_at, l.id"
+	if method == LotLIFO {
+		query = strings.Replace(query, "ORDER BY l.opened_at, l.id", "ORDER BY l.opened_at DESC, l.id DESC", 1)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch open lots: %w", err)
+	}
+	defer rows.Close()
+
+	var lots []openLot
+	for rows.Next() {
+		var l openLot
+		if err := rows.Scan(&l.ID, &l.Remaining, &l.RemainingCost); err != nil {
+			return nil, fmt.Errorf("failed to scan lot: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch open lots: %w", err)
+	}
+
+	if method != LotSpecific {
+		return lots, nil
+	}
+
+	// Specific identification consumes lots in the order the caller listed them
+	byID := make(map[int]openLot, len(lots))
+	for _, l := range lots {
+		byID[l.ID] = l
+	}
+	ordered := make([]openLot, 0, len(lotIDs))
+	added := make(map[int]bool, len(lotIDs))
+	for _, id := range lotIDs {
+		l, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: lot %d is not open in %s %s", ErrInsufficientLots, id, account, instrument)
+		}
+		if added[id] {
+			continue
+		}
+		added[id] = true
+		ordered = append(ordered, l)
+	}
+	return ordered, nil
+}
+
+// ensureInstrument registers an instrument on first use
+func ensureInstrument(ctx context.Context, tx *sql.Tx, orgID int, symbol string) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO instruments (org_id, symbol) VALUES ($1, $2) ON CONFLICT (org_id, symbol) DO NOTHING",
+		orgID, symbol,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register instrument: %w", err)
+	}
+	return nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInstrumentExists is returned when registering a symbol the organization already has
+var ErrInstrumentExists = errors.New("instrument already exists")
+
+// Instrument is a security that positions are held in
+type Instrument struct {
+	ID         int       `json:"id"`
+	Symbol     string    `json:"symbol"`
+	Name       string    `json:"name,omitempty"`
+	AssetClass string    `json:"asset_class,omitempty"`
+	Currency   string    `json:"currency,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Position is the open quantity and remaining cost of an instrument in an account
+type Position struct {
+	Account     string  `json:"account"`
+	Instrument  string  `json:"instrument"`
+	Quantity    float64 `json:"quantity"`
+	CostBasis   float64 `json:"cost_basis"`
+	AverageCost float64 `json:"average_cost"`
+	OpenLots    int     `json:"open_lots"`
+	RealizedPnL float64 `json:"realized_pnl"`
+}
+
+// Lot is an acquisition with what is left of it after closures
+type Lot struct {
+	ID            int       `json:"id"`
+	LedgerID      int       `json:"ledger_id"`
+	Account       string    `json:"account"`
+	Instrument    string    `json:"instrument"`
+	Quantity      float64   `json:"quantity"`
+	CostBasis     float64   `json:"cost_basis"`
+	Remaining     float64   `json:"remaining_quantity"`
+	RemainingCost float64   `json:"remaining_cost"`
+	OpenedAt      time.Time `json:"opened_at"`
+}
+
+type PositionRepository struct {
+	db *sql.DB
+}
+
+func NewPositionRepository(db *sql.DB) *PositionRepository {
+	return &PositionRepository{db: db}
+}
+
+// CreateInstrument registers an instrument with its descriptive fields
+func (r *PositionRepository) CreateInstrument(ctx context.Context, in Instrument) (*Instrument, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO instruments (org_id, symbol, name, asset_class, currency)
+		 VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''))
+		 RETURNING id, created_at`,
+		id.OrgID, in.Symbol, in.Name, in.AssetClass, in.Currency,
+	).Scan(&in.ID, &in.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrInstrumentExists
+		}
+		return nil, fmt.Errorf("failed to create instrument: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &in, nil
+}
+
+// Instruments lists the organization's instruments by symbol
+func (r *PositionRepository) Instruments(ctx context.Context) ([]Instrument, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, symbol, COALESCE(name, ''), COALESCE(asset_class, ''), COALESCE(currency, ''), created_at
+		 FROM instruments WHERE org_id = $1 ORDER BY symbol`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch instruments: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Instrument{}
+	for rows.Next() {
+		var in Instrument
+		if err := rows.Scan(&in.ID, &in.Symbol, &in.Name, &in.AssetClass, &in.Currency, &in.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan instrument: %w", err)
+		}
+		result = append(result, in)
+	}
+	return result, rows.Err()
+}
+
+// Positions aggregates lots per account and instrument. Fully closed
+// positions are included while they carry realized P&L. A positive level
+// rolls accounts up to that depth of the chart of accounts.
+func (r *PositionRepository) Positions(ctx context.Context, level int) ([]Position, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`WITH lot_state AS (
+		     SELECT l.account, l.instrument,
+		            l.quantity - COALESCE(SUM(c.quantity), 0) AS remaining,
+		            l.cost_basis - COALESCE(SUM(c.cost), 0) AS remaining_cost,
+		            COALESCE(SUM(c.proceeds - c.cost), 0) AS realized
+		     FROM lots l
+		     LEFT JOIN lot_closures c ON c.org_id = l.org_id AND c.lot_id = l.id
+		     WHERE l.org_id = $1
+		     GROUP BY l.id
+		 )
+		 SELECT account, instrument, SUM(remaining), SUM(remaining_cost),
+		        COUNT(*) FILTER (WHERE remaining > 0), SUM(realized)
+		 FROM lot_state
+		 GROUP BY account, instrument
+		 ORDER BY account, instrument`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch positions: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Position{}
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.Account, &p.Instrument, &p.Quantity, &p.CostBasis, &p.OpenLots, &p.RealizedPnL); err != nil {
+			return nil, fmt.Errorf("failed to scan position: %w", err)
+		}
+		if p.Quantity > quantityEpsilon {
+			p.AverageCost = p.CostBasis / p.Quantity
+		}
+		result = append(result, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if level <= 0 {
+		return result, nil
+	}
+
+	tree, err := loadAccountTree(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	return rollUpPositions(tree, result, level), nil
+}
+
+// rollUpPositions merges positions whose accounts share an ancestor at level
+func rollUpPositions(tree accountTree, positions []Position, level int) []Position {
+	type key struct{ account, instrument string }
+	index := map[key]int{}
+	result := []Position{}
+	for _, p := range positions {
+		p.Account = tree.at(p.Account, level)
+		k := key{p.Account, p.Instrument}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(result)
+			result = append(result, p)
+			continue
+		}
+		result[i].Quantity += p.Quantity
+		result[i].CostBasis += p.CostBasis
+		result[i].OpenLots += p.OpenLots
+		result[i].RealizedPnL += p.RealizedPnL
+	}
+	for i := range result {
+		result[i].AverageCost = 0
+		if result[i].Quantity > quantityEpsilon {
+			result[i].AverageCost = result[i].CostBasis / result[i].Quantity
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Account != result[j].Account {
+			return result[i].Account < result[j].Account
+		}
+		return result[i].Instrument < result[j].Instrument
+	})
+	return result
+}
+
+// OpenLots lists lots that still hold quantity, optionally narrowed to an
+// account and/or instrument, in FIFO order
+func (r *PositionRepository) OpenLots(ctx context.Context, account, instrument string) ([]Lot, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT l.id, l.ledger_id, l.account, l.instrument, l.quantity, l.cost_basis,
+		        l.quantity - COALESCE(SUM(c.quantity), 0), l.cost_basis - COALESCE(SUM(c.cost), 0), l.opened_at
+		 FROM lots l
+		 LEFT JOIN lot_closures c ON c.org_id = l.org_id AND c.lot_id = l.id
+		 WHERE l.org_id = $1 AND ($2 = '' OR l.account = $2) AND ($3 = '' OR l.instrument = $3)
+		 GROUP BY l.id
+		 HAVING l.quantity - COALESCE(SUM(c.quantity), 0) > 0
+		 ORDER BY l.account, l.instrument, l.opened_at, l.id`,
+		id.OrgID, account, instrument)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch lots: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Lot{}
+	for rows.Next() {
+		var l Lot
+		if err := rows.Scan(&l.ID, &l.LedgerID, &l.Account, &l.Instrument, &l.Quantity, &l.CostBasis,
+			&l.Remaining, &l.RemainingCost, &l.OpenedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan lot: %w", err)
+		}
+		result = append(result, l)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"ledger-go-system/internal/query"
+)
+
+// ErrQueryTimeout is returned when a ledger query runs longer than queryTimeout
+var ErrQueryTimeout = errors.New("query took too long; narrow it down with where or fewer groups")
+
+// queryTimeout bounds how long a ledger query may run
+const queryTimeout = "5s"
+
+// QueryResult is the result of a ledger query, one value per column in each
+// row. Truncated is set when more rows matched than the query's limit.
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+}
+
+// Query runs a planned ledger query over the live entries of the active
+// organization, in a read-only transaction with a statement timeout. A query
+// that could match archived entries, because it does not limit date to on or
+// after the end of the latest archived period, is refused with ErrArchivedRange.
+func (r *LedgerRepository) Query(ctx context.Context, plan *query.Plan) (*QueryResult, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	archived, err := archivedBefore(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if archived != nil && (plan.From == nil || plan.From.Before(*archived)) {
+		day := archived.Format("2006-01-02")
+		return nil, fmt.Errorf("%w: entries created before %s are archived; add where date >= %s", ErrArchivedRange, day, day)
+	}
+
+	if _, err := tx.ExecContext(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		return nil, fmt.Errorf("failed to make transaction read-only: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "SET LOCAL statement_timeout = '"+queryTimeout+"'"); err != nil {
+		return nil, fmt.Errorf("failed to set query timeout: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, plan.SQL, append([]interface{}{id.OrgID}, plan.Args...)...)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer rows.Close()
+
+	result := &QueryResult{Rows: [][]interface{}{}}
+	for _, c := range plan.Columns {
+		result.Columns = append(result.Columns, c.Name)
+	}
+	for rows.Next() {
+		if len(result.Rows) == plan.Limit {
+			result.Truncated = true
+			break
+		}
+
+		dest := make([]interface{}, len(plan.Columns))
+		for i, c := range plan.Columns {
+			if c.Number {
+				dest[i] = new(sql.NullFloat64)
+			} else {
+				dest[i] = new(sql.NullString)
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan query row: %w", err)
+		}
+
+		row := make([]interface{}, len(dest))
+		for i, d := range dest {
+			switch v := d.(type) {
+			case *sql.NullFloat64:
+				if v.Valid {
+					row[i] = v.Float64
+				}
+			case *sql.NullString:
+				if v.Valid {
+					row[i] = v.String
+				}
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(err)
+	}
+	return result, nil
+}
+
+// queryError maps a statement timeout to ErrQueryTimeout
+func queryError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "57014" {
+		return ErrQueryTimeout
+	}
+	return fmt.Errorf("failed to run query: %w", err)
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"ledger-go-system/internal/identity"
+)
+
+// ErrNoTenant is returned when a repository is called without an active organization
+var ErrNoTenant = errors.New("no active organization in context")
+
+// dbRoles maps application roles to the Postgres roles created in schema.sql.
+// Role names cannot be bound as query parameters, so only these literals are
+// ever interpolated into SET ROLE.
+var dbRoles = map[string]string{
+	"admin":  "ledger_admin",
+	"viewer": "ledger_viewer",
+}
+
+// beginScopedTx starts a transaction that runs as the caller. Every
+// tenant-scoped query goes through it:
+//   - the org, user and role are taken from the request identity, never from arguments
+//   - SET LOCAL ROLE switches to the caller's database role, so table privileges
+//     (e.g. viewer has no INSERT on ledger) are enforced by Postgres itself
+//   - app.org_id, app.user_id and app.role are published for the row-level
+//     security policies and column defaults in schema.sql
+//
+// All settings are transaction-local and vanish when the connection returns to the pool.
+func beginScopedTx(ctx context.Context, db *sql.DB) (*sql.Tx, identity.Identity, error) {
+	id, err := identityOf(ctx)
+	if err != nil {
+		return nil, id, err
+	}
+
+	dbRole, ok := dbRoles[id.Role]
+	if !ok {
+		return nil, id, fmt.Errorf("no database role for application role %q", id.Role)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, id, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+dbRole); err != nil {
+		tx.Rollback()
+		return nil, id, fmt.Errorf("failed to assume database role: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`SELECT set_config('app.org_id', $1, true),
+		        set_config('app.user_id', $2, true),
+		        set_config('app.role', $3, true)`,
+		strconv.Itoa(id.OrgID), strconv.Itoa(id.UserID), id.Role,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, id, fmt.Errorf("failed to set session scope: %w", err)
+	}
+	return tx, id, nil
+}
+
+// identityOf returns the request identity, failing closed when no tenant is set
+func identityOf(ctx context.Context) (identity.Identity, error) {
+	id, ok := identity.FromContext(ctx)
+	if !ok || id.OrgID == 0 {
+		return id, ErrNoTenant
+	}
+	return id, nil
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidSearch is returned for a search query that cannot be parsed
+var ErrInvalidSearch = errors.New("invalid search query")
+
+// searchConfig is the text search configuration ledger.search_vector is built with
+const searchConfig = "english"
+
+// searchHighlight marks matched terms in highlighted text
+const searchHighlight = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+
+// SearchHighlights are the entry's description and metadata with matched terms
+// wrapped in <mark></mark>
+type SearchHighlights struct {
+	Description string            `json:"description"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// SearchResult is an entry matching a search, best matches first
+type SearchResult struct {
+	Ledger
+	Rank       float64          `json:"rank"`
+	Highlights SearchHighlights `json:"highlights"`
+}
+
+// Search finds live entries whose description or metadata values match q,
+// ranked by relevance. q is a list of terms that must all match:
+//
+//	"wire transfer"  a phrase, the words adjacent and in order
+//	pay*             a prefix
+//	-refund          a term that must not match
+//	a OR b           either term
+//
+// Words are stemmed, so invoice also matches invoices. Archived entries are
+// not searched.
+func (r *LedgerRepository) Search(ctx context.Context, q string, limit, offset int) ([]SearchResult, error) {
+	expr, args, err := parseSearch(q, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	args = append([]interface{}{id.OrgID}, args...)
+	args = append(args, limit, offset)
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+ledgerColumns+`, ts_rank_cd(l.search_vector, q.query),
+		        ts_headline('`+searchConfig+`', l.description, q.query, '`+searchHighlight+`'),
+		        ts_headline('`+searchConfig+`', l.metadata, q.query, '`+searchHighlight+`')
+		 FROM ledger l, (SELECT `+expr+` AS query) q
+		 WHERE l.org_id = $1 AND l.search_vector @@ q.query
+		 ORDER BY ts_rank_cd(l.search_vector, q.query) DESC, l.id DESC
+		 LIMIT $`+fmt.Sprint(len(args)-1)+` OFFSET $`+fmt.Sprint(len(args)),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search ledger: %w", err)
+	}
+	defer rows.Close()
+
+	result := []SearchResult{}
+	for rows.Next() {
+		var s SearchResult
+		var metadata []byte
+		if err := scanLedger(rows, &s.Ledger, &s.Rank, &s.Highlights.Description, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &s.Highlights.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode highlighted metadata: %w", err)
+			}
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// parseSearch turns a search query into a tsquery expression over
+// placeholders numbered from first, with their arguments. Only placeholders
+// and operators are written into the SQL.
+func parseSearch(q string, first int) (string, []interface{}, error) {
+	var expr string
+	var args []interface{}
+	or := false
+
+	term := func(fn, text string, negate bool) {
+		args = append(args, text)
+		t := fmt.Sprintf("%s('%s', $%d)", fn, searchConfig, first+len(args)-1)
+		if negate {
+			t = "!!" + t
+		}
+		switch {
+		case expr == "":
+			expr = t
+		case or:
+			expr = "(" + expr + " || " + t + ")"
+		default:
+			expr = "(" + expr + " && " + t + ")"
+		}
+		or = false
+	}
+
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		negate := false
+		if rest[0] == '-' {
+			negate = true
+			rest = rest[1:]
+		}
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return "", nil, fmt.Errorf("%w: unterminated phrase", ErrInvalidSearch)
+			}
+			if phrase := strings.TrimSpace(rest[1 : end+1]); phrase != "" {
+				term("phraseto_tsquery", phrase, negate)
+			}
+			rest = strings.TrimSpace(rest[end+2:])
+			continue
+		}
+
+		word := rest
+		if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			word, rest = rest[:i], strings.TrimSpace(rest[i:])
+		} else {
+			rest = ""
+		}
+
+		switch {
+		case word == "OR" && !negate:
+			if expr == "" {
+				return "", nil, fmt.Errorf("%w: OR needs a term on each side", ErrInvalidSearch)
+			}
+			or = true
+		case strings.HasSuffix(word, "*"):
+			// A prefix is reduced to its letters and digits so it cannot carry tsquery syntax
+			prefix := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, word)
+			if prefix == "" {
+				return "", nil, fmt.Errorf("%w: empty prefix", ErrInvalidSearch)
+			}
+			term("to_tsquery", prefix+":*", negate)
+		case word != "":
+			term("plainto_tsquery", word, negate)
+		}
+	}
+
+	if expr == "" {
+		return "", nil, fmt.Errorf("%w: no search terms", ErrInvalidSearch)
+	}
+	if or {
+		return "", nil, fmt.Errorf("%w: OR needs a term on each side", ErrInvalidSearch)
+	}
+	return expr, args, nil
+}
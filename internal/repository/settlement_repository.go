@@ -0,0 +1,359 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ledger-go-system/internal/archive"
+	"ledger-go-system/internal/calendar"
+)
+
+// Settlement statuses. An entry with a settlement date starts pending and
+// moves to settled or failed; a failed settlement can be retried (failed
+// again) or settled, and settled is final.
+const (
+	SettlementPending = "pending"
+	SettlementSettled = "settled"
+	SettlementFailed  = "failed"
+)
+
+// ErrInvalidTransition is returned for a status change the lifecycle does not allow
+var ErrInvalidTransition = errors.New("invalid settlement transition")
+
+// ErrNoSettlement is returned for entries that were booked without a settlement date
+var ErrNoSettlement = errors.New("entry has no settlement date")
+
+var settlementTransitions = map[string][]string{
+	SettlementPending: {SettlementSettled, SettlementFailed},
+	SettlementFailed:  {SettlementSettled, SettlementFailed},
+}
+
+// SettlementEvent is one append-only status change of an entry
+type SettlementEvent struct {
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Settlement is an entry's settlement lifecycle
+type Settlement struct {
+	LedgerID       int               `json:"ledger_id"`
+	TradeDate      *time.Time        `json:"trade_date,omitempty"`
+	SettlementDate time.Time         `json:"settlement_date"`
+	Status         string            `json:"status"`
+	Events         []SettlementEvent `json:"events"`
+}
+
+// UnsettledEntry is a pending or failed settlement with its age in business days
+type UnsettledEntry struct {
+	LedgerID       int        `json:"ledger_id"`
+	Description    string     `json:"description"`
+	Amount         float64    `json:"amount"`
+	TradeDate      *time.Time `json:"trade_date,omitempty"`
+	SettlementDate time.Time  `json:"settlement_date"`
+	Status         string     `json:"status"`
+	Reason         string     `json:"reason,omitempty"`
+	DaysPastDue    int        `json:"days_past_due"`
+	Bucket         string     `json:"bucket"`
+}
+
+// AgingBucket totals the unsettled entries of one age band
+type AgingBucket struct {
+	Bucket string  `json:"bucket"`
+	Count  int     `json:"count"`
+	Amount float64 `json:"amount"`
+}
+
+// AgingReport lists everything unsettled as of a date, aged in business days past the settlement date
+type AgingReport struct {
+	AsOf    time.Time        `json:"as_of"`
+	Entries []UnsettledEntry `json:"entries"`
+	Buckets []AgingBucket    `json:"buckets"`
+}
+
+// agingBuckets are the report's age bands, in order
+var agingBuckets = []string{"not_due", "due", "1-2", "3-5", "6-10", "11+"}
+
+// SettlementRepository reads archived entries through archives: their
+// settlement events stay in Postgres, their dates and amounts move to segments
+type SettlementRepository struct {
+	db       *sql.DB
+	archives *ArchiveRepository
+}
+
+func NewSettlementRepository(db *sql.DB, archives *archive.Store) *SettlementRepository {
+	return &SettlementRepository{db: db, archives: NewArchiveRepository(db, archives)}
+}
+
+// Transition records a status change for an entry, checking it against the
+// current status. The change is also written to the entry's audit trail.
+func (r *SettlementRepository) Transition(ctx context.Context, ledgerID int64, status, reason, actor string) (*SettlementEvent, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize transitions of one entry so two callers cannot both move it out of pending
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('settlement'), $1)", ledgerID); err != nil {
+		return nil, fmt.Errorf("failed to lock settlement: %w", err)
+	}
+
+	current, err := currentSettlementStatus(ctx, tx, id.OrgID, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, next := range settlementTransitions[current] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, current, status)
+	}
+
+	ev := &SettlementEvent{Status: status, Reason: reason, Actor: actor}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO settlement_events (org_id, ledger_id, status, reason, actor)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING created_at`,
+		id.OrgID, ledgerID, status, reason, actor,
+	).Scan(&ev.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record settlement status: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_ledger (org_id, ledger_id, actor, action) VALUES ($1, $2, $3, $4)",
+		id.OrgID, ledgerID, actor, "SETTLEMENT_"+strings.ToUpper(status),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return ev, nil
+}
+
+// Get returns an entry's settlement dates, current status and status history
+func (r *SettlementRepository) Get(ctx context.Context, ledgerID int64) (*Settlement, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s := &Settlement{LedgerID: int(ledgerID), Events: []SettlementEvent{}}
+	var tradeDate, settlementDate sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT trade_date, settlement_date FROM ledger WHERE org_id = $1 AND id = $2", id.OrgID, ledgerID,
+	).Scan(&tradeDate, &settlementDate)
+	if err == sql.ErrNoRows {
+		l, archiveErr := r.archives.GetByID(ctx, ledgerID)
+		if archiveErr != nil {
+			return nil, archiveErr
+		}
+		if l.TradeDate != nil {
+			tradeDate = sql.NullTime{Time: *l.TradeDate, Valid: true}
+		}
+		if l.SettlementDate != nil {
+			settlementDate = sql.NullTime{Time: *l.SettlementDate, Valid: true}
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ledger entry not found: %w", err)
+	}
+	if !settlementDate.Valid {
+		return nil, ErrNoSettlement
+	}
+	s.SettlementDate = settlementDate.Time
+	if tradeDate.Valid {
+		s.TradeDate = &tradeDate.Time
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT status, COALESCE(reason, ''), actor, created_at FROM settlement_events
+		 WHERE org_id = $1 AND ledger_id = $2 ORDER BY id`, id.OrgID, ledgerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch settlement events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev SettlementEvent
+		if err := rows.Scan(&ev.Status, &ev.Reason, &ev.Actor, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan settlement event: %w", err)
+		}
+		s.Events = append(s.Events, ev)
+		s.Status = ev.Status
+	}
+	return s, rows.Err()
+}
+
+// Aging reports entries that were pending or failed at the end of asOf, aged
+// in business days past their settlement date on cal. Archived entries have
+// all settled, but may have been unsettled on an earlier asOf; they are read
+// back from their segments.
+func (r *SettlementRepository) Aging(ctx context.Context, asOf time.Time, cal *calendar.Calendar) (*AgingReport, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	day := calendar.Day(asOf)
+	endOfDay := day.Add(24*time.Hour - time.Microsecond)
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT l.id, l.description, l.amount, l.trade_date, l.settlement_date, e.status, COALESCE(e.reason, '')
+		 FROM ledger l
+		 JOIN LATERAL (
+		     SELECT status, reason FROM settlement_events s
+		     WHERE s.org_id = l.org_id AND s.ledger_id = l.id AND s.created_at <= $2
+		     ORDER BY s.id DESC LIMIT 1
+		 ) e ON true
+		 WHERE l.org_id = $1 AND l.settlement_date IS NOT NULL AND e.status <> $3`, id.OrgID, endOfDay, SettlementSettled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch unsettled entries: %w", err)
+	}
+	var entries []UnsettledEntry
+	for rows.Next() {
+		var u UnsettledEntry
+		var tradeDate sql.NullTime
+		if err := rows.Scan(&u.LedgerID, &u.Description, &u.Amount, &tradeDate, &u.SettlementDate, &u.Status, &u.Reason); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan unsettled entry: %w", err)
+		}
+		if tradeDate.Valid {
+			u.TradeDate = &tradeDate.Time
+		}
+		entries = append(entries, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch unsettled entries: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`SELECT i.ledger_id, e.status, COALESCE(e.reason, '')
+		 FROM ledger_archive_index i
+		 JOIN LATERAL (
+		     SELECT status, reason FROM settlement_events s
+		     WHERE s.org_id = i.org_id AND s.ledger_id = i.ledger_id AND s.created_at <= $2
+		     ORDER BY s.id DESC LIMIT 1
+		 ) e ON true
+		 WHERE i.org_id = $1 AND e.status <> $3`, id.OrgID, endOfDay, SettlementSettled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived unsettled entries: %w", err)
+	}
+	var archived []UnsettledEntry
+	for rows.Next() {
+		var u UnsettledEntry
+		if err := rows.Scan(&u.LedgerID, &u.Status, &u.Reason); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan unsettled entry: %w", err)
+		}
+		archived = append(archived, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch archived unsettled entries: %w", err)
+	}
+	for _, u := range archived {
+		l, err := r.archives.GetByID(ctx, int64(u.LedgerID))
+		if err != nil {
+			return nil, err
+		}
+		if l.SettlementDate == nil {
+			continue
+		}
+		u.Description, u.Amount, u.TradeDate, u.SettlementDate = l.Description, l.Amount, l.TradeDate, *l.SettlementDate
+		entries = append(entries, u)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].SettlementDate.Equal(entries[j].SettlementDate) {
+			return entries[i].SettlementDate.Before(entries[j].SettlementDate)
+		}
+		return entries[i].LedgerID < entries[j].LedgerID
+	})
+
+	report := &AgingReport{AsOf: day, Entries: []UnsettledEntry{}}
+	totals := make(map[string]*AgingBucket, len(agingBuckets))
+	for _, b := range agingBuckets {
+		report.Buckets = append(report.Buckets, AgingBucket{Bucket: b})
+	}
+	for i := range report.Buckets {
+		totals[report.Buckets[i].Bucket] = &report.Buckets[i]
+	}
+
+	for _, u := range entries {
+		u.DaysPastDue = cal.BusinessDaysBetween(u.SettlementDate, day)
+		switch {
+		case day.Before(calendar.Day(u.SettlementDate)):
+			u.Bucket = "not_due"
+		case u.DaysPastDue == 0:
+			u.Bucket = "due"
+		case u.DaysPastDue <= 2:
+			u.Bucket = "1-2"
+		case u.DaysPastDue <= 5:
+			u.Bucket = "3-5"
+		case u.DaysPastDue <= 10:
+			u.Bucket = "6-10"
+		default:
+			u.Bucket = "11+"
+		}
+
+		report.Entries = append(report.Entries, u)
+		totals[u.Bucket].Count++
+		totals[u.Bucket].Amount = round2(totals[u.Bucket].Amount + u.Amount)
+	}
+	return report, nil
+}
+
+// currentSettlementStatus returns the latest status of an entry that has a
+// settlement date. An archived entry is known by its archive index row, and
+// had a settlement date if it has settlement events.
+func currentSettlementStatus(ctx context.Context, tx *sql.Tx, orgID int, ledgerID int64) (string, error) {
+	var settlementDate sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT settlement_date FROM ledger WHERE org_id = $1 AND id = $2", orgID, ledgerID,
+	).Scan(&settlementDate)
+	archived := false
+	if err == sql.ErrNoRows {
+		err = tx.QueryRowContext(ctx,
+			"SELECT true FROM ledger_archive_index WHERE org_id = $1 AND ledger_id = $2", orgID, ledgerID,
+		).Scan(&archived)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ledger entry not found: %w", err)
+	}
+	if !settlementDate.Valid && !archived {
+		return "", ErrNoSettlement
+	}
+
+	var status string
+	err = tx.QueryRowContext(ctx,
+		"SELECT status FROM settlement_events WHERE org_id = $1 AND ledger_id = $2 ORDER BY id DESC LIMIT 1",
+		orgID, ledgerID,
+	).Scan(&status)
+	if err == sql.ErrNoRows && archived {
+		return "", ErrNoSettlement
+	}
+	if err == sql.ErrNoRows {
+		return SettlementPending, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch settlement status: %w", err)
+	}
+	return status, nil
+}
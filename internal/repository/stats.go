@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Stats groupings
+const (
+	StatsByDay   = "day"
+	StatsByWeek  = "week"
+	StatsByMonth = "month"
+	StatsByTag   = "tag"
+)
+
+// StatsQuery selects live entries created from..to (either may be nil) and
+// groups them. Days, weeks (from Monday) and months are those of Location.
+type StatsQuery struct {
+	GroupBy  string
+	From     *time.Time
+	To       *time.Time
+	Location *time.Location
+}
+
+// StatsSummary aggregates entry amounts
+type StatsSummary struct {
+	Count   int     `json:"count"`
+	Total   float64 `json:"total"`
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// StatsBucket summarizes one group: Key is the first day of a day, week or
+// month as YYYY-MM-DD, or a tag, "" collecting untagged entries
+type StatsBucket struct {
+	Key string `json:"key"`
+	StatsSummary
+}
+
+// Stats summarizes entries overall and by group. An entry with several tags
+// counts in each of their buckets but once overall. Buckets without entries
+// are left out.
+type Stats struct {
+	GroupBy  string        `json:"group_by"`
+	Timezone string        `json:"timezone"`
+	From     *time.Time    `json:"from,omitempty"`
+	To       *time.Time    `json:"to,omitempty"`
+	Buckets  []StatsBucket `json:"buckets"`
+	StatsSummary
+}
+
+// statsUnits are the date_trunc units of the time groupings
+var statsUnits = map[string]string{
+	StatsByDay:   "day",
+	StatsByWeek:  "week",
+	StatsByMonth: "month",
+}
+
+// statsAggregates computes a StatsSummary over l.amount
+const statsAggregates = `COUNT(*), COALESCE(SUM(l.amount), 0)::float8, COALESCE(AVG(l.amount), 0)::float8,
+		        COALESCE(MIN(l.amount), 0)::float8, COALESCE(MAX(l.amount), 0)::float8`
+
+// Stats aggregates the amounts of live entries in SQL. A range that could
+// include archived entries, starting before the end of the latest archived
+// period or not bounded below, is refused with ErrArchivedRange.
+func (r *LedgerRepository) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	location := q.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	var key, from string
+	args := []interface{}{0, q.From, q.To}
+	if unit, ok := statsUnits[q.GroupBy]; ok {
+		// created_at holds UTC wall-clock time, since db.New runs every session
+		// with TimeZone = 'UTC'; the bucket is taken on the local wall clock
+		args = append(args, location.String())
+		key = `to_char(date_trunc('` + unit + `', (l.created_at AT TIME ZONE 'UTC') AT TIME ZONE $4), 'YYYY-MM-DD')`
+		from = "ledger l"
+	} else if q.GroupBy == StatsByTag {
+		key = "t.tag"
+		from = "ledger l CROSS JOIN LATERAL unnest(CASE WHEN cardinality(l.tags) > 0 THEN l.tags ELSE ARRAY[''] END) AS t(tag)"
+	} else {
+		return nil, fmt.Errorf("unsupported stats grouping %q", q.GroupBy)
+	}
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	args[0] = id.OrgID
+
+	archived, err := archivedBefore(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if archived != nil && (q.From == nil || q.From.Before(*archived)) {
+		return nil, fmt.Errorf("%w: entries created before %s are archived, so from must be on or after it",
+			ErrArchivedRange, archived.Format("2006-01-02"))
+	}
+
+	const where = `l.org_id = $1 AND ($2::timestamp IS NULL OR l.created_at >= $2) AND ($3::timestamp IS NULL OR l.created_at <= $3)`
+
+	result := &Stats{GroupBy: q.GroupBy, Timezone: location.String(), From: q.From, To: q.To, Buckets: []StatsBucket{}}
+	err = tx.QueryRowContext(ctx, `SELECT `+statsAggregates+` FROM ledger l WHERE `+where, args[:3]...).
+		Scan(&result.Count, &result.Total, &result.Average, &result.Min, &result.Max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stats: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+key+`, `+statsAggregates+` FROM `+from+` WHERE `+where+` GROUP BY 1 ORDER BY 1`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stats buckets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b StatsBucket
+		if err := rows.Scan(&b.Key, &b.Count, &b.Total, &b.Average, &b.Min, &b.Max); err != nil {
+			return nil, fmt.Errorf("failed to scan stats bucket: %w", err)
+		}
+		result.Buckets = append(result.Buckets, b)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tax return periods
+const (
+	TaxPeriodMonth   = "month"
+	TaxPeriodQuarter = "quarter"
+)
+
+// ErrUnknownTaxCode is returned when a posting names a tax code the organization does not have
+var ErrUnknownTaxCode = errors.New("unknown tax code")
+
+// ErrNoTaxRate is returned when a tax code has no rate in effect on the entry's tax date
+var ErrNoTaxRate = errors.New("no tax rate in effect")
+
+// ErrTaxCodeExists is returned when creating a code the organization already has
+var ErrTaxCodeExists = errors.New("tax code already exists")
+
+// TaxRate is the rate of a code from EffectiveFrom on; 0.2 is 20%
+type TaxRate struct {
+	Rate          float64   `json:"rate"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TaxCode is a VAT/GST code and the account its tax is posted to
+type TaxCode struct {
+	ID         int       `json:"id"`
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	TaxAccount string    `json:"tax_account"`
+	Rates      []TaxRate `json:"rates"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TaxReturnLine totals one code for one period. Base and tax keep the sign of
+// the postings: credits (sales, output tax) are negative, debits (purchases,
+// input tax) positive.
+type TaxReturnLine struct {
+	Period string  `json:"period"`
+	Code   string  `json:"code"`
+	Base   float64 `json:"base"`
+	Tax    float64 `json:"tax"`
+	Lines  int     `json:"lines"`
+}
+
+// TaxReturn summarizes taxable base and tax by code and period. NetTax is
+// positive when more input tax was reclaimed than output tax charged.
+type TaxReturn struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Period string          `json:"period"`
+	Lines  []TaxReturnLine `json:"lines"`
+	NetTax float64         `json:"net_tax"`
+}
+
+// taxLine is the tax generated for one posting, recorded with the entry
+type taxLine struct {
+	codeID  int
+	account string
+	date    time.Time
+	rate    float64
+	base    float64
+	tax     float64
+}
+
+type TaxRepository struct {
+	db *sql.DB
+}
+
+func NewTaxRepository(db *sql.DB) *TaxRepository {
+	return &TaxRepository{db: db}
+}
+
+// CreateCode registers a tax code with its first rate
+func (r *TaxRepository) CreateCode(ctx context.Context, c TaxCode, rate TaxRate) (*TaxCode, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO tax_codes (org_id, code, name, tax_account) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		id.OrgID, c.Code, c.Name, c.TaxAccount,
+	).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrTaxCodeExists
+		}
+		return nil, fmt.Errorf("failed to create tax code: %w", err)
+	}
+
+	if err := insertTaxRate(ctx, tx, id.OrgID, c.ID, &rate); err != nil {
+		return nil, err
+	}
+	c.Rates = []TaxRate{rate}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &c, nil
+}
+
+// AddRate appends a rate to a code, in effect from its EffectiveFrom
+func (r *TaxRepository) AddRate(ctx context.Context, code string, rate TaxRate) (*TaxRate, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var codeID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM tax_codes WHERE org_id = $1 AND code = $2", id.OrgID, code).Scan(&codeID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTaxCode, code)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tax code: %w", err)
+	}
+
+	if err := insertTaxRate(ctx, tx, id.OrgID, codeID, &rate); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &rate, nil
+}
+
+// Codes lists the organization's tax codes with their rates
+func (r *TaxRepository) Codes(ctx context.Context) ([]TaxCode, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT c.id, c.code, c.name, c.tax_account, c.created_at, r.rate, r.effective_from, r.created_by, r.created_at
+		 FROM tax_codes c JOIN tax_rates r ON r.org_id = c.org_id AND r.tax_code_id = c.id
+		 WHERE c.org_id = $1 ORDER BY c.code, r.effective_from, r.id`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tax codes: %w", err)
+	}
+	defer rows.Close()
+
+	result := []TaxCode{}
+	for rows.Next() {
+		var c TaxCode
+		var rate TaxRate
+		if err := rows.Scan(&c.ID, &c.Code, &c.Name, &c.TaxAccount, &c.CreatedAt,
+			&rate.Rate, &rate.EffectiveFrom, &rate.CreatedBy, &rate.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tax code: %w", err)
+		}
+		if n := len(result); n > 0 && result[n-1].ID == c.ID {
+			result[n-1].Rates = append(result[n-1].Rates, rate)
+			continue
+		}
+		c.Rates = []TaxRate{rate}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// Return totals tax lines dated from..to (inclusive) by period and code
+func (r *TaxRepository) Return(ctx context.Context, from, to time.Time, period string) (*TaxReturn, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// period is one of the constants, never user text
+	rows, err := tx.QueryContext(ctx,
+		`SELECT date_trunc('`+period+`', t.tax_date)::date, c.code, SUM(t.base), SUM(t.tax), COUNT(*)
+		 FROM tax_lines t JOIN tax_codes c ON c.org_id = t.org_id AND c.id = t.tax_code_id
+		 WHERE t.org_id = $1 AND t.tax_date >= $2 AND t.tax_date <= $3
+		 GROUP BY 1, c.code ORDER BY 1, c.code`, id.OrgID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tax lines: %w", err)
+	}
+	defer rows.Close()
+
+	result := &TaxReturn{From: from, To: to, Period: period, Lines: []TaxReturnLine{}}
+	for rows.Next() {
+		var l TaxReturnLine
+		var start time.Time
+		if err := rows.Scan(&start, &l.Code, &l.Base, &l.Tax, &l.Lines); err != nil {
+			return nil, fmt.Errorf("failed to scan tax return: %w", err)
+		}
+		l.Period = start.Format("2006-01")
+		if period == TaxPeriodQuarter {
+			l.Period = fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+		}
+		l.Base = round2(l.Base)
+		l.Tax = round2(l.Tax)
+		result.NetTax += l.Tax
+		result.Lines = append(result.Lines, l)
+	}
+	result.NetTax = round2(result.NetTax)
+	return result, rows.Err()
+}
+
+// applyTaxCodes returns the entry's postings with a tax leg added after each
+// posting that has a tax code, and the tax lines to record. Tax is the
+// posting amount times the rate in effect on the trade date (today without
+// one), rounded to the cent, posted to the code's tax account with the same
+// sign: tax on a sale is credited, tax on a purchase debited.
+func applyTaxCodes(ctx context.Context, tx *sql.Tx, orgID int, e NewEntry) ([]Posting, []taxLine, error) {
+	date := time.Now().UTC()
+	if e.TradeDate != nil {
+		date = *e.TradeDate
+	}
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	var postings []Posting
+	var lines []taxLine
+	for _, p := range e.Postings {
+		if p.TaxCode == "" {
+			postings = append(postings, p)
+			continue
+		}
+
+		var codeID int
+		var account string
+		err := tx.QueryRowContext(ctx,
+			"SELECT id, tax_account FROM tax_codes WHERE org_id = $1 AND code = $2", orgID, p.TaxCode,
+		).Scan(&codeID, &account)
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownTaxCode, p.TaxCode)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch tax code: %w", err)
+		}
+
+		var rate float64
+		err = tx.QueryRowContext(ctx,
+			`SELECT rate FROM tax_rates WHERE org_id = $1 AND tax_code_id = $2 AND effective_from <= $3
+			 ORDER BY effective_from DESC, id DESC LIMIT 1`, orgID, codeID, date,
+		).Scan(&rate)
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("%w: %s on %s", ErrNoTaxRate, p.TaxCode, date.Format("2006-01-02"))
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch tax rate: %w", err)
+		}
+
+		tax := round2(p.Amount * rate)
+		lines = append(lines, taxLine{codeID: codeID, account: p.Account, date: date, rate: rate, base: p.Amount, tax: tax})
+
+		p.TaxCode = ""
+		postings = append(postings, p)
+		if tax != 0 {
+			postings = append(postings, Posting{Account: account, Amount: tax})
+		}
+	}
+	return postings, lines, nil
+}
+
+func insertTaxLines(ctx context.Context, tx *sql.Tx, orgID, ledgerID int, lines []taxLine) error {
+	for _, l := range lines {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO tax_lines (org_id, ledger_id, tax_code_id, account, tax_date, rate, base, tax)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			orgID, ledgerID, l.codeID, l.account, l.date, l.rate, l.base, l.tax,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record tax line: %w", err)
+		}
+	}
+	return nil
+}
+
+func insertTaxRate(ctx context.Context, tx *sql.Tx, orgID, codeID int, rate *TaxRate) error {
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO tax_rates (org_id, tax_code_id, rate, effective_from, created_by)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING created_at`,
+		orgID, codeID, rate.Rate, rate.EffectiveFrom, rate.CreatedBy,
+	).Scan(&rate.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create tax rate: %w", err)
+	}
+	return nil
+}
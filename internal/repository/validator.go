@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// Validator is a pre-commit policy check on every entry posted to the ledger,
+// whether from the API, an import, a FIX fill, an approved anomaly or a job.
+// It sees the entry after categorization, and rejects it by returning a
+// *RuleViolation; any other error aborts the post as a failure.
+type Validator interface {
+	Validate(ctx context.Context, e NewEntry) error
+}
+
+// RuleUniqueLotIDs is the rule an entry breaks by listing a lot more than once
+const RuleUniqueLotIDs = "unique-lot-ids"
+
+// RuleViolation is returned when a Validator, or NewEntry.Validate, rejects an entry
+type RuleViolation struct {
+	Rule    string
+	Message string
+}
+
+func (v *RuleViolation) Error() string {
+	return fmt.Sprintf("rule %s: %s", v.Rule, v.Message)
+}
+
+func runValidators(ctx context.Context, validators []Validator, e NewEntry) error {
+	for _, v := range validators {
+		if err := v.Validate(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"ledger-go-system/internal/prices"
+)
+
+// ErrValuationOrder is returned when booking a revaluation for a date before
+// one that has already been booked; deltas are only meaningful going forward
+var ErrValuationOrder = errors.New("a later valuation has already been booked")
+
+// ValuationLine values one position at the latest price on or before the valuation date
+type ValuationLine struct {
+	Account       string     `json:"account"`
+	Instrument    string     `json:"instrument"`
+	Quantity      float64    `json:"quantity"`
+	CostBasis     float64    `json:"cost_basis"`
+	Price         *float64   `json:"price"`
+	PriceDate     *time.Time `json:"price_date"`
+	MarketValue   float64    `json:"market_value"`
+	UnrealizedPnL float64    `json:"unrealized_pnl"`
+}
+
+// Valuation is the mark-to-market of every open position on a date.
+// Positions without a price are listed in Unpriced and carried at cost.
+type Valuation struct {
+	Date          time.Time       `json:"date"`
+	Lines         []ValuationLine `json:"lines"`
+	MarketValue   float64         `json:"market_value"`
+	CostBasis     float64         `json:"cost_basis"`
+	UnrealizedPnL float64         `json:"unrealized_pnl"`
+	Unpriced      []string        `json:"unpriced,omitempty"`
+}
+
+// ValuationRun is the recorded result of the daily mark-to-market job
+type ValuationRun struct {
+	ID            int       `json:"id"`
+	Date          time.Time `json:"date"`
+	MarketValue   float64   `json:"market_value"`
+	CostBasis     float64   `json:"cost_basis"`
+	UnrealizedPnL float64   `json:"unrealized_pnl"`
+	Unpriced      int       `json:"unpriced"`
+	LedgerID      *int      `json:"ledger_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// RevaluationAccounts are where booked mark-to-market moves are posted: the
+// change in unrealized P&L is debited to Revaluation (an adjustment alongside
+// the cost-carrying position accounts) and credited to UnrealizedPnL
+type RevaluationAccounts struct {
+	Revaluation   string
+	UnrealizedPnL string
+}
+
+type ValuationRepository struct {
+	db         *sql.DB
+	validators []Validator
+}
+
+// NewValuationRepository returns a repository whose entries must pass the given
+// validators before they are posted
+func NewValuationRepository(db *sql.DB, validators ...Validator) *ValuationRepository {
+	return &ValuationRepository{db: db, validators: validators}
+}
+
+// AddPrices stores prices append-only. A later row for the same instrument
+// and date supersedes earlier ones, so corrections are loaded, not edited.
+func (r *ValuationRepository) AddPrices(ctx context.Context, ps []prices.Price, source string) (int, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, p := range ps {
+		if err := ensureInstrument(ctx, tx, id.OrgID, p.Instrument); err != nil {
+			return 0, err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO prices (org_id, instrument, price_date, price, currency, source)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`,
+			id.OrgID, p.Instrument, p.Date, p.Price, p.Currency, source,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store price: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(ps), nil
+}
+
+// Prices returns the latest price of each instrument on or before date
+func (r *ValuationRepository) Prices(ctx context.Context, date time.Time) ([]prices.Price, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT DISTINCT ON (instrument) instrument, price_date, price, COALESCE(currency, '')
+		 FROM prices WHERE org_id = $1 AND price_date <= $2
+		 ORDER BY instrument, price_date DESC, id DESC`, id.OrgID, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices: %w", err)
+	}
+	defer rows.Close()
+
+	result := []prices.Price{}
+	for rows.Next() {
+		var p prices.Price
+		if err := rows.Scan(&p.Instrument, &p.Date, &p.Price, &p.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan price: %w", err)
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// Valuation marks positions as they stood at the end of date to the latest
+// price on or before date. A positive level rolls accounts up to that depth
+// of the chart of accounts.
+func (r *ValuationRepository) Valuation(ctx context.Context, date time.Time, level int) (*Valuation, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	v, err := valuePositions(ctx, tx, id.OrgID, date)
+	if err != nil || level <= 0 {
+		return v, err
+	}
+
+	tree, err := loadAccountTree(ctx, tx, id.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	type key struct{ account, instrument string }
+	index := map[key]int{}
+	lines := []ValuationLine{}
+	for _, l := range v.Lines {
+		l.Account = tree.at(l.Account, level)
+		k := key{l.Account, l.Instrument}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(lines)
+			lines = append(lines, l)
+			continue
+		}
+		lines[i].Quantity += l.Quantity
+		lines[i].CostBasis = round2(lines[i].CostBasis + l.CostBasis)
+		lines[i].MarketValue = round2(lines[i].MarketValue + l.MarketValue)
+		lines[i].UnrealizedPnL = round2(lines[i].UnrealizedPnL + l.UnrealizedPnL)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Account != lines[j].Account {
+			return lines[i].Account < lines[j].Account
+		}
+		return lines[i].Instrument < lines[j].Instrument
+	})
+	v.Lines = lines
+	return v, nil
+}
+
+// Runs lists recorded mark-to-market runs, newest first
+func (r *ValuationRepository) Runs(ctx context.Context) ([]ValuationRun, error) {
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, valuation_date, market_value, cost_basis, unrealized_pnl, unpriced, ledger_id, created_at
+		 FROM valuation_runs WHERE org_id = $1 ORDER BY valuation_date DESC`, id.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch valuation runs: %w", err)
+	}
+	defer rows.Close()
+
+	result := []ValuationRun{}
+	for rows.Next() {
+		var run ValuationRun
+		var ledgerID sql.NullInt64
+		if err := rows.Scan(&run.ID, &run.Date, &run.MarketValue, &run.CostBasis, &run.UnrealizedPnL,
+			&run.Unpriced, &ledgerID, &run.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan valuation run: %w", err)
+		}
+		if ledgerID.Valid {
+			v := int(ledgerID.Int64)
+			run.LedgerID = &v
+		}
+		result = append(result, run)
+	}
+	return result, rows.Err()
+}
+
+// MarkToMarket values positions for date and records the run with its lines.
+// It is idempotent per date: if a run already exists it is returned with
+// created=false and nothing is booked. When accounts is non-nil the change in
+// unrealized P&L since the last booked run is posted as a revaluation entry.
+func (r *ValuationRepository) MarkToMarket(ctx context.Context, date time.Time, accounts *RevaluationAccounts, actor string) (*ValuationRun, bool, error) {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	tx, id, err := beginScopedTx(ctx, r.db)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// One job at a time per organization, so two schedulers cannot both book a date
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", fmt.Sprintf("mtm:%d", id.OrgID)); err != nil {
+		return nil, false, fmt.Errorf("failed to lock valuation: %w", err)
+	}
+
+	existing, err := valuationRun(ctx, tx, id.OrgID, date)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+
+	v, err := valuePositions(ctx, tx, id.OrgID, date)
+	if err != nil {
+		return nil, false, err
+	}
+
+	run := &ValuationRun{
+		Date:          date,
+		MarketValue:   v.MarketValue,
+		CostBasis:     v.CostBasis,
+		UnrealizedPnL: v.UnrealizedPnL,
+		Unpriced:      len(v.Unpriced),
+	}
+
+	var ledgerID sql.NullInt64
+	var booked sql.NullFloat64
+	if accounts != nil {
+		// booked_unrealized_pnl is the cumulative revaluation the ledger reflects,
+		// so sub-cent deltas that were not posted are carried into the next run
+		var lastDate sql.NullTime
+		var lastBooked float64
+		err := tx.QueryRowContext(ctx,
+			`SELECT valuation_date, booked_unrealized_pnl FROM valuation_runs
+			 WHERE org_id = $1 AND booked_unrealized_pnl IS NOT NULL
+			 ORDER BY valuation_date DESC LIMIT 1`, id.OrgID,
+		).Scan(&lastDate, &lastBooked)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, false, fmt.Errorf("failed to fetch last booked valuation: %w", err)
+		}
+		if lastDate.Valid && !lastDate.Time.Before(date) {
+			return nil, false, fmt.Errorf("%w (%s)", ErrValuationOrder, lastDate.Time.Format("2006-01-02"))
+		}
+
+		booked = sql.NullFloat64{Float64: lastBooked, Valid: true}
+		if delta := round2(v.UnrealizedPnL - lastBooked); math.Abs(delta) >= 0.005 {
+			entry := NewEntry{
+				Amount:      math.Abs(delta),
+				Description: fmt.Sprintf("Mark-to-market revaluation %s", date.Format("2006-01-02")),
+				Postings: []Posting{
+					{Account: accounts.Revaluation, Amount: delta},
+					{Account: accounts.UnrealizedPnL, Amount: -delta},
+				},
+			}
+			lid, err := insertEntry(ctx, tx, id.OrgID, entry, r.validators, actor)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to book revaluation: %w", err)
+			}
+			ledgerID = sql.NullInt64{Int64: int64(lid), Valid: true}
+			l := lid
+			run.LedgerID = &l
+			booked.Float64 = round2(lastBooked + delta)
+		}
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO valuation_runs (org_id, valuation_date, market_value, cost_basis, unrealized_pnl, unpriced, booked_unrealized_pnl, ledger_id, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+		id.OrgID, date, run.MarketValue, run.CostBasis, run.UnrealizedPnL, run.Unpriced, booked, ledgerID, actor,
+	).Scan(&run.ID, &run.CreatedAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record valuation run: %w", err)
+	}
+
+	for _, line := range v.Lines {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO valuation_lines (org_id, run_id, account, instrument, quantity, cost_basis, price, price_date, market_value)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			id.OrgID, run.ID, line.Account, line.Instrument, line.Quantity, line.CostBasis, line.Price, line.PriceDate, line.MarketValue,
+		)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to record valuation line: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return run, true, nil
+}
+
+// valuationRun returns the run recorded for date, or nil
+func valuationRun(ctx context.Context, tx *sql.Tx, orgID int, date time.Time) (*ValuationRun, error) {
+	var run ValuationRun
+	var ledgerID sql.NullInt64
+	err := tx.QueryRowContext(ctx,
+		`SELECT id, valuation_date, market_value, cost_basis, unrealized_pnl, unpriced, ledger_id, created_at
+		 FROM valuation_runs WHERE org_id = $1 AND valuation_date = $2`, orgID, date,
+	).Scan(&run.ID, &run.Date, &run.MarketValue, &run.CostBasis, &run.UnrealizedPnL, &run.Unpriced, &ledgerID, &run.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch valuation run: %w", err)
+	}
+	if ledgerID.Valid {
+		v := int(ledgerID.Int64)
+		run.LedgerID = &v
+	}
+	return &run, nil
+}
+
+// valuePositions rebuilds open positions from lots and closures recorded by
+// the end of date and prices each at the latest price on or before date
+func valuePositions(ctx context.Context, tx *sql.Tx, orgID int, date time.Time) (*Valuation, error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	endOfDay := day.Add(24*time.Hour - time.Microsecond)
+
+	rows, err := tx.QueryContext(ctx,
+		`WITH lot_state AS (
+		     SELECT l.account, l.instrument,
+		            l.quantity - COALESCE(SUM(c.quantity), 0) AS remaining,
+		            l.cost_basis - COALESCE(SUM(c.cost), 0) AS remaining_cost
+		     FROM lots l
+		     LEFT JOIN lot_closures c ON c.org_id = l.org_id AND c.lot_id = l.id AND c.created_at <= $2
+		     WHERE l.org_id = $1 AND l.opened_at <= $2
+		     GROUP BY l.id
+		 ), positions AS (
+		     SELECT account, instrument, SUM(remaining) AS quantity, SUM(remaining_cost) AS cost_basis
+		     FROM lot_state GROUP BY account, instrument HAVING SUM(remaining) > 0
+		 ), latest AS (
+		     SELECT DISTINCT ON (instrument) instrument, price, price_date
+		     FROM prices WHERE org_id = $1 AND price_date <= $3
+		     ORDER BY instrument, price_date DESC, id DESC
+		 )
+		 SELECT p.account, p.instrument, p.quantity, p.cost_basis, lp.price, lp.price_date
+		 FROM positions p
+		 LEFT JOIN latest lp ON lp.instrument = p.instrument
+		 ORDER BY p.account, p.instrument`, orgID, endOfDay, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to value positions: %w", err)
+	}
+	defer rows.Close()
+
+	v := &Valuation{Date: day, Lines: []ValuationLine{}}
+	for rows.Next() {
+		var line ValuationLine
+		var price sql.NullFloat64
+		var priceDate sql.NullTime
+		if err := rows.Scan(&line.Account, &line.Instrument, &line.Quantity, &line.CostBasis, &price, &priceDate); err != nil {
+			return nil, fmt.Errorf("failed to scan position: %w", err)
+		}
+
+		line.MarketValue = line.CostBasis
+		if price.Valid {
+			p, d := price.Float64, priceDate.Time
+			line.Price, line.PriceDate = &p, &d
+			line.MarketValue = round2(line.Quantity * p)
+		} else {
+			v.Unpriced = append(v.Unpriced, line.Instrument)
+		}
+		line.UnrealizedPnL = round2(line.MarketValue - line.CostBasis)
+
+		v.Lines = append(v.Lines, line)
+		v.MarketValue += line.MarketValue
+		v.CostBasis += line.CostBasis
+		v.UnrealizedPnL += line.UnrealizedPnL
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to value positions: %w", err)
+	}
+
+	v.MarketValue, v.CostBasis, v.UnrealizedPnL = round2(v.MarketValue), round2(v.CostBasis), round2(v.UnrealizedPnL)
+	return v, nil
+}
@@ -0,0 +1,265 @@
+// Package validation provides the built-in policy rules entries are checked
+// against before they are posted, configured from a JSON file:
+//
+//	{
+//	  "rules": [
+//	    { "name": "admin-limit", "type": "max_amount", "limits": { "admin": 1000000 } },
+//	    { "name": "no-crypto", "type": "banned_keywords", "keywords": ["bitcoin", "ethereum"] },
+//	    { "name": "cost-center", "type": "required_metadata", "keys": ["cost_center"] },
+//	    { "name": "no-weekends", "type": "weekend_block", "timezone": "Europe/London", "roles": ["admin"] }
+//	  ]
+//	}
+//
+// Every rule needs a unique name, which is reported when it rejects an entry.
+// roles limits a rule to entries posted by those roles; without it the rule
+// applies to everyone.
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ledger-go-system/internal/identity"
+	"ledger-go-system/internal/repository"
+)
+
+// Rule types
+const (
+	TypeMaxAmount        = "max_amount"
+	TypeBannedKeywords   = "banned_keywords"
+	TypeRequiredMetadata = "required_metadata"
+	TypeWeekendBlock     = "weekend_block"
+)
+
+// RuleConfig is one rule of the configuration file. Only the fields of its
+// Type are used.
+type RuleConfig struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Roles []string `json:"roles,omitempty"`
+
+	// max_amount: the largest entry amount each role may post; roles not
+	// listed are not limited
+	Limits map[string]float64 `json:"limits,omitempty"`
+
+	// banned_keywords: words that may not appear in the description, in any case
+	Keywords []string `json:"keywords,omitempty"`
+
+	// required_metadata: metadata keys every entry must set
+	Keys []string `json:"keys,omitempty"`
+
+	// weekend_block: the time zone Saturdays and Sundays are observed in, UTC by default
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Config is the configuration file
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// MaxAmount rejects entries larger than the limit for the posting role
+type MaxAmount struct {
+	Name   string
+	Limits map[string]float64
+}
+
+func (r MaxAmount) Validate(ctx context.Context, e repository.NewEntry) error {
+	role := role(ctx)
+	limit, ok := r.Limits[role]
+	if !ok || e.Amount <= limit {
+		return nil
+	}
+	return &repository.RuleViolation{Rule: r.Name, Message: fmt.Sprintf("amount %.2f exceeds the %s limit of %.2f", e.Amount, role, limit)}
+}
+
+// BannedKeywords rejects entries whose description contains a keyword
+type BannedKeywords struct {
+	Name     string
+	Keywords []string
+}
+
+func (r BannedKeywords) Validate(ctx context.Context, e repository.NewEntry) error {
+	description := strings.ToLower(e.Description)
+	for _, k := range r.Keywords {
+		if strings.Contains(description, strings.ToLower(k)) {
+			return &repository.RuleViolation{Rule: r.Name, Message: fmt.Sprintf("description contains %q", k)}
+		}
+	}
+	return nil
+}
+
+// RequiredMetadata rejects entries missing any of the metadata keys
+type RequiredMetadata struct {
+	Name string
+	Keys []string
+}
+
+func (r RequiredMetadata) Validate(ctx context.Context, e repository.NewEntry) error {
+	for _, k := range r.Keys {
+		if strings.TrimSpace(e.Metadata[k]) == "" {
+			return &repository.RuleViolation{Rule: r.Name, Message: fmt.Sprintf("metadata %q is required", k)}
+		}
+	}
+	return nil
+}
+
+// WeekendBlock rejects entries posted on a Saturday or Sunday in Location
+type WeekendBlock struct {
+	Name     string
+	Location *time.Location
+	Now      func() time.Time
+}
+
+func (r WeekendBlock) Validate(ctx context.Context, e repository.NewEntry) error {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	day := now().In(r.Location).Weekday()
+	if day == time.Saturday || day == time.Sunday {
+		return &repository.RuleViolation{Rule: r.Name, Message: fmt.Sprintf("entries cannot be posted on %s", day)}
+	}
+	return nil
+}
+
+// forRoles applies a validator only to entries posted by the given roles
+type forRoles struct {
+	roles     []string
+	validator repository.Validator
+}
+
+func (r forRoles) Validate(ctx context.Context, e repository.NewEntry) error {
+	role := role(ctx)
+	for _, allowed := range r.roles {
+		if allowed == role {
+			return r.validator.Validate(ctx, e)
+		}
+	}
+	return nil
+}
+
+func role(ctx context.Context) string {
+	id, _ := identity.FromContext(ctx)
+	return id.Role
+}
+
+// Set is the validator for a configuration file. It checks the file's rules
+// in order and can reload them without a restart.
+type Set struct {
+	path string
+
+	mu    sync.RWMutex
+	rules []repository.Validator
+}
+
+// LoadFile reads a configuration file
+func LoadFile(path string) (*Set, error) {
+	s := &Set{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the configuration file. On error the rules in force are kept.
+func (s *Set) Reload() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+	return nil
+}
+
+// Len reports how many rules are in force
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rules)
+}
+
+func (s *Set) Validate(ctx context.Context, e repository.NewEntry) error {
+	s.mu.RLock()
+	rules := s.rules
+	s.mu.RUnlock()
+
+	for _, rule := range rules {
+		if err := rule.Validate(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse reads a configuration in the format described in the package documentation
+func Parse(r io.Reader) ([]repository.Validator, error) {
+	var config Config
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid validation config: %w", err)
+	}
+
+	names := map[string]bool{}
+	var rules []repository.Validator
+	for i, c := range config.Rules {
+		if c.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", c.Name)
+		}
+		names[c.Name] = true
+
+		var rule repository.Validator
+		switch c.Type {
+		case TypeMaxAmount:
+			if len(c.Limits) == 0 {
+				return nil, fmt.Errorf("rule %s: limits are required", c.Name)
+			}
+			rule = MaxAmount{Name: c.Name, Limits: c.Limits}
+		case TypeBannedKeywords:
+			if len(c.Keywords) == 0 {
+				return nil, fmt.Errorf("rule %s: keywords are required", c.Name)
+			}
+			rule = BannedKeywords{Name: c.Name, Keywords: c.Keywords}
+		case TypeRequiredMetadata:
+			if len(c.Keys) == 0 {
+				return nil, fmt.Errorf("rule %s: keys are required", c.Name)
+			}
+			rule = RequiredMetadata{Name: c.Name, Keys: c.Keys}
+		case TypeWeekendBlock:
+			location := time.UTC
+			if c.Timezone != "" {
+				var err error
+				if location, err = time.LoadLocation(c.Timezone); err != nil {
+					return nil, fmt.Errorf("rule %s: %w", c.Name, err)
+				}
+			}
+			rule = WeekendBlock{Name: c.Name, Location: location}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown type %q", c.Name, c.Type)
+		}
+
+		if len(c.Roles) > 0 {
+			rule = forRoles{roles: c.Roles, validator: rule}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
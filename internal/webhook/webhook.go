@@ -0,0 +1,49 @@
+// Package webhook posts JSON notifications to an HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client posts to one URL. Any 2xx response counts as delivered.
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// New returns a client for url with a ten second timeout
+func New(url string) *Client {
+	return &Client{URL: url, HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Post sends payload as a JSON request body
+func (c *Client) Post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}